@@ -0,0 +1,27 @@
+package main
+
+import (
+	"CodeCampass/utils"
+	"context"
+	"fmt"
+	"os"
+)
+
+// 主密钥轮换：先在配置中加入新主密钥并将 secret.activeKey 指向它（保留旧主密钥用于解密），
+// 然后在后端目录下执行 go run ./cmd/rotatesecrets，所有已存储的密钥会用新主密钥重新加密，
+// 未加密的旧数据也会一并加密。完成后即可从配置中移除旧主密钥。
+func main() {
+	utils.InitConfig()
+	utils.InitRedis()
+	if err := utils.InitSecretStore(); err != nil {
+		fmt.Println("初始化密钥存储失败:", err)
+		os.Exit(1)
+	}
+
+	count, err := utils.RotateSecrets(context.Background())
+	if err != nil {
+		fmt.Printf("轮换失败（已处理 %d 条）: %v\n", count, err)
+		os.Exit(1)
+	}
+	fmt.Printf("轮换完成，共重新加密 %d 条密钥\n", count)
+}
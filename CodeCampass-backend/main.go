@@ -3,7 +3,10 @@ package main
 import (
 	"CodeCampass/models"
 	"CodeCampass/router"
+	"CodeCampass/service"
+	"CodeCampass/storage"
 	"CodeCampass/utils"
+	"fmt"
 )
 
 // @title CodeCampass API 文档
@@ -18,9 +21,27 @@ import (
 
 func main() {
 	utils.InitConfig()
+	if err := storage.MigrateLegacyRoot(); err != nil {
+		fmt.Println("警告:", err)
+	}
 	utils.InitMySQL()
-	utils.DB.AutoMigrate(&models.UserBasic{}, &models.Project{}, &models.Repo{}, &models.ProjectEmbedding{})
+	utils.DB.AutoMigrate(&models.UserBasic{}, &models.Project{}, &models.Repo{}, &models.ProjectEmbedding{},
+		&models.Organization{}, &models.OrganizationMember{}, &models.ProviderCredential{},
+		&models.UsageRecord{}, &models.UserBudget{}, &models.GoPackage{}, &models.CodeSymbol{},
+		&models.GraphSnapshot{}, &models.CallEdge{}, &models.PackageImport{}, &models.ProjectSummary{}, &models.RepoDir{}, &models.UserQuota{},
+		&models.ProjectSync{}, &models.WebhookDelivery{}, &models.NotificationHook{}, &models.NotificationDelivery{})
+	if n, err := models.MigrateEmbeddingPaths(); err != nil {
+		fmt.Println("迁移 embedding 文件路径失败:", err)
+	} else if n > 0 {
+		fmt.Printf("已将 %d 条 embedding 的文件路径改为相对路径\n", n)
+	}
 	utils.InitRedis()
+	if err := utils.InitSecretStore(); err != nil {
+		panic(err)
+	}
+	service.RecoverInterruptedImports()
+	service.StartSyncScheduler()
+	service.StartNotifier()
 	r := router.Router()
 	r.Run(":8081") //listen on "localhost:8081"
 }
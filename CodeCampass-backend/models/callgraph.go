@@ -0,0 +1,169 @@
+package models
+
+import (
+	"CodeCampass/utils"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// GraphSnapshot 项目某次提交的调用图与包依赖图
+type GraphSnapshot struct {
+	ID           uint      `gorm:"primarykey" json:"id"`
+	CreatedAt    time.Time `json:"created_at"`
+	ProjectId    uint      `gorm:"index" json:"project_id"`
+	Commit       string    `json:"commit"`
+	Algorithm    string    `json:"algorithm"`
+	PackageCount int       `json:"package_count"`
+	EdgeCount    int       `json:"edge_count"`
+}
+
+func (table *GraphSnapshot) TableName() string {
+	return "graph_snapshot"
+}
+
+// CallEdge 调用图中的一条边，函数名采用 SSA 的写法，如 pkg.Func、(*pkg.Type).Method
+type CallEdge struct {
+	ID             uint   `gorm:"primarykey" json:"-"`
+	SnapshotId     uint   `gorm:"index" json:"-"`
+	Caller         string `gorm:"size:512;index" json:"caller"`
+	CallerPkg      string `json:"caller_pkg"`
+	Callee         string `gorm:"size:512;index" json:"callee"`
+	CalleePkg      string `json:"callee_pkg"`
+	CalleeExternal bool   `json:"callee_external"` // 被调用方在仓库之外
+	Dynamic        bool   `json:"dynamic"`         // 通过接口或函数值的动态调用
+	FilePath       string `json:"file_path"`       // 调用处
+	Line           int    `json:"line"`
+}
+
+func (table *CallEdge) TableName() string {
+	return "call_edge"
+}
+
+// PackageImport 包依赖图中的一条边
+type PackageImport struct {
+	ID         uint   `gorm:"primarykey" json:"-"`
+	SnapshotId uint   `gorm:"index" json:"-"`
+	FromPkg    string `json:"from"`
+	ToPkg      string `json:"to"`
+	External   bool   `json:"external"` // 被导入的包在仓库之外
+}
+
+func (table *PackageImport) TableName() string {
+	return "package_import"
+}
+
+// 保存新的图快照，同一提交的旧快照会被替换，每个项目只保留最近 keep 个快照
+func SaveGraphSnapshot(snap *GraphSnapshot, edges []CallEdge, imports []PackageImport, keep int) error {
+	return utils.DB.Transaction(func(tx *gorm.DB) error {
+		var stale []uint
+		tx.Model(&GraphSnapshot{}).Where("project_id = ? and `commit` = ?", snap.ProjectId, snap.Commit).Pluck("id", &stale)
+
+		var all []uint
+		tx.Model(&GraphSnapshot{}).Where("project_id = ? and `commit` <> ?", snap.ProjectId, snap.Commit).
+			Order("id desc").Pluck("id", &all)
+		if len(all) >= keep {
+			stale = append(stale, all[keep-1:]...)
+		}
+		if len(stale) > 0 {
+			if err := tx.Where("snapshot_id IN ?", stale).Delete(&CallEdge{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("snapshot_id IN ?", stale).Delete(&PackageImport{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("id IN ?", stale).Delete(&GraphSnapshot{}).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Create(snap).Error; err != nil {
+			return err
+		}
+		for i := range edges {
+			edges[i].SnapshotId = snap.ID
+		}
+		for i := range imports {
+			imports[i].SnapshotId = snap.ID
+		}
+		if len(edges) > 0 {
+			if err := tx.CreateInBatches(edges, 500).Error; err != nil {
+				return err
+			}
+		}
+		if len(imports) > 0 {
+			if err := tx.CreateInBatches(imports, 500).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// 查找项目的图快照，commit 为空时返回最新的快照
+func FindGraphSnapshot(projectId uint, commit string) (GraphSnapshot, error) {
+	snap := GraphSnapshot{}
+	db := utils.DB.Where("project_id = ?", projectId)
+	if commit != "" {
+		db = db.Where("`commit` = ?", commit)
+	}
+	err := db.Order("id desc").First(&snap).Error
+	return snap, err
+}
+
+// 得到项目的图快照列表
+func GetGraphSnapshotList(projectId uint) []*GraphSnapshot {
+	data := make([]*GraphSnapshot, 0)
+	utils.DB.Where("project_id = ?", projectId).Order("id desc").Find(&data)
+	return data
+}
+
+// 查找调用了 funcs 中任一函数的边
+func FindCallers(snapshotId uint, funcs []string) []CallEdge {
+	data := make([]CallEdge, 0)
+	utils.DB.Where("snapshot_id = ? and callee IN ?", snapshotId, funcs).Order("caller asc, line asc").Find(&data)
+	return data
+}
+
+// 查找 funcs 中任一函数发出的调用边
+func FindCallees(snapshotId uint, funcs []string) []CallEdge {
+	data := make([]CallEdge, 0)
+	utils.DB.Where("snapshot_id = ? and caller IN ?", snapshotId, funcs).Order("callee asc, line asc").Find(&data)
+	return data
+}
+
+// 按函数全名或结尾匹配（如 service.askProject、meteredClient).record、askProject）查找图中的函数名
+func MatchGraphFunctions(snapshotId uint, name string, limit int) []string {
+	var names []string
+	suffix := "%." + name
+	utils.DB.Model(&CallEdge{}).Distinct("caller").
+		Where("snapshot_id = ? and (caller = ? or caller LIKE ?)", snapshotId, name, suffix).
+		Limit(limit).Pluck("caller", &names)
+
+	seen := map[string]bool{}
+	for _, n := range names {
+		seen[n] = true
+	}
+	var callees []string
+	utils.DB.Model(&CallEdge{}).Distinct("callee").
+		Where("snapshot_id = ? and (callee = ? or callee LIKE ?)", snapshotId, name, suffix).
+		Limit(limit).Pluck("callee", &callees)
+	for _, n := range callees {
+		if !seen[n] {
+			names = append(names, n)
+			seen[n] = true
+		}
+	}
+	return names
+}
+
+// 得到快照中的包依赖边
+func FindPackageImports(snapshotId uint, includeExternal bool) []PackageImport {
+	data := make([]PackageImport, 0)
+	db := utils.DB.Where("snapshot_id = ?", snapshotId)
+	if !includeExternal {
+		db = db.Where("external = ?", false)
+	}
+	db.Order("from_pkg asc, to_pkg asc").Find(&data)
+	return data
+}
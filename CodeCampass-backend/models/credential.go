@@ -0,0 +1,74 @@
+package models
+
+import (
+	"CodeCampass/utils"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 支持的 LLM 服务商类型
+const (
+	ProviderOpenAI     = "openai"     // OpenAI 官方接口
+	ProviderAzure      = "azure"      // Azure OpenAI，模型名填写部署名
+	ProviderCompatible = "compatible" // 其他兼容 OpenAI 协议的服务（如 chatanywhere），需填写 base_url
+)
+
+// ProviderCredential 用户保存的一组 LLM 服务凭证，密钥本身加密存放在 Redis 的 credential_key:<id>
+type ProviderCredential struct {
+	gorm.Model
+	UserId         uint       `json:"user_id"`
+	Name           string     `json:"name"`
+	Provider       string     `json:"provider"`
+	BaseUrl        string     `json:"base_url"`
+	ChatModel      string     `json:"chat_model"`
+	EmbeddingModel string     `json:"embedding_model"`
+	KeyMask        string     `json:"key_mask"` // 脱敏后的密钥，仅用于展示
+	IsDefault      bool       `json:"is_default"`
+	ValidatedAt    *time.Time `json:"validated_at"`
+}
+
+func (table *ProviderCredential) TableName() string {
+	return "provider_credential"
+}
+
+// 得到某用户的凭证列表
+func GetUserCredentialList(userId uint) []*ProviderCredential {
+	data := make([]*ProviderCredential, 0)
+	utils.DB.Where("user_id = ?", userId).Order("is_default desc, id asc").Find(&data)
+	return data
+}
+
+// 查找用户的某个凭证
+func FindUserCredential(userId uint, id uint) (ProviderCredential, error) {
+	cred := ProviderCredential{}
+	err := utils.DB.Where("id = ? and user_id = ?", id, userId).First(&cred).Error
+	return cred, err
+}
+
+// 查找用户的默认凭证
+func FindDefaultCredential(userId uint) (ProviderCredential, error) {
+	cred := ProviderCredential{}
+	err := utils.DB.Where("user_id = ? and is_default = ?", userId, true).First(&cred).Error
+	return cred, err
+}
+
+// 将某个凭证设为用户的默认凭证
+func SetDefaultCredential(userId uint, id uint) error {
+	return utils.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&ProviderCredential{}).Where("user_id = ?", userId).Update("is_default", false).Error; err != nil {
+			return err
+		}
+		return tx.Model(&ProviderCredential{}).Where("id = ? and user_id = ?", id, userId).Update("is_default", true).Error
+	})
+}
+
+// 删除凭证，并清除引用它的项目设置
+func DeleteCredential(cred ProviderCredential) error {
+	return utils.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&Project{}).Where("credential_id = ?", cred.ID).Update("credential_id", 0).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&cred).Error
+	})
+}
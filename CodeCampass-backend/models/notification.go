@@ -0,0 +1,172 @@
+package models
+
+import (
+	"CodeCampass/utils"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+// 通知 webhook 的消息格式
+const (
+	NotifyFormatJSON     = "json"     // 通用 JSON，带 HMAC 签名
+	NotifyFormatSlack    = "slack"    // Slack Incoming Webhook
+	NotifyFormatFeishu   = "feishu"   // 飞书自定义机器人
+	NotifyFormatDingTalk = "dingtalk" // 钉钉自定义机器人
+)
+
+// 通知投递的状态
+const (
+	NotifyPending = "pending" // 等待发送或重试
+	NotifySuccess = "success"
+	NotifyFailed  = "failed" // 重试次数用尽
+)
+
+// NotificationHook 项目事件的通知 webhook，签名密钥加密存放在 Redis 的 notify_secret:<id>
+type NotificationHook struct {
+	gorm.Model
+	ProjectId uint   `gorm:"index" json:"project_id"`
+	CreatorId uint   `json:"creator_id"`
+	Name      string `json:"name"`
+	Url       string `gorm:"size:1024" json:"-"` // 机器人地址中通常带有令牌，不在接口中返回
+	Format    string `json:"format"`
+	// 逗号分隔的事件类型，支持 * 通配，如 embedding_*,sync_error
+	Events    string `json:"events"`
+	Template  string `gorm:"type:text" json:"template"` // 聊天消息的 text/template 模板，空表示使用默认文案
+	Enabled   bool   `json:"enabled"`
+	SecretSet bool   `json:"secret_set"`
+}
+
+func (table *NotificationHook) TableName() string {
+	return "notification_hook"
+}
+
+// EventList 订阅的事件类型
+func (h *NotificationHook) EventList() []string {
+	list := make([]string, 0)
+	for _, e := range strings.Split(h.Events, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			list = append(list, e)
+		}
+	}
+	return list
+}
+
+// 项目的通知 webhook 列表
+func GetProjectNotificationHooks(projectId uint) []NotificationHook {
+	list := make([]NotificationHook, 0)
+	utils.DB.Where("project_id = ?", projectId).Order("id asc").Find(&list)
+	return list
+}
+
+// 项目启用的通知 webhook
+func GetEnabledNotificationHooks(projectId uint) []NotificationHook {
+	list := make([]NotificationHook, 0)
+	utils.DB.Where("project_id = ? and enabled = ?", projectId, true).Find(&list)
+	return list
+}
+
+// 查找项目的某个通知 webhook
+func FindNotificationHook(projectId, id uint) (NotificationHook, error) {
+	hook := NotificationHook{}
+	err := utils.DB.Where("id = ? and project_id = ?", id, projectId).First(&hook).Error
+	return hook, err
+}
+
+// NotificationDelivery 一次通知的投递记录
+type NotificationDelivery struct {
+	ID            uint       `gorm:"primarykey" json:"id"`
+	HookId        uint       `gorm:"index" json:"hook_id"`
+	ProjectId     uint       `json:"project_id"`
+	Event         string     `json:"event"`
+	DeliveryId    string     `json:"delivery_id"` // 随请求发送，接收方可用于去重
+	Payload       string     `gorm:"type:text" json:"payload"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	StatusCode    int        `json:"status_code"` // 最近一次请求的 HTTP 状态码
+	Response      string     `gorm:"size:1024" json:"response"`
+	Error         string     `gorm:"size:1024" json:"error"`
+	NextAttemptAt *time.Time `json:"next_attempt_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+func (table *NotificationDelivery) TableName() string {
+	return "notification_delivery"
+}
+
+// maxNotificationDeliveries 每个通知 webhook 保留的投递记录数
+const maxNotificationDeliveries = 200
+
+// 创建投递记录，只保留最近的若干条
+func CreateNotificationDelivery(d *NotificationDelivery) error {
+	if err := utils.DB.Create(d).Error; err != nil {
+		return err
+	}
+	var ids []uint
+	utils.DB.Model(&NotificationDelivery{}).Where("hook_id = ?", d.HookId).
+		Order("id desc").Offset(maxNotificationDeliveries).Pluck("id", &ids)
+	if len(ids) > 0 {
+		utils.DB.Where("id in ?", ids).Delete(&NotificationDelivery{})
+	}
+	return nil
+}
+
+// 到期待发送的投递记录ID，按到期时间排序
+func GetDueNotificationDeliveries(now time.Time, limit int) []uint {
+	var ids []uint
+	utils.DB.Model(&NotificationDelivery{}).
+		Where("status = ? and (next_attempt_at is null or next_attempt_at <= ?)", NotifyPending, now).
+		Order("next_attempt_at asc").Limit(limit).Pluck("id", &ids)
+	return ids
+}
+
+// 认领一条到期的投递记录：把下次发送时间推迟 lease，认领期间其他协程与实例不会重复发送；
+// 返回是否认领成功
+func ClaimNotificationDelivery(id uint, now time.Time, lease time.Duration) (NotificationDelivery, bool) {
+	var d NotificationDelivery
+	res := utils.DB.Model(&NotificationDelivery{}).
+		Where("id = ? and status = ? and (next_attempt_at is null or next_attempt_at <= ?)", id, NotifyPending, now).
+		Update("next_attempt_at", now.Add(lease))
+	if res.Error != nil || res.RowsAffected == 0 {
+		return d, false
+	}
+	return d, utils.DB.Where("id = ?", id).First(&d).Error == nil
+}
+
+// truncateUTF8 截断到不超过 n 字节，不截断多字节字符
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// 保存一次投递尝试的结果
+func SaveNotificationDelivery(d *NotificationDelivery) error {
+	d.Response = truncateUTF8(d.Response, 1024)
+	d.Error = truncateUTF8(d.Error, 1024)
+	return utils.DB.Save(d).Error
+}
+
+// 通知 webhook 最近的投递记录，按时间倒序
+func GetNotificationDeliveries(hookId uint, limit int) []NotificationDelivery {
+	list := make([]NotificationDelivery, 0)
+	utils.DB.Where("hook_id = ?", hookId).Order("id desc").Limit(limit).Find(&list)
+	return list
+}
+
+// 删除通知 webhook 及其投递记录
+func DeleteNotificationHook(hook *NotificationHook) error {
+	return utils.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("hook_id = ?", hook.ID).Delete(&NotificationDelivery{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(hook).Error
+	})
+}
@@ -0,0 +1,93 @@
+package models
+
+import (
+	"CodeCampass/utils"
+
+	"gorm.io/gorm"
+)
+
+// 组织成员角色
+const (
+	OrgRoleOwner  = "owner"
+	OrgRoleAdmin  = "admin"
+	OrgRoleMember = "member"
+)
+
+type Organization struct {
+	gorm.Model
+	Name          string  `json:"name"`
+	Description   string  `json:"description"`
+	OwnerId       uint    `json:"owner_id"`
+	SpendingLimit float64 `json:"spending_limit"` // 每月 LLM 花费上限（美元），0 表示不限制
+	BudgetAction  string  `json:"budget_action"`  // 超出上限后的处理方式 refuse/degrade，为空时使用配置默认值
+}
+
+func (table *Organization) TableName() string {
+	return "organization"
+}
+
+type OrganizationMember struct {
+	gorm.Model
+	OrgId  uint   `json:"org_id"`
+	UserId uint   `json:"user_id"`
+	Role   string `json:"role"`
+}
+
+func (table *OrganizationMember) TableName() string {
+	return "organization_member"
+}
+
+// 创建组织，并把创建者加入为 owner
+func CreateOrganization(org *Organization) error {
+	return utils.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(org).Error; err != nil {
+			return err
+		}
+		return tx.Create(&OrganizationMember{
+			OrgId:  org.ID,
+			UserId: org.OwnerId,
+			Role:   OrgRoleOwner,
+		}).Error
+	})
+}
+
+// 删除组织及其成员关系
+func DeleteOrganization(org Organization) error {
+	return utils.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("org_id = ?", org.ID).Delete(&OrganizationMember{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&org).Error
+	})
+}
+
+// 得到某用户加入的组织列表
+func GetUserOrgList(userId uint) []*Organization {
+	data := make([]*Organization, 0)
+	utils.DB.Where("id in (?)", UserOrgIds(userId)).Find(&data)
+	return data
+}
+
+// UserOrgIds 返回用户所在组织ID的子查询
+func UserOrgIds(userId interface{}) *gorm.DB {
+	return utils.DB.Model(&OrganizationMember{}).Select("org_id").Where("user_id = ?", userId)
+}
+
+// 得到组织成员列表
+func GetOrgMemberList(orgId uint) []*OrganizationMember {
+	data := make([]*OrganizationMember, 0)
+	utils.DB.Where("org_id = ?", orgId).Find(&data)
+	return data
+}
+
+// 查找组织成员
+func FindOrgMember(orgId uint, userId uint) (OrganizationMember, error) {
+	member := OrganizationMember{}
+	err := utils.DB.Where("org_id = ? and user_id = ?", orgId, userId).First(&member).Error
+	return member, err
+}
+
+// IsManager 成员是否有组织管理权限
+func (m OrganizationMember) IsManager() bool {
+	return m.Role == OrgRoleOwner || m.Role == OrgRoleAdmin
+}
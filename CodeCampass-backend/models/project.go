@@ -2,17 +2,60 @@ package models
 
 import (
 	"CodeCampass/utils"
-	"fmt"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
 
 	"gorm.io/gorm"
 )
 
+// 项目索引状态
+const (
+	IndexStatusNone     = ""         // 尚未导入
+	IndexStatusCloning  = "cloning"  // 正在克隆仓库
+	IndexStatusIndexing = "indexing" // 正在构建索引
+	IndexStatusReady    = "ready"    // 索引完成
+	IndexStatusFailed   = "failed"   // 导入或索引失败
+)
+
+// 项目列表排序方式
+const (
+	ProjectSortUpdated = "updated" // 最近更新
+	ProjectSortAsked   = "asked"   // 最近提问
+	ProjectSortName    = "name"    // 按名称
+)
+
+var ErrInvalidCursor = errors.New("无效的分页游标")
+
 type Project struct {
 	gorm.Model
-	OwnerId     uint   `json:"owner_id"`
-	Name        string `json:"name"`
-	RepoUrl     string `json:"repo_url"`
-	Description string `json:"description"`
+	OwnerId     uint       `json:"owner_id"`
+	OrgId       uint       `json:"org_id"` // 所属组织，0 表示个人项目
+	Name        string     `json:"name" gorm:"index:idx_project_search,class:FULLTEXT,option:WITH PARSER ngram"`
+	RepoUrl     string     `json:"repo_url"`
+	Description string     `json:"description" gorm:"index:idx_project_search,class:FULLTEXT,option:WITH PARSER ngram"`
+	IndexStatus string     `json:"index_status"`
+	Language    string     `json:"language"` // 仓库主要语言，导入时统计得出
+	Tags        string     `json:"tags"`     // 逗号分隔的标签
+	LastAskedAt *time.Time `json:"last_asked_at"`
+	// 项目指定使用的 LLM 凭证，0 表示使用组织或用户的默认凭证
+	CredentialId uint `json:"credential_id"`
+	// 最近一次导入的提交，符号索引等按此提交构建
+	HeadCommit string `json:"head_commit"`
+	// 克隆的历史深度：0 只克隆最新提交，-1 完整历史，其他为克隆的提交数
+	HistoryDepth int `json:"history_depth"`
+	// 仓库与索引占用的磁盘字节数，导入后统计
+	DiskUsage int64 `json:"disk_usage"`
+	// 导入与同步跟踪的分支，空表示远端的默认分支
+	TrackedRef string `json:"tracked_ref"`
+	// 是否检出子模块；子模块的历史深度，含义同 HistoryDepth；不检出的子模块路径，逗号分隔，支持 * 通配
+	Submodules      bool   `json:"submodules"`
+	SubmoduleDepth  int    `json:"submodule_depth"`
+	SubmoduleIgnore string `json:"submodule_ignore"`
+	// 是否拉取 Git LFS 对象，不拉取时 LFS 文件保留为指针，不构建 embedding
+	FetchLFS bool `json:"fetch_lfs"`
 }
 
 func (table *Project) TableName() string {
@@ -21,27 +64,21 @@ func (table *Project) TableName() string {
 
 // 得到项目列表
 func GetProjectList() []*Project {
-	data := make([]*Project, 10)
+	data := make([]*Project, 0)
 	utils.DB.Find(&data)
-	for _, v := range data {
-		fmt.Println(v)
-	}
 	return data
 }
 
 // 得到某用户的项目列表
 func GetUserProjectList(UserId uint) []*Project {
-	data := make([]*Project, 10)
+	data := make([]*Project, 0)
 	utils.DB.Where("owner_id = ?", UserId).Find(&data)
-	for _, v := range data {
-		fmt.Println(v)
-	}
 	return data
 }
 
 // 创建项目
-func CreateProject(proj Project) *gorm.DB {
-	return utils.DB.Create(&proj)
+func CreateProject(proj *Project) *gorm.DB {
+	return utils.DB.Create(proj)
 }
 
 // 删除项目
@@ -56,3 +93,177 @@ func UpdateProject(proj Project) *gorm.DB {
 		RepoUrl: proj.RepoUrl,
 	})
 }
+
+// 修改项目索引状态
+func UpdateProjectIndexStatus(projectId uint, status string) *gorm.DB {
+	return utils.DB.Model(&Project{}).Where("id = ?", projectId).Update("index_status", status)
+}
+
+// 索引状态为其中之一的项目ID
+func GetProjectIdsByIndexStatus(statuses ...string) []uint {
+	var ids []uint
+	utils.DB.Model(&Project{}).Where("index_status in ?", statuses).Pluck("id", &ids)
+	return ids
+}
+
+// DeleteProjectIndexes 删除项目的文件索引、embedding、符号、调用图与摘要
+func DeleteProjectIndexes(projectId uint) error {
+	return utils.DB.Transaction(func(tx *gorm.DB) error {
+		snapshots := tx.Model(&GraphSnapshot{}).Select("id").Where("project_id = ?", projectId)
+		if err := tx.Where("snapshot_id in (?)", snapshots).Delete(&CallEdge{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("snapshot_id in (?)", snapshots).Delete(&PackageImport{}).Error; err != nil {
+			return err
+		}
+		for _, model := range []interface{}{&GraphSnapshot{}, &Repo{}, &RepoDir{}, &ProjectEmbedding{},
+			&GoPackage{}, &CodeSymbol{}, &ProjectSummary{}} {
+			if err := tx.Where("project_id = ?", projectId).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// 更新项目占用的磁盘空间
+func UpdateProjectDiskUsage(projectId uint, bytes int64) *gorm.DB {
+	return utils.DB.Model(&Project{}).Where("id = ?", projectId).Update("disk_usage", bytes)
+}
+
+// NormalizeTags 去除空白与重复标签，返回逗号分隔的字符串
+func NormalizeTags(tags []string) string {
+	seen := make(map[string]bool)
+	result := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		result = append(result, tag)
+	}
+	return strings.Join(result, ",")
+}
+
+// ProjectQuery 项目列表的查询条件
+type ProjectQuery struct {
+	UserId   uint
+	Keyword  string // 在项目名和描述中全文搜索
+	Status   string
+	Language string
+	Tag      string
+	Sort     string
+	Cursor   string
+	Limit    int // 0 表示不分页
+}
+
+// projectCursor 游标记录上一页最后一条的排序键和ID
+type projectCursor struct {
+	Key string `json:"k"`
+	ID  uint   `json:"id"`
+}
+
+// QueryUserProjects 按条件查询用户可访问的项目（个人项目与所在组织的项目），
+// 使用游标分页，返回下一页游标，没有更多数据时为空
+func QueryUserProjects(q ProjectQuery) ([]Project, string, error) {
+	tx := utils.DB.Model(&Project{}).
+		Where("(owner_id = ? and org_id = 0) or org_id in (?)", q.UserId, UserOrgIds(q.UserId))
+
+	if q.Keyword != "" {
+		tx = tx.Where("MATCH(name, description) AGAINST (? IN BOOLEAN MODE)", q.Keyword)
+	}
+	if q.Status != "" {
+		tx = tx.Where("index_status = ?", q.Status)
+	}
+	if q.Language != "" {
+		tx = tx.Where("language = ?", q.Language)
+	}
+	if q.Tag != "" {
+		tx = tx.Where("FIND_IN_SET(?, tags) > 0", q.Tag)
+	}
+
+	// 排序键表达式；最近提问为空的项目排在最后
+	var keyExpr string
+	var desc bool
+	switch q.Sort {
+	case ProjectSortName:
+		keyExpr = "name"
+	case ProjectSortAsked:
+		keyExpr, desc = "COALESCE(last_asked_at, '1970-01-01 00:00:00')", true
+	default:
+		q.Sort = ProjectSortUpdated
+		keyExpr, desc = "updated_at", true
+	}
+
+	if q.Cursor != "" {
+		cur, key, err := decodeProjectCursor(q.Cursor, q.Sort)
+		if err != nil {
+			return nil, "", err
+		}
+		op := ">"
+		if desc {
+			op = "<"
+		}
+		tx = tx.Where("("+keyExpr+" "+op+" ? or ("+keyExpr+" = ? and id "+op+" ?))", key, key, cur.ID)
+	}
+
+	order := keyExpr + " asc, id asc"
+	if desc {
+		order = keyExpr + " desc, id desc"
+	}
+	tx = tx.Order(order)
+	if q.Limit > 0 {
+		tx = tx.Limit(q.Limit + 1) // 多取一条用于判断是否还有下一页
+	}
+
+	var projects []Project
+	if err := tx.Find(&projects).Error; err != nil {
+		return nil, "", err
+	}
+
+	nextCursor := ""
+	if q.Limit > 0 && len(projects) > q.Limit {
+		projects = projects[:q.Limit]
+		nextCursor = encodeProjectCursor(projects[len(projects)-1], q.Sort)
+	}
+	return projects, nextCursor, nil
+}
+
+func encodeProjectCursor(p Project, sort string) string {
+	cur := projectCursor{ID: p.ID}
+	switch sort {
+	case ProjectSortName:
+		cur.Key = p.Name
+	case ProjectSortAsked:
+		if p.LastAskedAt != nil {
+			cur.Key = p.LastAskedAt.Format(time.RFC3339Nano)
+		}
+	default:
+		cur.Key = p.UpdatedAt.Format(time.RFC3339Nano)
+	}
+	data, _ := json.Marshal(cur)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeProjectCursor 解析游标，返回可直接用于比较的排序键
+func decodeProjectCursor(s string, sort string) (projectCursor, interface{}, error) {
+	var cur projectCursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || json.Unmarshal(data, &cur) != nil {
+		return cur, nil, ErrInvalidCursor
+	}
+	switch sort {
+	case ProjectSortName:
+		return cur, cur.Key, nil
+	case ProjectSortAsked:
+		if cur.Key == "" {
+			return cur, time.Date(1970, 1, 1, 0, 0, 0, 0, time.Local), nil
+		}
+	}
+	t, err := time.Parse(time.RFC3339Nano, cur.Key)
+	if err != nil {
+		return cur, nil, ErrInvalidCursor
+	}
+	return cur, t, nil
+}
@@ -1,5 +1,15 @@
 package models
 
+import (
+	"CodeCampass/utils"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
 type ProjectEmbedding struct {
 	ProjectID uint
 	FilePath  string
@@ -10,3 +20,54 @@ type ProjectEmbedding struct {
 func (table *ProjectEmbedding) TableName() string {
 	return "project_embedding"
 }
+
+// 项目中已有 embedding 的文件路径
+func GetEmbeddedFilePaths(projectId uint) map[string]bool {
+	var paths []string
+	utils.DB.Model(&ProjectEmbedding{}).Where("project_id = ?", projectId).Distinct().Pluck("file_path", &paths)
+	set := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		set[p] = true
+	}
+	return set
+}
+
+// 重建文件索引后，把已有 embedding 的文件重新标记为已构建
+func MarkEmbeddedFiles(projectId uint) {
+	utils.DB.Model(&Repo{}).Where("project_id = ? and file_path in (?)", projectId,
+		utils.DB.Model(&ProjectEmbedding{}).Select("file_path").Where("project_id = ?", projectId)).
+		Update("index_status", FileIndexEmbedded)
+}
+
+// MigrateEmbeddingPaths 旧版本按绝对路径 <根目录>/<所有者ID>/<项目ID>/<文件> 保存 embedding，
+// 启动时改写为相对仓库根目录的路径，与文件索引一致；返回改写的行数
+func MigrateEmbeddingPaths() (int64, error) {
+	var projectIds []uint
+	err := utils.DB.Model(&ProjectEmbedding{}).Where("file_path like ?", "/%").Distinct().Pluck("project_id", &projectIds).Error
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, id := range projectIds {
+		var samples []string
+		utils.DB.Model(&ProjectEmbedding{}).Where("project_id = ? and file_path like ?", id, "/%").Limit(1).Pluck("file_path", &samples)
+		if len(samples) == 0 {
+			continue
+		}
+		// 所有者可能已变更，按项目ID定位仓库目录
+		m := regexp.MustCompile(fmt.Sprintf(`^.*?/\d+/%d/`, id)).FindString(samples[0])
+		if m == "" {
+			continue
+		}
+		escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(m)
+		res := utils.DB.Model(&ProjectEmbedding{}).Where("project_id = ? and file_path like ?", id, escaped+"%").
+			Update("file_path", gorm.Expr("SUBSTRING(file_path, ?)", utf8.RuneCountInString(m)+1))
+		if res.Error != nil {
+			return total, res.Error
+		}
+		total += res.RowsAffected
+		MarkEmbeddedFiles(id)
+	}
+	return total, nil
+}
@@ -0,0 +1,59 @@
+package models
+
+import (
+	"CodeCampass/utils"
+
+	"gorm.io/gorm"
+)
+
+// UserQuota 单个用户的配额，覆盖配置中的默认值。字段为 0 时使用默认值，小于 0 表示不限制
+type UserQuota struct {
+	gorm.Model
+	UserId        uint  `gorm:"uniqueIndex" json:"user_id"`
+	MaxProjects   int   `json:"max_projects"`    // 创建的项目数
+	MaxTotalBytes int64 `json:"max_total_bytes"` // 所有项目占用的磁盘空间
+	MaxRepoBytes  int64 `json:"max_repo_bytes"`  // 单个仓库克隆后的大小
+	MaxFiles      int   `json:"max_files"`       // 单个仓库的文件数
+	MaxChunks     int   `json:"max_chunks"`      // 单个项目的 embedding 数
+}
+
+func (table *UserQuota) TableName() string {
+	return "user_quota"
+}
+
+// 查找用户的配额设置
+func FindUserQuota(userId uint) (UserQuota, error) {
+	quota := UserQuota{}
+	err := utils.DB.Where("user_id = ?", userId).First(&quota).Error
+	return quota, err
+}
+
+// 用户创建的项目数（含组织项目）
+func CountOwnedProjects(userId uint) int64 {
+	var count int64
+	utils.DB.Model(&Project{}).Where("owner_id = ?", userId).Count(&count)
+	return count
+}
+
+// 用户创建的项目占用的磁盘空间，exceptId 不为 0 时不计该项目（重新导入时旧仓库会被替换）；
+// 删除项目时会清空占用，已删除但文件仍在的项目（如旧版本删除的项目）继续计入
+func SumOwnedDiskUsage(userId, exceptId uint) int64 {
+	var total int64
+	utils.DB.Unscoped().Model(&Project{}).Select("COALESCE(SUM(disk_usage), 0)").
+		Where("owner_id = ? and id <> ?", userId, exceptId).Scan(&total)
+	return total
+}
+
+// 项目的文件数
+func CountProjectFiles(projectId uint) int64 {
+	var count int64
+	utils.DB.Model(&Repo{}).Where("project_id = ?", projectId).Count(&count)
+	return count
+}
+
+// 项目的 embedding 数
+func CountProjectEmbeddings(projectId uint) int64 {
+	var count int64
+	utils.DB.Model(&ProjectEmbedding{}).Where("project_id = ?", projectId).Count(&count)
+	return count
+}
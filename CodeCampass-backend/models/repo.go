@@ -1,18 +1,123 @@
 package models
 
-import "time"
+import (
+	"CodeCampass/utils"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 单个文件的 embedding 状态
+const (
+	FileIndexPending  = "pending"  // 等待构建 embedding
+	FileIndexEmbedded = "embedded" // 已构建
+	FileIndexSkipped  = "skipped"  // 二进制、超出预算等原因未构建
+	FileIndexFailed   = "failed"   // 调用 embedding 接口失败
+)
 
 type Repo struct {
-	ProjectID    uint
-	FilePath     string
+	ProjectID    uint   `gorm:"index:idx_repo_project_dir;index:idx_repo_project_path"`
+	FilePath     string `gorm:"size:512;index:idx_repo_project_path"` // 构建 embedding 时逐个文件更新状态
+	Dir          string `gorm:"size:512;index:idx_repo_project_dir"`  // 所在目录，根目录为空
 	FileType     string
+	Language     string
 	Size         int64
 	LastModified time.Time
 	IsText       bool
+	IndexStatus  string
 	CreatedAt    time.Time
 	UpdatedAt    time.Time
+	// Git LFS 指针文件（未拉取 LFS 对象），不构建 embedding
+	IsLFSPointer bool
+	// 未检出的子模块，只记录其根目录
+	IsSubmoduleRoot bool
 }
 
 func (table *Repo) TableName() string {
 	return "repo"
 }
+
+// RepoDir 仓库中的目录，导入时由文件索引汇总得出（不含根目录）
+type RepoDir struct {
+	ProjectID  uint   `gorm:"index:idx_repo_dir_parent"`
+	Path       string `gorm:"size:512"`
+	Parent     string `gorm:"size:512;index:idx_repo_dir_parent"`
+	FileCount  int    // 包含子目录在内的文件数
+	ChildCount int    // 直接子项（文件与目录）数
+	Size       int64  // 包含子目录在内的文件总大小
+	// 已检出的子模块根目录
+	IsSubmodule bool
+}
+
+func (table *RepoDir) TableName() string {
+	return "repo_dir"
+}
+
+// 用新的文件与目录索引替换项目的旧索引
+func ReplaceRepoIndex(projectId uint, files []Repo, dirs []RepoDir) error {
+	return utils.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("project_id = ?", projectId).Delete(&Repo{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("project_id = ?", projectId).Delete(&RepoDir{}).Error; err != nil {
+			return err
+		}
+		if len(files) > 0 {
+			if err := tx.CreateInBatches(files, 500).Error; err != nil {
+				return err
+			}
+		}
+		if len(dirs) > 0 {
+			if err := tx.CreateInBatches(dirs, 500).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// 旧版导入没有记录所在目录，索引需要重建
+func RepoIndexOutdated(projectId uint) bool {
+	var count int64
+	utils.DB.Model(&Repo{}).Where("project_id = ? and dir = '' and file_path like ?", projectId, "%/%").Count(&count)
+	return count > 0
+}
+
+// 查找项目中的目录
+func FindRepoDir(projectId uint, path string) (RepoDir, error) {
+	var dir RepoDir
+	err := utils.DB.Where("project_id = ? and path = ?", projectId, path).First(&dir).Error
+	return dir, err
+}
+
+// 分页查询目录的直接子目录与文件，子目录在前，各自按名称排序
+func GetRepoTreeLevel(projectId uint, dir string, offset, limit int) ([]RepoDir, []Repo, int64) {
+	var dirCount, fileCount int64
+	utils.DB.Model(&RepoDir{}).Where("project_id = ? and parent = ?", projectId, dir).Count(&dirCount)
+	utils.DB.Model(&Repo{}).Where("project_id = ? and dir = ?", projectId, dir).Count(&fileCount)
+
+	dirs := make([]RepoDir, 0)
+	files := make([]Repo, 0)
+	if int64(offset) < dirCount {
+		utils.DB.Where("project_id = ? and parent = ?", projectId, dir).
+			Order("path asc").Offset(offset).Limit(limit).Find(&dirs)
+	}
+	if rest := limit - len(dirs); rest > 0 {
+		fileOffset := offset - int(dirCount)
+		if fileOffset < 0 {
+			fileOffset = 0
+		}
+		utils.DB.Where("project_id = ? and dir = ?", projectId, dir).
+			Order("file_path asc").Offset(fileOffset).Limit(rest).Find(&files)
+	}
+	return dirs, files, dirCount + fileCount
+}
+
+// 更新文件的 embedding 状态，filePath 为空时更新项目的全部文件
+func UpdateRepoIndexStatus(projectId uint, filePath, status string) {
+	tx := utils.DB.Model(&Repo{}).Where("project_id = ?", projectId)
+	if filePath != "" {
+		tx = tx.Where("file_path = ?", filePath)
+	}
+	tx.Update("index_status", status)
+}
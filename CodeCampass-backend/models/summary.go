@@ -0,0 +1,69 @@
+package models
+
+import (
+	"CodeCampass/utils"
+	"time"
+)
+
+// 摘要层级
+const (
+	SummaryKindFile = "file"
+	SummaryKindDir  = "dir"
+	SummaryKindRepo = "repo" // 仓库整体概览，Path 为空
+)
+
+// ProjectSummary LLM 生成的文件、目录与仓库摘要。
+// Hash 对文件是内容哈希，对目录是子项哈希的汇总，哈希不变时沿用已有摘要
+type ProjectSummary struct {
+	ID        uint      `gorm:"primarykey" json:"-"`
+	UpdatedAt time.Time `json:"updated_at"`
+	ProjectId uint      `gorm:"uniqueIndex:idx_summary_project_path" json:"project_id"`
+	Path      string    `gorm:"size:512;uniqueIndex:idx_summary_project_path" json:"path"`
+	Kind      string    `json:"kind"`
+	Hash      string    `json:"-"`
+	Summary   string    `gorm:"type:text" json:"summary"`
+	Model     string    `json:"model"`
+}
+
+func (table *ProjectSummary) TableName() string {
+	return "project_summary"
+}
+
+// 项目的全部摘要，按路径索引
+func FindProjectSummaries(projectId uint) map[string]ProjectSummary {
+	var list []ProjectSummary
+	utils.DB.Where("project_id = ?", projectId).Find(&list)
+	res := make(map[string]ProjectSummary, len(list))
+	for _, s := range list {
+		res[s.Path] = s
+	}
+	return res
+}
+
+// 查询项目中指定层级的摘要，kinds 为空表示不限
+func GetProjectSummaryList(projectId uint, kinds ...string) []ProjectSummary {
+	data := make([]ProjectSummary, 0)
+	tx := utils.DB.Where("project_id = ?", projectId)
+	if len(kinds) > 0 {
+		tx = tx.Where("kind IN ?", kinds)
+	}
+	tx.Order("path").Find(&data)
+	return data
+}
+
+// 保存摘要，同一路径已存在时覆盖
+func SaveProjectSummary(s *ProjectSummary) error {
+	var existing ProjectSummary
+	if err := utils.DB.Where("project_id = ? AND path = ?", s.ProjectId, s.Path).First(&existing).Error; err == nil {
+		s.ID = existing.ID
+	}
+	return utils.DB.Save(s).Error
+}
+
+// 删除不再存在的路径的摘要
+func DeleteProjectSummaries(projectId uint, paths []string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+	return utils.DB.Where("project_id = ? AND path IN ?", projectId, paths).Delete(&ProjectSummary{}).Error
+}
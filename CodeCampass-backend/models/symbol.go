@@ -0,0 +1,108 @@
+package models
+
+import (
+	"CodeCampass/utils"
+
+	"gorm.io/gorm"
+)
+
+// 符号类型
+const (
+	SymbolKindPackage = "package"
+	SymbolKindType    = "type"
+	SymbolKindFunc    = "func"
+	SymbolKindMethod  = "method"
+	SymbolKindField   = "field"
+	SymbolKindConst   = "const"
+	SymbolKindVar     = "var"
+)
+
+// GoPackage 项目中的一个 Go 包
+type GoPackage struct {
+	ID         uint   `gorm:"primarykey" json:"id"`
+	ProjectId  uint   `gorm:"index" json:"project_id"`
+	Commit     string `json:"commit"`
+	ImportPath string `json:"import_path"`
+	Name       string `json:"name"`
+	Dir        string `json:"dir"` // 相对仓库根目录
+	FileCount  int    `json:"file_count"`
+	Doc        string `gorm:"type:text" json:"doc"`
+}
+
+func (table *GoPackage) TableName() string {
+	return "go_package"
+}
+
+// CodeSymbol 符号索引中的一项，位置为相对仓库根目录的文件路径与 1 起始的行列号
+type CodeSymbol struct {
+	ID        uint   `gorm:"primarykey" json:"id"`
+	ProjectId uint   `gorm:"index:idx_symbol_project_kind" json:"project_id"`
+	Commit    string `json:"commit"`
+	Package   string `json:"package"` // 包导入路径
+	Name      string `json:"name"`
+	Kind      string `gorm:"index:idx_symbol_project_kind" json:"kind"`
+	Parent    string `json:"parent"` // 方法的接收者类型、字段所属的类型
+	FilePath  string `json:"file_path"`
+	Line      int    `json:"line"`
+	Col       int    `json:"col"`
+	EndLine   int    `json:"end_line"`
+	Signature string `gorm:"type:text" json:"signature"`
+	Exported  bool   `json:"exported"`
+}
+
+func (table *CodeSymbol) TableName() string {
+	return "code_symbol"
+}
+
+// 用新的索引结果替换项目的包与符号
+func ReplaceProjectSymbols(projectId uint, pkgs []GoPackage, syms []CodeSymbol) error {
+	return utils.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("project_id = ?", projectId).Delete(&GoPackage{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("project_id = ?", projectId).Delete(&CodeSymbol{}).Error; err != nil {
+			return err
+		}
+		if len(pkgs) > 0 {
+			if err := tx.CreateInBatches(pkgs, 500).Error; err != nil {
+				return err
+			}
+		}
+		if len(syms) > 0 {
+			if err := tx.CreateInBatches(syms, 500).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// 查询项目的符号，kinds 为空表示不限类型，pkg 为空表示不限包
+func FindProjectSymbols(projectId uint, kinds []string, pkg string) ([]CodeSymbol, error) {
+	data := make([]CodeSymbol, 0)
+	db := utils.DB.Where("project_id = ?", projectId)
+	if len(kinds) > 0 {
+		db = db.Where("kind IN ?", kinds)
+	}
+	if pkg != "" {
+		db = db.Where("package = ?", pkg)
+	}
+	err := db.Find(&data).Error
+	return data, err
+}
+
+// 按名字精确查找项目的符号
+func FindSymbolsByName(projectId uint, name string) []CodeSymbol {
+	data := make([]CodeSymbol, 0)
+	utils.DB.Where("project_id = ? and name = ? and kind <> ?", projectId, name, SymbolKindPackage).
+		Order("file_path asc, line asc").Find(&data)
+	return data
+}
+
+// 查询文件中定义的符号，按位置排序
+func FindFileSymbols(projectId uint, filePath string) []CodeSymbol {
+	data := make([]CodeSymbol, 0)
+	utils.DB.Where("project_id = ? and file_path = ? and kind <> ?", projectId, filePath, SymbolKindPackage).
+		Order("line asc").Find(&data)
+	return data
+}
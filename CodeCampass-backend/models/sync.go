@@ -0,0 +1,74 @@
+package models
+
+import (
+	"CodeCampass/utils"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 自动同步的结果
+const (
+	SyncStatusSuccess  = "success"    // 拉取到新提交并完成索引
+	SyncStatusUpToDate = "up_to_date" // 没有新提交
+	SyncStatusFailed   = "failed"     // 拉取或索引失败
+)
+
+// ProjectSync 项目的自动同步计划与最近一次同步的结果
+type ProjectSync struct {
+	gorm.Model
+	ProjectId uint `gorm:"uniqueIndex" json:"project_id"`
+	// cron 表达式（如 0 3 * * *）或 @every 6h 形式的间隔
+	Schedule   string     `json:"schedule"`
+	Enabled    bool       `json:"enabled"`
+	LastRunAt  *time.Time `json:"last_run_at"`
+	LastStatus string     `json:"last_status"`
+	LastError  string     `gorm:"size:1024" json:"last_error"`
+	LastCommit string     `json:"last_commit"` // 最近一次同步后的提交
+}
+
+func (table *ProjectSync) TableName() string {
+	return "project_sync"
+}
+
+// 查找项目的同步计划
+func FindProjectSync(projectId uint) (ProjectSync, error) {
+	ps := ProjectSync{}
+	err := utils.DB.Where("project_id = ?", projectId).First(&ps).Error
+	return ps, err
+}
+
+// 保存项目的同步计划，不存在时创建
+func SaveProjectSync(ps *ProjectSync) error {
+	return utils.DB.Save(ps).Error
+}
+
+// 所有启用了自动同步的计划，不含已删除的项目
+func GetEnabledProjectSyncs() []ProjectSync {
+	list := make([]ProjectSync, 0)
+	utils.DB.Where("enabled = ? and project_id in (?)", true, utils.DB.Model(&Project{}).Select("id")).Find(&list)
+	return list
+}
+
+// 删除项目的同步计划与记录
+func DeleteProjectSync(projectId uint) error {
+	return utils.DB.Where("project_id = ?", projectId).Delete(&ProjectSync{}).Error
+}
+
+// 记录一次同步的结果，项目没有同步计划（如手动触发）时也会创建一条未启用的记录
+func UpdateProjectSyncResult(projectId uint, status, errMsg, commit string, at time.Time) error {
+	if len(errMsg) > 1024 {
+		errMsg = errMsg[:1024]
+	}
+	ps, err := FindProjectSync(projectId)
+	if err != nil {
+		ps = ProjectSync{ProjectId: projectId}
+	}
+	ps.LastRunAt = &at
+	ps.LastStatus = status
+	ps.LastError = errMsg
+	if commit != "" {
+		ps.LastCommit = commit
+	}
+	return utils.DB.Save(&ps).Error
+}
@@ -0,0 +1,152 @@
+package models
+
+import (
+	"CodeCampass/utils"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// LLM 调用类型
+const (
+	UsageKindChat      = "chat"
+	UsageKindEmbedding = "embedding"
+)
+
+// 超出预算后的处理方式
+const (
+	BudgetActionRefuse  = "refuse"  // 拒绝调用
+	BudgetActionDegrade = "degrade" // 降级：使用更便宜的模型、更少的上下文
+)
+
+// UsageRecord 一次 LLM 调用的用量记录
+type UsageRecord struct {
+	ID               uint      `gorm:"primarykey" json:"id"`
+	CreatedAt        time.Time `gorm:"index" json:"created_at"`
+	UserId           uint      `gorm:"index" json:"user_id"`
+	OrgId            uint      `gorm:"index" json:"org_id"`
+	ProjectId        uint      `gorm:"index" json:"project_id"`
+	CredentialId     uint      `json:"credential_id"`
+	Kind             string    `json:"kind"`
+	Model            string    `json:"model"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	EmbeddingTokens  int       `json:"embedding_tokens"`
+	Cost             float64   `json:"cost"` // 按配置单价估算的花费（美元）
+}
+
+func (table *UsageRecord) TableName() string {
+	return "usage_record"
+}
+
+// UserBudget 用户每月花费预算，未设置时使用配置中的默认值
+type UserBudget struct {
+	gorm.Model
+	UserId       uint    `gorm:"uniqueIndex" json:"user_id"`
+	MonthlyLimit float64 `json:"monthly_limit"` // 每月上限（美元），0 表示不限制
+	Action       string  `json:"action"`
+}
+
+func (table *UserBudget) TableName() string {
+	return "user_budget"
+}
+
+// UsageSummary 按维度聚合后的用量
+type UsageSummary struct {
+	Key              string  `json:"key"`
+	Calls            int64   `json:"calls"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	EmbeddingTokens  int64   `json:"embedding_tokens"`
+	Cost             float64 `json:"cost"`
+}
+
+// 聚合维度
+const (
+	UsageGroupDay     = "day"
+	UsageGroupProject = "project"
+	UsageGroupModel   = "model"
+)
+
+// UsageQuery 用量查询条件，UserId 与 OrgId 至少指定一个
+type UsageQuery struct {
+	UserId    uint
+	OrgId     uint
+	ProjectId uint
+	From      time.Time
+	To        time.Time
+	GroupBy   string
+}
+
+func CreateUsageRecord(rec *UsageRecord) error {
+	return utils.DB.Create(rec).Error
+}
+
+// 按条件聚合用量
+func AggregateUsage(q UsageQuery) ([]UsageSummary, error) {
+	var keyExpr string
+	switch q.GroupBy {
+	case UsageGroupProject:
+		keyExpr = "CAST(project_id AS CHAR)"
+	case UsageGroupModel:
+		keyExpr = "model"
+	default:
+		keyExpr = "DATE_FORMAT(created_at, '%Y-%m-%d')"
+	}
+
+	db := utils.DB.Model(&UsageRecord{}).Select(keyExpr + " AS `key`, COUNT(*) AS calls, " +
+		"SUM(prompt_tokens) AS prompt_tokens, SUM(completion_tokens) AS completion_tokens, " +
+		"SUM(embedding_tokens) AS embedding_tokens, SUM(cost) AS cost")
+	if q.OrgId != 0 {
+		db = db.Where("org_id = ?", q.OrgId)
+	} else {
+		db = db.Where("user_id = ?", q.UserId)
+	}
+	if q.ProjectId != 0 {
+		db = db.Where("project_id = ?", q.ProjectId)
+	}
+	if !q.From.IsZero() {
+		db = db.Where("created_at >= ?", q.From)
+	}
+	if !q.To.IsZero() {
+		db = db.Where("created_at < ?", q.To)
+	}
+
+	data := make([]UsageSummary, 0)
+	err := db.Group("`key`").Order("`key` asc").Scan(&data).Error
+	return data, err
+}
+
+// 用户从 since 起在个人项目上的花费
+func UserSpendSince(userId uint, since time.Time) float64 {
+	var total float64
+	utils.DB.Model(&UsageRecord{}).Select("COALESCE(SUM(cost), 0)").
+		Where("user_id = ? and org_id = 0 and created_at >= ?", userId, since).Scan(&total)
+	return total
+}
+
+// 组织从 since 起的花费
+func OrgSpendSince(orgId uint, since time.Time) float64 {
+	var total float64
+	utils.DB.Model(&UsageRecord{}).Select("COALESCE(SUM(cost), 0)").
+		Where("org_id = ? and created_at >= ?", orgId, since).Scan(&total)
+	return total
+}
+
+// 查找用户的预算设置
+func FindUserBudget(userId uint) (UserBudget, error) {
+	budget := UserBudget{}
+	err := utils.DB.Where("user_id = ?", userId).First(&budget).Error
+	return budget, err
+}
+
+// 保存用户的预算设置
+func SaveUserBudget(userId uint, limit float64, action string) (UserBudget, error) {
+	budget, err := FindUserBudget(userId)
+	if err != nil {
+		budget = UserBudget{UserId: userId}
+	}
+	budget.MonthlyLimit = limit
+	budget.Action = action
+	return budget, utils.DB.Save(&budget).Error
+}
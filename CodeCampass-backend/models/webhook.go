@@ -0,0 +1,74 @@
+package models
+
+import (
+	"CodeCampass/utils"
+	"time"
+)
+
+// webhook 推送的处理结果
+const (
+	WebhookQueued   = "queued"   // 已触发同步
+	WebhookIgnored  = "ignored"  // 非跟踪分支的推送或不处理的事件
+	WebhookRejected = "rejected" // 签名或令牌校验失败
+	WebhookFailed   = "failed"   // 触发同步失败
+)
+
+// 每个项目保留的推送记录数。校验失败的请求无需密钥即可发送，单独计数，
+// 大量伪造的请求不会挤掉正常的推送记录
+const (
+	maxWebhookDeliveries  = 100
+	maxRejectedDeliveries = 20
+)
+
+// WebhookDelivery 一次 webhook 推送的记录，用于排查
+type WebhookDelivery struct {
+	ID         uint      `gorm:"primarykey" json:"id"`
+	ProjectId  uint      `gorm:"index" json:"project_id"`
+	Provider   string    `json:"provider"`    // github、gitlab、gitea
+	Event      string    `json:"event"`       // 平台的事件名，如 push、Push Hook
+	DeliveryId string    `json:"delivery_id"` // 平台为每次推送生成的ID
+	Ref        string    `json:"ref"`
+	Commit     string    `json:"commit"`
+	Status     string    `json:"status"`
+	StatusCode int       `json:"status_code"` // 返回给平台的 HTTP 状态码
+	Message    string    `gorm:"size:1024" json:"message"`
+	RemoteAddr string    `json:"remote_addr"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+func (table *WebhookDelivery) TableName() string {
+	return "webhook_delivery"
+}
+
+// 记录一次推送，校验失败的与其他推送分别只保留项目最近的若干条
+func CreateWebhookDelivery(d *WebhookDelivery) error {
+	d.Message = truncateUTF8(d.Message, 1024)
+	if err := utils.DB.Create(d).Error; err != nil {
+		return err
+	}
+	query := utils.DB.Model(&WebhookDelivery{}).Where("project_id = ?", d.ProjectId)
+	keep := maxWebhookDeliveries
+	if d.Status == WebhookRejected {
+		query, keep = query.Where("status = ?", WebhookRejected), maxRejectedDeliveries
+	} else {
+		query = query.Where("status <> ?", WebhookRejected)
+	}
+	var ids []uint
+	query.Order("id desc").Offset(keep).Pluck("id", &ids)
+	if len(ids) > 0 {
+		utils.DB.Where("id in ?", ids).Delete(&WebhookDelivery{})
+	}
+	return nil
+}
+
+// 项目最近的推送记录，按时间倒序
+func GetWebhookDeliveries(projectId uint, limit int) []WebhookDelivery {
+	list := make([]WebhookDelivery, 0)
+	utils.DB.Where("project_id = ?", projectId).Order("id desc").Limit(limit).Find(&list)
+	return list
+}
+
+// 删除项目的推送记录
+func DeleteWebhookDeliveries(projectId uint) error {
+	return utils.DB.Where("project_id = ?", projectId).Delete(&WebhookDelivery{}).Error
+}
@@ -31,23 +31,104 @@ func Router() *gin.Engine {
 		user.GET("/getUserInfo", service.GetUserInfo)
 	}
 
-	//项目模块
+	//项目模块（旧接口，迁移期间保留，请改用 /api/v1）
 	api := r.Group("/api")
 	api.Use(middleware.AuthMiddleware())
 	{
-		api.POST("/createProject", service.CreateProject)
-		api.GET("/listProjects", service.ListProjects)
-		api.PUT("/updateProject", service.UpdateProject)
-		api.DELETE("/deleteProject", service.DeleteProject)
-		api.GET("/getProjectInfo", service.GetProjectInfo)
-		api.POST("/importProjectRepo", service.ImportProjectRepo)
-		api.POST("/askProject", service.AskProject)
-		api.GET("/getProjectFiles", service.GetProjectFiles)
-		api.GET("/getFileContent", service.GetFileContent)
+		api.POST("/createProject", middleware.Deprecated("/api/v1/projects"), service.CreateProject)
+		api.GET("/listProjects", middleware.Deprecated("/api/v1/projects"), service.ListProjects)
+		api.PUT("/updateProject", middleware.Deprecated("/api/v1/projects/{id}"), service.UpdateProject)
+		api.DELETE("/deleteProject", middleware.Deprecated("/api/v1/projects/{id}"), service.DeleteProject)
+		api.GET("/getProjectInfo", middleware.Deprecated("/api/v1/projects/{id}"), service.GetProjectInfo)
+		api.POST("/importProjectRepo", middleware.Deprecated("/api/v1/projects/{id}/import"), service.ImportProjectRepo)
+		api.POST("/askProject", middleware.Deprecated("/api/v1/projects/{id}/ask"), service.AskProject)
+		api.GET("/getProjectFiles", middleware.Deprecated("/api/v1/projects/{id}/files"), service.GetProjectFiles)
+		api.GET("/getFileContent", middleware.Deprecated("/api/v1/projects/{id}/files/content"), service.GetFileContent)
 		api.GET("/getOpenAIKey", service.GetOpenAIKey)
 		api.POST("/setOpenAIKey", service.SetOpenAIKey)
 		api.DELETE("/deleteOpenAIKey", service.DeleteOpenAIKey)
-		api.GET("/subscribeProjectEvents", service.SubscribeProjectEvents)
+		api.GET("/listCredentials", service.ListCredentials)
+		api.POST("/createCredential", service.CreateCredential)
+		api.PUT("/updateCredential", service.UpdateCredential)
+		api.DELETE("/deleteCredential", service.DeleteCredential)
+		api.PUT("/setDefaultCredential", service.SetDefaultCredential)
+		api.PUT("/setProjectCredential", service.SetProjectCredential)
+		api.GET("/subscribeProjectEvents", middleware.Deprecated("/api/v1/projects/{id}/events"), service.SubscribeProjectEvents)
+		api.POST("/transferProject", service.TransferProject)
+	}
+
+	//组织模块
+	{
+		api.POST("/createOrganization", service.CreateOrganization)
+		api.GET("/listOrganizations", service.ListOrganizations)
+		api.GET("/getOrganizationInfo", service.GetOrganizationInfo)
+		api.DELETE("/deleteOrganization", service.DeleteOrganization)
+		api.POST("/addOrgMember", service.AddOrgMember)
+		api.DELETE("/removeOrgMember", service.RemoveOrgMember)
+		api.POST("/setOrgOpenAIKey", service.SetOrgOpenAIKey)
+		api.DELETE("/deleteOrgOpenAIKey", service.DeleteOrgOpenAIKey)
+		api.PUT("/setOrgSpendingLimit", service.SetOrgSpendingLimit)
+	}
+	//项目模块 v1：按项目ID寻址，JSON 请求体，统一 {code,message,data} 响应
+	v1 := r.Group("/api/v1")
+	// EventSource 无法携带 Header，事件流接口在处理器内自行校验 token
+	v1.GET("/projects/:id/events", service.SubscribeProjectEventsV1)
+	// 代码托管平台的推送通知，以签名或令牌认证
+	v1.POST("/hooks/projects/:id", service.ReceiveWebhookV1)
+	// 渲染后的 Markdown 中图片的签名地址，<img> 无法携带 Header
+	v1.GET("/raw/projects/:id", service.GetSignedFileRawV1)
+	v1.Use(middleware.AuthMiddleware())
+	projects := v1.Group("/projects")
+	{
+		projects.GET("", service.ListProjectsV1)
+		projects.POST("", service.CreateProjectV1)
+		projects.GET("/:id", service.GetProjectV1)
+		projects.PATCH("/:id", service.UpdateProjectV1)
+		projects.DELETE("/:id", service.DeleteProjectV1)
+		projects.POST("/:id/import", service.ImportProjectV1)
+		projects.POST("/:id/ask", service.AskProjectV1)
+		projects.GET("/:id/files", service.ListProjectFilesV1)
+		projects.GET("/:id/tree", service.ListProjectTreeV1)
+		projects.GET("/:id/files/content", service.GetFileContentV1)
+		projects.GET("/:id/files/raw", service.GetFileRawV1)
+		projects.GET("/:id/archive", service.DownloadArchiveV1)
+		projects.GET("/:id/snapshots", service.ListSnapshotsV1)
+		projects.POST("/:id/snapshots", service.CreateSnapshotV1)
+		projects.GET("/:id/snapshots/:name", service.DownloadSnapshotV1)
+		projects.DELETE("/:id/snapshots/:name", service.DeleteSnapshotV1)
+		projects.POST("/:id/explain", service.ExplainCodeV1)
+		projects.POST("/:id/review", service.ReviewCodeV1)
+		projects.GET("/:id/overview", service.GetProjectOverviewV1)
+		projects.POST("/:id/overview/refresh", service.RefreshProjectOverviewV1)
+		projects.GET("/:id/commits", service.ListCommitsV1)
+		projects.GET("/:id/commits/:sha", service.GetCommitV1)
+		projects.GET("/:id/blame", service.GetBlameV1)
+		projects.GET("/:id/search", service.SearchCodeV1)
+		projects.GET("/:id/symbols", service.SearchSymbolsV1)
+		projects.GET("/:id/definition", service.GetDefinitionV1)
+		projects.GET("/:id/references", service.GetReferencesV1)
+		projects.GET("/:id/graphs", service.ListGraphSnapshotsV1)
+		projects.GET("/:id/callgraph", service.GetCallGraphV1)
+		projects.GET("/:id/packages/graph", service.GetPackageGraphV1)
+		projects.GET("/:id/sync", service.GetProjectSyncV1)
+		projects.PUT("/:id/sync", service.UpdateProjectSyncV1)
+		projects.POST("/:id/sync/run", service.RunProjectSyncV1)
+		projects.GET("/:id/webhook", service.GetWebhookV1)
+		projects.POST("/:id/webhook/secret", service.ResetWebhookSecretV1)
+		projects.GET("/:id/webhook/deliveries", service.ListWebhookDeliveriesV1)
+		projects.GET("/:id/notifications", service.ListNotificationsV1)
+		projects.POST("/:id/notifications", service.CreateNotificationV1)
+		projects.PATCH("/:id/notifications/:hookId", service.UpdateNotificationV1)
+		projects.DELETE("/:id/notifications/:hookId", service.DeleteNotificationV1)
+		projects.POST("/:id/notifications/:hookId/test", service.TestNotificationV1)
+		projects.GET("/:id/notifications/:hookId/deliveries", service.ListNotificationDeliveriesV1)
+	}
+	usage := v1.Group("/usage")
+	{
+		usage.GET("", service.GetUsageV1)
+		usage.GET("/budget", service.GetBudgetV1)
+		usage.PUT("/budget", service.UpdateBudgetV1)
+		usage.GET("/quota", service.GetQuotaV1)
 	}
 	return r
 }
@@ -0,0 +1,498 @@
+package service
+
+import (
+	"CodeCampass/models"
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/spf13/viper"
+)
+
+// agent 模式：LLM 通过 function calling 调用仓库工具，逐步收集上下文后回答
+
+const (
+	defaultAgentMaxSteps = 8
+	maxAgentToolOutput   = 8000 // 返回给模型的工具结果长度上限
+	maxAgentTraceOutput  = 1000 // 返回给客户端的调用记录中工具结果的长度上限
+	maxAgentReadLines    = 400
+	maxAgentListEntries  = 200
+	maxAgentGrepResults  = 100
+)
+
+// ChatCompleter 可以发起对话补全的客户端，meteredClient 与 ScriptedLLM 都实现了它
+type ChatCompleter interface {
+	CreateChatCompletion(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error)
+}
+
+// AgentStep 一次工具调用的记录
+type AgentStep struct {
+	Step      int    `json:"step"`
+	Tool      string `json:"tool"`
+	Arguments string `json:"arguments"`
+	Result    string `json:"result"`
+	Error     string `json:"error,omitempty"`
+}
+
+// AgentResult agent 模式的回答与工具调用记录
+type AgentResult struct {
+	Answer string      `json:"answer"`
+	Steps  []AgentStep `json:"steps"`
+	// 结束原因：answered 模型给出了回答，max_steps 达到步数上限后被要求直接回答
+	StopReason string `json:"stop_reason"`
+}
+
+// AgentWorkspace 工具操作的仓库。符号与语义检索依赖数据库中的索引，
+// 为空时对应的工具会告知模型不可用
+type AgentWorkspace struct {
+	BaseDir        string
+	SearchSymbols  func(query string, kinds []string, limit int) []models.CodeSymbol
+	SemanticSearch func(ctx context.Context, query string, k int) ([]scoredChunk, error)
+}
+
+// NewLocalWorkspace 基于本地目录的工作区，符号索引在内存中构建，不支持语义检索
+func NewLocalWorkspace(baseDir string) (*AgentWorkspace, error) {
+	_, syms, err := buildGoSymbolIndex(models.Project{}, baseDir)
+	if err != nil {
+		return nil, err
+	}
+	return &AgentWorkspace{
+		BaseDir: baseDir,
+		SearchSymbols: func(query string, kinds []string, limit int) []models.CodeSymbol {
+			return rankSymbols(syms, query, kinds, limit)
+		},
+	}, nil
+}
+
+// projectWorkspace 项目仓库的工作区
+func projectWorkspace(proj models.Project, client *meteredClient, embeddingModel string) *AgentWorkspace {
+	baseDir := repoBaseDir(proj)
+	return &AgentWorkspace{
+		BaseDir: baseDir,
+		SearchSymbols: func(query string, kinds []string, limit int) []models.CodeSymbol {
+			syms, err := models.FindProjectSymbols(proj.ID, kinds, "")
+			if err != nil {
+				return nil
+			}
+			return rankSymbols(syms, query, kinds, limit)
+		},
+		SemanticSearch: func(ctx context.Context, query string, k int) ([]scoredChunk, error) {
+			return semanticSearch(ctx, client, proj, embeddingModel, query, k)
+		},
+	}
+}
+
+// rankSymbols 按模糊匹配得分排序符号
+func rankSymbols(syms []models.CodeSymbol, query string, kinds []string, limit int) []models.CodeSymbol {
+	kindSet := map[string]bool{}
+	for _, k := range kinds {
+		kindSet[k] = true
+	}
+	items := make([]scoredSymbol, 0)
+	for _, sym := range syms {
+		if len(kindSet) > 0 && !kindSet[sym.Kind] {
+			continue
+		}
+		if score, ok := symbolScore(query, sym); ok {
+			items = append(items, scoredSymbol{CodeSymbol: sym, Score: score})
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool { return items[i].Score > items[j].Score })
+	if len(items) > limit {
+		items = items[:limit]
+	}
+	res := make([]models.CodeSymbol, 0, len(items))
+	for _, item := range items {
+		res = append(res, item.CodeSymbol)
+	}
+	return res
+}
+
+// resolve 把仓库内的相对路径转换为绝对路径，拒绝越出仓库目录的路径与指向仓库外的符号链接
+func (ws *AgentWorkspace) resolve(rel string) (string, error) {
+	full, err := resolveInRepo(ws.BaseDir, rel)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("%s 不存在", rel)
+	}
+	if err != nil {
+		return "", fmt.Errorf("路径 %s 不在仓库内", rel)
+	}
+	return full, nil
+}
+
+// agentTools 提供给模型的工具定义
+var agentTools = []openai.Tool{
+	agentTool("list_dir", "列出仓库中某个目录下的文件与子目录，目录以 / 结尾", `{
+		"type": "object",
+		"properties": {"path": {"type": "string", "description": "相对仓库根目录的路径，根目录为 ."}},
+		"required": ["path"]}`),
+	agentTool("read_file", "读取文件的指定行范围，返回带行号的内容", `{
+		"type": "object",
+		"properties": {
+			"path": {"type": "string"},
+			"start_line": {"type": "integer", "description": "起始行，从 1 开始"},
+			"end_line": {"type": "integer", "description": "结束行（包含），一次最多 400 行"}},
+		"required": ["path"]}`),
+	agentTool("grep", "在仓库中用正则表达式（RE2 语法）搜索代码，返回 文件:行号: 内容", `{
+		"type": "object",
+		"properties": {
+			"pattern": {"type": "string"},
+			"path_glob": {"type": "string", "description": "只搜索匹配该 glob 的文件，如 *.go 或 service/*.go"},
+			"max_results": {"type": "integer"}},
+		"required": ["pattern"]}`),
+	agentTool("search_symbols", "按名字模糊搜索 Go 符号（包、类型、函数、方法、字段、常量、变量），返回定义位置与签名", `{
+		"type": "object",
+		"properties": {
+			"query": {"type": "string", "description": "符号名，可写作 Type.Method"},
+			"kind": {"type": "string", "description": "可选：package/type/func/method/field/const/var"}},
+		"required": ["query"]}`),
+	agentTool("semantic_search", "按语义检索与描述最相关的代码片段", `{
+		"type": "object",
+		"properties": {
+			"query": {"type": "string"},
+			"k": {"type": "integer", "description": "返回片段数，默认 3"}},
+		"required": ["query"]}`),
+}
+
+func agentTool(name, description, schema string) openai.Tool {
+	return openai.Tool{
+		Type: openai.ToolTypeFunction,
+		Function: &openai.FunctionDefinition{
+			Name:        name,
+			Description: description,
+			Parameters:  json.RawMessage(schema),
+		},
+	}
+}
+
+// runTool 执行一次工具调用
+func (ws *AgentWorkspace) runTool(ctx context.Context, name, arguments string) (string, error) {
+	var args struct {
+		Path       string `json:"path"`
+		StartLine  int    `json:"start_line"`
+		EndLine    int    `json:"end_line"`
+		Pattern    string `json:"pattern"`
+		PathGlob   string `json:"path_glob"`
+		MaxResults int    `json:"max_results"`
+		Query      string `json:"query"`
+		Kind       string `json:"kind"`
+		K          int    `json:"k"`
+	}
+	if strings.TrimSpace(arguments) != "" {
+		if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+			return "", fmt.Errorf("参数不是合法的 JSON: %v", err)
+		}
+	}
+
+	switch name {
+	case "list_dir":
+		return ws.listDir(args.Path)
+	case "read_file":
+		return ws.readFile(args.Path, args.StartLine, args.EndLine)
+	case "grep":
+		return ws.grep(args.Pattern, args.PathGlob, args.MaxResults)
+	case "search_symbols":
+		if ws.SearchSymbols == nil {
+			return "", fmt.Errorf("符号索引不可用")
+		}
+		var kinds []string
+		if args.Kind != "" {
+			kinds = []string{args.Kind}
+		}
+		syms := ws.SearchSymbols(args.Query, kinds, 20)
+		if len(syms) == 0 {
+			return "没有找到匹配的符号", nil
+		}
+		var b strings.Builder
+		for _, s := range syms {
+			fmt.Fprintf(&b, "%s:%d %s %s\n", s.FilePath, s.Line, s.Kind, s.Signature)
+		}
+		return b.String(), nil
+	case "semantic_search":
+		if ws.SemanticSearch == nil {
+			return "", fmt.Errorf("语义检索不可用")
+		}
+		k := args.K
+		if k <= 0 || k > 10 {
+			k = 3
+		}
+		chunks, err := ws.SemanticSearch(ctx, args.Query, k)
+		if err != nil {
+			return "", err
+		}
+		var b strings.Builder
+		for _, chunk := range chunks {
+			rel := strings.TrimPrefix(chunk.Path, ws.BaseDir+"/")
+			fmt.Fprintf(&b, "[文件: %s 相似度 %.3f]\n%s\n\n", rel, chunk.Score, chunk.Content)
+		}
+		return b.String(), nil
+	}
+	return "", fmt.Errorf("未知的工具 %s", name)
+}
+
+func (ws *AgentWorkspace) listDir(rel string) (string, error) {
+	if rel == "" {
+		rel = "."
+	}
+	full, err := ws.resolve(rel)
+	if err != nil {
+		return "", err
+	}
+	entries, err := os.ReadDir(full)
+	if err != nil {
+		return "", fmt.Errorf("无法读取目录 %s", rel)
+	}
+
+	var b strings.Builder
+	count := 0
+	for _, e := range entries {
+		if e.Name() == ".git" {
+			continue
+		}
+		if count == maxAgentListEntries {
+			fmt.Fprintf(&b, "...（共 %d 项，仅列出前 %d 项）\n", len(entries), maxAgentListEntries)
+			break
+		}
+		if e.IsDir() {
+			b.WriteString(e.Name() + "/\n")
+		} else {
+			b.WriteString(e.Name() + "\n")
+		}
+		count++
+	}
+	return b.String(), nil
+}
+
+func (ws *AgentWorkspace) readFile(rel string, start, end int) (string, error) {
+	full, err := ws.resolve(rel)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(full)
+	if err != nil {
+		return "", fmt.Errorf("无法读取文件 %s", rel)
+	}
+	defer f.Close()
+
+	if start <= 0 {
+		start = 1
+	}
+	if end <= 0 || end < start {
+		end = start + 199
+	}
+	if end-start+1 > maxAgentReadLines {
+		end = start + maxAgentReadLines - 1
+	}
+
+	var b strings.Builder
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), maxNavFileSize)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if line < start {
+			continue
+		}
+		if line > end {
+			fmt.Fprintf(&b, "...（文件未完，可继续读取第 %d 行之后的内容）\n", end)
+			break
+		}
+		fmt.Fprintf(&b, "%d\t%s\n", line, scanner.Text())
+	}
+	if line < start {
+		return "", fmt.Errorf("文件只有 %d 行", line)
+	}
+	return b.String(), nil
+}
+
+func (ws *AgentWorkspace) grep(pattern, glob string, maxResults int) (string, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", fmt.Errorf("正则表达式无效: %v", err)
+	}
+	if maxResults <= 0 || maxResults > maxAgentGrepResults {
+		maxResults = 50
+	}
+
+	var b strings.Builder
+	count := 0
+	filepath.Walk(ws.BaseDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() {
+			if p != ws.BaseDir && skipSourceDir(info.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, _ := filepath.Rel(ws.BaseDir, p)
+		rel = filepath.ToSlash(rel)
+		if glob != "" {
+			matched, _ := path.Match(glob, rel)
+			baseMatched, _ := path.Match(glob, path.Base(rel))
+			if !matched && !baseMatched {
+				return nil
+			}
+		}
+		// 符号链接可能指向仓库外，只搜索普通文件
+		if !info.Mode().IsRegular() || info.Size() > maxNavFileSize {
+			return nil
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return nil
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), maxNavFileSize)
+		for line := 1; scanner.Scan(); line++ {
+			if text := scanner.Text(); re.MatchString(text) {
+				fmt.Fprintf(&b, "%s:%d: %s\n", rel, line, strings.TrimSpace(text))
+				count++
+				if count >= maxResults {
+					return filepath.SkipAll
+				}
+			}
+		}
+		return nil
+	})
+	if count == 0 {
+		return "没有匹配结果", nil
+	}
+	return b.String(), nil
+}
+
+// truncateText 截断交给模型的工具输出，不截断多字节字符
+func truncateText(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return truncateUTF8(s, n) + "...(已截断)"
+}
+
+const agentSystemPrompt = `你是代码与软件架构专家，正在帮助用户理解一个代码仓库。
+你可以调用工具浏览目录、读取文件、grep 搜索、查找符号和语义检索。
+先用工具收集足够的证据再回答，回答时引用相关的文件路径与行号。不要编造仓库中不存在的代码。`
+
+// RunAgent 运行 agent 循环：每一步把对话交给模型，模型请求工具时执行并把结果追加到对话，
+// 模型不再请求工具时结束；达到 maxSteps 后不再提供工具，要求模型直接回答
+func RunAgent(ctx context.Context, llm ChatCompleter, ws *AgentWorkspace, model, question string, maxSteps int) (AgentResult, error) {
+	messages := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: agentSystemPrompt},
+		{Role: openai.ChatMessageRoleUser, Content: question},
+	}
+	result := AgentResult{Steps: []AgentStep{}}
+
+	for step := 1; step <= maxSteps; step++ {
+		resp, err := llm.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+			Model:    model,
+			Messages: messages,
+			Tools:    agentTools,
+		})
+		if err != nil {
+			return result, fmt.Errorf("LLM调用失败: %v", err)
+		}
+		if len(resp.Choices) == 0 {
+			return result, fmt.Errorf("LLM 没有返回结果")
+		}
+
+		msg := resp.Choices[0].Message
+		messages = append(messages, msg)
+		if len(msg.ToolCalls) == 0 {
+			result.Answer = msg.Content
+			result.StopReason = "answered"
+			return result, nil
+		}
+
+		for _, call := range msg.ToolCalls {
+			output, err := ws.runTool(ctx, call.Function.Name, call.Function.Arguments)
+			trace := AgentStep{Step: step, Tool: call.Function.Name, Arguments: call.Function.Arguments}
+			if err != nil {
+				trace.Error = err.Error()
+				output = "工具调用失败: " + err.Error()
+			}
+			trace.Result = truncateText(output, maxAgentTraceOutput)
+			result.Steps = append(result.Steps, trace)
+
+			messages = append(messages, openai.ChatCompletionMessage{
+				Role:       openai.ChatMessageRoleTool,
+				Content:    truncateText(output, maxAgentToolOutput),
+				Name:       call.Function.Name,
+				ToolCallID: call.ID,
+			})
+		}
+	}
+
+	// 步数用尽，不再提供工具
+	messages = append(messages, openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleUser,
+		Content: "已达到工具调用次数上限，请根据目前收集到的信息直接回答问题。",
+	})
+	resp, err := llm.CreateChatCompletion(ctx, openai.ChatCompletionRequest{Model: model, Messages: messages})
+	if err != nil {
+		return result, fmt.Errorf("LLM调用失败: %v", err)
+	}
+	if len(resp.Choices) == 0 {
+		return result, fmt.Errorf("LLM 没有返回结果")
+	}
+	result.Answer = resp.Choices[0].Message.Content
+	result.StopReason = "max_steps"
+	return result, nil
+}
+
+// agentMaxSteps 请求的步数上限，不超过配置 agent.maxSteps（默认 8）
+func agentMaxSteps(requested int) int {
+	limit := viper.GetInt("agent.maxSteps")
+	if limit <= 0 {
+		limit = defaultAgentMaxSteps
+	}
+	if requested <= 0 || requested > limit {
+		return limit
+	}
+	return requested
+}
+
+// askProjectAgent 以 agent 模式回答项目问题；预算降级时换用便宜的模型并把步数限制为 2
+func askProjectAgent(proj models.Project, userID interface{}, question string, maxSteps int) (AgentResult, error) {
+	uid := toUserID(userID)
+	cfg, err := resolveLLMConfig(proj, uid)
+	if err != nil {
+		return AgentResult{}, err
+	}
+	if proj.IndexStatus == models.IndexStatusNone {
+		return AgentResult{}, newAPIError(http.StatusConflict, "项目尚未导入仓库")
+	}
+
+	maxSteps = agentMaxSteps(maxSteps)
+	if budget := projectBudgetStatus(proj, uid); budget.Exceeded(0) {
+		if !budget.Degraded() {
+			return AgentResult{}, budget.budgetError()
+		}
+		cfg = degradeConfig(cfg)
+		if maxSteps > 2 {
+			maxSteps = 2
+		}
+	}
+
+	client := cfg.newMeteredClient(projectUsageScope(proj, uid))
+	ws := projectWorkspace(proj, client, cfg.EmbeddingModel)
+	result, err := RunAgent(context.Background(), client, ws, cfg.ChatModel, question, maxSteps)
+	if err != nil {
+		return result, err
+	}
+
+	markProjectAsked(proj)
+	return result, nil
+}
@@ -0,0 +1,50 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// ScriptedLLM 按预先编排的回复依次应答的假 LLM，用于在不调用真实模型的情况下演练 agent 流程，
+// 见 tests/agent_harness
+type ScriptedLLM struct {
+	Replies []openai.ChatCompletionMessage
+
+	mu       sync.Mutex
+	Requests []openai.ChatCompletionRequest // 收到的请求，便于检查工具结果是否正确回传
+}
+
+// ScriptToolCall 编排一次工具调用
+func ScriptToolCall(id, name, arguments string) openai.ChatCompletionMessage {
+	return openai.ChatCompletionMessage{
+		Role: openai.ChatMessageRoleAssistant,
+		ToolCalls: []openai.ToolCall{{
+			ID:       id,
+			Type:     openai.ToolTypeFunction,
+			Function: openai.FunctionCall{Name: name, Arguments: arguments},
+		}},
+	}
+}
+
+// ScriptAnswer 编排最终回答
+func ScriptAnswer(content string) openai.ChatCompletionMessage {
+	return openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: content}
+}
+
+func (s *ScriptedLLM) CreateChatCompletion(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := len(s.Requests)
+	s.Requests = append(s.Requests, req)
+	if n >= len(s.Replies) {
+		return openai.ChatCompletionResponse{}, fmt.Errorf("脚本只编排了 %d 次回复，收到第 %d 次请求", len(s.Replies), n+1)
+	}
+	return openai.ChatCompletionResponse{
+		Model:   req.Model,
+		Choices: []openai.ChatCompletionChoice{{Message: s.Replies[n], FinishReason: openai.FinishReasonStop}},
+	}, nil
+}
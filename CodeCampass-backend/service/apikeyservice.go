@@ -1,10 +1,13 @@
 package service
 
 import (
+	"CodeCampass/models"
 	"CodeCampass/utils"
 	"fmt"
 	"net/http"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -23,17 +26,11 @@ func GetOpenAIKey(c *gin.Context) {
 		return
 	}
 
-	// 从 Redis 获取用户的 API Key
-	key := utils.Red.Get(utils.Red.Context(), fmt.Sprintf("openai_key:%d", userID)).Val()
-
-	// 如果 Redis 中没有，从环境变量获取（向后兼容）
-	if key == "" {
-		key = os.Getenv("OPENAI_API_KEY")
-	}
+	key := getOpenAIKey(userID)
 
 	// 返回（如果设置了，只返回前4位和后4位，中间用*代替）
 	if key != "" && len(key) > 8 {
-		maskedKey := key[:4] + "****" + key[len(key)-4:]
+		maskedKey := maskKey(key)
 		c.JSON(200, gin.H{
 			"code":    0,
 			"message": "获取成功",
@@ -80,8 +77,8 @@ func SetOpenAIKey(c *gin.Context) {
 		return
 	}
 
-	// 保存到 Redis（按用户存储）
-	err := utils.Red.Set(utils.Red.Context(), fmt.Sprintf("openai_key:%d", userID), key, 0).Err()
+	// 加密后保存到 Redis（按用户存储）
+	err := utils.SetSecret(utils.Red.Context(), fmt.Sprintf("openai_key:%d", userID), key)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"code":    -1,
@@ -112,7 +109,7 @@ func DeleteOpenAIKey(c *gin.Context) {
 	}
 
 	// 从 Redis 删除
-	err := utils.Red.Del(utils.Red.Context(), fmt.Sprintf("openai_key:%d", userID)).Err()
+	err := utils.DelSecret(utils.Red.Context(), fmt.Sprintf("openai_key:%d", userID))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"code":    -1,
@@ -134,9 +131,12 @@ func getOpenAIKey(userID interface{}) string {
 		return ""
 	}
 
-	// 先从 Redis 获取
-	key := utils.Red.Get(utils.Red.Context(), fmt.Sprintf("openai_key:%d", userID)).Val()
-	
+	// 先从 Redis 获取并解密
+	key, err := utils.GetSecret(utils.Red.Context(), fmt.Sprintf("openai_key:%d", userID))
+	if err != nil {
+		fmt.Println("读取 API Key 失败:", err)
+	}
+
 	// 如果 Redis 中没有，从环境变量获取（向后兼容）
 	if key == "" {
 		key = os.Getenv("OPENAI_API_KEY")
@@ -145,3 +145,364 @@ func getOpenAIKey(userID interface{}) string {
 	return key
 }
 
+// getOrgOpenAIKey 获取组织的 OpenAI API Key（内部函数）
+func getOrgOpenAIKey(orgID uint) string {
+	key, err := utils.GetSecret(utils.Red.Context(), fmt.Sprintf("openai_key:org:%d", orgID))
+	if err != nil {
+		fmt.Println("读取组织 API Key 失败:", err)
+	}
+	return key
+}
+
+// credentialRequest 创建/修改凭证的请求参数，支持 JSON 或表单提交（避免密钥出现在 URL 中）
+type credentialRequest struct {
+	Name           *string `json:"name" form:"name"`
+	Provider       *string `json:"provider" form:"provider"`
+	BaseUrl        *string `json:"base_url" form:"base_url"`
+	Key            *string `json:"key" form:"key"`
+	ChatModel      *string `json:"chat_model" form:"chat_model"`
+	EmbeddingModel *string `json:"embedding_model" form:"embedding_model"`
+	IsDefault      bool    `json:"is_default" form:"is_default"`
+}
+
+// ListCredentials
+// @Summary 列出当前用户的 LLM 凭证
+// @Tags 项目模块
+// @Security Bearer
+// @Success 200 {object} map[string]interface{}
+// @Router /api/listCredentials [get]
+func ListCredentials(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户未登录"})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"code":    0,
+		"message": "获取成功",
+		"data":    models.GetUserCredentialList(userID.(uint)),
+	})
+}
+
+// CreateCredential
+// @Summary 新增 LLM 凭证（保存前会发起一次测试调用验证）
+// @Tags 项目模块
+// @Security Bearer
+// @Param body body credentialRequest true "凭证信息，provider 可选 openai/azure/compatible"
+// @Success 200 {object} map[string]interface{}
+// @Router /api/createCredential [post]
+func CreateCredential(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户未登录"})
+		return
+	}
+
+	var req credentialRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": -1, "message": "参数格式错误"})
+		return
+	}
+	if req.Name == nil || *req.Name == "" || req.Key == nil || *req.Key == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"code": -1, "message": "名称和密钥不能为空"})
+		return
+	}
+
+	same := models.ProviderCredential{}
+	utils.DB.Where("user_id = ? and name = ?", userID, *req.Name).First(&same)
+	if same.Name != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"code": -1, "message": "凭证名称不可重复"})
+		return
+	}
+
+	cred := models.ProviderCredential{UserId: userID.(uint), Provider: models.ProviderOpenAI}
+	applyCredentialRequest(&cred, req)
+	if err := checkCredential(cred); err != nil {
+		c.JSON(errorStatus(err), gin.H{"code": -1, "message": err.Error()})
+		return
+	}
+
+	cfg := configFromCredential(cred, *req.Key)
+	if err := validateLLMConfig(cfg); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": -1, "message": fmt.Sprintf("凭证验证失败: %v", err)})
+		return
+	}
+	now := time.Now()
+	cred.ValidatedAt = &now
+	cred.KeyMask = maskKey(*req.Key)
+
+	if err := utils.DB.Create(&cred).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": -1, "message": "保存失败"})
+		return
+	}
+	if err := utils.SetSecret(utils.Red.Context(), credentialKeyName(cred.ID), *req.Key); err != nil {
+		utils.DB.Unscoped().Delete(&cred)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    -1,
+			"message": "保存失败",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	// 第一个凭证自动成为默认凭证
+	if _, err := models.FindDefaultCredential(cred.UserId); req.IsDefault || err != nil {
+		models.SetDefaultCredential(cred.UserId, cred.ID)
+		cred.IsDefault = true
+	}
+
+	c.JSON(200, gin.H{
+		"code":    0,
+		"message": "凭证保存成功",
+		"data":    cred,
+	})
+}
+
+// UpdateCredential
+// @Summary 修改 LLM 凭证（修改密钥、地址或模型时会重新验证）
+// @Tags 项目模块
+// @Security Bearer
+// @Param id query int true "凭证ID"
+// @Param body body credentialRequest true "要修改的字段"
+// @Success 200 {object} map[string]interface{}
+// @Router /api/updateCredential [put]
+func UpdateCredential(c *gin.Context) {
+	cred, ok := loadUserCredential(c)
+	if !ok {
+		return
+	}
+
+	var req credentialRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": -1, "message": "参数格式错误"})
+		return
+	}
+	if req.Name != nil && *req.Name != cred.Name {
+		same := models.ProviderCredential{}
+		utils.DB.Where("user_id = ? and name = ? and id <> ?", cred.UserId, *req.Name, cred.ID).First(&same)
+		if same.Name != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"code": -1, "message": "凭证名称不可重复"})
+			return
+		}
+	}
+
+	applyCredentialRequest(&cred, req)
+	if err := checkCredential(cred); err != nil {
+		c.JSON(errorStatus(err), gin.H{"code": -1, "message": err.Error()})
+		return
+	}
+
+	// 除名称外的任何改动都需要重新验证
+	if req.Provider != nil || req.BaseUrl != nil || req.Key != nil || req.ChatModel != nil || req.EmbeddingModel != nil {
+		key := ""
+		if req.Key != nil && *req.Key != "" {
+			key = *req.Key
+		} else {
+			stored, err := utils.GetSecret(utils.Red.Context(), credentialKeyName(cred.ID))
+			if err != nil || stored == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"code": -1, "message": "原密钥不可用，请重新填写密钥"})
+				return
+			}
+			key = stored
+		}
+
+		if err := validateLLMConfig(configFromCredential(cred, key)); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"code": -1, "message": fmt.Sprintf("凭证验证失败: %v", err)})
+			return
+		}
+		now := time.Now()
+		cred.ValidatedAt = &now
+
+		if req.Key != nil && *req.Key != "" {
+			if err := utils.SetSecret(utils.Red.Context(), credentialKeyName(cred.ID), key); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"code":    -1,
+					"message": "保存失败",
+					"error":   err.Error(),
+				})
+				return
+			}
+			cred.KeyMask = maskKey(key)
+		}
+	}
+
+	if err := utils.DB.Save(&cred).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": -1, "message": "保存失败"})
+		return
+	}
+	if req.IsDefault && !cred.IsDefault {
+		models.SetDefaultCredential(cred.UserId, cred.ID)
+		cred.IsDefault = true
+	}
+
+	c.JSON(200, gin.H{
+		"code":    0,
+		"message": "凭证更新成功",
+		"data":    cred,
+	})
+}
+
+// DeleteCredential
+// @Summary 删除 LLM 凭证
+// @Tags 项目模块
+// @Security Bearer
+// @Param id query int true "凭证ID"
+// @Success 200 {object} map[string]interface{}
+// @Router /api/deleteCredential [delete]
+func DeleteCredential(c *gin.Context) {
+	cred, ok := loadUserCredential(c)
+	if !ok {
+		return
+	}
+
+	if err := models.DeleteCredential(cred); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": -1, "message": "删除失败"})
+		return
+	}
+	utils.DelSecret(utils.Red.Context(), credentialKeyName(cred.ID))
+
+	c.JSON(200, gin.H{
+		"code":    0,
+		"message": "凭证已删除",
+	})
+}
+
+// SetDefaultCredential
+// @Summary 设置默认 LLM 凭证
+// @Tags 项目模块
+// @Security Bearer
+// @Param id query int true "凭证ID"
+// @Success 200 {object} map[string]interface{}
+// @Router /api/setDefaultCredential [put]
+func SetDefaultCredential(c *gin.Context) {
+	cred, ok := loadUserCredential(c)
+	if !ok {
+		return
+	}
+
+	if err := models.SetDefaultCredential(cred.UserId, cred.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": -1, "message": "设置失败"})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"code":    0,
+		"message": "默认凭证已更新",
+	})
+}
+
+// SetProjectCredential
+// @Summary 为项目指定 LLM 凭证（覆盖默认凭证）
+// @Tags 项目模块
+// @Security Bearer
+// @Param name query string true "项目名"
+// @Param credential_id query int true "凭证ID，0 表示恢复使用默认凭证"
+// @Success 200 {object} map[string]interface{}
+// @Router /api/setProjectCredential [put]
+func SetProjectCredential(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户未登录"})
+		return
+	}
+
+	proj, err := findUserProject(userID, c.Query("name"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "项目不存在"})
+		return
+	}
+	if !canManageProject(userID.(uint), proj) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "无权限修改此项目"})
+		return
+	}
+
+	credID, err := strconv.ParseUint(c.Query("credential_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": -1, "message": "无效的 credential_id"})
+		return
+	}
+	if credID != 0 {
+		if _, err := models.FindUserCredential(userID.(uint), uint(credID)); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"code": -1, "message": "凭证不存在"})
+			return
+		}
+	}
+
+	if err := utils.DB.Model(&proj).Update("credential_id", uint(credID)).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": -1, "message": "设置失败"})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"code":    0,
+		"message": "项目凭证已更新",
+		"data":    proj,
+	})
+}
+
+// loadUserCredential 根据 id 参数查询当前用户的凭证
+func loadUserCredential(c *gin.Context) (models.ProviderCredential, bool) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户未登录"})
+		return models.ProviderCredential{}, false
+	}
+
+	id, err := strconv.ParseUint(c.Query("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": -1, "message": "无效的凭证ID"})
+		return models.ProviderCredential{}, false
+	}
+
+	cred, err := models.FindUserCredential(userID.(uint), uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"code": -1, "message": "凭证不存在"})
+		return models.ProviderCredential{}, false
+	}
+	return cred, true
+}
+
+// applyCredentialRequest 把请求中传入的字段写入凭证（密钥单独保存）
+func applyCredentialRequest(cred *models.ProviderCredential, req credentialRequest) {
+	if req.Name != nil {
+		cred.Name = *req.Name
+	}
+	if req.Provider != nil && *req.Provider != "" {
+		cred.Provider = *req.Provider
+	}
+	if req.BaseUrl != nil {
+		cred.BaseUrl = *req.BaseUrl
+	}
+	if req.ChatModel != nil {
+		cred.ChatModel = *req.ChatModel
+	}
+	if req.EmbeddingModel != nil {
+		cred.EmbeddingModel = *req.EmbeddingModel
+	}
+}
+
+// checkCredential 校验服务商类型与地址
+func checkCredential(cred models.ProviderCredential) error {
+	if cred.Name == "" {
+		return newAPIError(http.StatusBadRequest, "名称不能为空")
+	}
+	switch cred.Provider {
+	case models.ProviderOpenAI:
+	case models.ProviderAzure, models.ProviderCompatible:
+		if cred.BaseUrl == "" {
+			return newAPIError(http.StatusBadRequest, "该服务商类型需要填写 base_url")
+		}
+	default:
+		return newAPIError(http.StatusBadRequest, "不支持的服务商类型")
+	}
+	return nil
+}
+
+// maskKey 只保留密钥前4位和后4位，中间用*代替
+func maskKey(key string) string {
+	if len(key) <= 8 {
+		return "****"
+	}
+	return key[:4] + "****" + key[len(key)-4:]
+}
@@ -0,0 +1,340 @@
+package service
+
+import (
+	"CodeCampass/models"
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// archiveFormats 支持的压缩包格式与对应的 Content-Type
+var archiveFormats = map[string]string{
+	"zip":    "application/zip",
+	"tar.gz": "application/gzip",
+}
+
+var unsafeArchiveName = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// archiveName 压缩包名称，同时作为包内的顶层目录
+func archiveName(proj models.Project, dir, rev string) string {
+	parts := []string{proj.Name}
+	if dir != "" {
+		parts = append(parts, path.Base(dir))
+	}
+	if rev != "" {
+		parts = append(parts, rev)
+	}
+	name := strings.Trim(unsafeArchiveName.ReplaceAllString(strings.Join(parts, "-"), "_"), "._-")
+	if name == "" {
+		name = fmt.Sprintf("project-%d", proj.ID)
+	}
+	return name
+}
+
+// listWorkingTreeFiles 列出工作区中未被忽略的文件（相对路径），包括已检出的子模块中的文件；
+// 不是 git 仓库时遍历目录，仅跳过 .git
+func listWorkingTreeFiles(baseDir, dir string) ([]string, error) {
+	if _, err := os.Stat(filepath.Join(baseDir, ".git")); err == nil {
+		files, err := gitWorkingTreeFiles(baseDir, "", 1)
+		if err != nil {
+			return nil, err
+		}
+		if dir != "" {
+			// 打包的目录可能在子模块中，展开子模块后再按目录过滤
+			filtered := files[:0]
+			for _, f := range files {
+				if strings.HasPrefix(f, dir+"/") {
+					filtered = append(filtered, f)
+				}
+			}
+			files = filtered
+		}
+		sort.Strings(files) // 未跟踪的文件排在最后输出，统一按路径排序
+		return files, nil
+	}
+
+	var files []string
+	root := filepath.Join(baseDir, filepath.FromSlash(dir))
+	err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() {
+			if info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, _ := filepath.Rel(baseDir, p)
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	return files, err
+}
+
+// gitWorkingTreeFiles prefix 所在仓库中跟踪的与未被忽略的文件，路径相对仓库根目录；
+// 子模块在 ls-files 中只有一项，已检出的逐层展开为其中的文件，与文件索引一致
+func gitWorkingTreeFiles(baseDir, prefix string, level int) ([]string, error) {
+	dir := filepath.Join(baseDir, filepath.FromSlash(prefix))
+	out, err := runGit(dir, "ls-files", "-z", "--cached", "--others", "--exclude-standard")
+	if err != nil {
+		return nil, err
+	}
+	gitlinks := map[string]bool{}
+	for _, root := range gitlinkPaths(dir) {
+		gitlinks[root] = true
+	}
+	var files []string
+	for _, f := range strings.Split(string(out), "\x00") {
+		if f == "" {
+			continue
+		}
+		full := path.Join(prefix, f)
+		if !gitlinks[f] {
+			files = append(files, full)
+			continue
+		}
+		if level >= maxSubmoduleNesting || !submoduleCheckedOut(baseDir, full) {
+			continue
+		}
+		sub, err := gitWorkingTreeFiles(baseDir, full, level+1)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, sub...)
+	}
+	return files, nil
+}
+
+// archiveWriter 逐个写入文件，zip 与 tar.gz 共用
+type archiveWriter interface {
+	add(name string, info os.FileInfo, linkTarget string, content io.Reader) error
+	Close() error
+}
+
+type zipArchive struct{ zw *zip.Writer }
+
+func (a zipArchive) add(name string, info os.FileInfo, linkTarget string, content io.Reader) error {
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	if linkTarget != "" {
+		// zip 中的符号链接以链接目标作为内容
+		header.Method = zip.Store
+		content = strings.NewReader(linkTarget)
+	} else {
+		header.Method = zip.Deflate
+	}
+	w, err := a.zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, content)
+	return err
+}
+
+func (a zipArchive) Close() error { return a.zw.Close() }
+
+type tarGzArchive struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func newTarGzArchive(w io.Writer) tarGzArchive {
+	gz := gzip.NewWriter(w)
+	return tarGzArchive{gz: gz, tw: tar.NewWriter(gz)}
+}
+
+func (a tarGzArchive) add(name string, info os.FileInfo, linkTarget string, content io.Reader) error {
+	header, err := tar.FileInfoHeader(info, linkTarget)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	// 不暴露服务器上的用户信息
+	header.Uid, header.Gid, header.Uname, header.Gname = 0, 0, "", ""
+	if err := a.tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if linkTarget == "" {
+		_, err = io.Copy(a.tw, content)
+	}
+	return err
+}
+
+func (a tarGzArchive) Close() error {
+	if err := a.tw.Close(); err != nil {
+		return err
+	}
+	return a.gz.Close()
+}
+
+// writeWorkingTreeArchive 将工作区文件逐个写入压缩包。符号链接按链接本身写入，不读取链接目标，
+// 避免指向仓库外的链接泄露服务器文件
+func writeWorkingTreeArchive(w io.Writer, format, baseDir, prefix string, files []string) error {
+	var archive archiveWriter
+	if format == "zip" {
+		archive = zipArchive{zw: zip.NewWriter(w)}
+	} else {
+		archive = newTarGzArchive(w)
+	}
+
+	for _, rel := range files {
+		full := filepath.Join(baseDir, filepath.FromSlash(rel))
+		info, err := os.Lstat(full)
+		if err != nil || info.IsDir() {
+			continue // 已删除的文件与子模块目录
+		}
+		name := prefix + rel
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(full)
+			if err != nil {
+				continue
+			}
+			if err := archive.add(name, info, target, nil); err != nil {
+				return err
+			}
+		case info.Mode().IsRegular():
+			f, err := os.Open(full)
+			if err != nil {
+				continue
+			}
+			err = archive.add(name, info, "", f)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		}
+		// 设备文件、管道等跳过
+	}
+	return archive.Close()
+}
+
+// writeRevisionArchive 用 git archive 打包指定版本，输出直接写入 w
+func writeRevisionArchive(w io.Writer, format, baseDir, prefix, commit, dir string) error {
+	gitFormat := "zip"
+	out := w
+	var gz *gzip.Writer
+	if format == "tar.gz" {
+		gitFormat = "tar"
+		gz = gzip.NewWriter(w)
+		out = gz
+	}
+	args := []string{"-C", baseDir, "archive", "--format=" + gitFormat, "--prefix=" + prefix, commit}
+	if dir != "" {
+		args = append(args, "--", dir)
+	}
+	cmd := exec.Command("git", args...)
+	var stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = out, &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("git archive 失败: %s", strings.TrimSpace(stderr.String()))
+	}
+	if gz != nil {
+		return gz.Close()
+	}
+	return nil
+}
+
+// DownloadArchiveV1
+// @Summary 下载项目快照压缩包
+// @Description 流式打包项目仓库（不含 .git 与被忽略的文件），可只打包子目录或指定版本
+// @Tags 项目模块 v1
+// @Security Bearer
+// @Param id path int true "项目ID"
+// @Param format query string false "zip（默认）或 tar.gz"
+// @Param path query string false "子目录，默认整个仓库"
+// @Param rev query string false "版本（分支、标签或提交），默认当前工作区"
+// @Success 200
+// @Router /api/v1/projects/{id}/archive [get]
+func DownloadArchiveV1(c *gin.Context) {
+	proj, ok := loadProjectV1(c)
+	if !ok {
+		return
+	}
+
+	format := c.DefaultQuery("format", "zip")
+	contentType, ok := archiveFormats[format]
+	if !ok {
+		respondError(c, newAPIError(http.StatusBadRequest, "format 只能是 zip 或 tar.gz"))
+		return
+	}
+	dir := strings.Trim(c.Query("path"), "/")
+	var err error
+	if dir != "" {
+		if dir, err = cleanRepoPath(dir); err != nil {
+			respondError(c, err)
+			return
+		}
+	}
+
+	rev := c.Query("rev")
+	baseDir := repoBaseDir(proj)
+	var commit string
+	var files []string
+	if rev != "" {
+		if baseDir, err = clonedRepoDir(proj); err != nil {
+			respondError(c, err)
+			return
+		}
+		if commit, err = resolveCommit(baseDir, rev); err != nil {
+			respondError(c, err)
+			return
+		}
+		if dir != "" {
+			if out, err := runGit(baseDir, "cat-file", "-t", commit+":"+dir); err != nil || strings.TrimSpace(string(out)) != "tree" {
+				respondError(c, newAPIError(http.StatusNotFound, "目录不存在"))
+				return
+			}
+		}
+	} else {
+		// 用 Lstat，指向仓库外的目录链接不能作为打包目录
+		info, err := os.Lstat(filepath.Join(baseDir, filepath.FromSlash(dir)))
+		if os.IsNotExist(err) && dir == "" {
+			respondError(c, newAPIError(http.StatusConflict, "仓库未同步"))
+			return
+		}
+		if err != nil || !info.IsDir() {
+			respondError(c, newAPIError(http.StatusNotFound, "目录不存在"))
+			return
+		}
+		if files, err = listWorkingTreeFiles(baseDir, dir); err != nil {
+			respondError(c, err)
+			return
+		}
+	}
+
+	// 响应头发出后出错只能中断连接，参数与目录需在此之前校验完毕
+	name := archiveName(proj, dir, rev)
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name + "." + format}))
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Status(http.StatusOK)
+
+	if rev != "" {
+		err = writeRevisionArchive(c.Writer, format, baseDir, name+"/", commit, dir)
+	} else {
+		err = writeWorkingTreeArchive(c.Writer, format, baseDir, name+"/", files)
+	}
+	if err != nil {
+		fmt.Printf("警告: 项目 %d 打包失败: %v\n", proj.ID, err)
+		c.Abort()
+	}
+}
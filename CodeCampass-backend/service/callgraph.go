@@ -0,0 +1,169 @@
+package service
+
+import (
+	"CodeCampass/models"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/tools/go/callgraph/cha"
+	"golang.org/x/tools/go/packages"
+	"golang.org/x/tools/go/ssa"
+	"golang.org/x/tools/go/ssa/ssautil"
+)
+
+// 每个项目保留的图快照数
+const maxGraphSnapshots = 3
+
+// buildProjectGraphs 计算仓库的调用图（CHA）与包依赖图。
+// 只记录仓库内函数发出的调用；动态调用（接口方法、函数值）只保留仓库内的被调用方，
+// 否则像 error.Error() 这样的调用会连到依赖中的所有实现
+func buildProjectGraphs(baseDir string, pkgs []*packages.Package) (edges []models.CallEdge, imports []models.PackageImport, pkgCount int, err error) {
+	// SSA 构建遇到异常代码可能 panic，不能让它拖垮导入流程
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("构建 SSA 失败: %v", r)
+		}
+	}()
+
+	local := map[string]bool{}
+	for _, pkg := range pkgs {
+		local[pkg.PkgPath] = true
+	}
+
+	// 包依赖图
+	for _, pkg := range pkgs {
+		for path := range pkg.Imports {
+			imports = append(imports, models.PackageImport{FromPkg: pkg.PkgPath, ToPkg: path, External: !local[path]})
+		}
+	}
+	sort.Slice(imports, func(i, j int) bool {
+		if imports[i].FromPkg != imports[j].FromPkg {
+			return imports[i].FromPkg < imports[j].FromPkg
+		}
+		return imports[i].ToPkg < imports[j].ToPkg
+	})
+
+	// 调用图：存在类型错误的包无法构建 SSA，会被跳过
+	prog, _ := ssautil.Packages(pkgs, ssa.InstantiateGenerics)
+	prog.Build()
+	cg := cha.CallGraph(prog)
+
+	funcPkg := func(fn *ssa.Function) string {
+		if fn.Origin() != nil {
+			fn = fn.Origin()
+		}
+		if fn.Pkg != nil {
+			return fn.Pkg.Pkg.Path()
+		}
+		if fn.Object() != nil && fn.Object().Pkg() != nil {
+			return fn.Object().Pkg().Path()
+		}
+		return ""
+	}
+	funcName := func(fn *ssa.Function) string {
+		if fn.Origin() != nil {
+			fn = fn.Origin()
+		}
+		return fn.String()
+	}
+
+	seen := map[string]bool{}
+	for fn, node := range cg.Nodes {
+		if fn == nil || fn.Synthetic != "" || !local[funcPkg(fn)] {
+			continue
+		}
+		for _, out := range node.Out {
+			callee := out.Callee.Func
+			calleePkg := funcPkg(callee)
+			dynamic := out.Site != nil && out.Site.Common().IsInvoke()
+			if dynamic && !local[calleePkg] {
+				continue
+			}
+
+			edge := models.CallEdge{
+				Caller:         funcName(fn),
+				CallerPkg:      funcPkg(fn),
+				Callee:         funcName(callee),
+				CalleePkg:      calleePkg,
+				CalleeExternal: !local[calleePkg],
+				Dynamic:        dynamic,
+			}
+			if out.Site != nil {
+				pos := prog.Fset.Position(out.Site.Pos())
+				if rel, err := filepath.Rel(baseDir, pos.Filename); err == nil && !strings.HasPrefix(rel, "..") {
+					edge.FilePath, edge.Line = filepath.ToSlash(rel), pos.Line
+				}
+			}
+
+			key := fmt.Sprintf("%s|%s|%s|%d", edge.Caller, edge.Callee, edge.FilePath, edge.Line)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			edges = append(edges, edge)
+		}
+	}
+	return edges, imports, len(pkgs), nil
+}
+
+// indexProjectGraphs 导入流程中的调用图阶段，只处理 Go 项目。
+// 同一次加载的包信息也用来生成跳转定义与查找引用所用的导航索引
+func indexProjectGraphs(proj models.Project, baseDir string) error {
+	if !hasGoModule(baseDir) {
+		return nil
+	}
+	publishProjectEvent(proj.ID, SSEEvent{
+		Event: "graph_start",
+		Data:  gin.H{"message": "开始构建调用图", "project_id": proj.ID},
+	})
+
+	pkgs, err := loadGoPackages(baseDir)
+	var edges []models.CallEdge
+	var imports []models.PackageImport
+	var pkgCount int
+	if err == nil {
+		if err := writeNavIndex(buildGoNavIndex(baseDir, proj.HeadCommit, pkgs), navIndexPath(proj)); err != nil {
+			fmt.Printf("警告: 写入项目 %d 的导航索引失败: %v\n", proj.ID, err)
+		}
+		edges, imports, pkgCount, err = buildProjectGraphs(baseDir, pkgs)
+	}
+	if err == nil {
+		err = models.SaveGraphSnapshot(&models.GraphSnapshot{
+			ProjectId:    proj.ID,
+			Commit:       proj.HeadCommit,
+			Algorithm:    "cha",
+			PackageCount: pkgCount,
+			EdgeCount:    len(edges),
+		}, edges, imports, maxGraphSnapshots)
+	}
+	if err != nil {
+		publishProjectEvent(proj.ID, SSEEvent{
+			Event: "graph_error",
+			Data: gin.H{
+				"message":    fmt.Sprintf("构建调用图失败: %v", err),
+				"project_id": proj.ID,
+				"error":      err.Error(),
+			},
+		})
+		return err
+	}
+
+	publishProjectEvent(proj.ID, SSEEvent{
+		Event: "graph_complete",
+		Data: gin.H{
+			"message":    "调用图构建完成",
+			"project_id": proj.ID,
+			"packages":   pkgCount,
+			"edges":      len(edges),
+		},
+	})
+	return nil
+}
+
+// hasGoModule 仓库根目录是否有 go.mod
+func hasGoModule(baseDir string) bool {
+	return readModulePath(baseDir) != ""
+}
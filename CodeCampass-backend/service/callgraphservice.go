@@ -0,0 +1,228 @@
+package service
+
+import (
+	"CodeCampass/models"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 调用关系查询的最大层数
+const maxCallGraphDepth = 3
+
+// loadGraphSnapshot 取得请求指定提交（默认最新）的图快照
+func loadGraphSnapshot(c *gin.Context, proj models.Project) (models.GraphSnapshot, bool) {
+	snap, err := models.FindGraphSnapshot(proj.ID, c.Query("commit"))
+	if err != nil {
+		respondError(c, newAPIError(http.StatusNotFound, "调用图尚未构建，请先导入 Go 项目"))
+		return snap, false
+	}
+	return snap, true
+}
+
+// walkCallGraph 从 start 出发按层查找调用方或被调用方
+func walkCallGraph(snapshotID uint, start string, callers bool, depth int) []models.CallEdge {
+	edges := make([]models.CallEdge, 0)
+	visited := map[string]bool{start: true}
+	frontier := []string{start}
+	for level := 0; level < depth && len(frontier) > 0; level++ {
+		var found []models.CallEdge
+		if callers {
+			found = models.FindCallers(snapshotID, frontier)
+		} else {
+			found = models.FindCallees(snapshotID, frontier)
+		}
+		frontier = nil
+		for _, e := range found {
+			edges = append(edges, e)
+			next := e.Callee
+			if callers {
+				next = e.Caller
+			} else if e.CalleeExternal {
+				continue // 不展开仓库外的函数
+			}
+			if !visited[next] {
+				visited[next] = true
+				frontier = append(frontier, next)
+			}
+		}
+	}
+	return edges
+}
+
+// ListGraphSnapshotsV1
+// @Summary 列出项目已构建调用图的提交
+// @Tags 项目模块 v1
+// @Security Bearer
+// @Param id path int true "项目ID"
+// @Success 200 {object} map[string]interface{}
+// @Router /api/v1/projects/{id}/graphs [get]
+func ListGraphSnapshotsV1(c *gin.Context) {
+	proj, ok := loadProjectV1(c)
+	if !ok {
+		return
+	}
+	respondOK(c, http.StatusOK, "查询成功", models.GetGraphSnapshotList(proj.ID))
+}
+
+// GetCallGraphV1
+// @Summary 查询函数的调用方与被调用方
+// @Tags 项目模块 v1
+// @Security Bearer
+// @Param id path int true "项目ID"
+// @Param func query string true "函数名，如 askProject、service.askProject、(*pkg.Type).Method"
+// @Param direction query string false "callers/callees/both，默认 both"
+// @Param depth query int false "展开层数，默认 1，最大 3"
+// @Param commit query string false "提交，默认最新"
+// @Success 200 {object} map[string]interface{}
+// @Router /api/v1/projects/{id}/callgraph [get]
+func GetCallGraphV1(c *gin.Context) {
+	proj, ok := loadProjectV1(c)
+	if !ok {
+		return
+	}
+	snap, ok := loadGraphSnapshot(c, proj)
+	if !ok {
+		return
+	}
+
+	name := strings.TrimSpace(c.Query("func"))
+	if name == "" {
+		respondError(c, newAPIError(http.StatusBadRequest, "请提供函数名"))
+		return
+	}
+	direction := c.DefaultQuery("direction", "both")
+	if direction != "callers" && direction != "callees" && direction != "both" {
+		respondError(c, newAPIError(http.StatusBadRequest, "direction 只能是 callers、callees 或 both"))
+		return
+	}
+	depth, err := strconv.Atoi(c.DefaultQuery("depth", "1"))
+	if err != nil || depth <= 0 {
+		respondError(c, newAPIError(http.StatusBadRequest, "无效的 depth"))
+		return
+	}
+	if depth > maxCallGraphDepth {
+		depth = maxCallGraphDepth
+	}
+
+	candidates := models.MatchGraphFunctions(snap.ID, name, 20)
+	fn := ""
+	for _, cand := range candidates {
+		if cand == name {
+			fn = cand
+		}
+	}
+	switch {
+	case fn != "":
+	case len(candidates) == 0:
+		respondError(c, newAPIError(http.StatusNotFound, "调用图中没有该函数"))
+		return
+	case len(candidates) == 1:
+		fn = candidates[0]
+	default:
+		sort.Strings(candidates)
+		c.JSON(http.StatusConflict, gin.H{
+			"code":    -1,
+			"message": "匹配到多个函数，请使用完整名称",
+			"data":    gin.H{"candidates": candidates},
+		})
+		return
+	}
+
+	data := gin.H{"commit": snap.Commit, "function": fn, "depth": depth}
+	if direction != "callees" {
+		data["callers"] = walkCallGraph(snap.ID, fn, true, depth)
+	}
+	if direction != "callers" {
+		data["callees"] = walkCallGraph(snap.ID, fn, false, depth)
+	}
+	respondOK(c, http.StatusOK, "查询成功", data)
+}
+
+// GetPackageGraphV1
+// @Summary 包依赖图
+// @Tags 项目模块 v1
+// @Security Bearer
+// @Param id path int true "项目ID"
+// @Param format query string false "json/dot/mermaid，默认 json"
+// @Param external query bool false "是否包含仓库外的包，默认 false"
+// @Param commit query string false "提交，默认最新"
+// @Success 200 {object} map[string]interface{}
+// @Router /api/v1/projects/{id}/packages/graph [get]
+func GetPackageGraphV1(c *gin.Context) {
+	proj, ok := loadProjectV1(c)
+	if !ok {
+		return
+	}
+	snap, ok := loadGraphSnapshot(c, proj)
+	if !ok {
+		return
+	}
+
+	edges := models.FindPackageImports(snap.ID, c.Query("external") == "true")
+
+	// 节点按名称排序，外部包只作为被导入方出现
+	external := map[string]bool{}
+	var nodes []string
+	addNode := func(pkg string, ext bool) {
+		if _, ok := external[pkg]; !ok {
+			external[pkg] = ext
+			nodes = append(nodes, pkg)
+		}
+	}
+	for _, e := range edges {
+		addNode(e.FromPkg, false)
+		addNode(e.ToPkg, e.External)
+	}
+	sort.Strings(nodes)
+
+	switch c.DefaultQuery("format", "json") {
+	case "dot":
+		var b strings.Builder
+		b.WriteString("digraph packages {\n\trankdir=LR;\n\tnode [shape=box];\n")
+		for _, n := range nodes {
+			if external[n] {
+				fmt.Fprintf(&b, "\t%q [style=dashed];\n", n)
+			} else {
+				fmt.Fprintf(&b, "\t%q;\n", n)
+			}
+		}
+		for _, e := range edges {
+			fmt.Fprintf(&b, "\t%q -> %q;\n", e.FromPkg, e.ToPkg)
+		}
+		b.WriteString("}\n")
+		c.Data(http.StatusOK, "text/vnd.graphviz; charset=utf-8", []byte(b.String()))
+
+	case "mermaid":
+		// mermaid 的节点ID不能包含 / 等字符，用序号代替
+		ids := map[string]string{}
+		var b strings.Builder
+		b.WriteString("graph LR\n")
+		for i, n := range nodes {
+			ids[n] = fmt.Sprintf("p%d", i)
+			fmt.Fprintf(&b, "    %s[\"%s\"]\n", ids[n], strings.ReplaceAll(n, `"`, "#quot;"))
+		}
+		for _, e := range edges {
+			fmt.Fprintf(&b, "    %s --> %s\n", ids[e.FromPkg], ids[e.ToPkg])
+		}
+		c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(b.String()))
+
+	case "json":
+		items := make([]gin.H, 0, len(nodes))
+		for _, n := range nodes {
+			items = append(items, gin.H{"id": n, "external": external[n]})
+		}
+		respondOK(c, http.StatusOK, "查询成功", gin.H{
+			"commit": snap.Commit,
+			"nodes":  items,
+			"edges":  edges,
+		})
+
+	default:
+		respondError(c, newAPIError(http.StatusBadRequest, "format 只能是 json、dot 或 mermaid"))
+	}
+}
@@ -0,0 +1,215 @@
+package service
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// 非 Go 代码没有类型信息，按 ctags 的思路用各语言常见的定义写法做正则匹配
+
+// 文本搜索的限制
+const (
+	maxNavFileSize   = 1 << 20
+	maxNavReferences = 1000
+)
+
+// languageFamilies 可以互相引用的语言视为同一族
+var languageFamilies = map[string]string{
+	"TypeScript":  "JavaScript",
+	"Vue":         "JavaScript",
+	"C++":         "C",
+	"Objective-C": "C",
+}
+
+func languageFamily(lang string) string {
+	if f, ok := languageFamilies[lang]; ok {
+		return f
+	}
+	return lang
+}
+
+// definitionPatterns 各语言族的定义写法，%s 为转义后的名字
+var definitionPatterns = map[string][]string{
+	"Go": {
+		`^\s*func\s+(\([^)]*\)\s*)?%s\b`,
+		`^\s*type\s+%s\b`,
+		`^\s*(var|const)\s+%s\b`,
+	},
+	"Python": {
+		`^\s*(async\s+)?def\s+%s\b`,
+		`^\s*class\s+%s\b`,
+		`^%s\s*(:[^=]*)?=[^=]`,
+	},
+	"JavaScript": {
+		`\bfunction\s*\*?\s*%s\b`,
+		`\bclass\s+%s\b`,
+		`\b(const|let|var)\s+%s\b`,
+		`^\s*(export\s+)?(declare\s+)?(interface|type|enum|namespace)\s+%s\b`,
+		`^\s*(public\s+|private\s+|protected\s+|static\s+|async\s+)*%s\s*\([^)]*\)\s*(:[^{]*)?\{`,
+	},
+	"C": {
+		`\b(class|struct|enum|union)\s+%s\b`,
+		`^\s*#\s*define\s+%s\b`,
+		`^\s*typedef\b.*\b%s\s*;`,
+		`^[\w\s\*&<>,:~]*\b%s\s*\([^;]*$`,
+	},
+	"Java": {
+		`\b(class|interface|enum|record)\s+%s\b`,
+		`^\s*(public|private|protected|static|final|abstract|synchronized|\s)*[\w<>\[\],\s]+\s+%s\s*\([^;]*$`,
+	},
+	"Kotlin": {
+		`\b(class|interface|object|fun|val|var|typealias)\s+%s\b`,
+	},
+	"Scala": {
+		`\b(class|trait|object|def|val|var|type)\s+%s\b`,
+	},
+	"C#": {
+		`\b(class|interface|struct|enum|record|delegate)\s+%s\b`,
+		`^\s*(public|private|protected|internal|static|virtual|override|async|\s)*[\w<>\[\],\s]+\s+%s\s*\([^;]*$`,
+	},
+	"Rust": {
+		`\b(fn|struct|enum|trait|type|mod|const|static|union)\s+%s\b`,
+		`\bmacro_rules!\s*%s\b`,
+	},
+	"Ruby": {
+		`^\s*(def|class|module)\s+(self\.)?%s\b`,
+	},
+	"PHP": {
+		`\bfunction\s+%s\b`,
+		`\b(class|interface|trait|enum)\s+%s\b`,
+	},
+	"Swift": {
+		`\b(func|class|struct|enum|protocol|extension|typealias|let|var)\s+%s\b`,
+	},
+	"Dart": {
+		`\b(class|mixin|enum|typedef|extension)\s+%s\b`,
+		`^\s*[\w<>?,\s]*\b%s\s*\([^;]*\)\s*(async\s*)?\{`,
+	},
+	"Lua": {
+		`\bfunction\s+([\w.:]+[.:])?%s\b`,
+		`\blocal\s+%s\s*=`,
+	},
+	"Shell": {
+		`^\s*(function\s+)?%s\s*\(\)`,
+		`^\s*function\s+%s\b`,
+	},
+}
+
+// genericDefinitionPatterns 未单独配置的语言使用的通用写法
+var genericDefinitionPatterns = []string{
+	`\b(def|function|func|fn|class|struct|interface|enum|type)\s+%s\b`,
+}
+
+func compileDefinitionPatterns(lang, name string) []*regexp.Regexp {
+	patterns, ok := definitionPatterns[languageFamily(lang)]
+	if !ok {
+		patterns = genericDefinitionPatterns
+	}
+	quoted := regexp.QuoteMeta(name)
+	res := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		res = append(res, regexp.MustCompile(fmt.Sprintf(p, quoted)))
+	}
+	return res
+}
+
+func isIdentByte(b byte) bool {
+	return b == '_' || b == '$' || b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= 0x80
+}
+
+// wordAt 取出行内给定列（1 起始，按字节）处的标识符
+func wordAt(line string, col int) string {
+	i := col - 1
+	if i < 0 || i >= len(line) || !isIdentByte(line[i]) {
+		return ""
+	}
+	start, end := i, i
+	for start > 0 && isIdentByte(line[start-1]) {
+		start--
+	}
+	for end < len(line) && isIdentByte(line[end]) {
+		end++
+	}
+	word := line[start:end]
+	if word[0] >= '0' && word[0] <= '9' {
+		return ""
+	}
+	return word
+}
+
+// ctagsResult 文本匹配得到的定义与引用
+type ctagsResult struct {
+	Definitions []navLocation
+	References  []navLocation
+	Truncated   bool
+}
+
+// ctagsLookup 在同一语言族的文件中查找名字的定义与引用
+func ctagsLookup(baseDir, lang, name string) ctagsResult {
+	family := languageFamily(lang)
+	defPatterns := compileDefinitionPatterns(lang, name)
+	wordRe := regexp.MustCompile(`(^|[^\w$])` + regexp.QuoteMeta(name) + `($|[^\w$])`)
+
+	result := ctagsResult{Definitions: []navLocation{}, References: []navLocation{}}
+	filepath.Walk(baseDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() {
+			if path != baseDir && skipSourceDir(info.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		// 符号链接可能指向仓库外，只读取普通文件
+		if !info.Mode().IsRegular() || info.Size() > maxNavFileSize || languageFamily(detectLanguage(path)) != family {
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return nil
+		}
+		defer f.Close()
+		rel, _ := filepath.Rel(baseDir, path)
+		rel = filepath.ToSlash(rel)
+
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), maxNavFileSize)
+		for lineNo := 1; scanner.Scan(); lineNo++ {
+			text := scanner.Text()
+			if !strings.Contains(text, name) {
+				continue
+			}
+			loc := wordRe.FindStringSubmatchIndex(text)
+			if loc == nil {
+				continue
+			}
+			col := loc[3] + 1 // 跳过分隔字符
+			nav := navLocation{Path: rel, Line: lineNo, Col: col, EndCol: col + len(name), Text: text}
+
+			isDef := false
+			for _, re := range defPatterns {
+				if re.MatchString(text) {
+					isDef = true
+					break
+				}
+			}
+			if isDef {
+				result.Definitions = append(result.Definitions, nav)
+				continue
+			}
+			if len(result.References) >= maxNavReferences {
+				result.Truncated = true
+				return filepath.SkipAll
+			}
+			result.References = append(result.References, nav)
+		}
+		return nil
+	})
+	return result
+}
@@ -0,0 +1,29 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+)
+
+// apiError 携带 HTTP 状态码的业务错误，便于旧接口与 v1 接口共用同一套处理逻辑
+type apiError struct {
+	Status  int
+	Message string
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+func newAPIError(status int, message string) *apiError {
+	return &apiError{Status: status, Message: message}
+}
+
+// errorStatus 取出错误对应的 HTTP 状态码，非 apiError 一律视为 500
+func errorStatus(err error) int {
+	var e *apiError
+	if errors.As(err, &e) {
+		return e.Status
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,312 @@
+package service
+
+import (
+	"CodeCampass/models"
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// 解释选中代码时，除选区本身外还提供：所在的函数或类型、选区中引用的仓库内定义、
+// 以及 embedding 检索到的相关片段
+
+const (
+	maxExplainLines           = 200
+	maxExplainDefinitions     = 8
+	maxDefinitionSnippetLines = 30
+	maxEnclosingLines         = 150
+	explainWindowLines        = 20 // 没有符号信息时，选区前后各附带的行数
+)
+
+// explainSnippet 提供给 LLM 的一段代码
+type explainSnippet struct {
+	Name      string `json:"name,omitempty"`
+	Kind      string `json:"kind,omitempty"`
+	Path      string `json:"path"`
+	StartLine int    `json:"start_line"`
+	EndLine   int    `json:"end_line"`
+	Content   string `json:"-"`
+}
+
+// explainContext 为一次解释收集的上下文
+type explainContext struct {
+	Path        string           `json:"path"`
+	Language    string           `json:"language"`
+	StartLine   int              `json:"start_line"`
+	EndLine     int              `json:"end_line"`
+	Selection   string           `json:"-"`
+	Enclosing   *explainSnippet  `json:"enclosing,omitempty"`
+	Definitions []explainSnippet `json:"definitions"`
+	Related     []string         `json:"related"` // 相关片段所在的文件
+	related     []scoredChunk
+}
+
+// fileLines 按需读取并缓存仓库文件的行
+type fileLines struct {
+	proj  models.Project
+	files map[string][]string
+}
+
+func (f *fileLines) get(path string) []string {
+	if lines, ok := f.files[path]; ok {
+		return lines
+	}
+	content, err := readProjectFile(f.proj, path)
+	var lines []string
+	if err == nil {
+		lines = strings.Split(string(content), "\n")
+	}
+	f.files[path] = lines
+	return lines
+}
+
+// snippet 取 [start, end] 行（1 起始，超出范围的部分被截掉）
+func (f *fileLines) snippet(path string, start, end int) (string, int, int) {
+	lines := f.get(path)
+	start = max(start, 1)
+	end = min(end, len(lines))
+	if start > end {
+		return "", start, start
+	}
+	return strings.Join(lines[start-1:end], "\n"), start, end
+}
+
+// buildExplainContext 收集解释 path 第 start-end 行所需的上下文
+func buildExplainContext(ctx context.Context, proj models.Project, setup askSetup, path string, start, end int) (*explainContext, error) {
+	path = strings.TrimPrefix(path, "/")
+	files := &fileLines{proj: proj, files: map[string][]string{}}
+	content, err := readProjectFile(proj, path)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(string(content), "\n")
+	files.files[path] = lines
+
+	if start <= 0 || end < start || start > len(lines) {
+		return nil, newAPIError(http.StatusBadRequest, "无效的行范围")
+	}
+	end = min(end, len(lines))
+	if end-start+1 > maxExplainLines {
+		return nil, newAPIError(http.StatusBadRequest, fmt.Sprintf("一次最多解释 %d 行", maxExplainLines))
+	}
+
+	ec := &explainContext{
+		Path:        path,
+		Language:    detectLanguage(path),
+		StartLine:   start,
+		EndLine:     end,
+		Definitions: make([]explainSnippet, 0),
+		Related:     make([]string, 0),
+	}
+	var numbered strings.Builder
+	for i := start; i <= end; i++ {
+		fmt.Fprintf(&numbered, "%d| %s\n", i, lines[i-1])
+	}
+	ec.Selection = numbered.String()
+
+	if strings.HasSuffix(path, ".go") {
+		ec.Enclosing = enclosingGoSymbol(proj, files, path, start, end)
+		ec.Definitions = goDefinitions(proj, files, ec)
+	} else {
+		ec.Definitions = textDefinitions(proj, files, ec, lines[start-1:end])
+	}
+	if ec.Enclosing == nil {
+		text, s, e := files.snippet(path, start-explainWindowLines, end+explainWindowLines)
+		ec.Enclosing = &explainSnippet{Kind: "context", Path: path, StartLine: s, EndLine: e, Content: text}
+	}
+
+	// 相关片段检索失败（如 embedding 尚未构建）不影响解释
+	query := strings.Join(lines[start-1:end], "\n")
+	query = truncateUTF8(query, 2000)
+	if chunks, err := semanticSearch(ctx, setup.client, proj, setup.cfg.EmbeddingModel, query, setup.topK+1); err == nil {
+		for _, chunk := range chunks {
+			if chunk.Path != path && len(ec.related) < setup.topK {
+				ec.related = append(ec.related, chunk)
+				ec.Related = append(ec.Related, chunk.Path)
+			}
+		}
+	}
+	return ec, nil
+}
+
+// enclosingGoSymbol 从符号索引中找出包含选区的最内层函数、方法或类型
+func enclosingGoSymbol(proj models.Project, files *fileLines, path string, start, end int) *explainSnippet {
+	var best *models.CodeSymbol
+	syms := models.FindFileSymbols(proj.ID, path)
+	for i := range syms {
+		s := &syms[i]
+		if s.Kind != models.SymbolKindFunc && s.Kind != models.SymbolKindMethod && s.Kind != models.SymbolKindType {
+			continue
+		}
+		if s.Line > start || s.EndLine < end {
+			continue
+		}
+		if best == nil || s.EndLine-s.Line < best.EndLine-best.Line {
+			best = s
+		}
+	}
+	if best == nil || (best.Line == start && best.EndLine == end) {
+		return nil
+	}
+
+	symEnd := best.EndLine
+	if symEnd-best.Line+1 > maxEnclosingLines {
+		// 过长的函数只保留开头与选区附近
+		symEnd = best.Line + 10
+	}
+	text, s, e := files.snippet(path, best.Line, symEnd)
+	if symEnd < best.EndLine {
+		window, _, _ := files.snippet(path, start-explainWindowLines, end+explainWindowLines)
+		text += "\n// ……\n" + window
+	}
+	name := best.Name
+	if best.Parent != "" {
+		name = best.Parent + "." + name
+	}
+	return &explainSnippet{Name: name, Kind: best.Kind, Path: path, StartLine: s, EndLine: max(e, best.EndLine), Content: text}
+}
+
+// goDefinitions 用类型信息找出选区中引用的、定义在选区与所在符号之外的仓库内对象
+func goDefinitions(proj models.Project, files *fileLines, ec *explainContext) []explainSnippet {
+	defs := make([]explainSnippet, 0)
+	idx, err := projectNavIndex(proj)
+	if err != nil {
+		return defs
+	}
+
+	inside := func(loc *navLocation) bool {
+		if loc.Path != ec.Path {
+			return false
+		}
+		if loc.Line >= ec.StartLine && loc.Line <= ec.EndLine {
+			return true
+		}
+		return ec.Enclosing != nil && loc.Line >= ec.Enclosing.StartLine && loc.Line <= ec.Enclosing.EndLine
+	}
+
+	seen := map[string]bool{}
+	for _, id := range idx.Idents[ec.Path] {
+		if id.Line < ec.StartLine || id.Line > ec.EndLine || seen[id.Object] {
+			continue
+		}
+		seen[id.Object] = true
+		obj := idx.Objects[id.Object]
+		if obj == nil || obj.Def == nil || obj.External || obj.Kind == models.SymbolKindPackage || inside(obj.Def) {
+			continue
+		}
+
+		// 符号索引中有结束行时取完整定义，否则取定义处开始的若干行
+		defEnd := obj.Def.Line + 10
+		for _, s := range models.FindSymbolsByName(proj.ID, obj.Name) {
+			if s.FilePath == obj.Def.Path && s.Line == obj.Def.Line && s.EndLine >= s.Line {
+				defEnd = s.EndLine
+				break
+			}
+		}
+		defEnd = min(defEnd, obj.Def.Line+maxDefinitionSnippetLines-1)
+		text, s, e := files.snippet(obj.Def.Path, obj.Def.Line, defEnd)
+		if text == "" {
+			continue
+		}
+		defs = append(defs, explainSnippet{Name: obj.Name, Kind: obj.Kind, Path: obj.Def.Path, StartLine: s, EndLine: e, Content: text})
+		if len(defs) == maxExplainDefinitions {
+			break
+		}
+	}
+	return defs
+}
+
+var identPattern = regexp.MustCompile(`[A-Za-z_$][\w$]{2,}`)
+
+// commonWords 文本匹配时不查找定义的常见关键字与类型名
+var commonWords = map[string]bool{
+	"and": true, "bool": true, "break": true, "case": true, "catch": true, "char": true, "class": true,
+	"const": true, "continue": true, "def": true, "default": true, "double": true, "elif": true, "else": true,
+	"enum": true, "export": true, "extends": true, "false": true, "final": true, "float": true, "for": true,
+	"from": true, "function": true, "import": true, "int": true, "interface": true, "let": true, "long": true,
+	"new": true, "None": true, "not": true, "null": true, "pass": true, "private": true, "protected": true,
+	"public": true, "raise": true, "return": true, "self": true, "static": true, "string": true, "String": true,
+	"struct": true, "super": true, "switch": true, "this": true, "throw": true, "True": true, "true": true,
+	"False": true, "try": true, "typeof": true, "undefined": true, "var": true, "void": true, "while": true,
+	"with": true, "yield": true, "async": true, "await": true, "lambda": true, "print": true,
+}
+
+// textDefinitions 非 Go 代码按出现次数挑出选区中的标识符，用 ctags 风格的规则查找定义
+func textDefinitions(proj models.Project, files *fileLines, ec *explainContext, selected []string) []explainSnippet {
+	defs := make([]explainSnippet, 0)
+	counts := map[string]int{}
+	for _, line := range selected {
+		for _, word := range identPattern.FindAllString(line, -1) {
+			if !commonWords[word] {
+				counts[word]++
+			}
+		}
+	}
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if counts[names[i]] != counts[names[j]] {
+			return counts[names[i]] > counts[names[j]]
+		}
+		return names[i] < names[j]
+	})
+
+	// 每次查找都要遍历仓库，只尝试出现最多的几个名字
+	baseDir := repoBaseDir(proj)
+	for _, name := range names[:min(len(names), 6)] {
+		for _, loc := range ctagsLookup(baseDir, ec.Language, name).Definitions {
+			if loc.Path == ec.Path && loc.Line >= ec.StartLine && loc.Line <= ec.EndLine {
+				continue
+			}
+			text, s, e := files.snippet(loc.Path, loc.Line, loc.Line+15)
+			defs = append(defs, explainSnippet{Name: name, Path: loc.Path, StartLine: s, EndLine: e, Content: text})
+			break
+		}
+		if len(defs) == 4 {
+			break
+		}
+	}
+	return defs
+}
+
+// prompt 拼出上下文与问题，交给 askSetup.chatRequest 组装请求
+func (ec *explainContext) prompt(extra string) (contextText, question string) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "\n[选中代码: %s 第 %d-%d 行]\n%s", ec.Path, ec.StartLine, ec.EndLine, ec.Selection)
+	if e := ec.Enclosing; e != nil {
+		if e.Name != "" {
+			fmt.Fprintf(&b, "\n[所在%s %s: %s 第 %d-%d 行]\n%s\n", e.Kind, e.Name, e.Path, e.StartLine, e.EndLine, e.Content)
+		} else {
+			fmt.Fprintf(&b, "\n[选区前后的代码: %s 第 %d-%d 行]\n%s\n", e.Path, e.StartLine, e.EndLine, e.Content)
+		}
+	}
+	for _, d := range ec.Definitions {
+		fmt.Fprintf(&b, "\n[引用的定义 %s: %s 第 %d-%d 行]\n%s\n", d.Name, d.Path, d.StartLine, d.EndLine, d.Content)
+	}
+	for _, chunk := range ec.related {
+		fmt.Fprintf(&b, "\n[相关文件: %s]\n%s\n", chunk.Path, chunk.Content)
+	}
+
+	question = fmt.Sprintf("请解释选中的代码（%s 第 %d-%d 行）：它做了什么、关键步骤的原因，以及它与仓库中其他部分的关系。", ec.Path, ec.StartLine, ec.EndLine)
+	if extra = strings.TrimSpace(extra); extra != "" {
+		question += "\n另外请回答：" + extra
+	}
+	return b.String(), question
+}
+
+// truncateUTF8 截断到不超过 n 字节，不截断多字节字符
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// explainRequest 解释选中代码的请求
+type explainRequest struct {
+	Path      string `json:"path" binding:"required"`
+	StartLine int    `json:"start_line" binding:"required"`
+	EndLine   int    `json:"end_line" binding:"required"`
+	Question  string `json:"question"` // 可选的补充问题
+	Stream    bool   `json:"stream"`   // 以 SSE 流式返回
+}
+
+// ExplainCodeV1
+// @Summary 解释选中的代码
+// @Description stream 为 true 时以 SSE 返回：context 事件为收集到的上下文，delta 事件为回答的增量，最后是 done 或 error 事件
+// @Tags 项目模块 v1
+// @Security Bearer
+// @Param id path int true "项目ID"
+// @Param body body explainRequest true "文件路径与行范围（1 起始，包含两端）"
+// @Success 200 {object} map[string]interface{}
+// @Router /api/v1/projects/{id}/explain [post]
+func ExplainCodeV1(c *gin.Context) {
+	proj, ok := loadProjectV1(c)
+	if !ok {
+		return
+	}
+
+	var req explainRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, newAPIError(http.StatusBadRequest, "请提供 path、start_line 与 end_line"))
+		return
+	}
+
+	userID, _ := c.Get("userID")
+	setup, err := newAskSetup(proj, toUserID(userID))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	ec, err := buildExplainContext(c.Request.Context(), proj, setup, req.Path, req.StartLine, req.EndLine)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	chatReq := setup.chatRequest(ec.prompt(req.Question))
+
+	if !req.Stream {
+		resp, err := setup.client.CreateChatCompletion(c.Request.Context(), chatReq)
+		if err != nil {
+			respondError(c, fmt.Errorf("LLM调用失败: %v", err))
+			return
+		}
+		if len(resp.Choices) == 0 {
+			respondError(c, fmt.Errorf("LLM 未返回内容"))
+			return
+		}
+		markProjectAsked(proj)
+		respondOK(c, http.StatusOK, "解释成功", gin.H{
+			"explanation": resp.Choices[0].Message.Content,
+			"context":     ec,
+		})
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // 禁用Nginx缓冲
+
+	c.SSEvent("context", ec)
+	c.Writer.Flush()
+	answer, err := setup.client.CreateChatCompletionStream(c.Request.Context(), chatReq, func(delta string) error {
+		c.SSEvent("delta", gin.H{"content": delta})
+		c.Writer.Flush()
+		return nil
+	})
+	if err != nil {
+		c.SSEvent("error", gin.H{"message": fmt.Sprintf("LLM调用失败: %v", err)})
+		c.Writer.Flush()
+		return
+	}
+	markProjectAsked(proj)
+	c.SSEvent("done", gin.H{"explanation": answer})
+	c.Writer.Flush()
+}
@@ -0,0 +1,571 @@
+package service
+
+import (
+	"CodeCampass/models"
+	"CodeCampass/utils"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	xunicode "golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+const (
+	maxContentBytes     = 5 * 1024 * 1024  // 以 JSON 返回的内容上限
+	maxRawRevisionBytes = 50 * 1024 * 1024 // 历史版本的原始下载需要读入内存，限制大小
+	charsetSampleBytes  = 64 * 1024
+)
+
+// projectFile 打开的仓库文件：工作区文件直接读磁盘，历史版本读入内存
+type projectFile struct {
+	Path    string
+	Size    int64
+	ModTime time.Time // 历史版本为零值
+	ETag    string
+	content io.ReadSeeker
+	closer  io.Closer
+}
+
+func (f *projectFile) Close() {
+	if f.closer != nil {
+		f.closer.Close()
+	}
+}
+
+// openProjectFile 打开工作区或指定版本中的文件。工作区文件经 resolveProjectPath 解析符号链接，
+// 指向仓库外的符号链接按文件不存在处理
+func openProjectFile(proj models.Project, rev, filePath string) (*projectFile, error) {
+	if rev == "" {
+		fullPath, info, err := resolveProjectPath(proj, filePath)
+		if err != nil {
+			return nil, err
+		}
+		fh, err := os.Open(fullPath)
+		if err != nil {
+			return nil, fmt.Errorf("读取文件失败: %v", err)
+		}
+		// 检查后到打开前文件被替换（如同步时改为符号链接）则不再读取
+		if opened, err := fh.Stat(); err != nil || !os.SameFile(opened, info) {
+			fh.Close()
+			return nil, newAPIError(http.StatusNotFound, "文件不存在")
+		}
+		return &projectFile{
+			Path:    filePath,
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+			ETag:    fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()),
+			content: fh,
+			closer:  fh,
+		}, nil
+	}
+
+	blob, err := resolveRevisionBlob(proj, rev, filePath)
+	if err != nil {
+		return nil, err
+	}
+	if blob.Size > maxRawRevisionBytes {
+		return nil, newAPIError(http.StatusBadRequest, "历史版本的文件过大")
+	}
+	data, err := runGit(blob.BaseDir, "cat-file", "blob", blob.Object)
+	if err != nil {
+		return nil, err
+	}
+	return &projectFile{
+		Path:    filePath,
+		Size:    blob.Size,
+		ETag:    `"` + blob.Oid + `"`,
+		content: bytes.NewReader(data),
+	}, nil
+}
+
+// sample 读取文件开头用于判断编码与是否为二进制，读完后回到开头
+func (f *projectFile) sample() ([]byte, error) {
+	buf := make([]byte, charsetSampleBytes)
+	n, err := io.ReadFull(f.content, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	if _, err := f.content.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// charsetCandidates 内容不是合法 UTF-8 时尝试的编码。EUC-KR、Big5 与 GBK 的字节范围大量重叠，
+// 无法可靠区分，需通过 charset 参数指定
+var charsetCandidates = []string{"gb18030", "shift_jis"}
+
+// detectCharset 猜测文本编码：有 BOM 时按 BOM，合法 UTF-8 视为 UTF-8，
+// 否则用各候选编码解码，按解码出的常用字符比例打分
+func detectCharset(sample []byte) string {
+	switch {
+	case bytes.HasPrefix(sample, []byte{0xEF, 0xBB, 0xBF}):
+		return "utf-8"
+	case bytes.HasPrefix(sample, []byte{0xFF, 0xFE}):
+		return "utf-16le"
+	case bytes.HasPrefix(sample, []byte{0xFE, 0xFF}):
+		return "utf-16be"
+	}
+	// 截断的样本末尾可能是半个字符
+	trimmed := sample
+	if len(trimmed) == charsetSampleBytes {
+		trimmed = trimmed[:len(trimmed)-4]
+	}
+	if utf8.Valid(trimmed) {
+		return "utf-8"
+	}
+
+	best, bestScore := "windows-1252", 0
+	for _, name := range charsetCandidates {
+		enc, err := htmlindex.Get(name)
+		if err != nil {
+			continue
+		}
+		decoded, err := enc.NewDecoder().Bytes(trimmed)
+		if err != nil {
+			continue
+		}
+		score := 0
+		for _, r := range string(decoded) {
+			switch {
+			case r == utf8.RuneError:
+				score -= 20
+			case r < utf8.RuneSelf:
+			case r >= 0xFF61 && r <= 0xFF9F:
+				score -= 3 // 半角片假名，多为其他编码被误按 Shift-JIS 解码
+			case unicode.In(r, unicode.Hiragana, unicode.Katakana):
+				// 日文文本总会夹杂假名，GBK 解码出假名的概率很低
+				if name == "shift_jis" {
+					score += 3
+				} else {
+					score--
+				}
+			case unicode.Is(unicode.Han, r):
+				score += 2
+			case unicode.IsPunct(r) || unicode.IsSpace(r):
+				score++
+			default:
+				score -= 2 // 生僻符号、私用区字符等
+			}
+		}
+		if score > bestScore {
+			best, bestScore = name, score
+		}
+	}
+	return best
+}
+
+// charsetEncoding 取得编码，utf-8 返回 nil
+func charsetEncoding(name string) (encoding.Encoding, error) {
+	switch strings.ToLower(name) {
+	case "utf-8", "utf8":
+		return nil, nil
+	case "utf-16le":
+		return xunicode.UTF16(xunicode.LittleEndian, xunicode.ExpectBOM), nil
+	case "utf-16be":
+		return xunicode.UTF16(xunicode.BigEndian, xunicode.ExpectBOM), nil
+	}
+	enc, err := htmlindex.Get(name)
+	if err != nil {
+		return nil, newAPIError(http.StatusBadRequest, "不支持的编码: "+name)
+	}
+	return enc, nil
+}
+
+// contentOptions 读取文件内容的参数，行号从 1 开始，EndLine 为 0 表示读到文件末尾
+type contentOptions struct {
+	StartLine int
+	EndLine   int
+	Charset   string // 为空时自动检测
+}
+
+// contentResult 转为 UTF-8 后的文件内容
+type contentResult struct {
+	Content    string `json:"content"`
+	Charset    string `json:"charset"`
+	Binary     bool   `json:"binary"`
+	Size       int64  `json:"size"`
+	StartLine  int    `json:"start_line,omitempty"`
+	EndLine    int    `json:"end_line,omitempty"`
+	TotalLines int    `json:"total_lines,omitempty"` // 读到文件末尾时才知道
+	Truncated  bool   `json:"truncated"`
+}
+
+// readContent 读取文件内容并转为 UTF-8；指定行范围时逐行读取，不受整体大小限制
+func readContent(f *projectFile, opts contentOptions) (contentResult, error) {
+	res := contentResult{Size: f.Size}
+	sample, err := f.sample()
+	if err != nil {
+		return res, fmt.Errorf("读取文件失败: %v", err)
+	}
+
+	res.Charset = strings.ToLower(opts.Charset)
+	if res.Charset == "" {
+		// UTF-16 文本含有大量 NUL，需先按 BOM 判断编码
+		res.Charset = detectCharset(sample)
+		if !strings.HasPrefix(res.Charset, "utf-16") && isBinaryContent(sample) {
+			res.Binary, res.Charset = true, ""
+			return res, nil
+		}
+	}
+	enc, err := charsetEncoding(res.Charset)
+	if err != nil {
+		return res, err
+	}
+	var r io.Reader = f.content
+	if enc != nil {
+		r = transform.NewReader(r, enc.NewDecoder())
+	} else if bytes.HasPrefix(sample, []byte{0xEF, 0xBB, 0xBF}) {
+		f.content.Seek(3, io.SeekStart) // 去掉 UTF-8 BOM
+	}
+
+	ranged := opts.StartLine > 0 || opts.EndLine > 0
+	if !ranged {
+		if f.Size > maxContentBytes {
+			return res, newAPIError(http.StatusBadRequest, "文件过大，超过5MB，请按行范围读取或下载原始文件")
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return res, fmt.Errorf("读取文件失败: %v", err)
+		}
+		res.Content = string(data)
+		return res, nil
+	}
+
+	start := max(opts.StartLine, 1)
+	var b strings.Builder
+	br := bufio.NewReader(r)
+	line := 0
+	for {
+		text, err := br.ReadString('\n')
+		if text != "" {
+			line++
+			if line >= start && (opts.EndLine == 0 || line <= opts.EndLine) {
+				if b.Len()+len(text) > maxContentBytes {
+					res.Truncated = true
+					break
+				}
+				if res.StartLine == 0 {
+					res.StartLine = line
+				}
+				res.EndLine = line
+				b.WriteString(text)
+			}
+			if opts.EndLine > 0 && line >= opts.EndLine {
+				break
+			}
+		}
+		if err == io.EOF {
+			res.TotalLines = line
+			break
+		}
+		if err != nil {
+			return res, fmt.Errorf("读取文件失败: %v", err)
+		}
+	}
+	res.Content = b.String()
+	return res, nil
+}
+
+// parseContentOptions 读取 start_line、end_line、charset 参数
+func parseContentOptions(c *gin.Context) (contentOptions, error) {
+	opts := contentOptions{Charset: c.Query("charset")}
+	var err error
+	if v := c.Query("start_line"); v != "" {
+		if opts.StartLine, err = strconv.Atoi(v); err != nil || opts.StartLine <= 0 {
+			return opts, newAPIError(http.StatusBadRequest, "无效的 start_line")
+		}
+	}
+	if v := c.Query("end_line"); v != "" {
+		if opts.EndLine, err = strconv.Atoi(v); err != nil || opts.EndLine <= 0 || opts.EndLine < opts.StartLine {
+			return opts, newAPIError(http.StatusBadRequest, "无效的 end_line")
+		}
+	}
+	return opts, nil
+}
+
+// notModified 请求的 If-None-Match 与 ETag 一致时返回 304
+func notModified(c *gin.Context, etag string) bool {
+	c.Header("ETag", etag)
+	for _, tag := range strings.Split(c.GetHeader("If-None-Match"), ",") {
+		if tag = strings.TrimSpace(tag); tag == etag || tag == "*" || tag == "W/"+etag {
+			c.Status(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// 浏览器可以直接展示且不会执行脚本的类型
+var inlineContentTypes = map[string]bool{
+	"image/png": true, "image/jpeg": true, "image/gif": true, "image/webp": true, "image/bmp": true,
+	"image/x-icon": true, "image/vnd.microsoft.icon": true, "image/avif": true,
+	"application/pdf": true, "audio/mpeg": true, "audio/ogg": true, "audio/wav": true,
+	"video/mp4": true, "video/webm": true,
+}
+
+// rawContentType 原始下载的 Content-Type。文本一律按 text/plain 返回，
+// 避免仓库中的 HTML、SVG 在本站域名下执行脚本
+func rawContentType(name string, sample []byte) string {
+	if charset := detectCharset(sample); strings.HasPrefix(charset, "utf-16") || !isBinaryContent(sample) {
+		return "text/plain; charset=" + charset
+	}
+	ct := mime.TypeByExtension(filepath.Ext(name))
+	if ct == "" {
+		ct = http.DetectContentType(sample)
+	}
+	if mediaType, _, err := mime.ParseMediaType(ct); err == nil && inlineContentTypes[mediaType] {
+		return mediaType
+	}
+	return "application/octet-stream"
+}
+
+// GetFileRawV1
+// @Summary 下载原始文件
+// @Description 支持 Range 分段下载与 ETag / If-None-Match 缓存校验；图片、PDF、音视频按原类型返回，文本按 text/plain 返回并带上检测到的编码
+// @Tags 项目模块 v1
+// @Security Bearer
+// @Param id path int true "项目ID"
+// @Param path query string true "文件路径"
+// @Param rev query string false "版本（分支、标签或提交），默认读取当前工作区"
+// @Param download query bool false "以附件形式下载"
+// @Success 200
+// @Router /api/v1/projects/{id}/files/raw [get]
+func GetFileRawV1(c *gin.Context) {
+	proj, ok := loadProjectV1(c)
+	if !ok {
+		return
+	}
+	serveRawFile(c, proj, c.Query("rev"), c.Query("path"))
+}
+
+// serveRawFile 以原始内容返回仓库文件
+func serveRawFile(c *gin.Context, proj models.Project, rev, filePath string) {
+	f, err := openProjectFile(proj, rev, filePath)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	defer f.Close()
+	sample, err := f.sample()
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	name := filepath.Base(f.Path)
+	c.Header("Content-Type", rawContentType(name, sample))
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Content-Security-Policy", "sandbox")
+	c.Header("ETag", f.ETag)
+	if c.Query("download") == "true" {
+		c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	}
+	// ServeContent 处理 Range、If-None-Match、If-Modified-Since
+	http.ServeContent(c.Writer, c.Request, name, f.ModTime, f.content)
+}
+
+// 渲染后的 Markdown 中，仓库内的图片指向带签名的原始文件地址：浏览器加载 <img> 时不会带上
+// Authorization 头。签名覆盖项目、路径、版本与过期时间，过期时间按小时对齐，
+// 同一小时内渲染出的地址相同，渲染结果仍可缓存
+const (
+	rawURLWindow = time.Hour
+	rawURLKeyKey = "raw_url_key" // 未配置 render.signingKey 时，各实例共用 Redis 中随机生成的签名密钥
+)
+
+var rawURLKeyCache struct {
+	sync.Mutex
+	key []byte
+}
+
+// rawURLKey 签名密钥：配置 render.signingKey 优先，否则取 Redis 中的共享密钥，不存在时生成
+func rawURLKey(ctx context.Context) ([]byte, error) {
+	if k := viper.GetString("render.signingKey"); k != "" {
+		return []byte(k), nil
+	}
+	rawURLKeyCache.Lock()
+	defer rawURLKeyCache.Unlock()
+	if rawURLKeyCache.key != nil {
+		return rawURLKeyCache.key, nil
+	}
+	if utils.Red == nil {
+		return nil, errors.New("Redis 不可用")
+	}
+	buf := make([]byte, 32)
+	rand.Read(buf)
+	if err := utils.Red.SetNX(ctx, rawURLKeyKey, hex.EncodeToString(buf), 0).Err(); err != nil {
+		return nil, err
+	}
+	v, err := utils.Red.Get(ctx, rawURLKeyKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	rawURLKeyCache.key = []byte(v)
+	return rawURLKeyCache.key, nil
+}
+
+// rawURLExpiry 当前签发的地址的过期时间，有效期在 1 到 2 个 rawURLWindow 之间
+func rawURLExpiry(now time.Time) int64 {
+	return now.Truncate(rawURLWindow).Add(2 * rawURLWindow).Unix()
+}
+
+func rawURLSignature(key []byte, projectID uint, filePath, rev string, expires int64) string {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%d\n%s\n%s\n%d", projectID, filePath, rev, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signedRawURL 无需登录即可在到期前访问的原始文件地址；无法取得签名密钥时返回需要登录的地址
+func signedRawURL(ctx context.Context, projectID uint, filePath, rev string, expires int64) string {
+	q := url.Values{"path": {filePath}}
+	if rev != "" {
+		q.Set("rev", rev)
+	}
+	key, err := rawURLKey(ctx)
+	if err != nil {
+		return fmt.Sprintf("/api/v1/projects/%d/files/raw?%s", projectID, q.Encode())
+	}
+	q.Set("expires", strconv.FormatInt(expires, 10))
+	q.Set("sig", rawURLSignature(key, projectID, filePath, rev, expires))
+	return fmt.Sprintf("/api/v1/raw/projects/%d?%s", projectID, q.Encode())
+}
+
+// GetSignedFileRawV1
+// @Summary 通过签名地址读取原始文件
+// @Description 渲染后的 Markdown 中的图片地址，由服务端签发，无需 Authorization 头，到期后失效
+// @Tags 项目模块 v1
+// @Param id path int true "项目ID"
+// @Param path query string true "文件路径"
+// @Param rev query string false "版本"
+// @Param expires query int true "过期时间（Unix 秒）"
+// @Param sig query string true "签名"
+// @Success 200
+// @Router /api/v1/raw/projects/{id} [get]
+func GetSignedFileRawV1(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		respondError(c, newAPIError(http.StatusBadRequest, "无效的项目ID"))
+		return
+	}
+	filePath, rev := c.Query("path"), c.Query("rev")
+	expires, err := strconv.ParseInt(c.Query("expires"), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		respondError(c, newAPIError(http.StatusForbidden, "地址已过期"))
+		return
+	}
+	key, err := rawURLKey(c.Request.Context())
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	want := rawURLSignature(key, uint(id), filePath, rev, expires)
+	if !hmac.Equal([]byte(want), []byte(c.Query("sig"))) {
+		respondError(c, newAPIError(http.StatusForbidden, "签名无效"))
+		return
+	}
+
+	var proj models.Project
+	if err := utils.DB.Where("id = ?", id).First(&proj).Error; err != nil {
+		respondError(c, newAPIError(http.StatusNotFound, "项目不存在"))
+		return
+	}
+	c.Header("Cache-Control", fmt.Sprintf("private, max-age=%d", expires-time.Now().Unix()))
+	serveRawFile(c, proj, rev, filePath)
+}
+
+// fileContentData 按请求参数读取文件内容，返回响应数据；内容未变化时已返回 304，ok 为 false
+func fileContentData(c *gin.Context, proj models.Project) (gin.H, bool, error) {
+	opts, err := parseContentOptions(c)
+	if err != nil {
+		return nil, false, err
+	}
+	filePath, rev := c.Query("path"), c.Query("rev")
+	f, err := openProjectFile(proj, rev, filePath)
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+	if notModified(c, f.ETag) {
+		return nil, false, nil
+	}
+
+	res, err := readContent(f, opts)
+	if err != nil {
+		return nil, false, err
+	}
+	data := gin.H{
+		"path":      filePath,
+		"content":   res.Content,
+		"charset":   res.Charset,
+		"binary":    res.Binary,
+		"size":      res.Size,
+		"truncated": res.Truncated,
+		"etag":      f.ETag,
+	}
+	if opts.StartLine > 0 || opts.EndLine > 0 {
+		data["start_line"], data["end_line"] = res.StartLine, res.EndLine
+		if res.TotalLines > 0 {
+			data["total_lines"] = res.TotalLines
+		}
+	}
+	if rev != "" {
+		data["rev"] = rev
+	}
+
+	format := c.DefaultQuery("format", formatText)
+	if format == formatText || res.Binary {
+		return data, true, nil
+	}
+	if format == formatRendered && (opts.StartLine > 0 || opts.EndLine > 0) {
+		return nil, false, newAPIError(http.StatusBadRequest, "rendered 格式不支持按行范围读取")
+	}
+	if len(res.Content) > maxHighlightBytes() || res.Truncated {
+		data["render_skipped"] = true // 内容过大，前端按纯文本展示
+		return data, true, nil
+	}
+	rendered, err := renderContent(c.Request.Context(), renderRequest{
+		Project:   proj,
+		Path:      filePath,
+		Rev:       rev,
+		Format:    format,
+		Style:     c.Query("style"),
+		StartLine: res.StartLine,
+	}, res.Content)
+	if err != nil {
+		return nil, false, err
+	}
+	data["format"] = rendered.Format
+	data["language"] = rendered.Language
+	data["css"] = rendered.CSS
+	data["cached"] = rendered.Cached
+	if rendered.HTML != "" {
+		data["html"] = rendered.HTML
+	}
+	if rendered.Tokens != nil {
+		data["tokens"] = rendered.Tokens
+	}
+	return data, true, nil
+}
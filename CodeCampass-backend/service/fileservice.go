@@ -2,7 +2,7 @@ package service
 
 import (
 	"CodeCampass/models"
-	"CodeCampass/utils"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -32,17 +32,14 @@ func GetProjectFiles(c *gin.Context) {
 	}
 
 	// 查找项目
-	var proj models.Project
-	if err := utils.DB.Where("owner_id = ? and name = ?", userID, name).First(&proj).Error; err != nil {
+	proj, err := findUserProject(userID, name)
+	if err != nil {
 		c.JSON(404, gin.H{"error": "项目不存在"})
 		return
 	}
 
-	// 仓库目录
-	baseDir := fmt.Sprintf("/home/ubuntu/Repos/%d/%d", proj.OwnerId, proj.ID)
-
-	// 检查目录是否存在
-	if _, err := os.Stat(baseDir); os.IsNotExist(err) {
+	fileTree, synced := projectFileTree(proj)
+	if !synced {
 		c.JSON(200, gin.H{
 			"code":    0,
 			"message": "仓库未同步",
@@ -51,9 +48,6 @@ func GetProjectFiles(c *gin.Context) {
 		return
 	}
 
-	// 读取目录结构
-	fileTree := buildFileTree(baseDir, "")
-
 	c.JSON(200, gin.H{
 		"code":    0,
 		"message": "获取成功",
@@ -61,18 +55,35 @@ func GetProjectFiles(c *gin.Context) {
 	})
 }
 
+// projectFileTree 读取项目仓库的文件树，仓库尚未同步时 synced 为 false
+func projectFileTree(proj models.Project) (fileTree []map[string]interface{}, synced bool) {
+	baseDir := repoBaseDir(proj)
+
+	// 检查目录是否存在
+	if _, err := os.Stat(baseDir); os.IsNotExist(err) {
+		return nil, false
+	}
+
+	// 读取目录结构
+	return buildFileTree(baseDir, ""), true
+}
+
 // GetFileContent
 // @Summary 获取文件内容
 // @Tags 项目模块
 // @Security Bearer
 // @Param name query string true "项目名"
 // @Param path query string true "文件路径"
+// @Param rev query string false "版本（分支、标签或提交），默认读取当前工作区"
+// @Param start_line query int false "起始行，从 1 开始"
+// @Param end_line query int false "结束行（含），默认读到文件末尾"
+// @Param charset query string false "文件编码，默认自动检测"
+// @Param format query string false "返回格式：text（默认）、html（语法高亮）、tokens（按行的 token）、rendered（渲染 Markdown 与 Notebook）"
+// @Param style query string false "高亮样式，默认 github"
 // @Success 200 {object} map[string]interface{}
 // @Router /api/getFileContent [get]
 func GetFileContent(c *gin.Context) {
 	name := c.Query("name")
-	filePath := c.Query("path")
-
 	// 从中间件中取出当前登录用户ID
 	userID, exists := c.Get("userID")
 	if !exists {
@@ -81,53 +92,104 @@ func GetFileContent(c *gin.Context) {
 	}
 
 	// 查找项目
-	var proj models.Project
-	if err := utils.DB.Where("owner_id = ? and name = ?", userID, name).First(&proj).Error; err != nil {
+	proj, err := findUserProject(userID, name)
+	if err != nil {
 		c.JSON(404, gin.H{"error": "项目不存在"})
 		return
 	}
 
-	// 仓库目录
-	baseDir := fmt.Sprintf("/home/ubuntu/Repos/%d/%d", proj.OwnerId, proj.ID)
-	fullPath := filepath.Join(baseDir, filePath)
-
-	// 安全检查：确保文件路径在仓库目录内
-	relPath, err := filepath.Rel(baseDir, fullPath)
-	if err != nil || strings.HasPrefix(relPath, "..") {
-		c.JSON(400, gin.H{"error": "无效的文件路径"})
+	data, ok, err := fileContentData(c, proj)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
-
-	// 检查文件是否存在
-	info, err := os.Stat(fullPath)
-	if os.IsNotExist(err) {
-		c.JSON(404, gin.H{"error": "文件不存在"})
+	if !ok {
 		return
 	}
 
-	if info.IsDir() {
-		c.JSON(400, gin.H{"error": "路径是目录，不是文件"})
-		return
+	data["code"] = 0
+	c.JSON(200, data)
+}
+
+// readProjectFile 读取项目仓库内的文件，filePath 为相对仓库根目录的路径
+func readProjectFile(proj models.Project, filePath string) ([]byte, error) {
+	fullPath, info, err := resolveProjectPath(proj, filePath)
+	if err != nil {
+		return nil, err
 	}
 
 	// 检查文件大小（限制最大5MB）
 	if info.Size() > 5*1024*1024 {
-		c.JSON(400, gin.H{"error": "文件过大，超过5MB"})
-		return
+		return nil, newAPIError(http.StatusBadRequest, "文件过大，超过5MB")
 	}
 
 	// 读取文件内容
 	content, err := ioutil.ReadFile(fullPath)
 	if err != nil {
-		c.JSON(500, gin.H{"error": fmt.Sprintf("读取文件失败: %v", err)})
-		return
+		return nil, fmt.Errorf("读取文件失败: %v", err)
 	}
 
-	c.JSON(200, gin.H{
-		"code":    0,
-		"content": string(content),
-		"path":    filePath,
-	})
+	return content, nil
+}
+
+// errOutsideRepo 路径越出了仓库目录
+var errOutsideRepo = errors.New("路径不在仓库内")
+
+// resolveInRepo 把相对仓库根目录的路径转换为真实的绝对路径。除了字面检查，还会解析符号链接，
+// 确认真实位置仍在仓库目录内：仓库中提交的符号链接可能指向服务器上的任意文件。
+// 指向仓库外的符号链接按不存在处理
+func resolveInRepo(baseDir, rel string) (string, error) {
+	full := filepath.Join(baseDir, filepath.FromSlash(strings.TrimPrefix(rel, "/")))
+	if !pathWithin(baseDir, full) {
+		return "", errOutsideRepo
+	}
+	realBase, err := filepath.EvalSymlinks(baseDir)
+	if err != nil {
+		return "", err
+	}
+	realPath, err := filepath.EvalSymlinks(full)
+	if err != nil {
+		return "", err
+	}
+	if !pathWithin(realBase, realPath) {
+		return "", fmt.Errorf("%s 指向仓库外: %w", rel, os.ErrNotExist)
+	}
+	return realPath, nil
+}
+
+// pathWithin p 是否为 dir 本身或其下的路径（只做字面比较）
+func pathWithin(dir, p string) bool {
+	r, err := filepath.Rel(dir, p)
+	return err == nil && r != ".." && !strings.HasPrefix(r, ".."+string(filepath.Separator))
+}
+
+// resolveProjectPath 检查仓库内的文件路径，返回完整路径与文件信息
+func resolveProjectPath(proj models.Project, filePath string) (string, os.FileInfo, error) {
+	// 安全检查：确保文件路径（包括符号链接指向的位置）在仓库目录内
+	fullPath, err := resolveInRepo(repoBaseDir(proj), filePath)
+	if errors.Is(err, errOutsideRepo) {
+		return "", nil, newAPIError(http.StatusBadRequest, "无效的文件路径")
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil, newAPIError(http.StatusNotFound, "文件不存在")
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("读取文件失败: %v", err)
+	}
+
+	// 检查文件是否存在
+	info, err := os.Stat(fullPath)
+	if os.IsNotExist(err) {
+		return "", nil, newAPIError(http.StatusNotFound, "文件不存在")
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("读取文件失败: %v", err)
+	}
+
+	if info.IsDir() {
+		return "", nil, newAPIError(http.StatusBadRequest, "路径是目录，不是文件")
+	}
+	return fullPath, info, nil
 }
 
 // buildFileTree 构建文件树
@@ -0,0 +1,292 @@
+package service
+
+import (
+	"CodeCampass/models"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var errTooManyFiles = errors.New("too many files")
+
+// indexRepoFiles 遍历仓库，重建文件与目录索引，返回按语言统计的文件数；
+// maxFiles 大于 0 时文件数超出即返回配额错误，不写入索引
+func indexRepoFiles(proj models.Project, baseDir string, maxFiles int) (map[string]int, error) {
+	langCounts := make(map[string]int)
+	var files []models.Repo
+	dirs := map[string]*models.RepoDir{}
+	var dirOrder []string
+
+	// dirOf 取得目录的统计项，不存在时连同上层目录一起创建
+	var dirOf func(dir string) *models.RepoDir
+	dirOf = func(dir string) *models.RepoDir {
+		if d, ok := dirs[dir]; ok {
+			return d
+		}
+		parent := path.Dir(dir)
+		if parent == "." {
+			parent = ""
+		}
+		d := &models.RepoDir{ProjectID: proj.ID, Path: dir, Parent: parent}
+		dirs[dir] = d
+		dirOrder = append(dirOrder, dir)
+		if parent != "" {
+			dirOf(parent).ChildCount++
+		}
+		return d
+	}
+
+	now := time.Now()
+	err := filepath.Walk(baseDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() {
+			if info.Name() == ".git" {
+				return filepath.SkipDir // 导入完整历史时 .git 可能很大，不计入文件索引
+			}
+			return nil
+		}
+		if info.Name() == ".git" {
+			return nil // 子模块中指向 .git/modules 的文件
+		}
+
+		if maxFiles > 0 && len(files) >= maxFiles {
+			return errTooManyFiles
+		}
+
+		rel, _ := filepath.Rel(baseDir, p)
+		rel = filepath.ToSlash(rel)
+		dir := path.Dir(rel)
+		if dir == "." {
+			dir = ""
+		}
+		lang := detectLanguage(p)
+		if lang != "" {
+			langCounts[lang]++
+		}
+
+		// 超大文件与前 8KB 含 NUL 的文件视为二进制
+		isText := info.Mode().IsRegular() && info.Size() <= 5*1024*1024 && !fileLooksBinary(p)
+		lfsPointer := isText && fileIsLFSPointer(p, info.Size())
+		status := models.FileIndexPending
+		if !isText || lfsPointer {
+			status = models.FileIndexSkipped
+		}
+		files = append(files, models.Repo{
+			ProjectID:    proj.ID,
+			FilePath:     rel,
+			Dir:          dir,
+			FileType:     strings.TrimPrefix(filepath.Ext(p), "."),
+			Language:     lang,
+			Size:         info.Size(),
+			LastModified: info.ModTime(),
+			IsText:       isText,
+			IndexStatus:  status,
+			CreatedAt:    now,
+			UpdatedAt:    now,
+			IsLFSPointer: lfsPointer,
+		})
+
+		if dir != "" {
+			dirOf(dir).ChildCount++
+			for d := dir; d != ""; d = dirs[d].Parent {
+				dirs[d].FileCount++
+				dirs[d].Size += info.Size()
+			}
+		}
+		return nil
+	})
+	if err == errTooManyFiles {
+		return nil, newAPIError(http.StatusRequestEntityTooLarge, fmt.Sprintf("仓库文件数超出配额 %d", maxFiles))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// 子模块的根目录：已检出的标记在目录上，未检出的单独记为一项
+	for _, root := range gitSubmoduleRoots(baseDir) {
+		if d, ok := dirs[root]; ok {
+			d.IsSubmodule = true
+			continue
+		}
+		dir := path.Dir(root)
+		if dir == "." {
+			dir = ""
+		}
+		files = append(files, models.Repo{
+			ProjectID:       proj.ID,
+			FilePath:        root,
+			Dir:             dir,
+			IndexStatus:     models.FileIndexSkipped,
+			CreatedAt:       now,
+			UpdatedAt:       now,
+			IsSubmoduleRoot: true,
+		})
+		if dir != "" {
+			dirOf(dir).ChildCount++
+		}
+	}
+
+	dirList := make([]models.RepoDir, 0, len(dirOrder))
+	for _, d := range dirOrder {
+		dirList = append(dirList, *dirs[d])
+	}
+	return langCounts, models.ReplaceRepoIndex(proj.ID, files, dirList)
+}
+
+// fileLooksBinary 读取文件开头判断是否为二进制
+func fileLooksBinary(p string) bool {
+	f, err := os.Open(p)
+	if err != nil {
+		return true
+	}
+	defer f.Close()
+	head := make([]byte, 8192)
+	n, _ := f.Read(head)
+	return isBinaryContent(head[:n])
+}
+
+// treeNode 文件树中的一项
+type treeNode struct {
+	Name         string     `json:"name"`
+	Path         string     `json:"path"`
+	Type         string     `json:"type"` // dir、file 或 submodule（未检出的子模块）
+	Size         int64      `json:"size"`
+	FileCount    int        `json:"file_count,omitempty"`
+	ChildCount   int        `json:"child_count,omitempty"`
+	FileType     string     `json:"file_type,omitempty"`
+	Language     string     `json:"language,omitempty"`
+	IsText       *bool      `json:"is_text,omitempty"`
+	LastModified *time.Time `json:"last_modified,omitempty"`
+	IndexStatus  string     `json:"index_status,omitempty"`
+	LFSPointer   bool       `json:"lfs_pointer,omitempty"` // 未拉取 LFS 对象的文件
+	Submodule    bool       `json:"submodule,omitempty"`   // 子模块的根目录
+}
+
+func dirNode(d models.RepoDir) treeNode {
+	return treeNode{
+		Name:       path.Base(d.Path),
+		Path:       d.Path,
+		Type:       "dir",
+		Size:       d.Size,
+		FileCount:  d.FileCount,
+		ChildCount: d.ChildCount,
+		Submodule:  d.IsSubmodule,
+	}
+}
+
+func fileNode(f models.Repo) treeNode {
+	if f.IsSubmoduleRoot {
+		// 未检出的子模块
+		return treeNode{
+			Name:        path.Base(f.FilePath),
+			Path:        f.FilePath,
+			Type:        "submodule",
+			IndexStatus: f.IndexStatus,
+			Submodule:   true,
+		}
+	}
+	isText, modified := f.IsText, f.LastModified
+	return treeNode{
+		Name:         path.Base(f.FilePath),
+		Path:         f.FilePath,
+		Type:         "file",
+		Size:         f.Size,
+		FileType:     f.FileType,
+		Language:     f.Language,
+		IsText:       &isText,
+		LastModified: &modified,
+		IndexStatus:  f.IndexStatus,
+		LFSPointer:   f.IsLFSPointer,
+	}
+}
+
+// ListProjectTreeV1
+// @Summary 按目录分页获取文件树
+// @Tags 项目模块 v1
+// @Security Bearer
+// @Param id path int true "项目ID"
+// @Param path query string false "目录路径，默认仓库根目录"
+// @Param offset query int false "跳过的子项数"
+// @Param limit query int false "返回的子项数，默认 100，最大 500"
+// @Success 200 {object} map[string]interface{}
+// @Router /api/v1/projects/{id}/tree [get]
+func ListProjectTreeV1(c *gin.Context) {
+	proj, ok := loadProjectV1(c)
+	if !ok {
+		return
+	}
+
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		respondError(c, newAPIError(http.StatusBadRequest, "无效的 offset"))
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 {
+		respondError(c, newAPIError(http.StatusBadRequest, "无效的 limit"))
+		return
+	}
+	if limit > 500 {
+		limit = 500
+	}
+
+	baseDir := repoBaseDir(proj)
+	_, statErr := os.Stat(baseDir)
+	// 旧版导入的索引缺少目录信息，仓库还在时就地重建
+	if models.RepoIndexOutdated(proj.ID) && statErr == nil {
+		if _, err := indexRepoFiles(proj, baseDir, 0); err != nil {
+			respondError(c, err)
+			return
+		}
+		// 重建后所有文件都是 pending，已有 embedding 的文件恢复为已构建
+		models.MarkEmbeddedFiles(proj.ID)
+	}
+
+	dir := strings.Trim(c.Query("path"), "/")
+	data := gin.H{"path": dir}
+	if dir != "" {
+		if dir, err = cleanRepoPath(dir); err != nil {
+			respondError(c, err)
+			return
+		}
+		d, err := models.FindRepoDir(proj.ID, dir)
+		if err != nil {
+			respondError(c, newAPIError(http.StatusNotFound, "目录不存在"))
+			return
+		}
+		data["path"], data["node"] = dir, dirNode(d)
+	}
+
+	dirs, files, total := models.GetRepoTreeLevel(proj.ID, dir, offset, limit)
+	if total == 0 && dir == "" && os.IsNotExist(statErr) {
+		respondError(c, newAPIError(http.StatusConflict, "仓库未同步"))
+		return
+	}
+
+	items := make([]treeNode, 0, len(dirs)+len(files))
+	for _, d := range dirs {
+		items = append(items, dirNode(d))
+	}
+	for _, f := range files {
+		items = append(items, fileNode(f))
+	}
+	hasMore := int64(offset+len(items)) < total
+	data["items"] = items
+	data["total"] = total
+	data["offset"] = offset
+	data["has_more"] = hasMore
+	if hasMore {
+		data["next_offset"] = offset + len(items)
+	}
+	respondOK(c, http.StatusOK, "获取成功", data)
+}
@@ -2,6 +2,7 @@ package service
 
 import (
 	"CodeCampass/models"
+	"CodeCampass/storage"
 	"CodeCampass/utils"
 	"context"
 	"encoding/json"
@@ -11,7 +12,6 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
-	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sashabaranov/go-openai"
@@ -23,6 +23,12 @@ import (
 // @Tags 项目模块
 // @Security Bearer
 // @Param name query string true "项目名"
+// @Param history query string false "克隆的历史：shallow（默认）、partial、full"
+// @Param depth query int false "history 为 partial 时克隆的提交数，默认 100"
+// @Param submodules query bool false "是否检出子模块"
+// @Param submodule_depth query int false "子模块的历史深度：0 只克隆最新提交（默认），-1 完整历史"
+// @Param submodule_ignore query string false "不检出的子模块路径，逗号分隔，支持 * 通配"
+// @Param lfs query string false "fetch 拉取 LFS 对象，skip 保留为指针（默认）"
 // @Success 200 {object} map[string]interface{}
 // @Router /api/importProjectRepo [post]
 func ImportProjectRepo(c *gin.Context) {
@@ -38,153 +44,408 @@ func ImportProjectRepo(c *gin.Context) {
 	}
 
 	// 查找项目
-	var proj models.Project
-	if err := utils.DB.Where("owner_id = ? and name = ?", userID, name).First(&proj).Error; err != nil {
+	proj, err := findUserProject(userID, name)
+	if err != nil {
 		c.JSON(404, gin.H{"error": "项目不存在"})
 		return
 	}
+	if !canManageProject(userID.(uint), proj) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "无权限导入此项目"})
+		return
+	}
+	if err := applyHistoryOptions(c, &proj); err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	if err := applyCloneOptions(c, &proj); err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
 
-	// 目标路径：存储在 ubuntu 用户目录下的 Repos 文件夹
-	baseDir := fmt.Sprintf("/home/ubuntu/Repos/%d/%d", proj.OwnerId, proj.ID)
-	os.MkdirAll(baseDir, 0755)
+	baseDir, err := importProject(proj)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
 
-	// 如果之前存在仓库则先删除（可选）
-	os.RemoveAll(baseDir)
+	c.JSON(200, gin.H{
+		"code":    0,
+		"message": "导入完成，仓库已保存至云主机，embedding 正在后台构建",
+		"path":    baseDir,
+	})
+}
 
-	// git clone
-	cmd := exec.Command("git", "clone", "--depth", "1", proj.RepoUrl, baseDir)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		c.JSON(500, gin.H{"error": fmt.Sprintf("git clone 失败: %v", err)})
+// repoBaseDir 项目仓库在工作区中的存放目录，根目录由 storage.root 配置
+func repoBaseDir(proj models.Project) string {
+	return storage.ProjectDir(proj.OwnerId, proj.ID)
+}
+
+// recordDiskUsage 统计项目仓库与索引占用的磁盘空间
+func recordDiskUsage(proj models.Project) {
+	usage, err := storage.ProjectUsage(proj.OwnerId, proj.ID, searchIndexExt, navIndexExt)
+	if err != nil {
+		fmt.Printf("警告: 统计项目 %d 的磁盘占用失败: %v\n", proj.ID, err)
 		return
 	}
+	models.UpdateProjectDiskUsage(proj.ID, usage)
+}
 
-	// 清空旧索引
-	utils.DB.Where("project_id = ?", proj.ID).Delete(&models.Repo{})
+// abortImport 导入失败时删除临时克隆目录，避免超出配额的仓库继续占用磁盘；
+// 之前导入的仓库保持不变，恢复原来的索引状态
+func abortImport(proj models.Project, cloneDir string) {
+	os.RemoveAll(cloneDir)
+	if _, err := os.Stat(repoBaseDir(proj)); err == nil {
+		models.UpdateProjectIndexStatus(proj.ID, proj.IndexStatus)
+		return
+	}
+	models.UpdateProjectIndexStatus(proj.ID, models.IndexStatusFailed)
+	models.UpdateProjectDiskUsage(proj.ID, 0)
+}
 
-	// 遍历文件并建立索引
-	filepath.Walk(baseDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil || info.IsDir() {
-			return nil
+// deleteProject 删除项目，同时删除工作区中的仓库、附属索引文件与数据库中的索引，
+// 以及同步计划、webhook 与通知的设置和密钥、快照；项目正在同步或导入时返回 409
+func deleteProject(proj models.Project) error {
+	unlock, err := lockProjectSync(proj.ID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	baseDir := repoBaseDir(proj)
+	for _, p := range []string{baseDir, baseDir + ".importing",
+		storage.SidecarPath(proj.OwnerId, proj.ID, searchIndexExt), storage.SidecarPath(proj.OwnerId, proj.ID, navIndexExt)} {
+		if err := os.RemoveAll(p); err != nil {
+			return fmt.Errorf("删除项目文件失败: %v", err)
 		}
+	}
+	if err := models.DeleteProjectIndexes(proj.ID); err != nil {
+		return err
+	}
+	if err := deleteProjectSettings(proj); err != nil {
+		return err
+	}
+	deleteProjectSnapshots(proj)
+	models.UpdateProjectDiskUsage(proj.ID, 0)
+	return utils.DB.Delete(&proj).Error
+}
 
-		relPath, _ := filepath.Rel(baseDir, path)
-		ext := strings.TrimPrefix(filepath.Ext(path), ".")
+// deleteProjectSettings 删除项目的同步计划、通知 webhook、推送记录与 Redis 中的密钥
+func deleteProjectSettings(proj models.Project) error {
+	ctx := context.Background()
+	if err := models.DeleteProjectSync(proj.ID); err != nil {
+		return err
+	}
+	reloadSyncSchedules()
+	for _, hook := range models.GetProjectNotificationHooks(proj.ID) {
+		if err := models.DeleteNotificationHook(&hook); err != nil {
+			return err
+		}
+		utils.DelSecret(ctx, notifySecretKey(hook.ID))
+	}
+	utils.DelSecret(ctx, webhookSecretKey(proj.ID))
+	return models.DeleteWebhookDeliveries(proj.ID)
+}
 
-		isText := true
-		if info.Size() > 5*1024*1024 {
-			isText = false // 超大文件可能是二进制
+// deleteProjectSnapshots 删除项目的快照；未配置快照存储或删除失败时只记录警告
+func deleteProjectSnapshots(proj models.Project) {
+	store, err := snapshotStore()
+	if err != nil {
+		return
+	}
+	ctx := context.Background()
+	list, err := store.List(ctx, snapshotPrefix(proj))
+	if err != nil {
+		fmt.Printf("警告: 列出项目 %d 的快照失败: %v\n", proj.ID, err)
+		return
+	}
+	for _, info := range list {
+		if err := store.Delete(ctx, info.Key); err != nil {
+			fmt.Printf("警告: 删除项目 %d 的快照 %s 失败: %v\n", proj.ID, info.Key, err)
 		}
+	}
+}
 
-		utils.DB.Create(&models.Repo{
-			ProjectID:    proj.ID,
-			FilePath:     relPath,
-			FileType:     ext,
-			Size:         info.Size(),
-			LastModified: info.ModTime(),
-			IsText:       isText,
-			CreatedAt:    time.Now(),
-			UpdatedAt:    time.Now(),
-		})
-		return nil
-	})
+// importProject 克隆项目仓库并建立文件索引，embedding 在后台异步构建，返回仓库目录；
+// 导入期间一直持有同步锁，项目正在同步或导入时返回 409
+func importProject(proj models.Project) (string, error) {
+	if proj.RepoUrl == "" {
+		return "", newAPIError(http.StatusBadRequest, "项目未设置仓库地址")
+	}
+	unlock, err := lockProjectSync(proj.ID)
+	if err != nil {
+		return "", err
+	}
+	baseDir, err := cloneProject(proj)
+	if err != nil {
+		unlock()
+		return "", err
+	}
 
-	// 异步构建 embedding（不阻塞响应）
+	// 异步构建 embedding（不阻塞响应），构建完成后释放锁
 	go func() {
-		// 发送开始构建事件
-		GetSSEManager().Publish(proj.ID, SSEEvent{
-			Event: "embedding_start",
+		defer unlock()
+		buildProjectIndexes(proj, baseDir, nil)
+	}()
+	return baseDir, nil
+}
+
+// cloneProject 克隆仓库、替换之前的仓库目录并建立文件索引
+func cloneProject(proj models.Project) (string, error) {
+	// 目标路径：工作区根目录下的 <所有者ID>/<项目ID>
+	baseDir := repoBaseDir(proj)
+
+	// 克隆前先按 GitHub API 报告的大小检查配额，克隆过程中再由看门狗统计实际大小
+	quota := userQuota(proj.OwnerId)
+	sizeLimit := quota.repoSizeLimit(proj)
+	if sizeLimit > 0 {
+		if size := probeRepoSize(proj.RepoUrl); size > sizeLimit {
+			abortImport(proj, "")
+			return "", repoTooLargeError(size, sizeLimit)
+		}
+	}
+
+	// 先克隆到临时目录，检查通过后再替换之前的仓库，失败时旧仓库保持可用
+	cloneDir := baseDir + ".importing"
+	os.RemoveAll(cloneDir)
+	os.MkdirAll(filepath.Dir(baseDir), 0755)
+
+	// git clone
+	models.UpdateProjectIndexStatus(proj.ID, models.IndexStatusCloning)
+	cmd := exec.Command("git", cloneArgs(proj, cloneDir)...)
+	cmd.Env = gitEnv()
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := runWithSizeWatchdog(cmd, cloneDir, sizeLimit); err != nil {
+		abortImport(proj, cloneDir)
+		if errorStatus(err) != http.StatusInternalServerError {
+			return "", err
+		}
+		return "", fmt.Errorf("git clone 失败: %v", err)
+	}
+	// 按项目设置检出子模块、拉取 LFS 对象
+	if err := prepareWorkTree(proj, cloneDir, sizeLimit); err != nil {
+		abortImport(proj, cloneDir)
+		return "", err
+	}
+	// 看门狗按间隔检查，最后一次检查之后写入的数据在这里补查
+	if sizeLimit > 0 {
+		if size, _ := storage.DirUsage(cloneDir); size > sizeLimit {
+			abortImport(proj, cloneDir)
+			return "", repoTooLargeError(size, sizeLimit)
+		}
+	}
+
+	// 重建文件索引，同时按文件数统计语言；索引只记录相对路径，可以在替换目录前建立
+	langCounts, err := indexRepoFiles(proj, cloneDir, quota.MaxFiles)
+	if err != nil {
+		abortImport(proj, cloneDir)
+		if errorStatus(err) != http.StatusInternalServerError {
+			return "", err
+		}
+		return "", fmt.Errorf("建立文件索引失败: %v", err)
+	}
+
+	os.RemoveAll(baseDir)
+	if err := os.Rename(cloneDir, baseDir); err != nil {
+		abortImport(proj, cloneDir)
+		return "", fmt.Errorf("替换仓库目录失败: %v", err)
+	}
+
+	proj.HeadCommit = gitHeadCommit(baseDir)
+	utils.DB.Model(&proj).Updates(map[string]interface{}{
+		"language":     dominantLanguage(langCounts),
+		"index_status": models.IndexStatusIndexing,
+		"head_commit":  proj.HeadCommit,
+	})
+	return baseDir, nil
+}
+
+// buildProjectIndexes 依次构建搜索索引、符号索引、调用图、embedding 与分层摘要；
+// changed 为 nil 时重建全部 embedding，否则只重建其中列出的文件
+func buildProjectIndexes(proj models.Project, baseDir string, changed map[string]bool) error {
+	// 搜索索引、符号索引与调用图不调用 LLM，先于 embedding 构建，失败不影响后续阶段
+	if err := indexProjectSearch(proj, baseDir); err != nil {
+		fmt.Printf("警告: 构建搜索索引失败: %v\n", err)
+	}
+	if err := indexProjectSymbols(proj, baseDir); err != nil {
+		fmt.Printf("警告: 构建符号索引失败: %v\n", err)
+	}
+	if err := indexProjectGraphs(proj, baseDir); err != nil {
+		fmt.Printf("警告: 构建调用图失败: %v\n", err)
+	}
+	recordDiskUsage(proj)
+
+	// 发送开始构建事件
+	publishProjectEvent(proj.ID, SSEEvent{
+		Event: "embedding_start",
+		Data: gin.H{
+			"message":   "开始构建 embedding",
+			"project_id": proj.ID,
+		},
+	})
+
+	err := buildProjectEmbedding(utils.DB, proj.ID, baseDir, changed)
+	if err != nil {
+		// embedding 构建失败不影响整体导入，记录警告即可
+		fmt.Printf("警告: 构建 embedding 失败: %v\n", err)
+		models.UpdateProjectIndexStatus(proj.ID, models.IndexStatusFailed)
+		// 发送失败事件
+		publishProjectEvent(proj.ID, SSEEvent{
+			Event: "embedding_error",
+			Data: gin.H{
+				"message":   fmt.Sprintf("构建 embedding 失败: %v", err),
+				"project_id": proj.ID,
+				"error":     err.Error(),
+			},
+		})
+	} else {
+		fmt.Printf("项目 %d 的 embedding 构建完成\n", proj.ID)
+		models.UpdateProjectIndexStatus(proj.ID, models.IndexStatusReady)
+		// 发送完成事件
+		publishProjectEvent(proj.ID, SSEEvent{
+			Event: "embedding_complete",
 			Data: gin.H{
-				"message":   "开始构建 embedding",
+				"message":   "Embedding 构建完成",
 				"project_id": proj.ID,
 			},
 		})
 
-		err := BuildProjectEmbedding(utils.DB, proj.ID, baseDir)
-		if err != nil {
-			// embedding 构建失败不影响整体导入，记录警告即可
-			fmt.Printf("警告: 构建 embedding 失败: %v\n", err)
-			// 发送失败事件
-			GetSSEManager().Publish(proj.ID, SSEEvent{
-				Event: "embedding_error",
-				Data: gin.H{
-					"message":   fmt.Sprintf("构建 embedding 失败: %v", err),
-					"project_id": proj.ID,
-					"error":     err.Error(),
-				},
-			})
-		} else {
-			fmt.Printf("项目 %d 的 embedding 构建完成\n", proj.ID)
-			// 发送完成事件
-			GetSSEManager().Publish(proj.ID, SSEEvent{
-				Event: "embedding_complete",
-				Data: gin.H{
-					"message":   "Embedding 构建完成",
-					"project_id": proj.ID,
-				},
-			})
+		// 分层摘要依赖 LLM，放在最后；未变化的文件沿用上次的摘要
+		if err := indexProjectSummaries(proj, baseDir); err != nil {
+			fmt.Printf("警告: 生成项目概览失败: %v\n", err)
 		}
-	}()
-
-	c.JSON(200, gin.H{
-		"code":    0,
-		"message": "导入完成，仓库已保存至云主机，embedding 正在后台构建",
-		"path":    baseDir,
-	})
+	}
+	return err
 }
 
 func BuildProjectEmbedding(db *gorm.DB, projectID uint, basePath string) error {
+	return buildProjectEmbedding(db, projectID, basePath, nil)
+}
+
+// buildProjectEmbedding 构建项目的 embedding。changed 不为 nil 时增量构建：
+// 只重建 changed 中的文件与此前没有 embedding 的文件，其余文件沿用已有结果
+func buildProjectEmbedding(db *gorm.DB, projectID uint, basePath string, changed map[string]bool) error {
 	// 获取项目所有者ID
 	var proj models.Project
 	if err := db.Where("id = ?", projectID).First(&proj).Error; err != nil {
 		return fmt.Errorf("项目不存在")
 	}
 
-	// 从 Redis 获取用户的 API Key
-	apiKey := utils.Red.Get(utils.Red.Context(), fmt.Sprintf("openai_key:%d", proj.OwnerId)).Val()
-	
-	// 如果 Redis 中没有，从环境变量获取（向后兼容）
-	if apiKey == "" {
-		apiKey = os.Getenv("OPENAI_API_KEY")
+	// 组织项目使用组织的凭证，个人项目使用所有者的凭证
+	cfg, err := resolveLLMConfig(proj, proj.OwnerId)
+	if err != nil {
+		fmt.Println("警告: 未设置 API Key，跳过 embedding 构建")
+		return err
 	}
 
-	if apiKey == "" {
-		fmt.Println("警告: 未设置 OPENAI_API_KEY，跳过 embedding 构建")
-		return fmt.Errorf("OPENAI_API_KEY 未设置")
+	// 超出当月预算时拒绝索引；降级模式下只索引代码文件，且每个文件取更短的内容
+	budget := projectBudgetStatus(proj, proj.OwnerId)
+	degraded, maxContent := false, 3000
+	if budget.Exceeded(0) {
+		if !budget.Degraded() {
+			return budget.budgetError()
+		}
+		degraded, maxContent = true, 1000
 	}
+	client := cfg.newMeteredClient(projectUsageScope(proj, proj.OwnerId))
 
-	// 使用 Config 配置 BaseURL
-	cfg := openai.DefaultConfig(apiKey)
-	//cfg.BaseURL = "https://api.chatanywhere.tech" // 国内首选
-	cfg.BaseURL = "https://api.chatanywhere.org" // 国外使用
-	client := openai.NewClientWithConfig(cfg)
+	// 重新导入时清空旧的 embedding，增量构建时只删除变更文件的
+	var embedded map[string]bool
+	if changed == nil {
+		db.Where("project_id = ?", projectID).Delete(&models.ProjectEmbedding{})
+	} else {
+		paths := make([]string, 0, len(changed))
+		for p := range changed {
+			paths = append(paths, p)
+		}
+		if len(paths) > 0 {
+			db.Where("project_id = ? and file_path in ?", projectID, paths).Delete(&models.ProjectEmbedding{})
+		}
+		embedded = models.GetEmbeddedFilePaths(projectID)
+		models.MarkEmbeddedFiles(projectID)
+	}
+
+	// 达到 embedding 数配额后，其余文件不再构建
+	maxChunks, chunks, quotaNotified := userQuota(proj.OwnerId).MaxChunks, len(embedded), false
 
 	return filepath.Walk(basePath, func(path string, info os.FileInfo, err error) error {
-		if err != nil || info.IsDir() {
+		if err != nil {
 			return nil
 		}
+		if info.IsDir() {
+			if info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Name() == ".git" {
+			return nil // 子模块中指向 .git/modules 的文件
+		}
+		relPath, _ := filepath.Rel(basePath, path)
+		relPath = filepath.ToSlash(relPath)
+		if !info.Mode().IsRegular() {
+			models.UpdateRepoIndexStatus(projectID, relPath, models.FileIndexSkipped)
+			return nil // 符号链接可能指向仓库外的文件
+		}
+		if embedded[relPath] {
+			return nil // 未变化且已有 embedding
+		}
 		if strings.HasSuffix(path, ".png") || strings.HasSuffix(path, ".exe") {
+			models.UpdateRepoIndexStatus(projectID, relPath, models.FileIndexSkipped)
 			return nil // 跳过二进制文件
 		}
+		// 索引过程中花费达到上限时，拒绝模式立即停止，降级模式转为精简索引
+		if !degraded && budget.Exceeded(client.Spent()) {
+			if budget.Action != models.BudgetActionDegrade {
+				return budget.budgetError()
+			}
+			degraded, maxContent = true, 1000
+		}
+		if degraded {
+			if lang := detectLanguage(path); lang == "" || nonCodeLanguages[lang] {
+				models.UpdateRepoIndexStatus(projectID, relPath, models.FileIndexSkipped)
+				return nil
+			}
+		}
+		if maxChunks > 0 && chunks >= maxChunks {
+			if !quotaNotified {
+				quotaNotified = true
+				fmt.Printf("项目 %d 的 embedding 数达到配额 %d，其余文件不再构建\n", projectID, maxChunks)
+				publishProjectEvent(projectID, SSEEvent{
+					Event: "embedding_quota",
+					Data: gin.H{
+						"message":    fmt.Sprintf("embedding 数达到配额上限 %d，其余文件未构建", maxChunks),
+						"project_id": projectID,
+					},
+				})
+			}
+			models.UpdateRepoIndexStatus(projectID, relPath, models.FileIndexSkipped)
+			return nil
+		}
 
 		contentBytes, err := os.ReadFile(path)
 		if err != nil {
 			fmt.Println("读取文件失败:", path, err)
+			models.UpdateRepoIndexStatus(projectID, relPath, models.FileIndexFailed)
 			return nil
 		}
+		if isLFSPointer(contentBytes) {
+			models.UpdateRepoIndexStatus(projectID, relPath, models.FileIndexSkipped)
+			return nil // 未拉取的 LFS 文件只有指针
+		}
 		content := string(contentBytes)
-		if len(content) > 3000 {
-			content = content[:3000] // 简化：只取前3k
+		if len(content) > maxContent {
+			content = content[:maxContent] // 简化：只取前3k，降级时取前1k
 		}
 
 		// 调用 ChatAnywhere embedding 接口
 		embResp, err := client.CreateEmbeddings(context.Background(), openai.EmbeddingRequest{
-			Model: openai.SmallEmbedding3,
+			Model: openai.EmbeddingModel(cfg.EmbeddingModel),
 			Input: []string{content},
 		})
 		if err != nil {
 			fmt.Println("embedding error:", err)
+			models.UpdateRepoIndexStatus(projectID, relPath, models.FileIndexFailed)
 			return nil
 		}
 
@@ -197,13 +458,15 @@ func BuildProjectEmbedding(db *gorm.DB, projectID uint, basePath string) error {
 			return nil
 		}
 
-		// 存入数据库
+		// 存入数据库，路径相对仓库根目录
 		db.Create(&models.ProjectEmbedding{
 			ProjectID: projectID,
-			FilePath:  path,
+			FilePath:  relPath,
 			Content:   content,
 			Embedding: string(embJSON), // Embedding 字段数据库类型 TEXT / LONGTEXT
 		})
+		models.UpdateRepoIndexStatus(projectID, relPath, models.FileIndexEmbedded)
+		chunks++
 
 		return nil
 	})
@@ -0,0 +1,466 @@
+package service
+
+import (
+	"CodeCampass/models"
+	"CodeCampass/utils"
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 提交历史、blame 与历史版本的文件都直接从本地克隆中读取
+
+const (
+	defaultPartialDepth = 100
+	maxHistoryDepth     = 10000
+	maxDiffBytes        = 1 << 20 // 提交详情中返回的 diff 总量上限
+	maxHistoryFileSize  = 5 * 1024 * 1024
+)
+
+// revPattern 允许的版本写法：提交哈希、分支、标签以及 HEAD~1 之类的相对写法
+var revPattern = regexp.MustCompile(`^[A-Za-z0-9._/~^@{}-]+$`)
+
+var hashPattern = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// cloneArgs 按项目的历史深度与跟踪的分支生成 git clone 参数
+func cloneArgs(proj models.Project, baseDir string) []string {
+	args := []string{"clone"}
+	switch {
+	case proj.HistoryDepth == 0:
+		args = append(args, "--depth", "1")
+	case proj.HistoryDepth > 0:
+		args = append(args, "--depth", strconv.Itoa(proj.HistoryDepth))
+	}
+	if proj.TrackedRef != "" {
+		args = append(args, "--branch", proj.TrackedRef)
+	}
+	return append(args, proj.RepoUrl, baseDir)
+}
+
+// normalizeTrackedRef 校验跟踪的分支名，允许带 refs/heads/ 前缀
+func normalizeTrackedRef(ref string) (string, error) {
+	ref = strings.TrimPrefix(strings.TrimSpace(ref), "refs/heads/")
+	if ref == "" {
+		return "", nil
+	}
+	if !revPattern.MatchString(ref) || strings.HasPrefix(ref, "-") || strings.Contains(ref, "..") ||
+		strings.ContainsAny(ref, "~^{}") || strings.HasSuffix(ref, "/") || strings.HasSuffix(ref, ".lock") {
+		return "", newAPIError(http.StatusBadRequest, "无效的分支名")
+	}
+	return ref, nil
+}
+
+// applyHistoryOptions 读取导入请求中的 history 与 depth 参数并保存到项目；
+// 未指定 history 时沿用项目上次导入的设置
+func applyHistoryOptions(c *gin.Context, proj *models.Project) error {
+	depth := proj.HistoryDepth
+	switch c.Query("history") {
+	case "":
+		return nil
+	case "shallow":
+		depth = 0
+	case "full":
+		depth = -1
+	case "partial":
+		n, err := strconv.Atoi(c.DefaultQuery("depth", strconv.Itoa(defaultPartialDepth)))
+		if err != nil || n <= 0 {
+			return newAPIError(http.StatusBadRequest, "无效的 depth")
+		}
+		if n > maxHistoryDepth {
+			n = maxHistoryDepth
+		}
+		depth = n
+	default:
+		return newAPIError(http.StatusBadRequest, "history 只能是 shallow、partial 或 full")
+	}
+	if depth == proj.HistoryDepth {
+		return nil
+	}
+	if err := utils.DB.Model(proj).Update("history_depth", depth).Error; err != nil {
+		return err
+	}
+	proj.HistoryDepth = depth
+	return nil
+}
+
+// runGit 在仓库目录下执行 git 命令，失败时带上 stderr
+func runGit(baseDir string, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", append([]string{"-C", baseDir}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s 失败: %s", args[0], strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// clonedRepoDir 返回项目的本地克隆目录，尚未导入时返回 409
+func clonedRepoDir(proj models.Project) (string, error) {
+	baseDir := repoBaseDir(proj)
+	if _, err := os.Stat(filepath.Join(baseDir, ".git")); err != nil {
+		return "", newAPIError(http.StatusConflict, "仓库未同步")
+	}
+	return baseDir, nil
+}
+
+// resolveCommit 把版本解析为完整的提交哈希，rev 为空时取 HEAD
+func resolveCommit(baseDir, rev string) (string, error) {
+	if rev == "" {
+		rev = "HEAD"
+	}
+	if strings.HasPrefix(rev, "-") || strings.Contains(rev, "..") || !revPattern.MatchString(rev) {
+		return "", newAPIError(http.StatusBadRequest, "无效的版本")
+	}
+	out, err := runGit(baseDir, "rev-parse", "--verify", "--quiet", rev+"^{commit}")
+	if err != nil {
+		return "", newAPIError(http.StatusNotFound, "提交不存在（浅克隆的项目只包含最近的提交）")
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// cleanRepoPath 规范化仓库内的相对路径，拒绝跳出仓库的路径
+func cleanRepoPath(p string) (string, error) {
+	if strings.Contains(p, "\\") {
+		p = strings.ReplaceAll(p, "\\", "/")
+	}
+	if p == "" || strings.HasPrefix(p, "/") || strings.HasPrefix(p, "../") || p == ".." {
+		return "", newAPIError(http.StatusBadRequest, "无效的文件路径")
+	}
+	cleaned := path.Clean(p)
+	if cleaned == "." || strings.HasPrefix(cleaned, "../") {
+		return "", newAPIError(http.StatusBadRequest, "无效的文件路径")
+	}
+	return cleaned, nil
+}
+
+// isShallowRepo 仓库是否为浅克隆
+func isShallowRepo(baseDir string) bool {
+	out, err := runGit(baseDir, "rev-parse", "--is-shallow-repository")
+	return err == nil && strings.TrimSpace(string(out)) == "true"
+}
+
+// commitInfo 提交的元信息
+type commitInfo struct {
+	Hash          string    `json:"hash"`
+	Parents       []string  `json:"parents"`
+	AuthorName    string    `json:"author_name"`
+	AuthorEmail   string    `json:"author_email"`
+	AuthorDate    time.Time `json:"author_date"`
+	CommitterName string    `json:"committer_name"`
+	CommitDate    time.Time `json:"commit_date"`
+	Subject       string    `json:"subject"`
+	Body          string    `json:"body,omitempty"`
+}
+
+// 字段用 0x1f 分隔、记录用 0x1e 分隔，避免与提交说明中的字符冲突
+const commitFormat = "--format=%H%x1f%P%x1f%an%x1f%ae%x1f%aI%x1f%cn%x1f%cI%x1f%s%x1f%b%x1e"
+
+func parseCommits(out []byte) []commitInfo {
+	commits := make([]commitInfo, 0)
+	for _, rec := range strings.Split(string(out), "\x1e") {
+		fields := strings.Split(strings.TrimLeft(rec, "\n"), "\x1f")
+		if len(fields) != 9 {
+			continue
+		}
+		ci := commitInfo{
+			Hash:          fields[0],
+			Parents:       strings.Fields(fields[1]),
+			AuthorName:    fields[2],
+			AuthorEmail:   fields[3],
+			CommitterName: fields[5],
+			Subject:       fields[7],
+			Body:          strings.TrimSpace(fields[8]),
+		}
+		ci.AuthorDate, _ = time.Parse(time.RFC3339, fields[4])
+		ci.CommitDate, _ = time.Parse(time.RFC3339, fields[6])
+		commits = append(commits, ci)
+	}
+	return commits
+}
+
+// gitLog 分页查询提交历史，可按路径与作者（不区分大小写的子串）过滤
+func gitLog(baseDir, commit, filePath, author string, offset, limit int) ([]commitInfo, bool, error) {
+	args := []string{"log", commitFormat, "--skip=" + strconv.Itoa(offset), "--max-count=" + strconv.Itoa(limit+1)}
+	if author != "" {
+		args = append(args, "--author="+author, "--regexp-ignore-case", "--fixed-strings")
+	}
+	args = append(args, commit)
+	if filePath != "" {
+		args = append(args, "--", filePath)
+	}
+	out, err := runGit(baseDir, args...)
+	if err != nil {
+		return nil, false, err
+	}
+	commits := parseCommits(out)
+	if len(commits) > limit {
+		return commits[:limit], true, nil
+	}
+	return commits, false, nil
+}
+
+// commitFile 提交中改动的文件
+type commitFile struct {
+	Path      string `json:"path"`
+	OldPath   string `json:"old_path,omitempty"`
+	Status    string `json:"status"` // A/M/D/R/C/T
+	Additions int    `json:"additions"`
+	Deletions int    `json:"deletions"`
+	Binary    bool   `json:"binary"`
+	Patch     string `json:"patch,omitempty"`
+	Truncated bool   `json:"truncated,omitempty"`
+}
+
+// commitDetail 提交详情，合并提交的改动按第一个父提交计算
+type commitDetail struct {
+	commitInfo
+	Files     []commitFile `json:"files"`
+	Truncated bool         `json:"truncated"`
+}
+
+func gitCommitDetail(baseDir, commit string) (commitDetail, error) {
+	var detail commitDetail
+	out, err := runGit(baseDir, "show", "-s", commitFormat, commit)
+	if err != nil {
+		return detail, err
+	}
+	commits := parseCommits(out)
+	if len(commits) == 0 {
+		return detail, fmt.Errorf("解析提交 %s 失败", commit)
+	}
+	detail.commitInfo = commits[0]
+
+	diffArgs := []string{"show", "--format=", "--first-parent", "-M"}
+
+	// 改动类型与路径
+	out, err = runGit(baseDir, append(diffArgs, "--name-status", "-z", commit)...)
+	if err != nil {
+		return detail, err
+	}
+	fields := strings.Split(strings.TrimRight(string(out), "\x00"), "\x00")
+	for i := 0; i+1 < len(fields); {
+		f := commitFile{Status: fields[i][:1], Path: fields[i+1]}
+		i += 2
+		if (f.Status == "R" || f.Status == "C") && i < len(fields) {
+			f.OldPath, f.Path = f.Path, fields[i]
+			i++
+		}
+		detail.Files = append(detail.Files, f)
+	}
+
+	// 增删行数，-z 下重命名的记录是 "增\t删\t\0旧路径\0新路径\0"
+	out, err = runGit(baseDir, append(diffArgs, "--numstat", "-z", commit)...)
+	if err != nil {
+		return detail, err
+	}
+	stats := map[string][2]int{}
+	binary := map[string]bool{}
+	fields = strings.Split(strings.TrimRight(string(out), "\x00"), "\x00")
+	for i := 0; i < len(fields); i++ {
+		parts := strings.SplitN(fields[i], "\t", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		p := parts[2]
+		if p == "" && i+2 < len(fields) {
+			p = fields[i+2]
+			i += 2
+		}
+		if parts[0] == "-" {
+			binary[p] = true
+			continue
+		}
+		add, _ := strconv.Atoi(parts[0])
+		del, _ := strconv.Atoi(parts[1])
+		stats[p] = [2]int{add, del}
+	}
+
+	// 补丁按文件拆分，顺序与 --name-status 一致
+	out, err = runGit(baseDir, append(diffArgs, "--patch", commit)...)
+	if err != nil {
+		return detail, err
+	}
+	patches := splitPatch(string(out))
+	total := 0
+	for i := range detail.Files {
+		f := &detail.Files[i]
+		f.Additions, f.Deletions = stats[f.Path][0], stats[f.Path][1]
+		f.Binary = binary[f.Path]
+		if len(patches) != len(detail.Files) {
+			continue
+		}
+		if total+len(patches[i]) > maxDiffBytes {
+			f.Truncated, detail.Truncated = true, true
+			continue
+		}
+		total += len(patches[i])
+		f.Patch = patches[i]
+	}
+	if detail.Files == nil {
+		detail.Files = make([]commitFile, 0)
+	}
+	return detail, nil
+}
+
+// splitPatch 按 "diff --git" 行拆分补丁
+func splitPatch(patch string) []string {
+	var parts []string
+	var cur strings.Builder
+	for _, line := range strings.SplitAfter(patch, "\n") {
+		if strings.HasPrefix(line, "diff --git ") && cur.Len() > 0 {
+			parts = append(parts, cur.String())
+			cur.Reset()
+		}
+		cur.WriteString(line)
+	}
+	if cur.Len() > 0 {
+		parts = append(parts, cur.String())
+	}
+	return parts
+}
+
+// blameCommit blame 结果中引用的提交
+type blameCommit struct {
+	AuthorName  string    `json:"author_name"`
+	AuthorEmail string    `json:"author_email"`
+	AuthorDate  time.Time `json:"author_date"`
+	Summary     string    `json:"summary"`
+	Boundary    bool      `json:"boundary"` // 浅克隆的边界提交，实际的最后修改可能更早
+}
+
+// blameRange 连续由同一提交最后修改的行
+type blameRange struct {
+	Commit    string   `json:"commit"`
+	StartLine int      `json:"start_line"`
+	EndLine   int      `json:"end_line"`
+	Lines     []string `json:"lines"`
+}
+
+// gitBlame 解析 git blame --porcelain 的输出
+func gitBlame(baseDir, commit, filePath string) ([]blameRange, map[string]*blameCommit, error) {
+	out, err := runGit(baseDir, "blame", "--porcelain", commit, "--", filePath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ranges := make([]blameRange, 0)
+	commits := map[string]*blameCommit{}
+	var sha string
+	var finalLine int
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	scanner.Buffer(make([]byte, 64*1024), maxHistoryFileSize)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "\t") {
+			// 内容行，结束当前条目
+			text := line[1:]
+			if n := len(ranges); n > 0 && ranges[n-1].Commit == sha && ranges[n-1].EndLine == finalLine-1 {
+				ranges[n-1].EndLine = finalLine
+				ranges[n-1].Lines = append(ranges[n-1].Lines, text)
+			} else {
+				ranges = append(ranges, blameRange{Commit: sha, StartLine: finalLine, EndLine: finalLine, Lines: []string{text}})
+			}
+			continue
+		}
+
+		// 条目头："<哈希> <原行号> <当前行号> [<行数>]"，其后是该提交首次出现时的元信息
+		key, value, _ := strings.Cut(line, " ")
+		if parts := strings.Fields(value); hashPattern.MatchString(key) && len(parts) >= 2 {
+			sha = key
+			finalLine, _ = strconv.Atoi(parts[1])
+			if commits[sha] == nil {
+				commits[sha] = &blameCommit{}
+			}
+			continue
+		}
+		bc := commits[sha]
+		if bc == nil {
+			continue
+		}
+		switch key {
+		case "author":
+			bc.AuthorName = value
+		case "author-mail":
+			bc.AuthorEmail = strings.Trim(value, "<>")
+		case "author-time":
+			if sec, err := strconv.ParseInt(value, 10, 64); err == nil {
+				bc.AuthorDate = time.Unix(sec, 0)
+			}
+		case "summary":
+			bc.Summary = value
+		case "boundary":
+			bc.Boundary = true
+		}
+	}
+	return ranges, commits, nil
+}
+
+// revisionBlob 历史版本中的一个文件
+type revisionBlob struct {
+	BaseDir string
+	Object  string // <提交>:<路径>
+	Oid     string
+	Size    int64
+}
+
+// resolveRevisionBlob 查找指定版本中的文件
+func resolveRevisionBlob(proj models.Project, rev, filePath string) (revisionBlob, error) {
+	var blob revisionBlob
+	baseDir, err := clonedRepoDir(proj)
+	if err != nil {
+		return blob, err
+	}
+	commit, err := resolveCommit(baseDir, rev)
+	if err != nil {
+		return blob, err
+	}
+	cleaned, err := cleanRepoPath(filePath)
+	if err != nil {
+		return blob, err
+	}
+
+	// 输出为 "<oid> <类型> <大小>"，对象不存在时为 "<对象> missing"
+	object := commit + ":" + cleaned
+	cmd := exec.Command("git", "-C", baseDir, "cat-file", "--batch-check")
+	cmd.Stdin = strings.NewReader(object + "\n")
+	out, err := cmd.Output()
+	if err != nil {
+		return blob, fmt.Errorf("git cat-file 失败: %v", err)
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) != 3 {
+		return blob, newAPIError(http.StatusNotFound, "文件不存在")
+	}
+	if fields[1] != "blob" {
+		return blob, newAPIError(http.StatusBadRequest, "路径是目录，不是文件")
+	}
+	size, _ := strconv.ParseInt(fields[2], 10, 64)
+	return revisionBlob{BaseDir: baseDir, Object: object, Oid: fields[0], Size: size}, nil
+}
+
+// readProjectFileAt 读取指定版本的文件，rev 为空时读取工作区中的文件
+func readProjectFileAt(proj models.Project, rev, filePath string) ([]byte, error) {
+	if rev == "" {
+		return readProjectFile(proj, filePath)
+	}
+	blob, err := resolveRevisionBlob(proj, rev, filePath)
+	if err != nil {
+		return nil, err
+	}
+	if blob.Size > maxHistoryFileSize {
+		return nil, newAPIError(http.StatusBadRequest, "文件过大，超过5MB")
+	}
+	return runGit(blob.BaseDir, "cat-file", "blob", blob.Object)
+}
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListCommitsV1
+// @Summary 提交历史
+// @Tags 项目模块 v1
+// @Security Bearer
+// @Param id path int true "项目ID"
+// @Param rev query string false "起始版本（分支、标签或提交），默认 HEAD"
+// @Param path query string false "只列出改动了该文件或目录的提交"
+// @Param author query string false "作者名或邮箱（不区分大小写的子串）"
+// @Param offset query int false "跳过的提交数"
+// @Param limit query int false "返回的提交数，默认 30，最大 100"
+// @Success 200 {object} map[string]interface{}
+// @Router /api/v1/projects/{id}/commits [get]
+func ListCommitsV1(c *gin.Context) {
+	proj, ok := loadProjectV1(c)
+	if !ok {
+		return
+	}
+	baseDir, err := clonedRepoDir(proj)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	commit, err := resolveCommit(baseDir, c.Query("rev"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	filePath := c.Query("path")
+	if filePath != "" {
+		if filePath, err = cleanRepoPath(filePath); err != nil {
+			respondError(c, err)
+			return
+		}
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		respondError(c, newAPIError(http.StatusBadRequest, "无效的 offset"))
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "30"))
+	if err != nil || limit <= 0 {
+		respondError(c, newAPIError(http.StatusBadRequest, "无效的 limit"))
+		return
+	}
+	if limit > 100 {
+		limit = 100
+	}
+
+	commits, hasMore, err := gitLog(baseDir, commit, filePath, strings.TrimSpace(c.Query("author")), offset, limit)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	data := gin.H{
+		"items":    commits,
+		"offset":   offset,
+		"has_more": hasMore,
+		"shallow":  isShallowRepo(baseDir),
+	}
+	if hasMore {
+		data["next_offset"] = offset + len(commits)
+	}
+	respondOK(c, http.StatusOK, "查询成功", data)
+}
+
+// GetCommitV1
+// @Summary 提交详情（含改动文件与 diff）
+// @Tags 项目模块 v1
+// @Security Bearer
+// @Param id path int true "项目ID"
+// @Param sha path string true "提交哈希或其他版本写法"
+// @Success 200 {object} map[string]interface{}
+// @Router /api/v1/projects/{id}/commits/{sha} [get]
+func GetCommitV1(c *gin.Context) {
+	proj, ok := loadProjectV1(c)
+	if !ok {
+		return
+	}
+	baseDir, err := clonedRepoDir(proj)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	commit, err := resolveCommit(baseDir, c.Param("sha"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	detail, err := gitCommitDetail(baseDir, commit)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, "查询成功", detail)
+}
+
+// GetBlameV1
+// @Summary 文件逐行的最后修改提交
+// @Tags 项目模块 v1
+// @Security Bearer
+// @Param id path int true "项目ID"
+// @Param path query string true "文件路径"
+// @Param rev query string false "版本，默认 HEAD"
+// @Success 200 {object} map[string]interface{}
+// @Router /api/v1/projects/{id}/blame [get]
+func GetBlameV1(c *gin.Context) {
+	proj, ok := loadProjectV1(c)
+	if !ok {
+		return
+	}
+	baseDir, err := clonedRepoDir(proj)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	commit, err := resolveCommit(baseDir, c.Query("rev"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	filePath, err := cleanRepoPath(c.Query("path"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	// 先按文件读取做存在性、类型与大小检查
+	if _, err := readProjectFileAt(proj, commit, filePath); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	ranges, commits, err := gitBlame(baseDir, commit, filePath)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, "查询成功", gin.H{
+		"commit":  commit,
+		"path":    filePath,
+		"ranges":  ranges,
+		"commits": commits,
+		"shallow": isShallowRepo(baseDir),
+	})
+}
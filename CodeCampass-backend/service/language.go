@@ -0,0 +1,78 @@
+package service
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// extLanguages 文件扩展名到编程语言的映射
+var extLanguages = map[string]string{
+	"go":    "Go",
+	"py":    "Python",
+	"js":    "JavaScript",
+	"jsx":   "JavaScript",
+	"mjs":   "JavaScript",
+	"ts":    "TypeScript",
+	"tsx":   "TypeScript",
+	"java":  "Java",
+	"kt":    "Kotlin",
+	"scala": "Scala",
+	"c":     "C",
+	"h":     "C",
+	"cc":    "C++",
+	"cpp":   "C++",
+	"cxx":   "C++",
+	"hpp":   "C++",
+	"cs":    "C#",
+	"rs":    "Rust",
+	"rb":    "Ruby",
+	"php":   "PHP",
+	"swift": "Swift",
+	"m":     "Objective-C",
+	"dart":  "Dart",
+	"lua":   "Lua",
+	"sh":    "Shell",
+	"bash":  "Shell",
+	"sql":   "SQL",
+	"vue":   "Vue",
+	"html":  "HTML",
+	"css":   "CSS",
+	"scss":  "CSS",
+	"less":  "CSS",
+	"md":    "Markdown",
+	"json":  "JSON",
+	"yaml":  "YAML",
+	"yml":   "YAML",
+	"xml":   "XML",
+	"proto": "Protocol Buffers",
+}
+
+// nonCodeLanguages 统计项目主要语言时不计入的语言
+var nonCodeLanguages = map[string]bool{
+	"Markdown": true,
+	"JSON":     true,
+	"YAML":     true,
+	"XML":      true,
+	"HTML":     true,
+	"CSS":      true,
+}
+
+// detectLanguage 根据文件扩展名判断编程语言，无法识别时返回空字符串
+func detectLanguage(path string) string {
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))
+	return extLanguages[ext]
+}
+
+// dominantLanguage 按文件数统计出项目的主要语言，文档与配置类文件不计入
+func dominantLanguage(counts map[string]int) string {
+	best, bestCount := "", 0
+	for lang, count := range counts {
+		if nonCodeLanguages[lang] {
+			continue
+		}
+		if count > bestCount || (count == bestCount && lang < best) {
+			best, bestCount = lang, count
+		}
+	}
+	return best
+}
@@ -0,0 +1,149 @@
+package service
+
+import (
+	"CodeCampass/models"
+	"CodeCampass/utils"
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/spf13/viper"
+)
+
+const (
+	// 旧版单一 API Key 默认走 chatanywhere
+	//defaultLegacyBaseURL = "https://api.chatanywhere.tech" // 国内首选
+	defaultLegacyBaseURL = "https://api.chatanywhere.org" // 国外使用
+
+	defaultChatModel      = openai.GPT4oMini
+	defaultEmbeddingModel = string(openai.SmallEmbedding3)
+)
+
+// llmConfig 一次 LLM 调用所使用的凭证与模型
+type llmConfig struct {
+	APIKey         string
+	Provider       string
+	BaseURL        string
+	ChatModel      string
+	EmbeddingModel string
+	CredentialID   uint // 0 表示来自旧版 API Key 或环境变量
+}
+
+// newClient 按服务商类型创建 OpenAI 客户端
+func (cfg llmConfig) newClient() *openai.Client {
+	var clientCfg openai.ClientConfig
+	if cfg.Provider == models.ProviderAzure {
+		clientCfg = openai.DefaultAzureConfig(cfg.APIKey, cfg.BaseURL)
+	} else {
+		clientCfg = openai.DefaultConfig(cfg.APIKey)
+		if cfg.BaseURL != "" {
+			clientCfg.BaseURL = cfg.BaseURL
+		}
+	}
+	return openai.NewClientWithConfig(clientCfg)
+}
+
+// credentialKeyName 凭证密钥在 Redis 中的键
+func credentialKeyName(id uint) string {
+	return fmt.Sprintf("credential_key:%d", id)
+}
+
+// credentialConfig 读取凭证的密钥并组装调用配置
+func credentialConfig(cred models.ProviderCredential) (llmConfig, error) {
+	key, err := utils.GetSecret(utils.Red.Context(), credentialKeyName(cred.ID))
+	if err != nil {
+		return llmConfig{}, fmt.Errorf("读取凭证 %s 失败: %v", cred.Name, err)
+	}
+	if key == "" {
+		return llmConfig{}, newAPIError(http.StatusBadRequest, fmt.Sprintf("凭证 %s 的密钥不存在，请重新设置", cred.Name))
+	}
+	return configFromCredential(cred, key), nil
+}
+
+// configFromCredential 用凭证与给定密钥组装调用配置，未填写的模型使用默认值
+func configFromCredential(cred models.ProviderCredential, key string) llmConfig {
+	cfg := llmConfig{
+		APIKey:         key,
+		Provider:       cred.Provider,
+		BaseURL:        cred.BaseUrl,
+		ChatModel:      cred.ChatModel,
+		EmbeddingModel: cred.EmbeddingModel,
+		CredentialID:   cred.ID,
+	}
+	if cfg.ChatModel == "" {
+		cfg.ChatModel = defaultChatModel
+	}
+	if cfg.EmbeddingModel == "" {
+		cfg.EmbeddingModel = defaultEmbeddingModel
+	}
+	return cfg
+}
+
+// legacyConfig 旧版单一 API Key 的调用配置
+func legacyConfig(key string) llmConfig {
+	baseURL := viper.GetString("openai.baseURL")
+	if baseURL == "" {
+		baseURL = defaultLegacyBaseURL
+	}
+	return llmConfig{
+		APIKey:         key,
+		Provider:       models.ProviderCompatible,
+		BaseURL:        baseURL,
+		ChatModel:      defaultChatModel,
+		EmbeddingModel: defaultEmbeddingModel,
+	}
+}
+
+// resolveLLMConfig 决定项目调用 LLM 使用的凭证，优先级：
+// 项目指定的凭证 > 组织 API Key > 用户默认凭证 > 用户旧版 API Key > 环境变量 OPENAI_API_KEY
+func resolveLLMConfig(proj models.Project, userID uint) (llmConfig, error) {
+	if proj.CredentialId != 0 {
+		var cred models.ProviderCredential
+		if err := utils.DB.Where("id = ?", proj.CredentialId).First(&cred).Error; err == nil {
+			return credentialConfig(cred)
+		}
+	}
+
+	if proj.OrgId != 0 {
+		if key := getOrgOpenAIKey(proj.OrgId); key != "" {
+			return legacyConfig(key), nil
+		}
+	}
+
+	if cred, err := models.FindDefaultCredential(userID); err == nil {
+		return credentialConfig(cred)
+	}
+
+	// getOpenAIKey 已包含环境变量兜底
+	if key := getOpenAIKey(userID); key != "" {
+		return legacyConfig(key), nil
+	}
+	return llmConfig{}, newAPIError(http.StatusBadRequest, "请先设置 OpenAI API Key")
+}
+
+// validateLLMConfig 用一次很小的 embedding 请求和一次只生成 1 个 token 的对话请求，
+// 验证密钥、地址、embedding 模型与对话模型是否可用
+func validateLLMConfig(cfg llmConfig) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	client := cfg.newClient()
+	if _, err := client.CreateEmbeddings(ctx, openai.EmbeddingRequest{
+		Model: openai.EmbeddingModel(cfg.EmbeddingModel),
+		Input: []string{"ping"},
+	}); err != nil {
+		return fmt.Errorf("embedding 模型 %s: %v", cfg.EmbeddingModel, err)
+	}
+	if _, err := client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+		Model:     cfg.ChatModel,
+		MaxTokens: 1,
+		Messages: []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleUser, Content: "ping"},
+		},
+	}); err != nil {
+		return fmt.Errorf("对话模型 %s: %v", cfg.ChatModel, err)
+	}
+	return nil
+}
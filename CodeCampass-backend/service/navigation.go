@@ -0,0 +1,309 @@
+package service
+
+import (
+	"CodeCampass/models"
+	"CodeCampass/storage"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"go/ast"
+	"go/types"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// navLocation 源码中的一个位置，行列号从 1 开始，列按字节计
+type navLocation struct {
+	Path   string `json:"path"`
+	Line   int    `json:"line"`
+	Col    int    `json:"col"`
+	EndCol int    `json:"end_col"`
+	Text   string `json:"text,omitempty"` // 所在行的内容
+}
+
+// navObject 一个被定义或引用的对象
+type navObject struct {
+	Name     string        `json:"name"`
+	Kind     string        `json:"kind"`
+	Package  string        `json:"package"`
+	External bool          `json:"external"` // 定义在仓库之外（标准库或依赖）
+	Def      *navLocation  `json:"-"`
+	Refs     []navLocation `json:"-"`
+}
+
+// navIdent 文件中的一个标识符及其指向的对象
+type navIdent struct {
+	Line, Col, EndCol int
+	Object            string
+}
+
+// goNavIndex 基于 go/packages 类型信息的导航索引，导入时构建并序列化到磁盘
+type goNavIndex struct {
+	Version int
+	Commit  string
+	Idents  map[string][]navIdent // 相对路径 -> 按位置排序的标识符
+	Objects map[string]*navObject
+}
+
+const navIndexVersion = 1
+
+// navIndexExt 导航索引文件的扩展名
+const navIndexExt = "nav"
+
+// navIndexPath 项目导航索引的存放位置，与搜索索引一样放在仓库目录旁边
+func navIndexPath(proj models.Project) string {
+	return storage.SidecarPath(proj.OwnerId, proj.ID, navIndexExt)
+}
+
+// lookup 查找给定位置的标识符所指向的对象
+func (idx *goNavIndex) lookup(path string, line, col int) *navObject {
+	spans := idx.Idents[path]
+	i := sort.Search(len(spans), func(i int) bool {
+		return spans[i].Line > line || (spans[i].Line == line && spans[i].EndCol > col)
+	})
+	if i < len(spans) && spans[i].Line == line && spans[i].Col <= col {
+		return idx.Objects[spans[i].Object]
+	}
+	return nil
+}
+
+// objectKind 类型对象对应的符号类型
+func objectKind(obj types.Object) string {
+	switch o := obj.(type) {
+	case *types.Func:
+		if sig, ok := o.Type().(*types.Signature); ok && sig.Recv() != nil {
+			return models.SymbolKindMethod
+		}
+		return models.SymbolKindFunc
+	case *types.Var:
+		if o.IsField() {
+			return models.SymbolKindField
+		}
+		return models.SymbolKindVar
+	case *types.TypeName:
+		return models.SymbolKindType
+	case *types.Const:
+		return models.SymbolKindConst
+	case *types.PkgName:
+		return models.SymbolKindPackage
+	case *types.Label:
+		return "label"
+	}
+	return ""
+}
+
+// goLoadTimeout 加载仓库 Go 包的最长时间
+const goLoadTimeout = 5 * time.Minute
+
+// loadGoPackages 用 go/packages 从源码加载仓库中的所有包及其依赖，只在导入流程中调用。
+// 仓库不可信：go list 不联网下载模块或工具链，也不读取 go.work 与 VCS 信息；关闭 cgo，
+// 避免按仓库中的 #cgo 指令调用本机的 C 编译器；不改写仓库中的 go.mod 与 go.sum，工作区保持干净。
+// 依赖不在本机模块缓存中时类型信息不完整，但仓库内部的对象仍可解析
+func loadGoPackages(baseDir string) ([]*packages.Package, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), goLoadTimeout)
+	defer cancel()
+
+	cfg := &packages.Config{
+		// 依赖也从源码加载：只依赖导出数据时，缺失的依赖会让 go/packages 直接退出进程
+		Mode: packages.NeedName | packages.NeedFiles | packages.NeedSyntax |
+			packages.NeedTypes | packages.NeedTypesInfo | packages.NeedImports | packages.NeedDeps,
+		Context: ctx,
+		Dir:     baseDir,
+		Env: append(os.Environ(),
+			"GOFLAGS=-mod=readonly -buildvcs=false",
+			"CGO_ENABLED=0",
+			"GOPROXY=off",
+			"GOTOOLCHAIN=local",
+			"GOWORK=off",
+		),
+	}
+	return packages.Load(cfg, "./...")
+}
+
+// buildGoNavIndex 记录仓库中每个标识符的定义与引用
+func buildGoNavIndex(baseDir, commit string, pkgs []*packages.Package) *goNavIndex {
+	idx := &goNavIndex{
+		Version: navIndexVersion,
+		Commit:  commit,
+		Idents:  map[string][]navIdent{},
+		Objects: map[string]*navObject{},
+	}
+	relPath := func(filename string) (string, bool) {
+		rel, err := filepath.Rel(baseDir, filename)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			return "", false
+		}
+		return filepath.ToSlash(rel), true
+	}
+
+	seen := map[string]bool{}
+	for _, pkg := range pkgs {
+		if pkg.TypesInfo == nil {
+			continue
+		}
+		add := func(ident *ast.Ident, obj types.Object, isDef bool) {
+			if obj == nil || obj.Pkg() == nil {
+				return // 内置类型与函数
+			}
+			p := pkg.Fset.Position(ident.Pos())
+			path, ok := relPath(p.Filename)
+			if !ok {
+				return
+			}
+			// 同一文件可能属于多个被加载的包，标识符只记录一次
+			spanKey := fmt.Sprintf("%s:%d:%d", path, p.Line, p.Column)
+			if seen[spanKey] {
+				return
+			}
+			seen[spanKey] = true
+
+			key := obj.Pkg().Path() + "." + obj.Name()
+			var def *navLocation
+			if obj.Pos().IsValid() {
+				dp := pkg.Fset.Position(obj.Pos())
+				key = fmt.Sprintf("%s:%d:%d", dp.Filename, dp.Line, dp.Column)
+				if defPath, ok := relPath(dp.Filename); ok {
+					def = &navLocation{Path: defPath, Line: dp.Line, Col: dp.Column, EndCol: dp.Column + len(obj.Name())}
+				}
+			}
+
+			o := idx.Objects[key]
+			if o == nil {
+				o = &navObject{Name: obj.Name(), Kind: objectKind(obj), Package: obj.Pkg().Path(), External: def == nil, Def: def}
+				idx.Objects[key] = o
+			}
+			loc := navLocation{Path: path, Line: p.Line, Col: p.Column, EndCol: p.Column + len(ident.Name)}
+			if !isDef {
+				o.Refs = append(o.Refs, loc)
+			}
+			idx.Idents[path] = append(idx.Idents[path], navIdent{Line: loc.Line, Col: loc.Col, EndCol: loc.EndCol, Object: key})
+		}
+
+		for ident, obj := range pkg.TypesInfo.Defs {
+			add(ident, obj, true)
+		}
+		for ident, obj := range pkg.TypesInfo.Uses {
+			add(ident, obj, false)
+		}
+	}
+
+	for path, spans := range idx.Idents {
+		sort.Slice(spans, func(i, j int) bool {
+			if spans[i].Line != spans[j].Line {
+				return spans[i].Line < spans[j].Line
+			}
+			return spans[i].Col < spans[j].Col
+		})
+		idx.Idents[path] = spans
+	}
+	for _, o := range idx.Objects {
+		sort.Slice(o.Refs, func(i, j int) bool {
+			a, b := o.Refs[i], o.Refs[j]
+			if a.Path != b.Path {
+				return a.Path < b.Path
+			}
+			if a.Line != b.Line {
+				return a.Line < b.Line
+			}
+			return a.Col < b.Col
+		})
+	}
+	return idx
+}
+
+// writeNavIndex 先写临时文件再改名，避免查询读到写了一半的索引
+func writeNavIndex(idx *goNavIndex, dest string) error {
+	tmp := dest + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(f).Encode(idx); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dest)
+}
+
+func readNavIndex(src string) (*goNavIndex, error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var idx goNavIndex
+	if err := gob.NewDecoder(f).Decode(&idx); err != nil {
+		return nil, err
+	}
+	if idx.Version != navIndexVersion {
+		return nil, fmt.Errorf("导航索引版本 %d 已过期", idx.Version)
+	}
+	return &idx, nil
+}
+
+// 已加载的导航索引按文件路径与修改时间缓存，只保留最近使用的若干个
+const maxNavCacheEntries = 8
+
+type navCacheEntry struct {
+	modTime int64
+	index   *goNavIndex
+}
+
+var navCache = struct {
+	sync.Mutex
+	entries map[string]*navCacheEntry
+	order   []string // 最近使用的在末尾
+}{entries: map[string]*navCacheEntry{}}
+
+// projectNavIndex 读取项目导入时构建的导航索引；索引不存在或不是当前提交的返回错误，
+// 请求中不会加载 Go 包
+func projectNavIndex(proj models.Project) (*goNavIndex, error) {
+	src := navIndexPath(proj)
+	info, err := os.Stat(src)
+	if err != nil {
+		return nil, err
+	}
+
+	navCache.Lock()
+	entry, ok := navCache.entries[src]
+	navCache.Unlock()
+	if !ok || entry.modTime != info.ModTime().UnixNano() {
+		idx, err := readNavIndex(src)
+		if err != nil {
+			return nil, err
+		}
+		entry = &navCacheEntry{modTime: info.ModTime().UnixNano(), index: idx}
+
+		navCache.Lock()
+		for i, k := range navCache.order {
+			if k == src {
+				navCache.order = append(navCache.order[:i], navCache.order[i+1:]...)
+				break
+			}
+		}
+		navCache.order = append(navCache.order, src)
+		navCache.entries[src] = entry
+		if len(navCache.order) > maxNavCacheEntries {
+			delete(navCache.entries, navCache.order[0])
+			navCache.order = navCache.order[1:]
+		}
+		navCache.Unlock()
+	}
+
+	if entry.index.Commit != proj.HeadCommit {
+		return nil, fmt.Errorf("导航索引不是当前提交的")
+	}
+	return entry.index, nil
+}
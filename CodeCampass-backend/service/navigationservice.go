@@ -0,0 +1,194 @@
+package service
+
+import (
+	"CodeCampass/models"
+	"bytes"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 导航结果的来源
+const (
+	navSourceTypes = "types" // Go 类型信息
+	navSourceIndex = "index" // Go 符号索引
+	navSourceCtags = "ctags" // 按语言规则的文本匹配
+)
+
+// navResult 定义与引用查询的结果
+type navResult struct {
+	Source      string        `json:"source"`
+	Symbol      navObject     `json:"symbol"`
+	Definitions []navLocation `json:"definitions"`
+	References  []navLocation `json:"references,omitempty"`
+	Truncated   bool          `json:"truncated,omitempty"`
+}
+
+// navQuery 解析查询参数，并返回光标所在行的内容
+func navQuery(c *gin.Context, proj models.Project) (path string, line, col int, lineText string, err error) {
+	path = strings.TrimPrefix(filepath.ToSlash(c.Query("path")), "/")
+	line, errLine := strconv.Atoi(c.Query("line"))
+	col, errCol := strconv.Atoi(c.Query("col"))
+	if path == "" || errLine != nil || errCol != nil || line <= 0 || col <= 0 {
+		return "", 0, 0, "", newAPIError(http.StatusBadRequest, "请提供 path、line、col 参数（行列号从 1 开始）")
+	}
+
+	content, err := readProjectFile(proj, path)
+	if err != nil {
+		return "", 0, 0, "", err
+	}
+	lines := bytes.Split(content, []byte("\n"))
+	if line > len(lines) {
+		return "", 0, 0, "", newAPIError(http.StatusBadRequest, "行号超出文件范围")
+	}
+	return path, line, col, strings.TrimRight(string(lines[line-1]), "\r"), nil
+}
+
+// resolveNavigation 依次尝试 Go 类型信息、Go 符号索引与文本匹配
+func resolveNavigation(proj models.Project, path string, line, col int, lineText string, withRefs bool) (navResult, error) {
+	if strings.HasSuffix(path, ".go") {
+		if idx, err := projectNavIndex(proj); err == nil {
+			if obj := idx.lookup(path, line, col); obj != nil {
+				res := navResult{Source: navSourceTypes, Symbol: *obj, Definitions: []navLocation{}}
+				if obj.Def != nil {
+					res.Definitions = append(res.Definitions, *obj.Def)
+				}
+				if withRefs {
+					res.References = append([]navLocation{}, obj.Refs...)
+					if len(res.References) > maxNavReferences {
+						res.References, res.Truncated = res.References[:maxNavReferences], true
+					}
+				}
+				return res, nil
+			}
+		}
+	}
+
+	name := wordAt(lineText, col)
+	if name == "" {
+		return navResult{}, newAPIError(http.StatusNotFound, "该位置没有标识符")
+	}
+
+	baseDir := repoBaseDir(proj)
+	lang := detectLanguage(path)
+	found := ctagsLookup(baseDir, lang, name)
+	res := navResult{
+		Source:      navSourceCtags,
+		Symbol:      navObject{Name: name},
+		Definitions: found.Definitions,
+		Truncated:   found.Truncated,
+	}
+	if withRefs {
+		res.References = found.References
+	}
+
+	// Go 代码类型信息不可用时，优先采用导入时建立的符号索引给出定义
+	if lang == "Go" {
+		if syms := models.FindSymbolsByName(proj.ID, name); len(syms) > 0 {
+			res.Source = navSourceIndex
+			res.Symbol.Kind = syms[0].Kind
+			res.Symbol.Package = syms[0].Package
+			res.Definitions = make([]navLocation, 0, len(syms))
+			for _, sym := range syms {
+				res.Definitions = append(res.Definitions, navLocation{
+					Path: sym.FilePath, Line: sym.Line, Col: sym.Col, EndCol: sym.Col + len(sym.Name),
+				})
+			}
+		}
+	}
+	return res, nil
+}
+
+// fillLineText 为没有行内容的位置补全所在行
+func fillLineText(baseDir string, locs []navLocation) {
+	files := map[string][]string{}
+	for i := range locs {
+		if locs[i].Text != "" {
+			continue
+		}
+		lines, ok := files[locs[i].Path]
+		if !ok {
+			if full, err := resolveInRepo(baseDir, locs[i].Path); err == nil {
+				if data, err := os.ReadFile(full); err == nil {
+					lines = strings.Split(string(data), "\n")
+				}
+			}
+			files[locs[i].Path] = lines
+		}
+		if n := locs[i].Line; n > 0 && n <= len(lines) {
+			locs[i].Text = strings.TrimRight(lines[n-1], "\r")
+		}
+	}
+}
+
+// GetDefinitionV1
+// @Summary 跳转到定义
+// @Tags 项目模块 v1
+// @Security Bearer
+// @Param id path int true "项目ID"
+// @Param path query string true "文件路径"
+// @Param line query int true "行号（从 1 开始）"
+// @Param col query int true "列号（从 1 开始，按字节计）"
+// @Success 200 {object} map[string]interface{}
+// @Router /api/v1/projects/{id}/definition [get]
+func GetDefinitionV1(c *gin.Context) {
+	proj, ok := loadProjectV1(c)
+	if !ok {
+		return
+	}
+	path, line, col, lineText, err := navQuery(c, proj)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	res, err := resolveNavigation(proj, path, line, col, lineText, false)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	fillLineText(repoBaseDir(proj), res.Definitions)
+	respondOK(c, http.StatusOK, "查询成功", res)
+}
+
+// GetReferencesV1
+// @Summary 查找引用
+// @Tags 项目模块 v1
+// @Security Bearer
+// @Param id path int true "项目ID"
+// @Param path query string true "文件路径"
+// @Param line query int true "行号（从 1 开始）"
+// @Param col query int true "列号（从 1 开始，按字节计）"
+// @Param include_declaration query bool false "结果中是否包含定义处"
+// @Success 200 {object} map[string]interface{}
+// @Router /api/v1/projects/{id}/references [get]
+func GetReferencesV1(c *gin.Context) {
+	proj, ok := loadProjectV1(c)
+	if !ok {
+		return
+	}
+	path, line, col, lineText, err := navQuery(c, proj)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	res, err := resolveNavigation(proj, path, line, col, lineText, true)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	if c.Query("include_declaration") == "true" {
+		res.References = append(append([]navLocation{}, res.Definitions...), res.References...)
+	}
+	if res.References == nil {
+		res.References = []navLocation{}
+	}
+	fillLineText(repoBaseDir(proj), res.Definitions)
+	fillLineText(repoBaseDir(proj), res.References)
+	respondOK(c, http.StatusOK, "查询成功", res)
+}
@@ -0,0 +1,531 @@
+package service
+
+import (
+	"CodeCampass/models"
+	"CodeCampass/utils"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"text/template"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+)
+
+// 项目事件除推送到浏览器的事件流外，还会投递到项目配置的通知 webhook。
+// 投递在后台队列中进行，失败时按指数退避重试。下次发送时间记录在数据库中，各实例定期扫描到期的投递，
+// 实例重启后未完成的投递会继续发送
+
+// publishProjectEvent 发布项目事件：推送给订阅事件流的浏览器，并投递到项目的通知 webhook
+func publishProjectEvent(projectID uint, event SSEEvent) {
+	GetSSEManager().Publish(projectID, event)
+	notifyProjectEvent(projectID, event)
+}
+
+// defaultNotifyEvents 未指定事件类型时订阅的事件：索引与同步的完成和失败
+var defaultNotifyEvents = []string{"embedding_complete", "embedding_error", "sync_complete", "sync_error"}
+
+// notifyTestEvent 测试发送时使用的事件类型
+const notifyTestEvent = "ping"
+
+// defaultNotifyTemplate 聊天消息的默认文案
+const defaultNotifyTemplate = "[CodeCampass] {{.Project}} · {{.Event}}\n{{.Message}}"
+
+// notifyPayload 通知的内容，通用 JSON 格式直接发送，聊天机器人格式由它生成文本
+type notifyPayload struct {
+	DeliveryID string      `json:"delivery_id"`
+	Event      string      `json:"event"`
+	ProjectID  uint        `json:"project_id"`
+	Project    string      `json:"project"`
+	Message    string      `json:"message"`
+	Data       interface{} `json:"data"`
+	Timestamp  time.Time   `json:"timestamp"`
+}
+
+// notifySecretKey 通知 webhook 的签名密钥加密存放在 Redis 中的键
+func notifySecretKey(hookID uint) string {
+	return fmt.Sprintf("notify_secret:%d", hookID)
+}
+
+// matchNotifyEvent 事件是否在订阅的类型中
+func matchNotifyEvent(patterns []string, event string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, event); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// eventMessage 取出事件数据中的 message 字段
+func eventMessage(data interface{}) string {
+	var m map[string]interface{}
+	switch d := data.(type) {
+	case gin.H:
+		m = d
+	case map[string]interface{}:
+		m = d
+	}
+	msg, _ := m["message"].(string)
+	return msg
+}
+
+func newDeliveryID() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+var (
+	notifyOnce   sync.Once
+	notifyEvents chan notifyEventItem
+	notifyQueue  chan uint
+)
+
+// notifySweepInterval 扫描到期投递的间隔，重试的实际等待时间最多因此延后一个间隔
+const notifySweepInterval = 10 * time.Second
+
+// notifyClaimLease 认领投递后其他实例不会重复发送的时间，应长于一次发送的超时
+const notifyClaimLease = time.Minute
+
+type notifyEventItem struct {
+	projectID uint
+	event     SSEEvent
+	at        time.Time
+}
+
+// StartNotifier 启动通知协程并开始扫描到期的投递，上次退出时未完成的投递随之恢复
+func StartNotifier() {
+	notifyOnce.Do(startNotifier)
+}
+
+// startNotifier 启动分发事件、发送通知与扫描到期投递的协程
+func startNotifier() {
+	notifyEvents = make(chan notifyEventItem, 1000)
+	notifyQueue = make(chan uint, 1000)
+	go func() {
+		for item := range notifyEvents {
+			dispatchProjectEvent(item)
+		}
+	}()
+	workers := viper.GetInt("notify.workers")
+	if workers <= 0 {
+		workers = 4
+	}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for id := range notifyQueue {
+				deliverNotification(id)
+			}
+		}()
+	}
+	go func() {
+		sweepNotifications()
+		for range time.Tick(notifySweepInterval) {
+			sweepNotifications()
+		}
+	}()
+}
+
+// sweepNotifications 把到期的投递交给发送协程；队列已满时留到下次扫描
+func sweepNotifications() {
+	for _, id := range models.GetDueNotificationDeliveries(time.Now(), cap(notifyQueue)) {
+		if !enqueueNotification(id) {
+			return
+		}
+	}
+}
+
+// enqueueNotification 把投递交给发送协程，不阻塞；队列已满时返回 false，由扫描协程稍后发送
+func enqueueNotification(id uint) bool {
+	select {
+	case notifyQueue <- id:
+		return true
+	default:
+		return false
+	}
+}
+
+// notifyProjectEvent 把事件交给分发协程，不阻塞发布方；队列已满时丢弃
+func notifyProjectEvent(projectID uint, event SSEEvent) {
+	notifyOnce.Do(startNotifier)
+	select {
+	case notifyEvents <- notifyEventItem{projectID: projectID, event: event, at: time.Now()}:
+	default:
+		fmt.Printf("警告: 通知队列已满，丢弃项目 %d 的事件 %s\n", projectID, event.Event)
+	}
+}
+
+// dispatchProjectEvent 为订阅了该事件的每个通知 webhook 创建投递记录并排队发送
+func dispatchProjectEvent(item notifyEventItem) {
+	hooks := models.GetEnabledNotificationHooks(item.projectID)
+	if len(hooks) == 0 {
+		return
+	}
+	var proj models.Project
+	utils.DB.Where("id = ?", item.projectID).First(&proj)
+	for _, hook := range hooks {
+		if !matchNotifyEvent(hook.EventList(), item.event.Event) {
+			continue
+		}
+		if _, err := queueNotification(hook, proj, item.event, item.at); err != nil {
+			fmt.Printf("警告: 创建通知投递记录失败: %v\n", err)
+		}
+	}
+}
+
+// queueNotification 创建投递记录并排队发送
+func queueNotification(hook models.NotificationHook, proj models.Project, event SSEEvent, at time.Time) (models.NotificationDelivery, error) {
+	notifyOnce.Do(startNotifier)
+	payload := notifyPayload{
+		DeliveryID: newDeliveryID(),
+		Event:      event.Event,
+		ProjectID:  hook.ProjectId,
+		Project:    proj.Name,
+		Message:    eventMessage(event.Data),
+		Data:       event.Data,
+		Timestamp:  at,
+	}
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return models.NotificationDelivery{}, err
+	}
+	now := time.Now()
+	d := models.NotificationDelivery{
+		HookId:        hook.ID,
+		ProjectId:     hook.ProjectId,
+		Event:         event.Event,
+		DeliveryId:    payload.DeliveryID,
+		Payload:       string(raw),
+		Status:        models.NotifyPending,
+		NextAttemptAt: &now,
+	}
+	if err := models.CreateNotificationDelivery(&d); err != nil {
+		return d, err
+	}
+	enqueueNotification(d.ID)
+	return d, nil
+}
+
+// notifyMaxAttempts 每条通知最多发送的次数
+func notifyMaxAttempts() int {
+	if n := viper.GetInt("notify.maxAttempts"); n > 0 {
+		return n
+	}
+	return 5
+}
+
+// notifyBackoff 第 attempts 次失败后等待的时间：从 notify.retryBase（默认 10 秒）起每次乘 4，最长 1 小时
+func notifyBackoff(attempts int) time.Duration {
+	d := viper.GetDuration("notify.retryBase")
+	if d <= 0 {
+		d = 10 * time.Second
+	}
+	for i := 1; i < attempts && d < time.Hour; i++ {
+		d *= 4
+	}
+	if d > time.Hour {
+		d = time.Hour
+	}
+	return d
+}
+
+// permanentNotifyError 重试也不会成功的错误，如模板错误、签名错误
+type permanentNotifyError struct{ error }
+
+func (e permanentNotifyError) Unwrap() error { return e.error }
+
+// shouldRetry 网络错误与 5xx、408、429 值得重试，其余 4xx 说明配置有误
+func shouldRetry(code int, err error) bool {
+	var perm permanentNotifyError
+	if errors.As(err, &perm) {
+		return false
+	}
+	return code == 0 || code >= 500 || code == http.StatusRequestTimeout || code == http.StatusTooManyRequests
+}
+
+// deliverNotification 发送一次通知并记录结果，失败时记下重试时间，由扫描协程到期后重新排队
+func deliverNotification(id uint) {
+	d, ok := models.ClaimNotificationDelivery(id, time.Now(), notifyClaimLease)
+	if !ok {
+		return // 已发送完成，或已被其他协程、实例认领
+	}
+	hook, err := models.FindNotificationHook(d.ProjectId, d.HookId)
+	if err != nil || !hook.Enabled {
+		d.Status, d.Error, d.NextAttemptAt = models.NotifyFailed, "webhook 已删除或停用", nil
+		saveNotificationDelivery(&d)
+		return
+	}
+
+	code, resp, err := sendNotification(hook, d.Payload)
+	d.Attempts++
+	d.StatusCode, d.Response = code, resp
+	if err == nil {
+		d.Status, d.Error, d.NextAttemptAt = models.NotifySuccess, "", nil
+		saveNotificationDelivery(&d)
+		return
+	}
+	d.Error = err.Error()
+	if d.Attempts >= notifyMaxAttempts() || !shouldRetry(code, err) {
+		d.Status, d.NextAttemptAt = models.NotifyFailed, nil
+		saveNotificationDelivery(&d)
+		return
+	}
+	next := time.Now().Add(notifyBackoff(d.Attempts))
+	d.NextAttemptAt = &next
+	saveNotificationDelivery(&d)
+}
+
+// saveNotificationDelivery 保存投递结果；保存失败时认领到期后由扫描协程重新发送，接收方可按投递ID去重
+func saveNotificationDelivery(d *models.NotificationDelivery) {
+	if err := models.SaveNotificationDelivery(d); err != nil {
+		fmt.Printf("警告: 保存通知投递 %d 的结果失败: %v\n", d.ID, err)
+	}
+}
+
+var errPrivateAddress = errors.New("不允许访问内网地址")
+
+// blockedNotifyNets 标准库未归入内网但同样不应访问的网段：运营商级 NAT 共享地址、
+// 0.0.0.0/8（部分系统上等同本机）与 NAT64 转换前缀（可映射到任意 IPv4 内网地址）
+var blockedNotifyNets = func() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range []string{"100.64.0.0/10", "0.0.0.0/8", "64:ff9b::/96"} {
+		_, n, _ := net.ParseCIDR(cidr)
+		nets = append(nets, n)
+	}
+	return nets
+}()
+
+// guardNotifyAddress 拒绝连接回环、内网与链路本地地址，避免通知 webhook 被用来探测内网；
+// 在建立连接时检查解析后的地址，不受 DNS 重绑定影响
+func guardNotifyAddress(network, address string, _ syscall.RawConn) error {
+	if viper.GetBool("notify.allowPrivateNetworks") {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsUnspecified() || ip.IsMulticast() {
+		return errPrivateAddress
+	}
+	for _, n := range blockedNotifyNets {
+		if n.Contains(ip) {
+			return errPrivateAddress
+		}
+	}
+	return nil
+}
+
+var notifyClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: 5 * time.Second, Control: guardNotifyAddress}).DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+	},
+	// 不跟随重定向，重定向的目标未经检查
+	CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+}
+
+// validateNotifyURL 通知地址只能是 http 或 https
+func validateNotifyURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return newAPIError(http.StatusBadRequest, "无效的通知地址")
+	}
+	return nil
+}
+
+// maskNotifyURL 隐藏地址中的路径与参数，机器人的令牌通常在其中
+func maskNotifyURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host + "/…"
+}
+
+// parseNotifyTemplate 解析聊天消息模板，空模板使用默认文案
+func parseNotifyTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		text = defaultNotifyTemplate
+	}
+	return template.New("notify").Option("missingkey=zero").Parse(text)
+}
+
+// notifyText 按模板生成聊天消息
+func notifyText(hook models.NotificationHook, p notifyPayload) (string, error) {
+	tmpl, err := parseNotifyTemplate(hook.Template)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, p); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// hmacBase64 HMAC-SHA256 签名，base64 编码
+func hmacBase64(key, message string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(message))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// buildNotifyRequest 按格式生成请求。每次发送时重新生成，飞书与钉钉的签名带有时间戳，过期后会被拒绝
+func buildNotifyRequest(hook models.NotificationHook, secret, rawPayload string) (*http.Request, error) {
+	var p notifyPayload
+	if err := json.Unmarshal([]byte(rawPayload), &p); err != nil {
+		return nil, err
+	}
+	target := hook.Url
+	now := time.Now()
+	headers := map[string]string{}
+	var body interface{}
+
+	if hook.Format == models.NotifyFormatJSON {
+		raw := []byte(rawPayload)
+		ts := strconv.FormatInt(now.Unix(), 10)
+		headers["X-CodeCampass-Event"] = p.Event
+		headers["X-CodeCampass-Delivery"] = p.DeliveryID
+		headers["X-CodeCampass-Timestamp"] = ts
+		if secret != "" {
+			// 签名内容为 "<时间戳>.<请求体>"，接收方可据此拒绝重放的请求
+			mac := hmac.New(sha256.New, []byte(secret))
+			mac.Write([]byte(ts + "."))
+			mac.Write(raw)
+			headers["X-CodeCampass-Signature"] = "sha256=" + hex.EncodeToString(mac.Sum(nil))
+		}
+		return newNotifyRequest(target, raw, headers)
+	}
+
+	text, err := notifyText(hook, p)
+	if err != nil {
+		return nil, fmt.Errorf("消息模板错误: %v", err)
+	}
+	switch hook.Format {
+	case models.NotifyFormatSlack:
+		// Slack 的 Incoming Webhook 不支持签名，地址本身即凭证
+		body = gin.H{"text": text}
+	case models.NotifyFormatFeishu:
+		msg := gin.H{"msg_type": "text", "content": gin.H{"text": text}}
+		if secret != "" {
+			ts := strconv.FormatInt(now.Unix(), 10)
+			msg["timestamp"] = ts
+			msg["sign"] = hmacBase64(ts+"\n"+secret, "")
+		}
+		body = msg
+	case models.NotifyFormatDingTalk:
+		if secret != "" {
+			ts := strconv.FormatInt(now.UnixMilli(), 10)
+			sep := "?"
+			if strings.Contains(target, "?") {
+				sep = "&"
+			}
+			target += sep + "timestamp=" + ts + "&sign=" + url.QueryEscape(hmacBase64(secret, ts+"\n"+secret))
+		}
+		body = gin.H{"msgtype": "text", "text": gin.H{"content": text}}
+	default:
+		return nil, fmt.Errorf("不支持的通知格式: %s", hook.Format)
+	}
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	return newNotifyRequest(target, raw, headers)
+}
+
+func newNotifyRequest(target string, body []byte, headers map[string]string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "CodeCampass-Webhook")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	return req, nil
+}
+
+// chatResponseError 飞书与钉钉在出错时也返回 200，错误码在响应体中
+func chatResponseError(format string, body []byte) error {
+	var resp struct {
+		Code       *int   `json:"code"`
+		StatusCode *int   `json:"StatusCode"`
+		Msg        string `json:"msg"`
+		ErrCode    *int   `json:"errcode"`
+		ErrMsg     string `json:"errmsg"`
+	}
+	switch format {
+	case models.NotifyFormatFeishu:
+		if json.Unmarshal(body, &resp) == nil {
+			if resp.Code != nil && *resp.Code != 0 {
+				return fmt.Errorf("飞书返回错误 %d: %s", *resp.Code, resp.Msg)
+			}
+			if resp.StatusCode != nil && *resp.StatusCode != 0 {
+				return fmt.Errorf("飞书返回错误 %d", *resp.StatusCode)
+			}
+		}
+	case models.NotifyFormatDingTalk:
+		if json.Unmarshal(body, &resp) == nil && resp.ErrCode != nil && *resp.ErrCode != 0 {
+			return fmt.Errorf("钉钉返回错误 %d: %s", *resp.ErrCode, resp.ErrMsg)
+		}
+	}
+	return nil
+}
+
+// sendNotification 发送一次通知，返回 HTTP 状态码与截断后的响应体
+func sendNotification(hook models.NotificationHook, rawPayload string) (int, string, error) {
+	secret := ""
+	if hook.SecretSet {
+		var err error
+		secret, err = utils.GetSecret(context.Background(), notifySecretKey(hook.ID))
+		if err != nil {
+			return 0, "", fmt.Errorf("读取签名密钥失败: %v", err)
+		}
+	}
+	req, err := buildNotifyRequest(hook, secret, rawPayload)
+	if err != nil {
+		return 0, "", permanentNotifyError{err}
+	}
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		if errors.Is(err, errPrivateAddress) {
+			return 0, "", permanentNotifyError{err}
+		}
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	// 读取上限可能截在多字节字符中间，去掉无效的字节，避免写入数据库失败
+	text := strings.ToValidUTF8(string(body), "")
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, text, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	if err := chatResponseError(hook.Format, body); err != nil {
+		// 业务错误（签名错误、关键词不匹配等）重试也不会成功
+		return resp.StatusCode, text, permanentNotifyError{err}
+	}
+	return resp.StatusCode, text, nil
+}
@@ -0,0 +1,323 @@
+package service
+
+import (
+	"CodeCampass/models"
+	"CodeCampass/utils"
+	"errors"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// notificationRequest 创建或修改通知 webhook 的请求，修改时只更新传入的字段
+type notificationRequest struct {
+	Name     *string   `json:"name"`
+	Url      *string   `json:"url"`
+	Format   *string   `json:"format"`   // json、slack、feishu、dingtalk
+	Events   *[]string `json:"events"`   // 事件类型，支持 * 通配；创建时不传则订阅索引与同步的完成和失败
+	Template *string   `json:"template"` // 聊天消息的 text/template 模板，可用 .Project .Event .Message .Data
+	Secret   *string   `json:"secret"`   // 签名密钥，空字符串表示清除；Slack 不支持签名
+	Enabled  *bool     `json:"enabled"`
+}
+
+// notificationView 接口返回的通知 webhook，地址经过脱敏
+type notificationView struct {
+	models.NotificationHook
+	UrlMask string `json:"url_mask"`
+}
+
+func newNotificationView(h models.NotificationHook) notificationView {
+	return notificationView{NotificationHook: h, UrlMask: maskNotifyURL(h.Url)}
+}
+
+var notifyFormats = map[string]bool{
+	models.NotifyFormatJSON:     true,
+	models.NotifyFormatSlack:    true,
+	models.NotifyFormatFeishu:   true,
+	models.NotifyFormatDingTalk: true,
+}
+
+// applyNotificationRequest 校验并写入请求中的字段，返回需要保存的密钥（nil 表示不修改）
+func applyNotificationRequest(hook *models.NotificationHook, req notificationRequest) (*string, error) {
+	if req.Name != nil {
+		hook.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.Url != nil {
+		if err := validateNotifyURL(*req.Url); err != nil {
+			return nil, err
+		}
+		hook.Url = *req.Url
+	}
+	if req.Format != nil {
+		if !notifyFormats[*req.Format] {
+			return nil, newAPIError(http.StatusBadRequest, "format 只能是 json、slack、feishu 或 dingtalk")
+		}
+		hook.Format = *req.Format
+	}
+	if req.Events != nil {
+		events := make([]string, 0, len(*req.Events))
+		for _, e := range *req.Events {
+			e = strings.TrimSpace(e)
+			if e == "" {
+				continue
+			}
+			if _, err := path.Match(e, ""); err != nil || strings.Contains(e, ",") {
+				return nil, newAPIError(http.StatusBadRequest, "无效的事件类型: "+e)
+			}
+			events = append(events, e)
+		}
+		if len(events) == 0 {
+			return nil, newAPIError(http.StatusBadRequest, "至少订阅一种事件")
+		}
+		hook.Events = strings.Join(events, ",")
+	}
+	if req.Template != nil {
+		if _, err := parseNotifyTemplate(*req.Template); err != nil {
+			return nil, newAPIError(http.StatusBadRequest, "消息模板错误: "+err.Error())
+		}
+		hook.Template = *req.Template
+	}
+	if req.Enabled != nil {
+		hook.Enabled = *req.Enabled
+	}
+	return req.Secret, nil
+}
+
+// saveNotifySecret 保存或清除签名密钥
+func saveNotifySecret(c *gin.Context, hook *models.NotificationHook, secret string) error {
+	key := notifySecretKey(hook.ID)
+	if secret == "" {
+		hook.SecretSet = false
+		return utils.DelSecret(c.Request.Context(), key)
+	}
+	if err := utils.SetSecret(c.Request.Context(), key, secret); err != nil {
+		if errors.Is(err, utils.ErrNoMasterKey) {
+			return newAPIError(http.StatusServiceUnavailable, err.Error())
+		}
+		return err
+	}
+	hook.SecretSet = true
+	return nil
+}
+
+// loadNotificationHook 按路径参数查找项目的通知 webhook，写操作需要项目的管理权限
+func loadNotificationHook(c *gin.Context, manage bool) (models.Project, models.NotificationHook, bool) {
+	var hook models.NotificationHook
+	proj, ok := loadProjectV1(c)
+	if !ok {
+		return proj, hook, false
+	}
+	if manage {
+		userID, _ := c.Get("userID")
+		if !canManageProject(userID.(uint), proj) {
+			respondError(c, newAPIError(http.StatusForbidden, "无权限修改此项目的通知"))
+			return proj, hook, false
+		}
+	}
+	id, err := strconv.ParseUint(c.Param("hookId"), 10, 64)
+	if err != nil {
+		respondError(c, newAPIError(http.StatusBadRequest, "无效的通知ID"))
+		return proj, hook, false
+	}
+	hook, err = models.FindNotificationHook(proj.ID, uint(id))
+	if err != nil {
+		respondError(c, newAPIError(http.StatusNotFound, "通知不存在"))
+		return proj, hook, false
+	}
+	return proj, hook, true
+}
+
+// ListNotificationsV1
+// @Summary 获取项目的通知 webhook 列表
+// @Tags 项目模块 v1
+// @Security Bearer
+// @Param id path int true "项目ID"
+// @Success 200 {object} map[string]interface{}
+// @Router /api/v1/projects/{id}/notifications [get]
+func ListNotificationsV1(c *gin.Context) {
+	proj, ok := loadProjectV1(c)
+	if !ok {
+		return
+	}
+	hooks := models.GetProjectNotificationHooks(proj.ID)
+	items := make([]notificationView, 0, len(hooks))
+	for _, h := range hooks {
+		items = append(items, newNotificationView(h))
+	}
+	respondOK(c, http.StatusOK, "获取成功", gin.H{"notifications": items})
+}
+
+// CreateNotificationV1
+// @Summary 添加通知 webhook
+// @Description 项目事件（如索引完成或失败）发生时向该地址发送通知。json 格式带 X-CodeCampass-Signature 签名（对 "<时间戳>.<请求体>" 做 HMAC-SHA256），飞书、钉钉使用各自的加签方式
+// @Tags 项目模块 v1
+// @Security Bearer
+// @Param id path int true "项目ID"
+// @Param body body notificationRequest true "url 与 format 必填"
+// @Success 201 {object} map[string]interface{}
+// @Router /api/v1/projects/{id}/notifications [post]
+func CreateNotificationV1(c *gin.Context) {
+	proj, ok := loadProjectV1(c)
+	if !ok {
+		return
+	}
+	userID, _ := c.Get("userID")
+	if !canManageProject(userID.(uint), proj) {
+		respondError(c, newAPIError(http.StatusForbidden, "无权限修改此项目的通知"))
+		return
+	}
+	var req notificationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, newAPIError(http.StatusBadRequest, "请求体格式错误"))
+		return
+	}
+	if req.Url == nil || req.Format == nil {
+		respondError(c, newAPIError(http.StatusBadRequest, "url 与 format 不能为空"))
+		return
+	}
+
+	hook := models.NotificationHook{
+		ProjectId: proj.ID,
+		CreatorId: userID.(uint),
+		Events:    strings.Join(defaultNotifyEvents, ","),
+		Enabled:   true,
+	}
+	secret, err := applyNotificationRequest(&hook, req)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	if err := utils.DB.Create(&hook).Error; err != nil {
+		respondError(c, err)
+		return
+	}
+	// 密钥按 ID 存放，创建后再保存
+	if secret != nil && *secret != "" {
+		if err := saveNotifySecret(c, &hook, *secret); err != nil {
+			utils.DB.Delete(&hook)
+			respondError(c, err)
+			return
+		}
+		utils.DB.Save(&hook)
+	}
+	respondOK(c, http.StatusCreated, "通知已添加", newNotificationView(hook))
+}
+
+// UpdateNotificationV1
+// @Summary 修改通知 webhook（只更新传入的字段）
+// @Tags 项目模块 v1
+// @Security Bearer
+// @Param id path int true "项目ID"
+// @Param hookId path int true "通知ID"
+// @Param body body notificationRequest true "通知设置"
+// @Success 200 {object} map[string]interface{}
+// @Router /api/v1/projects/{id}/notifications/{hookId} [patch]
+func UpdateNotificationV1(c *gin.Context) {
+	_, hook, ok := loadNotificationHook(c, true)
+	if !ok {
+		return
+	}
+	var req notificationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, newAPIError(http.StatusBadRequest, "请求体格式错误"))
+		return
+	}
+	secret, err := applyNotificationRequest(&hook, req)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	if secret != nil {
+		if err := saveNotifySecret(c, &hook, *secret); err != nil {
+			respondError(c, err)
+			return
+		}
+	}
+	if err := utils.DB.Save(&hook).Error; err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, "通知已更新", newNotificationView(hook))
+}
+
+// DeleteNotificationV1
+// @Summary 删除通知 webhook
+// @Tags 项目模块 v1
+// @Security Bearer
+// @Param id path int true "项目ID"
+// @Param hookId path int true "通知ID"
+// @Success 200 {object} map[string]interface{}
+// @Router /api/v1/projects/{id}/notifications/{hookId} [delete]
+func DeleteNotificationV1(c *gin.Context) {
+	_, hook, ok := loadNotificationHook(c, true)
+	if !ok {
+		return
+	}
+	if err := models.DeleteNotificationHook(&hook); err != nil {
+		respondError(c, err)
+		return
+	}
+	utils.DelSecret(c.Request.Context(), notifySecretKey(hook.ID))
+	respondOK(c, http.StatusOK, "通知已删除", nil)
+}
+
+// TestNotificationV1
+// @Summary 发送测试通知
+// @Description 不论订阅的事件类型，向该地址发送一条 ping 事件，结果见投递记录
+// @Tags 项目模块 v1
+// @Security Bearer
+// @Param id path int true "项目ID"
+// @Param hookId path int true "通知ID"
+// @Success 202 {object} map[string]interface{}
+// @Router /api/v1/projects/{id}/notifications/{hookId}/test [post]
+func TestNotificationV1(c *gin.Context) {
+	proj, hook, ok := loadNotificationHook(c, true)
+	if !ok {
+		return
+	}
+	if !hook.Enabled {
+		respondError(c, newAPIError(http.StatusConflict, "通知已停用"))
+		return
+	}
+	d, err := queueNotification(hook, proj, SSEEvent{
+		Event: notifyTestEvent,
+		Data: gin.H{
+			"message":    "这是一条测试通知",
+			"project_id": proj.ID,
+		},
+	}, time.Now())
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusAccepted, "测试通知已排队", d)
+}
+
+// ListNotificationDeliveriesV1
+// @Summary 查看通知的投递记录
+// @Tags 项目模块 v1
+// @Security Bearer
+// @Param id path int true "项目ID"
+// @Param hookId path int true "通知ID"
+// @Param limit query int false "返回条数，默认 30，最多 200"
+// @Success 200 {object} map[string]interface{}
+// @Router /api/v1/projects/{id}/notifications/{hookId}/deliveries [get]
+func ListNotificationDeliveriesV1(c *gin.Context) {
+	_, hook, ok := loadNotificationHook(c, false)
+	if !ok {
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "30"))
+	if err != nil || limit <= 0 {
+		respondError(c, newAPIError(http.StatusBadRequest, "无效的 limit"))
+		return
+	}
+	if limit > 200 {
+		limit = 200
+	}
+	respondOK(c, http.StatusOK, "获取成功", gin.H{"deliveries": models.GetNotificationDeliveries(hook.ID, limit)})
+}
@@ -0,0 +1,402 @@
+package service
+
+import (
+	"CodeCampass/models"
+	"CodeCampass/utils"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CreateOrganization
+// @Summary 创建组织
+// @Tags 组织模块
+// @Security Bearer
+// @Param name query string true "组织名"
+// @Param description query string false "组织介绍"
+// @Success 200 {object} map[string]interface{}
+// @Router /api/createOrganization [post]
+func CreateOrganization(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户未登录"})
+		return
+	}
+
+	name := c.Query("name")
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"code": -1, "message": "组织名不能为空"})
+		return
+	}
+
+	same := models.Organization{}
+	utils.DB.Where("name = ?", name).First(&same)
+	if same.Name != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"code": -1, "message": "组织名已存在"})
+		return
+	}
+
+	org := models.Organization{
+		Name:        name,
+		Description: c.Query("description"),
+		OwnerId:     userID.(uint),
+	}
+	if err := models.CreateOrganization(&org); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": -1, "message": "创建失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "组织创建成功",
+		"data":    org,
+	})
+}
+
+// ListOrganizations
+// @Summary 列出当前用户所在的组织
+// @Tags 组织模块
+// @Security Bearer
+// @Success 200 {object} map[string]interface{}
+// @Router /api/listOrganizations [get]
+func ListOrganizations(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户未登录"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "获取成功",
+		"data":    models.GetUserOrgList(userID.(uint)),
+	})
+}
+
+// GetOrganizationInfo
+// @Summary 查看组织信息（含成员与项目）
+// @Tags 组织模块
+// @Security Bearer
+// @Param org_id query int true "组织ID"
+// @Success 200 {object} map[string]interface{}
+// @Router /api/getOrganizationInfo [get]
+func GetOrganizationInfo(c *gin.Context) {
+	org, _, ok := loadOrgForMember(c, false)
+	if !ok {
+		return
+	}
+
+	var projects []models.Project
+	utils.DB.Where("org_id = ?", org.ID).Find(&projects)
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "查看成功",
+		"data": gin.H{
+			"organization": org,
+			"members":      models.GetOrgMemberList(org.ID),
+			"projects":     projects,
+			"has_key":      getOrgOpenAIKey(org.ID) != "",
+		},
+	})
+}
+
+// DeleteOrganization
+// @Summary 删除组织（仅所有者，组织下不能有项目）
+// @Tags 组织模块
+// @Security Bearer
+// @Param org_id query int true "组织ID"
+// @Success 200 {object} map[string]interface{}
+// @Router /api/deleteOrganization [delete]
+func DeleteOrganization(c *gin.Context) {
+	org, member, ok := loadOrgForMember(c, true)
+	if !ok {
+		return
+	}
+	if member.Role != models.OrgRoleOwner {
+		c.JSON(http.StatusForbidden, gin.H{"error": "只有组织所有者可以删除组织"})
+		return
+	}
+
+	var count int64
+	utils.DB.Model(&models.Project{}).Where("org_id = ?", org.ID).Count(&count)
+	if count > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"code": -1, "message": "请先删除或转移组织下的项目"})
+		return
+	}
+
+	if err := models.DeleteOrganization(org); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": -1, "message": "删除失败"})
+		return
+	}
+	utils.DelSecret(utils.Red.Context(), fmt.Sprintf("openai_key:org:%d", org.ID))
+
+	c.JSON(http.StatusOK, gin.H{"code": 0, "message": "组织已删除"})
+}
+
+// AddOrgMember
+// @Summary 添加组织成员
+// @Tags 组织模块
+// @Security Bearer
+// @Param org_id query int true "组织ID"
+// @Param user_name query string true "用户名"
+// @Param role query string false "角色 admin/member，默认 member"
+// @Success 200 {object} map[string]interface{}
+// @Router /api/addOrgMember [post]
+func AddOrgMember(c *gin.Context) {
+	org, _, ok := loadOrgForMember(c, true)
+	if !ok {
+		return
+	}
+
+	role := c.DefaultQuery("role", models.OrgRoleMember)
+	if role != models.OrgRoleMember && role != models.OrgRoleAdmin {
+		c.JSON(http.StatusBadRequest, gin.H{"code": -1, "message": "无效的角色"})
+		return
+	}
+
+	user := models.FindUserByName(c.Query("user_name"))
+	if user.Name == "" {
+		c.JSON(http.StatusNotFound, gin.H{"code": -1, "message": "用户不存在"})
+		return
+	}
+	if _, err := models.FindOrgMember(org.ID, user.ID); err == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": -1, "message": "该用户已是组织成员"})
+		return
+	}
+
+	member := models.OrganizationMember{OrgId: org.ID, UserId: user.ID, Role: role}
+	if err := utils.DB.Create(&member).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": -1, "message": "添加失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "成员添加成功",
+		"data":    member,
+	})
+}
+
+// RemoveOrgMember
+// @Summary 移除组织成员
+// @Tags 组织模块
+// @Security Bearer
+// @Param org_id query int true "组织ID"
+// @Param user_id query int true "成员用户ID"
+// @Success 200 {object} map[string]interface{}
+// @Router /api/removeOrgMember [delete]
+func RemoveOrgMember(c *gin.Context) {
+	org, _, ok := loadOrgForMember(c, true)
+	if !ok {
+		return
+	}
+
+	memberUserID, err := strconv.ParseUint(c.Query("user_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": -1, "message": "无效的 user_id"})
+		return
+	}
+
+	target, err := models.FindOrgMember(org.ID, uint(memberUserID))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"code": -1, "message": "成员不存在"})
+		return
+	}
+	if target.Role == models.OrgRoleOwner {
+		c.JSON(http.StatusBadRequest, gin.H{"code": -1, "message": "不能移除组织所有者"})
+		return
+	}
+
+	if err := utils.DB.Delete(&target).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": -1, "message": "移除失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"code": 0, "message": "成员已移除"})
+}
+
+// SetOrgOpenAIKey
+// @Summary 设置组织的 OpenAI API Key
+// @Tags 组织模块
+// @Security Bearer
+// @Param org_id query int true "组织ID"
+// @Param key query string true "OpenAI API Key"
+// @Success 200 {object} map[string]interface{}
+// @Router /api/setOrgOpenAIKey [post]
+func SetOrgOpenAIKey(c *gin.Context) {
+	org, _, ok := loadOrgForMember(c, true)
+	if !ok {
+		return
+	}
+
+	key := c.Query("key")
+	if key == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"code": -1, "message": "API Key 不能为空"})
+		return
+	}
+
+	err := utils.SetSecret(utils.Red.Context(), fmt.Sprintf("openai_key:org:%d", org.ID), key)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    -1,
+			"message": "保存失败",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"code": 0, "message": "组织 API Key 设置成功"})
+}
+
+// DeleteOrgOpenAIKey
+// @Summary 删除组织的 OpenAI API Key
+// @Tags 组织模块
+// @Security Bearer
+// @Param org_id query int true "组织ID"
+// @Success 200 {object} map[string]interface{}
+// @Router /api/deleteOrgOpenAIKey [delete]
+func DeleteOrgOpenAIKey(c *gin.Context) {
+	org, _, ok := loadOrgForMember(c, true)
+	if !ok {
+		return
+	}
+
+	err := utils.DelSecret(utils.Red.Context(), fmt.Sprintf("openai_key:org:%d", org.ID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    -1,
+			"message": "删除失败",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"code": 0, "message": "组织 API Key 已删除"})
+}
+
+// SetOrgSpendingLimit
+// @Summary 设置组织每月 LLM 花费上限
+// @Tags 组织模块
+// @Security Bearer
+// @Param org_id query int true "组织ID"
+// @Param limit query number true "每月上限（美元），0 表示不限制"
+// @Param action query string false "超出上限后的处理方式 refuse/degrade"
+// @Success 200 {object} map[string]interface{}
+// @Router /api/setOrgSpendingLimit [put]
+func SetOrgSpendingLimit(c *gin.Context) {
+	org, _, ok := loadOrgForMember(c, true)
+	if !ok {
+		return
+	}
+
+	limit, err := strconv.ParseFloat(c.Query("limit"), 64)
+	if err != nil || limit < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"code": -1, "message": "无效的花费上限"})
+		return
+	}
+
+	action := c.Query("action")
+	if action != "" && action != models.BudgetActionRefuse && action != models.BudgetActionDegrade {
+		c.JSON(http.StatusBadRequest, gin.H{"code": -1, "message": "action 只能是 refuse 或 degrade"})
+		return
+	}
+
+	if err := utils.DB.Model(&org).Updates(map[string]interface{}{
+		"spending_limit": limit,
+		"budget_action":  action,
+	}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": -1, "message": "更新失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "花费上限已更新",
+		"data":    org,
+	})
+}
+
+// TransferProject
+// @Summary 将个人项目转移到组织
+// @Tags 组织模块
+// @Security Bearer
+// @Param name query string true "项目名"
+// @Param org_id query int true "目标组织ID"
+// @Success 200 {object} map[string]interface{}
+// @Router /api/transferProject [post]
+func TransferProject(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户未登录"})
+		return
+	}
+
+	name := c.Query("name")
+	var proj models.Project
+	if err := utils.DB.Where("owner_id = ? and org_id = 0 and name = ?", userID, name).First(&proj).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "个人项目不存在"})
+		return
+	}
+
+	org, _, ok := loadOrgForMember(c, false)
+	if !ok {
+		return
+	}
+
+	same := models.Project{}
+	utils.DB.Where("org_id = ? and name = ?", org.ID, name).First(&same)
+	if same.Name != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"code": -1, "message": "组织内已存在同名项目"})
+		return
+	}
+
+	// 仓库目录按 owner_id 存放，转移后保持不变，只修改归属组织
+	if err := utils.DB.Model(&proj).Update("org_id", org.ID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": -1, "message": "转移失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "项目已转移到组织",
+		"data":    proj,
+	})
+}
+
+// loadOrgForMember 根据 org_id 查询组织并校验当前用户是成员，needManager 为 true 时要求管理员权限
+func loadOrgForMember(c *gin.Context, needManager bool) (models.Organization, models.OrganizationMember, bool) {
+	var org models.Organization
+	var member models.OrganizationMember
+
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户未登录"})
+		return org, member, false
+	}
+
+	orgID, err := strconv.ParseUint(c.Query("org_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": -1, "message": "无效的 org_id"})
+		return org, member, false
+	}
+
+	if err := utils.DB.Where("id = ?", orgID).First(&org).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "组织不存在"})
+		return org, member, false
+	}
+
+	member, err = models.FindOrgMember(org.ID, userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "不是该组织成员"})
+		return org, member, false
+	}
+	if needManager && !member.IsManager() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "需要组织管理员权限"})
+		return org, member, false
+	}
+
+	return org, member, true
+}
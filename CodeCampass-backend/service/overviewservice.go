@@ -0,0 +1,103 @@
+package service
+
+import (
+	"CodeCampass/models"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetProjectOverviewV1
+// @Summary 项目架构概览与目录、文件摘要
+// @Tags 项目模块 v1
+// @Security Bearer
+// @Param id path int true "项目ID"
+// @Param path query string false "目录或文件路径，不传时返回仓库概览与前两层目录的摘要"
+// @Success 200 {object} map[string]interface{}
+// @Router /api/v1/projects/{id}/overview [get]
+func GetProjectOverviewV1(c *gin.Context) {
+	proj, ok := loadProjectV1(c)
+	if !ok {
+		return
+	}
+
+	summaries := models.FindProjectSummaries(proj.ID)
+	overview, ok := summaries[""]
+	if !ok {
+		respondError(c, newAPIError(http.StatusConflict, "项目概览尚未生成，请先导入项目"))
+		return
+	}
+
+	target := strings.Trim(c.Query("path"), "/")
+	if target == "" {
+		dirs := make([]models.ProjectSummary, 0)
+		for _, s := range models.GetProjectSummaryList(proj.ID, models.SummaryKindDir) {
+			if strings.Count(s.Path, "/") <= 1 {
+				dirs = append(dirs, s)
+			}
+		}
+		respondOK(c, http.StatusOK, "查询成功", gin.H{
+			"overview":    overview,
+			"directories": dirs,
+		})
+		return
+	}
+
+	node, ok := summaries[target]
+	if !ok {
+		respondError(c, newAPIError(http.StatusNotFound, fmt.Sprintf("没有 %s 的摘要", target)))
+		return
+	}
+	children := make([]models.ProjectSummary, 0)
+	for _, s := range models.GetProjectSummaryList(proj.ID) {
+		if s.Path != "" && s.Path != target && path.Dir(s.Path) == target {
+			children = append(children, s)
+		}
+	}
+	respondOK(c, http.StatusOK, "查询成功", gin.H{
+		"summary":  node,
+		"children": children,
+	})
+}
+
+// RefreshProjectOverviewV1
+// @Summary 重新生成项目概览（只为变化的文件与目录调用 LLM）
+// @Description 需要项目管理权限；正在生成时返回 409
+// @Tags 项目模块 v1
+// @Security Bearer
+// @Param id path int true "项目ID"
+// @Success 202 {object} map[string]interface{}
+// @Router /api/v1/projects/{id}/overview/refresh [post]
+func RefreshProjectOverviewV1(c *gin.Context) {
+	proj, ok := loadProjectV1(c)
+	if !ok {
+		return
+	}
+	userID, _ := c.Get("userID")
+	if !canManageProject(userID.(uint), proj) {
+		respondError(c, newAPIError(http.StatusForbidden, "无权限重新生成此项目的概览"))
+		return
+	}
+	baseDir, err := clonedRepoDir(proj)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	// 在请求中加锁，正在生成时直接返回 409
+	unlock, err := lockProjectSummaries(proj.ID)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	go func() {
+		defer unlock()
+		if err := generateProjectSummaries(proj, baseDir); err != nil {
+			fmt.Printf("警告: 生成项目概览失败: %v\n", err)
+		}
+	}()
+	respondOK(c, http.StatusAccepted, "项目概览正在后台生成", nil)
+}
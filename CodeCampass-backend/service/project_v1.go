@@ -0,0 +1,414 @@
+package service
+
+import (
+	"CodeCampass/models"
+	"CodeCampass/utils"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// v1 接口统一返回 {code, message, data}，code 为 0 表示成功，-1 表示失败
+
+// respondOK 返回成功响应
+func respondOK(c *gin.Context, status int, message string, data interface{}) {
+	c.JSON(status, gin.H{
+		"code":    0,
+		"message": message,
+		"data":    data,
+	})
+}
+
+// respondError 返回失败响应，状态码取自 apiError
+func respondError(c *gin.Context, err error) {
+	c.JSON(errorStatus(err), gin.H{
+		"code":    -1,
+		"message": err.Error(),
+		"data":    nil,
+	})
+}
+
+// projectRequest v1 创建/修改项目的请求体，修改时只更新传入的字段
+type projectRequest struct {
+	Name        *string   `json:"name"`
+	Description *string   `json:"description"`
+	RepoUrl     *string   `json:"repo_url"`
+	Tags        *[]string `json:"tags"`
+	TrackedRef  *string   `json:"tracked_ref"` // 跟踪的分支，空字符串表示远端的默认分支
+	OrgId       uint      `json:"org_id"`
+}
+
+// 问答模式
+const (
+	askModeRAG   = "rag"   // 检索最相关的片段后一次性回答（默认）
+	askModeAgent = "agent" // 模型调用仓库工具逐步查找后回答
+)
+
+// askRequest v1 项目问答的请求体
+type askRequest struct {
+	Question string `json:"question" binding:"required"`
+	Mode     string `json:"mode"`
+	MaxSteps int    `json:"max_steps"`
+}
+
+// loadProjectV1 解析路径中的项目ID并校验访问权限
+func loadProjectV1(c *gin.Context) (models.Project, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		respondError(c, newAPIError(http.StatusBadRequest, "无效的项目ID"))
+		return models.Project{}, false
+	}
+
+	userID, _ := c.Get("userID")
+	proj, err := findUserProjectByID(userID, uint(id))
+	if err != nil {
+		respondError(c, newAPIError(http.StatusNotFound, "项目不存在"))
+		return models.Project{}, false
+	}
+	return proj, true
+}
+
+// ListProjectsV1
+// @Summary 列出项目（支持搜索、筛选、排序与游标分页）
+// @Tags 项目模块 v1
+// @Security Bearer
+// @Param q query string false "在项目名和描述中全文搜索"
+// @Param status query string false "索引状态 cloning/indexing/ready/failed"
+// @Param language query string false "主要语言"
+// @Param tag query string false "标签"
+// @Param sort query string false "排序 updated/asked/name，默认 updated"
+// @Param cursor query string false "分页游标，取自上一页的 next_cursor"
+// @Param limit query int false "每页数量，默认 20，最大 100"
+// @Success 200 {object} map[string]interface{}
+// @Router /api/v1/projects [get]
+func ListProjectsV1(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	query, err := projectQueryFromRequest(c, userID.(uint), 20)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	projects, nextCursor, err := queryProjects(query)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, "获取成功", gin.H{
+		"items":       projects,
+		"next_cursor": nextCursor,
+	})
+}
+
+// CreateProjectV1
+// @Summary 创建项目
+// @Tags 项目模块 v1
+// @Security Bearer
+// @Param body body projectRequest true "项目信息"
+// @Success 201 {object} map[string]interface{}
+// @Router /api/v1/projects [post]
+func CreateProjectV1(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	var req projectRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, newAPIError(http.StatusBadRequest, "请求体格式错误"))
+		return
+	}
+
+	proj := models.Project{OrgId: req.OrgId}
+	if req.Name != nil {
+		proj.Name = *req.Name
+	}
+	if req.Description != nil {
+		proj.Description = *req.Description
+	}
+	if req.RepoUrl != nil {
+		proj.RepoUrl = *req.RepoUrl
+	}
+	if req.Tags != nil {
+		proj.Tags = models.NormalizeTags(*req.Tags)
+	}
+
+	proj, err := createProject(userID.(uint), proj)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusCreated, "项目创建成功", proj)
+}
+
+// GetProjectV1
+// @Summary 查看项目信息
+// @Tags 项目模块 v1
+// @Security Bearer
+// @Param id path int true "项目ID"
+// @Success 200 {object} map[string]interface{}
+// @Router /api/v1/projects/{id} [get]
+func GetProjectV1(c *gin.Context) {
+	proj, ok := loadProjectV1(c)
+	if !ok {
+		return
+	}
+	respondOK(c, http.StatusOK, "查看成功", proj)
+}
+
+// UpdateProjectV1
+// @Summary 修改项目（只更新传入的字段）
+// @Tags 项目模块 v1
+// @Security Bearer
+// @Param id path int true "项目ID"
+// @Param body body projectRequest true "项目信息"
+// @Success 200 {object} map[string]interface{}
+// @Router /api/v1/projects/{id} [patch]
+func UpdateProjectV1(c *gin.Context) {
+	proj, ok := loadProjectV1(c)
+	if !ok {
+		return
+	}
+
+	userID, _ := c.Get("userID")
+	if !canManageProject(userID.(uint), proj) {
+		respondError(c, newAPIError(http.StatusForbidden, "无权限修改此项目"))
+		return
+	}
+
+	var req projectRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, newAPIError(http.StatusBadRequest, "请求体格式错误"))
+		return
+	}
+
+	if req.Name != nil {
+		if *req.Name == "" {
+			respondError(c, newAPIError(http.StatusBadRequest, "项目名不能为空"))
+			return
+		}
+		if *req.Name != proj.Name {
+			var count int64
+			query := utils.DB.Model(&models.Project{}).Where("name = ? and id <> ?", *req.Name, proj.ID)
+			if proj.OrgId != 0 {
+				query = query.Where("org_id = ?", proj.OrgId)
+			} else {
+				query = query.Where("owner_id = ? and org_id = 0", proj.OwnerId)
+			}
+			query.Count(&count)
+			if count > 0 {
+				respondError(c, newAPIError(http.StatusConflict, "项目名不可重复"))
+				return
+			}
+		}
+		proj.Name = *req.Name
+	}
+	if req.Description != nil {
+		proj.Description = *req.Description
+	}
+	if req.RepoUrl != nil {
+		proj.RepoUrl = *req.RepoUrl
+	}
+	if req.Tags != nil {
+		proj.Tags = models.NormalizeTags(*req.Tags)
+	}
+	if req.TrackedRef != nil {
+		ref, err := normalizeTrackedRef(*req.TrackedRef)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		proj.TrackedRef = ref
+	}
+
+	if err := utils.DB.Save(&proj).Error; err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, "项目更新成功", proj)
+}
+
+// DeleteProjectV1
+// @Summary 删除项目
+// @Tags 项目模块 v1
+// @Security Bearer
+// @Param id path int true "项目ID"
+// @Success 200 {object} map[string]interface{}
+// @Router /api/v1/projects/{id} [delete]
+func DeleteProjectV1(c *gin.Context) {
+	proj, ok := loadProjectV1(c)
+	if !ok {
+		return
+	}
+
+	userID, _ := c.Get("userID")
+	if !canManageProject(userID.(uint), proj) {
+		respondError(c, newAPIError(http.StatusForbidden, "无权限删除此项目"))
+		return
+	}
+
+	if err := deleteProject(proj); err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, "项目删除成功", nil)
+}
+
+// ImportProjectV1
+// @Summary 导入项目仓库（embedding 在后台构建）
+// @Tags 项目模块 v1
+// @Security Bearer
+// @Param id path int true "项目ID"
+// @Param history query string false "克隆的历史：shallow（默认，仅最新提交）、partial、full；不传时沿用上次导入的设置"
+// @Param depth query int false "history 为 partial 时克隆的提交数，默认 100"
+// @Param submodules query bool false "是否检出子模块；不传时沿用上次导入的设置"
+// @Param submodule_depth query int false "子模块的历史深度：0 只克隆最新提交（默认），-1 完整历史"
+// @Param submodule_ignore query string false "不检出的子模块路径，逗号分隔，支持 * 通配"
+// @Param lfs query string false "fetch 拉取 LFS 对象，skip 保留为指针（默认）；LFS 指针与未检出的子模块不构建 embedding"
+// @Success 200 {object} map[string]interface{}
+// @Router /api/v1/projects/{id}/import [post]
+func ImportProjectV1(c *gin.Context) {
+	proj, ok := loadProjectV1(c)
+	if !ok {
+		return
+	}
+
+	userID, _ := c.Get("userID")
+	if !canManageProject(userID.(uint), proj) {
+		respondError(c, newAPIError(http.StatusForbidden, "无权限导入此项目"))
+		return
+	}
+	if err := applyHistoryOptions(c, &proj); err != nil {
+		respondError(c, err)
+		return
+	}
+	if err := applyCloneOptions(c, &proj); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	baseDir, err := importProject(proj)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, "导入完成，embedding 正在后台构建", gin.H{"path": baseDir})
+}
+
+// AskProjectV1
+// @Summary LLM代码问答
+// @Tags 项目模块 v1
+// @Security Bearer
+// @Param id path int true "项目ID"
+// @Param body body askRequest true "问题；mode 为 rag（默认）或 agent，agent 模式可用 max_steps 限制步数"
+// @Success 200 {object} map[string]interface{}
+// @Router /api/v1/projects/{id}/ask [post]
+func AskProjectV1(c *gin.Context) {
+	proj, ok := loadProjectV1(c)
+	if !ok {
+		return
+	}
+
+	var req askRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, newAPIError(http.StatusBadRequest, "问题不能为空"))
+		return
+	}
+
+	userID, _ := c.Get("userID")
+	switch req.Mode {
+	case "", askModeRAG:
+	case askModeAgent:
+		result, err := askProjectAgent(proj, userID, req.Question, req.MaxSteps)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		respondOK(c, http.StatusOK, "回答成功", result)
+		return
+	default:
+		respondError(c, newAPIError(http.StatusBadRequest, "mode 只能是 rag 或 agent"))
+		return
+	}
+
+	answer, err := askProject(proj, userID, req.Question)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, "回答成功", gin.H{"answer": answer})
+}
+
+// ListProjectFilesV1
+// @Summary 获取项目文件树
+// @Tags 项目模块 v1
+// @Security Bearer
+// @Param id path int true "项目ID"
+// @Success 200 {object} map[string]interface{}
+// @Router /api/v1/projects/{id}/files [get]
+func ListProjectFilesV1(c *gin.Context) {
+	proj, ok := loadProjectV1(c)
+	if !ok {
+		return
+	}
+
+	fileTree, synced := projectFileTree(proj)
+	if !synced {
+		respondError(c, newAPIError(http.StatusConflict, "仓库未同步"))
+		return
+	}
+	respondOK(c, http.StatusOK, "获取成功", fileTree)
+}
+
+// GetFileContentV1
+// @Summary 获取文件内容
+// @Tags 项目模块 v1
+// @Security Bearer
+// @Param id path int true "项目ID"
+// @Param path query string true "文件路径"
+// @Param rev query string false "版本（分支、标签或提交），默认读取当前工作区"
+// @Param start_line query int false "起始行，从 1 开始"
+// @Param end_line query int false "结束行（含），默认读到文件末尾"
+// @Param charset query string false "文件编码，默认自动检测（如 gbk、shift_jis）"
+// @Param format query string false "返回格式：text（默认）、html（语法高亮）、tokens（按行的 token）、rendered（渲染 Markdown 与 Notebook）"
+// @Param style query string false "高亮样式，默认 github"
+// @Success 200 {object} map[string]interface{}
+// @Success 304 "If-None-Match 与 ETag 一致"
+// @Router /api/v1/projects/{id}/files/content [get]
+func GetFileContentV1(c *gin.Context) {
+	proj, ok := loadProjectV1(c)
+	if !ok {
+		return
+	}
+
+	data, ok, err := fileContentData(c, proj)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	if ok {
+		respondOK(c, http.StatusOK, "获取成功", data)
+	}
+}
+
+// SubscribeProjectEventsV1
+// @Summary 订阅项目事件（SSE）
+// @Tags 项目模块 v1
+// @Security Bearer
+// @Param id path int true "项目ID"
+// @Param token query string false "认证Token（EventSource不支持header，通过URL参数传递）"
+// @Success 200
+// @Router /api/v1/projects/{id}/events [get]
+func SubscribeProjectEventsV1(c *gin.Context) {
+	userID, exists := resolveRequestUserID(c)
+	if !exists {
+		respondError(c, newAPIError(http.StatusUnauthorized, "用户未登录"))
+		return
+	}
+	c.Set("userID", userID)
+
+	proj, ok := loadProjectV1(c)
+	if !ok {
+		return
+	}
+	streamProjectEvents(c, proj.ID)
+}
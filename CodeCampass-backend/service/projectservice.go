@@ -5,11 +5,14 @@ import (
 	"CodeCampass/utils"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math"
 	"net/http"
-	"os"
 	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sashabaranov/go-openai"
@@ -22,6 +25,8 @@ import (
 // @param name query string false "项目名"
 // @param description query string false "项目介绍"
 // @param repo_url query string false "仓库网址"
+// @param org_id query int false "所属组织ID（不填则为个人项目）"
+// @param tags query string false "标签，逗号分隔"
 // @Success 200 {object} map[string]interface{}
 // @Router /api/createProject [post]
 func CreateProject(c *gin.Context) {
@@ -39,30 +44,23 @@ func CreateProject(c *gin.Context) {
 	description := c.Query("description")
 	repo_url := c.Query("repo_url")
 
-	if name == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"message": "项目名不能为空",
-		})
-		return
-	}
-
-	same_project := models.Project{}
-	utils.DB.Where("owner_id = ? and name = ?", userID, name).First(&same_project)
-	if same_project.Name != "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"message": "项目名不可重复",
-		})
+	var orgID uint
+	if orgIDStr := c.Query("org_id"); orgIDStr != "" {
+		if _, err := fmt.Sscanf(orgIDStr, "%d", &orgID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "无效的 org_id"})
+			return
+		}
 	}
 
-	proj := models.Project{
+	proj, err := createProject(userID.(uint), models.Project{
 		Name:        name,
 		Description: description,
-		OwnerId:     userID.(uint),
+		OrgId:       orgID,
 		RepoUrl:     repo_url,
-	}
-
-	if err := models.CreateProject(proj).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建失败"})
+		Tags:        models.NormalizeTags(strings.Split(c.Query("tags"), ",")),
+	})
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
@@ -72,10 +70,50 @@ func CreateProject(c *gin.Context) {
 	})
 }
 
+// createProject 校验项目名与组织权限后创建项目，proj.OrgId 不为 0 时创建为组织项目
+func createProject(userID uint, proj models.Project) (models.Project, error) {
+	if proj.Name == "" {
+		return proj, newAPIError(http.StatusBadRequest, "项目名不能为空")
+	}
+
+	if proj.OrgId != 0 {
+		if _, err := models.FindOrgMember(proj.OrgId, userID); err != nil {
+			return proj, newAPIError(http.StatusForbidden, "不是该组织成员")
+		}
+	}
+
+	same_project := models.Project{}
+	if proj.OrgId != 0 {
+		utils.DB.Where("org_id = ? and name = ?", proj.OrgId, proj.Name).First(&same_project)
+	} else {
+		utils.DB.Where("owner_id = ? and org_id = 0 and name = ?", userID, proj.Name).First(&same_project)
+	}
+	if same_project.Name != "" {
+		return proj, newAPIError(http.StatusConflict, "项目名不可重复")
+	}
+
+	if err := checkProjectQuota(userID); err != nil {
+		return proj, err
+	}
+
+	proj.OwnerId = userID
+	if err := models.CreateProject(&proj).Error; err != nil {
+		return proj, fmt.Errorf("创建失败")
+	}
+	return proj, nil
+}
+
 // ListProjects
-// @Summary 列出所有项目
+// @Summary 列出所有项目（支持搜索、筛选、排序与游标分页）
 // @Tags 项目模块
 // @Security Bearer
+// @Param q query string false "在项目名和描述中全文搜索"
+// @Param status query string false "索引状态 cloning/indexing/ready/failed"
+// @Param language query string false "主要语言"
+// @Param tag query string false "标签"
+// @Param sort query string false "排序 updated/asked/name，默认 updated"
+// @Param cursor query string false "分页游标，取自上一页的 next_cursor"
+// @Param limit query int false "每页数量，不填则返回全部"
 // @Success 200 {object} map[string]interface{}
 // @Router /api/listProjects [get]
 func ListProjects(c *gin.Context) {
@@ -85,17 +123,69 @@ func ListProjects(c *gin.Context) {
 		return
 	}
 
-	var projects []models.Project
-	if err := utils.DB.Where("owner_id = ?", userID).Find(&projects).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取失败"})
+	// 旧接口默认不分页，保持原有行为
+	query, err := projectQueryFromRequest(c, userID.(uint), 0)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	projects, nextCursor, err := queryProjects(query)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"projects": projects,
+		"projects":    projects,
+		"next_cursor": nextCursor,
 	})
 }
 
+// projectQueryFromRequest 从查询参数解析项目列表的筛选、排序与分页条件
+func projectQueryFromRequest(c *gin.Context, userID uint, defaultLimit int) (models.ProjectQuery, error) {
+	query := models.ProjectQuery{
+		UserId:   userID,
+		Keyword:  strings.TrimSpace(c.Query("q")),
+		Status:   c.Query("status"),
+		Language: c.Query("language"),
+		Tag:      c.Query("tag"),
+		Sort:     c.Query("sort"),
+		Cursor:   c.Query("cursor"),
+		Limit:    defaultLimit,
+	}
+
+	switch query.Sort {
+	case "", models.ProjectSortUpdated, models.ProjectSortAsked, models.ProjectSortName:
+	default:
+		return query, newAPIError(http.StatusBadRequest, "无效的排序方式")
+	}
+
+	if limitStr := c.Query("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			return query, newAPIError(http.StatusBadRequest, "无效的 limit")
+		}
+		query.Limit = limit
+	}
+	if query.Limit > 100 {
+		query.Limit = 100
+	}
+	return query, nil
+}
+
+// queryProjects 查询项目列表，游标无效时返回 400
+func queryProjects(query models.ProjectQuery) ([]models.Project, string, error) {
+	projects, nextCursor, err := models.QueryUserProjects(query)
+	if errors.Is(err, models.ErrInvalidCursor) {
+		return nil, "", newAPIError(http.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("获取失败")
+	}
+	return projects, nextCursor, nil
+}
+
 // UpdateProject
 // @Summary 修改项目
 // @Tags 项目模块
@@ -104,6 +194,7 @@ func ListProjects(c *gin.Context) {
 // @Param name query string false "新项目名"
 // @Param description query string false "新项目描述"
 // @Param repo_url query string false "新仓库网址"
+// @Param tags query string false "新标签，逗号分隔"
 // @Success 200 {object} map[string]interface{}
 // @Router /api/updateProject [put]
 func UpdateProject(c *gin.Context) {
@@ -115,13 +206,13 @@ func UpdateProject(c *gin.Context) {
 
 	pre_name := c.Query("pre_name")
 
-	var project models.Project
-	if err := utils.DB.Where("owner_id = ? and name = ?", userID, pre_name).First(&project).Error; err != nil {
+	project, err := findUserProject(userID, pre_name)
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "项目不存在"})
 		return
 	}
 
-	if project.OwnerId != userID.(uint) {
+	if !canManageProject(userID.(uint), project) {
 		c.JSON(http.StatusForbidden, gin.H{"error": "无权限修改此项目"})
 		return
 	}
@@ -140,6 +231,9 @@ func UpdateProject(c *gin.Context) {
 	if repoURL != "" {
 		project.RepoUrl = repoURL
 	}
+	if tags, ok := c.GetQuery("tags"); ok {
+		project.Tags = models.NormalizeTags(strings.Split(tags, ","))
+	}
 
 	if err := utils.DB.Save(&project).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -170,24 +264,24 @@ func DeleteProject(c *gin.Context) {
 
 	name := c.Query("name")
 
-	var project models.Project
-	if err := utils.DB.Where("owner_id = ? and name = ?", userID, name).First(&project).Error; err != nil {
+	project, err := findUserProject(userID, name)
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{
 			"error": "项目不存在",
 		})
 		return
 	}
 
-	if project.OwnerId != userID.(uint) {
+	if !canManageProject(userID.(uint), project) {
 		c.JSON(http.StatusForbidden, gin.H{
 			"error": "无权限删除此项目",
 		})
 		return
 	}
 
-	if err := utils.DB.Delete(&project).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "删除失败",
+	if err := deleteProject(project); err != nil {
+		c.JSON(errorStatus(err), gin.H{
+			"error": "删除失败: " + err.Error(),
 		})
 		return
 	}
@@ -212,20 +306,13 @@ func GetProjectInfo(c *gin.Context) {
 	}
 
 	name := c.Query("name")
-	var project models.Project
-	if err := utils.DB.Where("owner_id = ? and name = ?", userID, name).First(&project).Error; err != nil {
+	project, err := findUserProject(userID, name)
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{
 			"error": "项目不存在",
 		})
 		return
 	}
-
-	if project.OwnerId != userID.(uint) {
-		c.JSON(http.StatusForbidden, gin.H{
-			"error": "无权限查看此项目",
-		})
-		return
-	}
 	c.JSON(http.StatusOK, gin.H{
 		"code":    0,
 		"message": "查看成功",
@@ -239,6 +326,8 @@ func GetProjectInfo(c *gin.Context) {
 // @Security Bearer
 // @Param name query string  true "项目名"
 // @Param question query string true "用户问题"
+// @Param mode query string false "agent 表示由模型调用仓库工具逐步查找后回答"
+// @Param max_steps query int false "agent 模式的最大步数"
 // @Success 200 {object} map[string]string "answer"
 // @Router /api/askProject [post]
 func AskProject(c *gin.Context) {
@@ -253,99 +342,161 @@ func AskProject(c *gin.Context) {
 	}
 
 	// 查找项目
-	var proj models.Project
-	if err := utils.DB.Where("owner_id = ? and name = ?", userID, name).First(&proj).Error; err != nil {
+	proj, err := findUserProject(userID, name)
+	if err != nil {
 		c.JSON(404, gin.H{"error": "项目不存在"})
 		return
 	}
 
-	// 从 Redis 获取用户的 API Key
-	apiKey := utils.Red.Get(utils.Red.Context(), fmt.Sprintf("openai_key:%d", userID)).Val()
-	
-	// 如果 Redis 中没有，从环境变量获取（向后兼容）
-	if apiKey == "" {
-		apiKey = os.Getenv("OPENAI_API_KEY")
+	if c.Query("mode") == askModeAgent {
+		maxSteps, _ := strconv.Atoi(c.Query("max_steps"))
+		result, err := askProjectAgent(proj, userID, question, maxSteps)
+		if err != nil {
+			c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{"answer": result.Answer, "steps": result.Steps, "stop_reason": result.StopReason})
+		return
 	}
 
-	if apiKey == "" {
-		c.JSON(500, gin.H{"error": "请先设置 OpenAI API Key"})
+	answer, err := askProject(proj, userID, question)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
-	// 配置 ChatAnywhere
-	cfg := openai.DefaultConfig(apiKey)
-	//cfg.BaseURL = "https://api.chatanywhere.tech" // 国内首选
-	cfg.BaseURL = "https://api.chatanywhere.org" // 国外使用
-	client := openai.NewClientWithConfig(cfg)
+	c.JSON(200, gin.H{"answer": answer})
+}
 
-	// 生成问题 embedding
-	embResp, err := client.CreateEmbeddings(context.Background(), openai.EmbeddingRequest{
-		Model: openai.SmallEmbedding3,
-		Input: []string{question},
-	})
+// askProject 基于项目 embedding 检索相关代码片段并调用 LLM 回答问题
+func askProject(proj models.Project, userID interface{}, question string) (string, error) {
+	setup, err := newAskSetup(proj, toUserID(userID))
 	if err != nil {
-		c.JSON(500, gin.H{"error": "embedding生成失败"})
-		return
+		return "", err
 	}
-	questionVec := embResp.Data[0].Embedding
 
-	// 查询项目所有文件 embedding
-	var all []models.ProjectEmbedding
-	utils.DB.Where("project_id = ?", proj.ID).Find(&all)
+	topChunks, err := semanticSearch(context.Background(), setup.client, proj, setup.cfg.EmbeddingModel, question, setup.topK)
+	if err != nil {
+		return "", err
+	}
 
-	type ScoredChunk struct {
-		Path    string
-		Content string
-		Score   float64
+	// 拼接上下文；询问整体架构等宽泛问题时先附上仓库概览与目录摘要
+	var contextText string
+	if isBroadQuestion(question) {
+		contextText += projectOverviewContext(proj)
+	}
+	for _, chunk := range topChunks {
+		contextText += fmt.Sprintf("\n[文件: %s]\n%s\n", chunk.Path, chunk.Content)
 	}
 
-	var topChunks []ScoredChunk
-	for _, e := range all {
-		// JSON 反序列化 embedding
-		var emb []float32
-		if err := json.Unmarshal([]byte(e.Embedding), &emb); err != nil {
-			fmt.Println("embedding解析失败:", e.FilePath, err)
-			continue
-		}
-		score := cosineSimilarity(emb, questionVec)
-		topChunks = append(topChunks, ScoredChunk{
-			Path:    e.FilePath,
-			Content: e.Content,
-			Score:   score,
-		})
+	// 调用 LLM
+	chatResp, err := setup.client.CreateChatCompletion(context.Background(), setup.chatRequest(contextText, question))
+	if err != nil {
+		return "", fmt.Errorf("LLM调用失败: %v", err)
+	}
+	if len(chatResp.Choices) == 0 {
+		return "", fmt.Errorf("LLM 未返回内容")
 	}
 
-	// 取前三个最相关片段
-	sort.Slice(topChunks, func(i, j int) bool { return topChunks[i].Score > topChunks[j].Score })
-	if len(topChunks) > 3 {
-		topChunks = topChunks[:3]
+	markProjectAsked(proj)
+	return chatResp.Choices[0].Message.Content, nil
+}
+
+// askSetup 一次问答使用的模型、检索片段数与回答长度
+type askSetup struct {
+	cfg       llmConfig
+	client    *meteredClient
+	topK      int
+	maxTokens int
+}
+
+// newAskSetup 选择凭证并检查预算：超出当月预算时拒绝，或降级为更便宜的模型与更少的上下文
+func newAskSetup(proj models.Project, uid uint) (askSetup, error) {
+	// 按 项目凭证 > 组织 Key > 用户默认凭证 > 旧版 Key 的顺序选择
+	cfg, err := resolveLLMConfig(proj, uid)
+	if err != nil {
+		return askSetup{}, err
 	}
 
-	// 拼接上下文
-	var contextText string
-	for _, chunk := range topChunks {
-		contextText += fmt.Sprintf("\n[文件: %s]\n%s\n", chunk.Path, chunk.Content)
+	setup := askSetup{topK: 3}
+	if budget := projectBudgetStatus(proj, uid); budget.Exceeded(0) {
+		if !budget.Degraded() {
+			return askSetup{}, budget.budgetError()
+		}
+		cfg = degradeConfig(cfg)
+		setup.topK, setup.maxTokens = 1, 512
 	}
+	setup.cfg = cfg
+	setup.client = cfg.newMeteredClient(projectUsageScope(proj, uid))
+	return setup, nil
+}
 
+// chatRequest 组装问答请求：仓库上下文在前，问题在后
+func (s askSetup) chatRequest(contextText, question string) openai.ChatCompletionRequest {
 	prompt := fmt.Sprintf(`你是一名代码分析专家，请基于以下仓库内容回答问题：
 %s
 
 问题：%s`, contextText, question)
 
-	// 调用 LLM
-	chatResp, err := client.CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{
-		Model: openai.GPT4oMini,
+	return openai.ChatCompletionRequest{
+		Model:     s.cfg.ChatModel,
+		MaxTokens: s.maxTokens,
 		Messages: []openai.ChatCompletionMessage{
 			{Role: "system", Content: "你是代码与软件架构专家。"},
 			{Role: "user", Content: prompt},
 		},
+	}
+}
+
+// markProjectAsked 记录最近提问时间，不影响 updated_at
+func markProjectAsked(proj models.Project) {
+	utils.DB.Model(&proj).UpdateColumn("last_asked_at", time.Now())
+}
+
+// scoredChunk 语义检索命中的代码片段
+type scoredChunk struct {
+	Path    string
+	Content string
+	Score   float64
+}
+
+// semanticSearch 用项目 embedding 检索与问题最相关的 k 个片段
+func semanticSearch(ctx context.Context, client *meteredClient, proj models.Project, embeddingModel, query string, k int) ([]scoredChunk, error) {
+	// 生成问题 embedding
+	embResp, err := client.CreateEmbeddings(ctx, openai.EmbeddingRequest{
+		Model: openai.EmbeddingModel(embeddingModel),
+		Input: []string{query},
 	})
 	if err != nil {
-		c.JSON(500, gin.H{"error": fmt.Sprintf("LLM调用失败: %v", err)})
-		return
+		return nil, fmt.Errorf("embedding生成失败")
+	}
+	questionVec := embResp.Data[0].Embedding
+
+	// 查询项目所有文件 embedding
+	var all []models.ProjectEmbedding
+	utils.DB.Where("project_id = ?", proj.ID).Find(&all)
+
+	var topChunks []scoredChunk
+	for _, e := range all {
+		// JSON 反序列化 embedding
+		var emb []float32
+		if err := json.Unmarshal([]byte(e.Embedding), &emb); err != nil {
+			fmt.Println("embedding解析失败:", e.FilePath, err)
+			continue
+		}
+		topChunks = append(topChunks, scoredChunk{
+			Path:    e.FilePath,
+			Content: e.Content,
+			Score:   cosineSimilarity(emb, questionVec),
+		})
 	}
 
-	c.JSON(200, gin.H{"answer": chatResp.Choices[0].Message.Content})
+	// 取最相关的 k 个片段
+	sort.Slice(topChunks, func(i, j int) bool { return topChunks[i].Score > topChunks[j].Score })
+	if len(topChunks) > k {
+		topChunks = topChunks[:k]
+	}
+	return topChunks, nil
 }
 
 func cosineSimilarity(a, b []float32) float64 {
@@ -363,3 +514,37 @@ func cosineSimilarity(a, b []float32) float64 {
 	}
 	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
 }
+
+// findUserProject 按项目名查找当前用户可访问的项目，个人项目优先，其次是所在组织的项目
+func findUserProject(userID interface{}, name string) (models.Project, error) {
+	var proj models.Project
+	err := utils.DB.Where("name = ? and ((owner_id = ? and org_id = 0) or org_id in (?))", name, userID, models.UserOrgIds(userID)).
+		Order("org_id asc").First(&proj).Error
+	return proj, err
+}
+
+// findUserProjectByID 按项目ID查找当前用户可访问的项目
+func findUserProjectByID(userID interface{}, projectID uint) (models.Project, error) {
+	var proj models.Project
+	err := utils.DB.Where("id = ? and ((owner_id = ? and org_id = 0) or org_id in (?))", projectID, userID, models.UserOrgIds(userID)).
+		First(&proj).Error
+	return proj, err
+}
+
+// canManageProject 个人项目只有所有者可以管理，组织项目由创建者或组织管理员管理
+func canManageProject(userID uint, proj models.Project) bool {
+	if proj.OrgId == 0 {
+		return proj.OwnerId == userID
+	}
+	member, err := models.FindOrgMember(proj.OrgId, userID)
+	if err != nil {
+		return false
+	}
+	return member.IsManager() || proj.OwnerId == userID
+}
+
+// toUserID 将中间件中取出的用户ID转换为 uint
+func toUserID(userID interface{}) uint {
+	id, _ := userID.(uint)
+	return id
+}
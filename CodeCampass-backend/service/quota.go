@@ -0,0 +1,205 @@
+package service
+
+import (
+	"CodeCampass/models"
+	"CodeCampass/storage"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+)
+
+// quotaLimits 用户生效的配额，0 表示不限制
+type quotaLimits struct {
+	MaxProjects   int   `json:"max_projects"`
+	MaxTotalBytes int64 `json:"max_total_bytes"`
+	MaxRepoBytes  int64 `json:"max_repo_bytes"`
+	MaxFiles      int   `json:"max_files"`
+	MaxChunks     int   `json:"max_chunks"`
+}
+
+// overrideQuota 用户单独设置的值：0 沿用默认值，小于 0 表示不限制
+func overrideQuota[T int | int64](def, v T) T {
+	switch {
+	case v < 0:
+		return 0
+	case v > 0:
+		return v
+	}
+	return def
+}
+
+// userQuota 用户的配额，默认值取自配置 quota，user_quota 表中的设置优先
+func userQuota(userID uint) quotaLimits {
+	q := quotaLimits{
+		MaxProjects:   viper.GetInt("quota.maxProjects"),
+		MaxTotalBytes: viper.GetInt64("quota.maxTotalBytes"),
+		MaxRepoBytes:  viper.GetInt64("quota.maxRepoBytes"),
+		MaxFiles:      viper.GetInt("quota.maxFiles"),
+		MaxChunks:     viper.GetInt("quota.maxChunks"),
+	}
+	if u, err := models.FindUserQuota(userID); err == nil {
+		q.MaxProjects = overrideQuota(q.MaxProjects, u.MaxProjects)
+		q.MaxTotalBytes = overrideQuota(q.MaxTotalBytes, u.MaxTotalBytes)
+		q.MaxRepoBytes = overrideQuota(q.MaxRepoBytes, u.MaxRepoBytes)
+		q.MaxFiles = overrideQuota(q.MaxFiles, u.MaxFiles)
+		q.MaxChunks = overrideQuota(q.MaxChunks, u.MaxChunks)
+	}
+	return q
+}
+
+// repoSizeLimit 本次导入允许的仓库大小：单仓库上限与总空间剩余量中较小的一个，0 表示不限制
+func (q quotaLimits) repoSizeLimit(proj models.Project) int64 {
+	limit := q.MaxRepoBytes
+	if q.MaxTotalBytes > 0 {
+		remaining := q.MaxTotalBytes - models.SumOwnedDiskUsage(proj.OwnerId, proj.ID)
+		if remaining <= 0 {
+			remaining = 1 // 已无剩余空间，任何克隆都会被拒绝
+		}
+		if limit == 0 || remaining < limit {
+			limit = remaining
+		}
+	}
+	return limit
+}
+
+// formatBytes 以可读的单位显示字节数
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// repoTooLargeError 仓库超出大小配额
+func repoTooLargeError(size, limit int64) error {
+	return newAPIError(http.StatusRequestEntityTooLarge,
+		fmt.Sprintf("仓库大小 %s 超出配额 %s", formatBytes(size), formatBytes(limit)))
+}
+
+// checkProjectQuota 创建项目前检查项目数配额
+func checkProjectQuota(userID uint) error {
+	q := userQuota(userID)
+	if q.MaxProjects > 0 && models.CountOwnedProjects(userID) >= int64(q.MaxProjects) {
+		return newAPIError(http.StatusForbidden, fmt.Sprintf("项目数已达配额上限 %d", q.MaxProjects))
+	}
+	return nil
+}
+
+var githubRepoPattern = regexp.MustCompile(`^(?:https?://(?:[^@/]+@)?github\.com/|git@github\.com:|ssh://git@github\.com/)([\w.-]+)/([\w.-]+?)(?:\.git)?/?$`)
+
+// githubAPIBase GitHub API 地址
+var githubAPIBase = "https://api.github.com"
+
+// probeRepoSize 克隆前估算仓库大小，目前仅支持 GitHub 公开仓库；无法估算时返回 0
+func probeRepoSize(repoURL string) int64 {
+	m := githubRepoPattern.FindStringSubmatch(repoURL)
+	if m == nil {
+		return 0
+	}
+	client := http.Client{Timeout: 5 * time.Second}
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/repos/%s/%s", githubAPIBase, m[1], m[2]), nil)
+	if err != nil {
+		return 0
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0
+	}
+	var info struct {
+		Size int64 `json:"size"` // 单位 KB
+	}
+	if json.NewDecoder(resp.Body).Decode(&info) != nil {
+		return 0
+	}
+	return info.Size * 1024
+}
+
+// cloneWatchdogInterval 克隆过程中检查目录大小的间隔
+const cloneWatchdogInterval = 2 * time.Second
+
+// runWithSizeWatchdog 运行克隆命令，定期统计目标目录大小，超出 limit 时终止克隆
+func runWithSizeWatchdog(cmd *exec.Cmd, dir string, limit int64) error {
+	if limit <= 0 {
+		return cmd.Run()
+	}
+	startProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	ticker := time.NewTicker(cloneWatchdogInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-done:
+			return err
+		case <-ticker.C:
+			if used, _ := storage.DirUsage(dir); used > limit {
+				killProcessGroup(cmd)
+				<-done
+				return repoTooLargeError(used, limit)
+			}
+		}
+	}
+}
+
+// projectQuotaUsage 项目的资源占用
+type projectQuotaUsage struct {
+	ID        uint   `json:"id"`
+	Name      string `json:"name"`
+	DiskUsage int64  `json:"disk_usage"`
+	Files     int64  `json:"files"`
+	Chunks    int64  `json:"chunks"`
+}
+
+// GetQuotaV1
+// @Summary 查看配额与当前占用
+// @Tags 用量模块 v1
+// @Security Bearer
+// @Success 200 {object} map[string]interface{}
+// @Router /api/v1/usage/quota [get]
+func GetQuotaV1(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	uid := userID.(uint)
+
+	projects := models.GetUserProjectList(uid)
+	items := make([]projectQuotaUsage, 0, len(projects))
+	var totalBytes int64
+	for _, p := range projects {
+		totalBytes += p.DiskUsage
+		items = append(items, projectQuotaUsage{
+			ID:        p.ID,
+			Name:      p.Name,
+			DiskUsage: p.DiskUsage,
+			Files:     models.CountProjectFiles(p.ID),
+			Chunks:    models.CountProjectEmbeddings(p.ID),
+		})
+	}
+	respondOK(c, http.StatusOK, "查询成功", gin.H{
+		"limits": userQuota(uid),
+		"used": gin.H{
+			"projects":    len(projects),
+			"total_bytes": totalBytes,
+		},
+		"projects": items,
+	})
+}
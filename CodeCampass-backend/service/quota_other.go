@@ -0,0 +1,13 @@
+//go:build !unix
+
+package service
+
+import "os/exec"
+
+func startProcessGroup(cmd *exec.Cmd) {}
+
+func killProcessGroup(cmd *exec.Cmd) {
+	if cmd.Process != nil {
+		cmd.Process.Kill()
+	}
+}
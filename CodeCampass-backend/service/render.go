@@ -0,0 +1,481 @@
+package service
+
+import (
+	"CodeCampass/models"
+	"CodeCampass/utils"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/chroma/v2"
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+	"github.com/spf13/viper"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// 文件内容的返回格式
+const (
+	formatText     = "text"     // 原始文本
+	formatHTML     = "html"     // 语法高亮后的 HTML
+	formatTokens   = "tokens"   // 按行切分的 token 列表
+	formatRendered = "rendered" // Markdown、Jupyter Notebook 渲染后的 HTML
+)
+
+const renderCacheVersion = "v2" // 渲染逻辑变化时修改，使旧缓存失效
+
+// renderTTL 渲染结果在 Redis 中的缓存时间
+func renderTTL() time.Duration {
+	hours := 24
+	if viper.IsSet("render.cacheHours") {
+		hours = viper.GetInt("render.cacheHours")
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// maxHighlightBytes 超过该大小的内容不做高亮，直接返回文本
+func maxHighlightBytes() int {
+	if viper.IsSet("render.maxHighlightBytes") {
+		return viper.GetInt("render.maxHighlightBytes")
+	}
+	return 1024 * 1024
+}
+
+// highlightToken 一个高亮 token，Type 为 chroma 的 token 类型名，如 KeywordType
+type highlightToken struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// renderResult 渲染结果，按内容哈希缓存
+type renderResult struct {
+	Format   string             `json:"format"`
+	Language string             `json:"language,omitempty"`
+	HTML     string             `json:"html,omitempty"`
+	Tokens   [][]highlightToken `json:"tokens,omitempty"`
+	CSS      string             `json:"css,omitempty"` // 高亮样式，HTML 使用 class 而非内联样式
+	Cached   bool               `json:"cached"`
+}
+
+// renderRequest 渲染参数，Path、Rev 用于解析 Markdown 中的相对链接
+type renderRequest struct {
+	Project   models.Project
+	Path      string
+	Rev       string
+	Format    string
+	Style     string
+	StartLine int // 按行范围读取时高亮的起始行号
+	// rendered 格式中图片签名地址的过期时间，按小时对齐，计入缓存键
+	URLExpires int64
+}
+
+// renderable 判断文件能否以 rendered 格式返回
+func renderable(filePath string) bool {
+	switch strings.ToLower(path.Ext(filePath)) {
+	case ".md", ".markdown", ".mdown", ".ipynb":
+		return true
+	}
+	return false
+}
+
+// renderContent 按格式渲染文本内容，结果按内容哈希缓存在 Redis
+func renderContent(ctx context.Context, req renderRequest, content string) (renderResult, error) {
+	if req.Style == "" {
+		req.Style = viper.GetString("render.style")
+	}
+	if req.Style == "" {
+		req.Style = "github"
+	}
+	style := styles.Get(req.Style)
+	if style == styles.Fallback && req.Style != styles.Fallback.Name {
+		return renderResult{}, newAPIError(http.StatusBadRequest, "不支持的高亮样式: "+req.Style)
+	}
+	if req.Format == formatRendered && !renderable(req.Path) {
+		return renderResult{}, newAPIError(http.StatusBadRequest, "只有 Markdown 与 Jupyter Notebook 文件支持 rendered 格式")
+	}
+
+	ttl := renderTTL()
+	if req.Format == formatRendered {
+		req.URLExpires = rawURLExpiry(time.Now())
+		// 缓存不超过签名地址的有效期
+		if until := time.Until(time.Unix(req.URLExpires, 0)); until < ttl {
+			ttl = until
+		}
+	}
+	key := renderCacheKey(req, style.Name, content)
+	if cached, ok := loadRenderCache(ctx, key); ok {
+		return cached, nil
+	}
+
+	res := renderResult{Format: req.Format}
+	var err error
+	switch req.Format {
+	case formatHTML, formatTokens:
+		lexer := fileLexer(req.Path, content)
+		res.Language = strings.ToLower(lexer.Config().Name)
+		if req.Format == formatHTML {
+			res.HTML, err = highlightHTML(lexer, style, content, max(req.StartLine, 1))
+		} else {
+			res.Tokens, err = highlightTokens(lexer, content)
+		}
+		res.CSS = highlightCSS(style)
+	case formatRendered:
+		if strings.EqualFold(path.Ext(req.Path), ".ipynb") {
+			res.Language = "jupyter"
+			res.HTML, err = renderNotebook(req, style, []byte(content))
+		} else {
+			res.Language = "markdown"
+			res.HTML, err = renderMarkdown(req, style, []byte(content))
+		}
+		res.CSS = highlightCSS(style)
+	default:
+		return res, newAPIError(http.StatusBadRequest, "format 只能是 text、html、tokens 或 rendered")
+	}
+	if err != nil {
+		return res, err
+	}
+	storeRenderCache(ctx, key, res, ttl)
+	return res, nil
+}
+
+// renderCacheKey 渲染结果的缓存键。rendered 格式中的链接与项目、路径、版本有关，一并计入
+func renderCacheKey(req renderRequest, style, content string) string {
+	h := sha256.New()
+	h.Write([]byte(content))
+	fmt.Fprintf(h, "\x00%s\x00%s\x00%d\x00%s", req.Format, style, req.StartLine, path.Ext(req.Path))
+	if req.Format == formatRendered {
+		fmt.Fprintf(h, "\x00%d\x00%s\x00%s\x00%d", req.Project.ID, req.Path, req.Rev, req.URLExpires)
+	} else {
+		// 词法分析器按文件名选择
+		fmt.Fprintf(h, "\x00%s", path.Base(req.Path))
+	}
+	return "render:" + renderCacheVersion + ":" + hex.EncodeToString(h.Sum(nil))
+}
+
+// loadRenderCache 读取缓存，Redis 不可用时视为未命中
+func loadRenderCache(ctx context.Context, key string) (renderResult, bool) {
+	var res renderResult
+	if utils.Red == nil {
+		return res, false
+	}
+	data, err := utils.Red.Get(ctx, key).Bytes()
+	if err != nil || json.Unmarshal(data, &res) != nil {
+		return res, false
+	}
+	res.Cached = true
+	return res, true
+}
+
+func storeRenderCache(ctx context.Context, key string, res renderResult, ttl time.Duration) {
+	if utils.Red == nil {
+		return
+	}
+	data, err := json.Marshal(res)
+	if err != nil {
+		return
+	}
+	utils.Red.Set(ctx, key, data, ttl)
+}
+
+// fileLexer 按文件名选择词法分析器，匹配不到时按内容猜测
+func fileLexer(filePath, content string) chroma.Lexer {
+	lexer := lexers.Match(path.Base(filePath))
+	if lexer == nil {
+		lexer = lexers.Analyse(content)
+	}
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+	return chroma.Coalesce(lexer)
+}
+
+// highlightFormatter 使用 class 输出，样式由 highlightCSS 提供；startLine 大于 0 时从该行开始编号
+func highlightFormatter(startLine int) *chromahtml.Formatter {
+	opts := []chromahtml.Option{chromahtml.WithClasses(true), chromahtml.TabWidth(4)}
+	if startLine > 0 {
+		opts = append(opts, chromahtml.WithLineNumbers(true), chromahtml.BaseLineNumber(startLine))
+	}
+	return chromahtml.New(opts...)
+}
+
+// highlightHTML 高亮代码，startLine 为 0 时不带行号
+func highlightHTML(lexer chroma.Lexer, style *chroma.Style, content string, startLine int) (string, error) {
+	it, err := lexer.Tokenise(nil, content)
+	if err != nil {
+		return "", fmt.Errorf("语法高亮失败: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := highlightFormatter(startLine).Format(&buf, style, it); err != nil {
+		return "", fmt.Errorf("语法高亮失败: %v", err)
+	}
+	return buf.String(), nil
+}
+
+// highlightTokens 将代码切分为 token，按行分组
+func highlightTokens(lexer chroma.Lexer, content string) ([][]highlightToken, error) {
+	it, err := lexer.Tokenise(nil, content)
+	if err != nil {
+		return nil, fmt.Errorf("语法高亮失败: %v", err)
+	}
+	lines := chroma.SplitTokensIntoLines(it.Tokens())
+	out := make([][]highlightToken, 0, len(lines))
+	for _, line := range lines {
+		tokens := make([]highlightToken, 0, len(line))
+		for _, t := range line {
+			tokens = append(tokens, highlightToken{Type: t.Type.String(), Value: t.Value})
+		}
+		out = append(out, tokens)
+	}
+	return out, nil
+}
+
+func highlightCSS(style *chroma.Style) string {
+	var buf bytes.Buffer
+	highlightFormatter(0).WriteCSS(&buf, style)
+	return buf.String()
+}
+
+// repoLinkResolver 将 Markdown 中的相对链接解析为仓库内路径：
+// 图片指向原始文件接口，其他链接带上 data-repo-path 供前端跳转
+type repoLinkResolver struct {
+	req renderRequest
+}
+
+func (r repoLinkResolver) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch node := n.(type) {
+		case *ast.Image:
+			if p, ok := r.resolve(string(node.Destination)); ok {
+				node.Destination = []byte(r.rawURL(p))
+			}
+		case *ast.Link:
+			if p, ok := r.resolve(string(node.Destination)); ok {
+				node.SetAttributeString("data-repo-path", []byte(p))
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+}
+
+// resolve 解析相对链接，以 / 开头的链接相对仓库根目录；外部链接、页内锚点与越出仓库的路径返回 false
+func (r repoLinkResolver) resolve(dest string) (string, bool) {
+	if dest == "" || strings.HasPrefix(dest, "#") || strings.HasPrefix(dest, "//") {
+		return "", false
+	}
+	u, err := url.Parse(dest)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" {
+		return "", false
+	}
+	p := u.Path
+	if !strings.HasPrefix(p, "/") {
+		p = path.Join(path.Dir(r.req.Path), p)
+	}
+	p, err = cleanRepoPath(strings.TrimPrefix(p, "/"))
+	if err != nil || p == "" || p == "." {
+		return "", false
+	}
+	return p, true
+}
+
+// rawURL 图片的原始文件地址，带签名以便 <img> 无需登录头即可加载
+func (r repoLinkResolver) rawURL(p string) string {
+	return signedRawURL(context.Background(), r.req.Project.ID, p, r.req.Rev, r.req.URLExpires)
+}
+
+// codeBlockRenderer 用 chroma 高亮 Markdown 中的代码块
+type codeBlockRenderer struct {
+	style *chroma.Style
+}
+
+func (r codeBlockRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(ast.KindFencedCodeBlock, r.render)
+}
+
+func (r codeBlockRenderer) render(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	block := node.(*ast.FencedCodeBlock)
+	var code bytes.Buffer
+	lines := block.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		seg := lines.At(i)
+		code.Write(seg.Value(source))
+	}
+
+	var lexer chroma.Lexer
+	if lang := block.Language(source); lang != nil {
+		lexer = lexers.Get(string(lang))
+	}
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+	out, err := highlightHTML(chroma.Coalesce(lexer), r.style, code.String(), 0)
+	if err != nil {
+		return ast.WalkStop, err
+	}
+	w.WriteString(out)
+	return ast.WalkSkipChildren, nil
+}
+
+// renderMarkdown 渲染 Markdown（GFM），原始 HTML 不输出
+func renderMarkdown(req renderRequest, style *chroma.Style, source []byte) (string, error) {
+	md := goldmark.New(
+		goldmark.WithExtensions(extension.GFM),
+		goldmark.WithParserOptions(
+			parser.WithAutoHeadingID(),
+			parser.WithASTTransformers(util.Prioritized(repoLinkResolver{req: req}, 100)),
+		),
+		goldmark.WithRendererOptions(
+			renderer.WithNodeRenderers(util.Prioritized(codeBlockRenderer{style: style}, 100)),
+		),
+	)
+	var buf bytes.Buffer
+	if err := md.Convert(source, &buf); err != nil {
+		return "", fmt.Errorf("渲染 Markdown 失败: %v", err)
+	}
+	return buf.String(), nil
+}
+
+// notebookText Notebook 中的文本字段，可能是字符串或字符串数组
+type notebookText string
+
+func (t *notebookText) UnmarshalJSON(data []byte) error {
+	var parts []string
+	if err := json.Unmarshal(data, &parts); err == nil {
+		*t = notebookText(strings.Join(parts, ""))
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	*t = notebookText(s)
+	return nil
+}
+
+type notebookOutput struct {
+	OutputType string                  `json:"output_type"`
+	Name       string                  `json:"name"`
+	Text       notebookText            `json:"text"`
+	Data       map[string]notebookText `json:"data"`
+	Ename      string                  `json:"ename"`
+	Evalue     string                  `json:"evalue"`
+}
+
+type notebookCell struct {
+	CellType       string           `json:"cell_type"`
+	Source         notebookText     `json:"source"`
+	ExecutionCount *int             `json:"execution_count"`
+	Outputs        []notebookOutput `json:"outputs"`
+}
+
+type notebook struct {
+	Cells    []notebookCell `json:"cells"`
+	Metadata struct {
+		Kernelspec struct {
+			Language string `json:"language"`
+		} `json:"kernelspec"`
+		LanguageInfo struct {
+			Name string `json:"name"`
+		} `json:"language_info"`
+	} `json:"metadata"`
+}
+
+// notebookImageTypes 以 data URL 内嵌的图片输出；通过 img 加载的 SVG 不会执行脚本
+var notebookImageTypes = []string{"image/png", "image/jpeg", "image/gif", "image/svg+xml"}
+
+// renderNotebook 渲染 Jupyter Notebook：Markdown 单元格按 Markdown 渲染，代码单元格高亮，
+// 输出中的图片内嵌，HTML 与 JavaScript 输出不渲染
+func renderNotebook(req renderRequest, style *chroma.Style, source []byte) (string, error) {
+	var nb notebook
+	if err := json.Unmarshal(source, &nb); err != nil {
+		return "", newAPIError(http.StatusBadRequest, "Notebook 格式错误")
+	}
+	lang := nb.Metadata.LanguageInfo.Name
+	if lang == "" {
+		lang = nb.Metadata.Kernelspec.Language
+	}
+	lexer := lexers.Get(lang)
+	if lexer == nil {
+		lexer = lexers.Get("python")
+	}
+	lexer = chroma.Coalesce(lexer)
+
+	var b strings.Builder
+	b.WriteString(`<div class="notebook">`)
+	for _, cell := range nb.Cells {
+		switch cell.CellType {
+		case "markdown":
+			out, err := renderMarkdown(req, style, []byte(cell.Source))
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(`<div class="nb-cell nb-markdown">` + out + `</div>`)
+		case "code":
+			prompt := " "
+			if cell.ExecutionCount != nil {
+				prompt = fmt.Sprint(*cell.ExecutionCount)
+			}
+			code, err := highlightHTML(lexer, style, string(cell.Source), 0)
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(&b, `<div class="nb-cell nb-code"><div class="nb-input"><span class="nb-prompt">In [%s]:</span>%s</div>`, html.EscapeString(prompt), code)
+			for _, out := range cell.Outputs {
+				b.WriteString(notebookOutputHTML(out))
+			}
+			b.WriteString(`</div>`)
+		default:
+			b.WriteString(`<div class="nb-cell nb-raw"><pre>` + html.EscapeString(string(cell.Source)) + `</pre></div>`)
+		}
+	}
+	b.WriteString(`</div>`)
+	return b.String(), nil
+}
+
+func notebookOutputHTML(out notebookOutput) string {
+	switch out.OutputType {
+	case "stream":
+		return fmt.Sprintf(`<pre class="nb-output nb-%s">%s</pre>`, html.EscapeString(out.Name), html.EscapeString(string(out.Text)))
+	case "error":
+		return fmt.Sprintf(`<pre class="nb-output nb-error">%s: %s</pre>`, html.EscapeString(out.Ename), html.EscapeString(out.Evalue))
+	}
+	for _, mime := range notebookImageTypes {
+		data, ok := out.Data[mime]
+		if !ok {
+			continue
+		}
+		encoded := strings.Join(strings.Fields(string(data)), "")
+		if mime == "image/svg+xml" {
+			encoded = base64.StdEncoding.EncodeToString([]byte(data))
+		} else if _, err := base64.StdEncoding.DecodeString(encoded); err != nil {
+			continue
+		}
+		return fmt.Sprintf(`<div class="nb-output"><img src="data:%s;base64,%s"></div>`, mime, encoded)
+	}
+	if data, ok := out.Data["text/plain"]; ok {
+		return `<pre class="nb-output">` + html.EscapeString(string(data)) + `</pre>`
+	}
+	return ""
+}
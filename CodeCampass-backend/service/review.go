@@ -0,0 +1,386 @@
+package service
+
+import (
+	"CodeCampass/models"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// 代码评审：把 diff 按文件拆开、标注新文件中的行号，分批交给 LLM，
+// 要求以 JSON 返回评审意见，再校验意见所指的文件与行确实出现在 diff 中
+
+const (
+	maxReviewFiles      = 30
+	maxReviewBatchBytes = 24 * 1024 // 单次 LLM 调用包含的 diff 上限
+	maxReviewDiffBytes  = 2 << 20
+)
+
+// 评审意见的严重程度
+const (
+	reviewSeverityError   = "error"
+	reviewSeverityWarning = "warning"
+	reviewSeverityInfo    = "info"
+)
+
+// reviewComment 一条评审意见，Line 为新文件中的行号，0 表示针对整个文件
+type reviewComment struct {
+	File       string `json:"file"`
+	Line       int    `json:"line"`
+	Severity   string `json:"severity"`
+	Message    string `json:"message"`
+	Suggestion string `json:"suggestion,omitempty"`
+}
+
+// reviewResult 评审结果
+type reviewResult struct {
+	Summary   string          `json:"summary"`
+	Comments  []reviewComment `json:"comments"`
+	Files     []string        `json:"files"`   // 参与评审的文件
+	Skipped   []string        `json:"skipped"` // 删除、二进制或超出数量上限而未评审的文件
+	Truncated bool            `json:"truncated"`
+	Base      string          `json:"base,omitempty"`
+	Head      string          `json:"head,omitempty"`
+}
+
+// diffFile diff 中的一个文件
+type diffFile struct {
+	OldPath   string
+	Path      string
+	Deleted   bool
+	Binary    bool
+	Lines     map[int]bool // 在 diff 中出现的新文件行（新增或上下文）
+	annotated strings.Builder
+}
+
+// hunkHeader 匹配 @@ -旧起始行,旧行数 +新起始行,新行数 @@，省略的行数为 1
+var hunkHeader = regexp.MustCompile(`^@@ -\d+(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// diffPath 去掉 diff 头中的 a/ b/ 前缀与 diff -u 附带的时间戳
+func diffPath(s string) string {
+	if i := strings.IndexByte(s, '\t'); i >= 0 {
+		s = s[:i]
+	}
+	s = strings.TrimSpace(s)
+	if s == "/dev/null" {
+		return ""
+	}
+	if strings.HasPrefix(s, "a/") || strings.HasPrefix(s, "b/") {
+		return s[2:]
+	}
+	return s
+}
+
+// hunkCount hunk 头中的行数，省略时为 1
+func hunkCount(s string) int {
+	if s == "" {
+		return 1
+	}
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+// parseUnifiedDiff 解析 git diff 或 diff -u 格式的补丁。按 hunk 头中的行数读取 hunk 内容，
+// 删除的 "-- xx" 与新增的 "++ xx" 这样的行不会被当作新文件的 ---/+++ 头
+func parseUnifiedDiff(diff string) []*diffFile {
+	var files []*diffFile
+	var cur *diffFile
+	oldLeft, newLeft := 0, 0 // 当前 hunk 中尚未读到的旧文件、新文件行数
+	newLine := 0
+	lines := strings.Split(strings.ReplaceAll(diff, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if cur != nil && (oldLeft > 0 || newLeft > 0) {
+			inHunk := true
+			switch {
+			case strings.HasPrefix(line, "+"):
+				cur.Lines[newLine] = true
+				fmt.Fprintf(&cur.annotated, "%5d + %s\n", newLine, line[1:])
+				newLine++
+				newLeft--
+			case strings.HasPrefix(line, "-"):
+				fmt.Fprintf(&cur.annotated, "      - %s\n", line[1:])
+				oldLeft--
+			case strings.HasPrefix(line, " ") || (line == "" && i < len(lines)-1):
+				cur.Lines[newLine] = true
+				fmt.Fprintf(&cur.annotated, "%5d   %s\n", newLine, strings.TrimPrefix(line, " "))
+				newLine++
+				oldLeft--
+				newLeft--
+			case strings.HasPrefix(line, "\\"):
+				// \ No newline at end of file
+			default:
+				// 行数与 hunk 头不符（补丁被截断或手工编辑过），按文件头或 hunk 头继续解析
+				oldLeft, newLeft, inHunk = 0, 0, false
+			}
+			if inHunk {
+				continue
+			}
+		}
+
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			cur = &diffFile{Lines: map[int]bool{}}
+			files = append(files, cur)
+			if parts := strings.SplitN(strings.TrimPrefix(line, "diff --git "), " b/", 2); len(parts) == 2 {
+				cur.OldPath, cur.Path = diffPath(parts[0]), parts[1]
+			}
+
+		case strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ "):
+			// 没有 diff --git 行的补丁以 ---/+++ 开始新文件
+			if cur == nil || cur.annotated.Len() > 0 {
+				cur = &diffFile{Lines: map[int]bool{}}
+				files = append(files, cur)
+			}
+			cur.OldPath = diffPath(line[4:])
+			cur.Path = diffPath(lines[i+1][4:])
+			if cur.Path == "" {
+				cur.Deleted, cur.Path = true, cur.OldPath
+			}
+			i++
+
+		case cur == nil:
+
+		case strings.HasPrefix(line, "deleted file mode"):
+			cur.Deleted = true
+		case strings.HasPrefix(line, "Binary files ") || line == "GIT binary patch":
+			cur.Binary = true
+
+		case strings.HasPrefix(line, "@@"):
+			m := hunkHeader.FindStringSubmatch(line)
+			if m == nil {
+				continue
+			}
+			newLine, _ = strconv.Atoi(m[2])
+			oldLeft, newLeft = hunkCount(m[1]), hunkCount(m[3])
+			fmt.Fprintf(&cur.annotated, "%s\n", line)
+		}
+	}
+	return files
+}
+
+// diffBetween 生成两个版本之间的 diff；能找到共同祖先时与 PR 一样从共同祖先比较
+func diffBetween(baseDir, base, head string) (string, error) {
+	from := base
+	if out, err := runGit(baseDir, "merge-base", base, head); err == nil {
+		from = strings.TrimSpace(string(out))
+	}
+	out, err := runGit(baseDir, "diff", "--no-color", "--no-ext-diff", "-M", from, head)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// normalizeSeverity 把模型给出的各种写法归到三档
+func normalizeSeverity(s string) string {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "error", "critical", "high", "blocker", "bug":
+		return reviewSeverityError
+	case "warning", "warn", "medium", "major":
+		return reviewSeverityWarning
+	default:
+		return reviewSeverityInfo
+	}
+}
+
+// reviewBatchReply 模型返回的 JSON
+type reviewBatchReply struct {
+	Summary  string          `json:"summary"`
+	Comments []reviewComment `json:"comments"`
+}
+
+// parseReviewReply 解析模型返回的 JSON，兼容包在 ``` 代码块中的写法
+func parseReviewReply(content string) (reviewBatchReply, error) {
+	content = strings.TrimSpace(content)
+	if strings.HasPrefix(content, "```") {
+		content = strings.TrimPrefix(content, "```json")
+		content = strings.TrimPrefix(content, "```")
+		content = strings.TrimSuffix(strings.TrimSpace(content), "```")
+	}
+	var reply reviewBatchReply
+	err := json.Unmarshal([]byte(content), &reply)
+	return reply, err
+}
+
+const reviewInstructions = `请评审上面的 diff。每行开头的数字是新文件中的行号，"+" 为新增行，"-" 为删除行。
+只指出真正值得修改的问题：缺陷、安全隐患、并发问题、错误处理遗漏、与仓库中已有约定不一致之处等，不要复述代码做了什么。
+只输出 JSON，格式为：
+{"summary": "对这部分改动的总体评价（一两句话）", "comments": [{"file": "文件路径", "line": 新文件中的行号（针对整个文件时为 0）, "severity": "error|warning|info", "message": "问题说明", "suggestion": "修改建议或修改后的代码，可为空"}]}
+没有问题时 comments 为空数组。`
+
+// reviewDiff 评审 diff，文件按顺序分批调用 LLM
+func reviewDiff(ctx context.Context, proj models.Project, uid uint, setup askSetup, diff string) (reviewResult, error) {
+	result := reviewResult{Comments: make([]reviewComment, 0), Files: make([]string, 0), Skipped: make([]string, 0)}
+	if len(diff) > maxReviewDiffBytes {
+		return result, newAPIError(http.StatusBadRequest, "diff 过大")
+	}
+
+	fileLimit := maxReviewFiles
+	if setup.maxTokens > 0 {
+		fileLimit = 5 // 预算降级时只评审前几个文件
+	}
+	byPath := map[string]*diffFile{}
+	var batches [][]*diffFile
+	var batch []*diffFile
+	batchSize := 0
+	for _, f := range parseUnifiedDiff(diff) {
+		if f.Path == "" {
+			continue
+		}
+		if f.Deleted || f.Binary || f.annotated.Len() == 0 || len(result.Files) == fileLimit {
+			result.Skipped = append(result.Skipped, f.Path)
+			if len(result.Files) == fileLimit {
+				result.Truncated = true
+			}
+			continue
+		}
+		result.Files = append(result.Files, f.Path)
+		byPath[f.Path] = f
+		if batchSize > 0 && batchSize+f.annotated.Len() > maxReviewBatchBytes {
+			batches = append(batches, batch)
+			batch, batchSize = nil, 0
+		}
+		batch = append(batch, f)
+		batchSize += f.annotated.Len()
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+	if len(batches) == 0 {
+		return result, newAPIError(http.StatusBadRequest, "diff 中没有可评审的改动")
+	}
+
+	// 降级模式下预算已经超出，只靠文件数限制；否则评审途中达到上限时停止
+	budget := projectBudgetStatus(proj, uid)
+	var summaries []string
+	for _, files := range batches {
+		if !budget.Degraded() && budget.Exceeded(setup.client.Spent()) {
+			result.Truncated = true
+			break
+		}
+
+		var diffText strings.Builder
+		for _, f := range files {
+			annotated := f.annotated.String()
+			if len(annotated) > maxReviewBatchBytes {
+				annotated = annotated[:maxReviewBatchBytes] + "\n（diff 过长，以下省略）\n"
+				result.Truncated = true
+			}
+			fmt.Fprintf(&diffText, "\n[diff: %s]\n%s", f.Path, annotated)
+		}
+
+		// 检索仓库中与改动相关的代码作为评审依据，检索失败时只看 diff
+		var contextText string
+		query := diffText.String()
+		query = truncateUTF8(query, 2000)
+		if chunks, err := semanticSearch(ctx, setup.client, proj, setup.cfg.EmbeddingModel, query, setup.topK+len(files)); err == nil {
+			n := 0
+			for _, chunk := range chunks {
+				if byPath[chunk.Path] != nil || n == setup.topK {
+					continue
+				}
+				contextText += fmt.Sprintf("\n[相关文件: %s]\n%s\n", chunk.Path, chunk.Content)
+				n++
+			}
+		}
+		contextText += diffText.String()
+
+		req := setup.chatRequest(contextText, reviewInstructions)
+		req.ResponseFormat = &openai.ChatCompletionResponseFormat{Type: openai.ChatCompletionResponseFormatTypeJSONObject}
+		resp, err := setup.client.CreateChatCompletion(ctx, req)
+		if err != nil {
+			return result, fmt.Errorf("LLM调用失败: %v", err)
+		}
+		if len(resp.Choices) == 0 {
+			return result, fmt.Errorf("LLM 未返回内容")
+		}
+		reply, err := parseReviewReply(resp.Choices[0].Message.Content)
+		if err != nil {
+			return result, fmt.Errorf("解析评审结果失败: %v", err)
+		}
+
+		if s := strings.TrimSpace(reply.Summary); s != "" {
+			summaries = append(summaries, s)
+		}
+		for _, cm := range reply.Comments {
+			f := byPath[strings.TrimPrefix(cm.File, "b/")]
+			if f == nil || strings.TrimSpace(cm.Message) == "" {
+				continue
+			}
+			cm.File = f.Path
+			if !f.Lines[cm.Line] {
+				cm.Line = 0 // 行号不在 diff 中时作为针对整个文件的意见
+			}
+			cm.Severity = normalizeSeverity(cm.Severity)
+			result.Comments = append(result.Comments, cm)
+		}
+	}
+	result.Summary = strings.Join(summaries, "\n\n")
+
+	order := map[string]int{}
+	for i, p := range result.Files {
+		order[p] = i
+	}
+	sort.SliceStable(result.Comments, func(i, j int) bool {
+		a, b := result.Comments[i], result.Comments[j]
+		if a.File != b.File {
+			return order[a.File] < order[b.File]
+		}
+		return a.Line < b.Line
+	})
+	return result, nil
+}
+
+// markdown 渲染为可直接发到 PR 评论的 Markdown
+func (r reviewResult) markdown() string {
+	var b strings.Builder
+	b.WriteString("## CodeCampass 代码评审\n\n")
+	if r.Base != "" {
+		fmt.Fprintf(&b, "`%.8s` → `%.8s`\n\n", r.Base, r.Head)
+	}
+	if r.Summary != "" {
+		b.WriteString(r.Summary + "\n\n")
+	}
+
+	counts := map[string]int{}
+	for _, cm := range r.Comments {
+		counts[cm.Severity]++
+	}
+	fmt.Fprintf(&b, "共评审 %d 个文件，%d 条意见（error %d，warning %d，info %d）。\n",
+		len(r.Files), len(r.Comments), counts[reviewSeverityError], counts[reviewSeverityWarning], counts[reviewSeverityInfo])
+	if r.Truncated {
+		b.WriteString("\n> 改动过多或超出预算，部分内容未评审。\n")
+	}
+
+	file := ""
+	for _, cm := range r.Comments {
+		if cm.File != file {
+			file = cm.File
+			fmt.Fprintf(&b, "\n### `%s`\n\n", file)
+		}
+		where := "整个文件"
+		if cm.Line > 0 {
+			where = fmt.Sprintf("第 %d 行", cm.Line)
+		}
+		fmt.Fprintf(&b, "- **%s** %s：%s\n", cm.Severity, where, cm.Message)
+		if cm.Suggestion != "" {
+			fmt.Fprintf(&b, "\n  ```\n  %s\n  ```\n", strings.ReplaceAll(cm.Suggestion, "\n", "\n  "))
+		}
+	}
+	if len(r.Skipped) > 0 {
+		fmt.Fprintf(&b, "\n<details><summary>未评审的文件（%d）</summary>\n\n", len(r.Skipped))
+		for _, p := range r.Skipped {
+			fmt.Fprintf(&b, "- `%s`\n", p)
+		}
+		b.WriteString("\n</details>\n")
+	}
+	return b.String()
+}
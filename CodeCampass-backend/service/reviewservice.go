@@ -0,0 +1,98 @@
+package service
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// reviewRequest 评审请求：diff 与 base/head 二选一
+type reviewRequest struct {
+	Diff   string `json:"diff"` // git diff 或 diff -u 格式的补丁
+	Base   string `json:"base"` // 本地克隆中的版本，如 main
+	Head   string `json:"head"` // 默认 HEAD
+	Format string `json:"format"`
+}
+
+// ReviewCodeV1
+// @Summary LLM 代码评审
+// @Description 对粘贴的 diff 或本地克隆中两个版本之间的改动给出评审意见。format 为 markdown 时直接返回 Markdown 文本，便于 CI 发到 PR 评论
+// @Tags 项目模块 v1
+// @Security Bearer
+// @Param id path int true "项目ID"
+// @Param body body reviewRequest true "diff，或 base 与 head；format 为 json（默认）或 markdown"
+// @Success 200 {object} map[string]interface{}
+// @Router /api/v1/projects/{id}/review [post]
+func ReviewCodeV1(c *gin.Context) {
+	proj, ok := loadProjectV1(c)
+	if !ok {
+		return
+	}
+
+	var req reviewRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, newAPIError(http.StatusBadRequest, "请求格式错误"))
+		return
+	}
+	if req.Format == "" {
+		req.Format = c.DefaultQuery("format", "json")
+	}
+	if req.Format != "json" && req.Format != "markdown" {
+		respondError(c, newAPIError(http.StatusBadRequest, "format 只能是 json 或 markdown"))
+		return
+	}
+
+	diff := req.Diff
+	var base, head string
+	switch {
+	case strings.TrimSpace(req.Diff) != "" && req.Base != "":
+		respondError(c, newAPIError(http.StatusBadRequest, "diff 与 base 只能提供一个"))
+		return
+	case strings.TrimSpace(req.Diff) != "":
+	case req.Base != "":
+		baseDir, err := clonedRepoDir(proj)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		if base, err = resolveCommit(baseDir, req.Base); err != nil {
+			respondError(c, err)
+			return
+		}
+		if head, err = resolveCommit(baseDir, req.Head); err != nil {
+			respondError(c, err)
+			return
+		}
+		if diff, err = diffBetween(baseDir, base, head); err != nil {
+			respondError(c, err)
+			return
+		}
+	default:
+		respondError(c, newAPIError(http.StatusBadRequest, "请提供 diff，或 base 与 head"))
+		return
+	}
+
+	userID, _ := c.Get("userID")
+	uid := toUserID(userID)
+	setup, err := newAskSetup(proj, uid)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	result, err := reviewDiff(c.Request.Context(), proj, uid, setup, diff)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	result.Base, result.Head = base, head
+
+	if req.Format == "markdown" {
+		c.Data(http.StatusOK, "text/markdown; charset=utf-8", []byte(result.markdown()))
+		return
+	}
+	respondOK(c, http.StatusOK, "评审完成", gin.H{
+		"review":   result,
+		"markdown": result.markdown(),
+	})
+}
@@ -0,0 +1,298 @@
+package service
+
+import (
+	"CodeCampass/models"
+	"bufio"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxSearchContext  = 10
+	maxSearchLineText = 1000 // 超长的行（如压缩后的 JS）只返回前面一段
+)
+
+// searchMatch 一处匹配的行
+type searchMatch struct {
+	Path     string   `json:"path"`
+	Language string   `json:"language"`
+	Line     int      `json:"line"`
+	Text     string   `json:"text"`
+	Ranges   [][2]int `json:"ranges"` // 行内匹配的字节区间 [start, end)
+	Before   []string `json:"before,omitempty"`
+	After    []string `json:"after,omitempty"`
+}
+
+// codeSearchQuery 代码搜索条件
+type codeSearchQuery struct {
+	Pattern       string
+	Regex         bool
+	CaseSensitive bool
+	Include       []*regexp.Regexp
+	Exclude       []*regexp.Regexp
+	Languages     map[string]bool // 小写的语言名
+	Context       int
+	Offset        int
+	Limit         int
+}
+
+// compile 生成用于逐行匹配的正则，以及用于筛选候选文件的字面量
+func (q codeSearchQuery) compile() (*regexp.Regexp, []string, error) {
+	expr := q.Pattern
+	literals := []string{q.Pattern}
+	if q.Regex {
+		if _, err := regexp.Compile(expr); err != nil {
+			return nil, nil, newAPIError(http.StatusBadRequest, fmt.Sprintf("正则表达式无效: %v", err))
+		}
+		literals = requiredLiterals(expr)
+	} else {
+		expr = regexp.QuoteMeta(expr)
+	}
+	if !q.CaseSensitive {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, nil, newAPIError(http.StatusBadRequest, fmt.Sprintf("正则表达式无效: %v", err))
+	}
+	return re, literals, nil
+}
+
+// matchFile 文件是否满足路径与语言条件
+func (q codeSearchQuery) matchFile(f indexedFile) bool {
+	if len(q.Languages) > 0 && !q.Languages[strings.ToLower(f.Language)] {
+		return false
+	}
+	for _, re := range q.Exclude {
+		if re.MatchString(f.Path) {
+			return false
+		}
+	}
+	if len(q.Include) == 0 {
+		return true
+	}
+	for _, re := range q.Include {
+		if re.MatchString(f.Path) {
+			return true
+		}
+	}
+	return false
+}
+
+// searchProjectCode 在项目中搜索，返回第 offset 条起的至多 limit 条匹配以及是否还有更多
+func searchProjectCode(proj models.Project, q codeSearchQuery) ([]searchMatch, bool, string, error) {
+	idx, err := loadTrigramIndex(searchIndexPath(proj))
+	if err != nil {
+		return nil, false, "", newAPIError(http.StatusConflict, "搜索索引尚未构建，请先导入项目")
+	}
+	re, literals, err := q.compile()
+	if err != nil {
+		return nil, false, "", err
+	}
+
+	baseDir := repoBaseDir(proj)
+	matches := make([]searchMatch, 0, q.Limit)
+	skipped := 0
+	for _, id := range idx.sortedFileIDs(idx.candidates(literals)) {
+		f := idx.Files[id]
+		if !q.matchFile(f) {
+			continue
+		}
+		fileMatches, err := searchFile(filepath.Join(baseDir, f.Path), re, q.Context)
+		if err != nil {
+			continue
+		}
+		for _, m := range fileMatches {
+			if skipped < q.Offset {
+				skipped++
+				continue
+			}
+			if len(matches) == q.Limit {
+				return matches, true, idx.Commit, nil
+			}
+			m.Path, m.Language = f.Path, f.Language
+			matches = append(matches, m)
+		}
+	}
+	return matches, false, idx.Commit, nil
+}
+
+// searchFile 逐行匹配文件，附带前后 context 行
+func searchFile(fullPath string, re *regexp.Regexp, context int) ([]searchMatch, error) {
+	f, err := os.Open(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), maxIndexedFileSize)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+
+	var matches []searchMatch
+	for i, text := range lines {
+		locs := re.FindAllStringIndex(text, -1)
+		if len(locs) == 0 {
+			continue
+		}
+		m := searchMatch{Line: i + 1, Text: clipLine(text)}
+		for _, loc := range locs {
+			if loc[0] < len(m.Text) {
+				m.Ranges = append(m.Ranges, [2]int{loc[0], min(loc[1], len(m.Text))})
+			}
+		}
+		for j := max(0, i-context); j < i; j++ {
+			m.Before = append(m.Before, clipLine(lines[j]))
+		}
+		for j := i + 1; j <= min(len(lines)-1, i+context); j++ {
+			m.After = append(m.After, clipLine(lines[j]))
+		}
+		matches = append(matches, m)
+	}
+	return matches, nil
+}
+
+func clipLine(s string) string {
+	if len(s) > maxSearchLineText {
+		return s[:maxSearchLineText]
+	}
+	return s
+}
+
+// splitGlobs 解析逗号分隔的 glob 列表
+func splitGlobs(v string) ([]*regexp.Regexp, error) {
+	var res []*regexp.Regexp
+	for _, g := range strings.Split(v, ",") {
+		if g = strings.TrimSpace(g); g == "" {
+			continue
+		}
+		re, err := globRegexp(g)
+		if err != nil {
+			return nil, newAPIError(http.StatusBadRequest, "无效的路径匹配: "+g)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// SearchCodeV1
+// @Summary 代码搜索（字面量或正则）
+// @Tags 项目模块 v1
+// @Security Bearer
+// @Param id path int true "项目ID"
+// @Param q query string true "搜索内容"
+// @Param regex query bool false "按正则（RE2 语法）搜索，默认按字面量"
+// @Param case_sensitive query bool false "区分大小写，默认不区分"
+// @Param path query string false "只搜索匹配的路径，glob 写法，多个用逗号分隔，如 *.go,web/**/*.ts"
+// @Param exclude query string false "排除匹配的路径，写法同 path"
+// @Param lang query string false "语言，多个用逗号分隔，如 Go,TypeScript"
+// @Param context query int false "前后各返回几行上下文，默认 2，最大 10"
+// @Param offset query int false "跳过的匹配数"
+// @Param limit query int false "返回的匹配数，默认 50，最大 200"
+// @Success 200 {object} map[string]interface{}
+// @Router /api/v1/projects/{id}/search [get]
+func SearchCodeV1(c *gin.Context) {
+	proj, ok := loadProjectV1(c)
+	if !ok {
+		return
+	}
+
+	q := codeSearchQuery{
+		Pattern:       c.Query("q"),
+		Regex:         c.Query("regex") == "true",
+		CaseSensitive: c.Query("case_sensitive") == "true",
+		Languages:     map[string]bool{},
+	}
+	if q.Pattern == "" {
+		respondError(c, newAPIError(http.StatusBadRequest, "请输入搜索内容"))
+		return
+	}
+
+	var err error
+	if q.Include, err = splitGlobs(c.Query("path")); err != nil {
+		respondError(c, err)
+		return
+	}
+	if q.Exclude, err = splitGlobs(c.Query("exclude")); err != nil {
+		respondError(c, err)
+		return
+	}
+	for _, lang := range strings.Split(c.Query("lang"), ",") {
+		if lang = strings.TrimSpace(lang); lang != "" {
+			q.Languages[strings.ToLower(lang)] = true
+		}
+	}
+
+	q.Context, err = strconv.Atoi(c.DefaultQuery("context", "2"))
+	if err != nil || q.Context < 0 {
+		respondError(c, newAPIError(http.StatusBadRequest, "无效的 context"))
+		return
+	}
+	q.Context = min(q.Context, maxSearchContext)
+	q.Offset, err = strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || q.Offset < 0 {
+		respondError(c, newAPIError(http.StatusBadRequest, "无效的 offset"))
+		return
+	}
+	q.Limit, err = strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || q.Limit <= 0 {
+		respondError(c, newAPIError(http.StatusBadRequest, "无效的 limit"))
+		return
+	}
+	q.Limit = min(q.Limit, 200)
+
+	matches, hasMore, commit, err := searchProjectCode(proj, q)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	data := gin.H{
+		"commit":   commit,
+		"items":    matches,
+		"offset":   q.Offset,
+		"has_more": hasMore,
+	}
+	if hasMore {
+		data["next_offset"] = q.Offset + len(matches)
+	}
+	respondOK(c, http.StatusOK, "查询成功", data)
+}
+
+// indexProjectSearch 导入流程中的搜索索引阶段
+func indexProjectSearch(proj models.Project, baseDir string) error {
+	idx, err := buildTrigramIndex(baseDir, proj.HeadCommit)
+	if err == nil {
+		err = writeTrigramIndex(idx, searchIndexPath(proj))
+	}
+	if err != nil {
+		publishProjectEvent(proj.ID, SSEEvent{
+			Event: "search_index_error",
+			Data: gin.H{
+				"message":    fmt.Sprintf("构建搜索索引失败: %v", err),
+				"project_id": proj.ID,
+				"error":      err.Error(),
+			},
+		})
+		return err
+	}
+
+	publishProjectEvent(proj.ID, SSEEvent{
+		Event: "search_index_complete",
+		Data: gin.H{
+			"message":    "搜索索引构建完成",
+			"project_id": proj.ID,
+			"files":      len(idx.Files),
+		},
+	})
+	return nil
+}
@@ -0,0 +1,242 @@
+package service
+
+import (
+	"CodeCampass/models"
+	"CodeCampass/storage"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var snapshotNamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]+\.(zip|tar\.gz)$`)
+
+// snapshotPrefix 项目快照在存储中的前缀
+func snapshotPrefix(proj models.Project) string {
+	return fmt.Sprintf("projects/%d/", proj.ID)
+}
+
+// snapshotStore 取得快照存储，配置错误时返回 503
+func snapshotStore() (storage.SnapshotStore, error) {
+	store, err := storage.Snapshots()
+	if err != nil {
+		return nil, newAPIError(http.StatusServiceUnavailable, "快照存储不可用: "+err.Error())
+	}
+	return store, nil
+}
+
+// snapshotError 把存储层的错误转为 apiError
+func snapshotError(err error) error {
+	switch {
+	case errors.Is(err, storage.ErrSnapshotNotFound):
+		return newAPIError(http.StatusNotFound, "快照不存在")
+	case errors.Is(err, storage.ErrInvalidKey):
+		return newAPIError(http.StatusBadRequest, "无效的快照名称")
+	}
+	return err
+}
+
+// snapshotKey 校验快照名称并拼出完整的 key
+func snapshotKey(proj models.Project, name string) (string, error) {
+	if !snapshotNamePattern.MatchString(name) {
+		return "", newAPIError(http.StatusBadRequest, "无效的快照名称")
+	}
+	return snapshotPrefix(proj) + name, nil
+}
+
+// snapshotItem 快照列表中的一项
+func snapshotItem(info storage.SnapshotInfo) gin.H {
+	return gin.H{
+		"name":     path.Base(info.Key),
+		"size":     info.Size,
+		"mod_time": info.ModTime,
+	}
+}
+
+// createSnapshot 将当前工作区打包写入快照存储，打包与上传同时进行，不在内存或本地留存整个压缩包
+func createSnapshot(ctx context.Context, proj models.Project, format string) (storage.SnapshotInfo, error) {
+	var info storage.SnapshotInfo
+	store, err := snapshotStore()
+	if err != nil {
+		return info, err
+	}
+	baseDir := repoBaseDir(proj)
+	if _, err := os.Stat(baseDir); err != nil {
+		return info, newAPIError(http.StatusConflict, "仓库未同步")
+	}
+	files, err := listWorkingTreeFiles(baseDir, "")
+	if err != nil {
+		return info, err
+	}
+
+	commit := gitHeadCommit(baseDir)
+	label := time.Now().Format("20060102-150405")
+	if len(commit) >= 12 {
+		label += "-" + commit[:12]
+	}
+	name := archiveName(proj, "", "")
+	key := snapshotPrefix(proj) + label + "." + format
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeWorkingTreeArchive(pw, format, baseDir, name+"/", files))
+	}()
+	if err := store.Put(ctx, key, pr); err != nil {
+		pr.CloseWithError(err) // 让打包协程退出
+		return info, fmt.Errorf("保存快照失败: %v", err)
+	}
+
+	rc, info, err := store.Open(ctx, key)
+	if err != nil {
+		return info, snapshotError(err)
+	}
+	rc.Close()
+	return info, nil
+}
+
+// CreateSnapshotV1
+// @Summary 归档项目快照
+// @Description 将当前工作区（不含 .git 与被忽略的文件）打包保存到快照存储（本地目录或 S3 兼容存储）
+// @Tags 项目模块 v1
+// @Security Bearer
+// @Param id path int true "项目ID"
+// @Param format query string false "tar.gz（默认）或 zip"
+// @Success 201 {object} map[string]interface{}
+// @Router /api/v1/projects/{id}/snapshots [post]
+func CreateSnapshotV1(c *gin.Context) {
+	proj, ok := loadProjectV1(c)
+	if !ok {
+		return
+	}
+	userID, _ := c.Get("userID")
+	if !canManageProject(userID.(uint), proj) {
+		respondError(c, newAPIError(http.StatusForbidden, "无权限归档此项目"))
+		return
+	}
+	format := c.DefaultQuery("format", "tar.gz")
+	if _, ok := archiveFormats[format]; !ok {
+		respondError(c, newAPIError(http.StatusBadRequest, "format 只能是 zip 或 tar.gz"))
+		return
+	}
+
+	info, err := createSnapshot(c.Request.Context(), proj, format)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusCreated, "快照已保存", snapshotItem(info))
+}
+
+// ListSnapshotsV1
+// @Summary 获取项目快照列表
+// @Tags 项目模块 v1
+// @Security Bearer
+// @Param id path int true "项目ID"
+// @Success 200 {object} map[string]interface{}
+// @Router /api/v1/projects/{id}/snapshots [get]
+func ListSnapshotsV1(c *gin.Context) {
+	proj, ok := loadProjectV1(c)
+	if !ok {
+		return
+	}
+	store, err := snapshotStore()
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	list, err := store.List(c.Request.Context(), snapshotPrefix(proj))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	items := make([]gin.H, 0, len(list))
+	for _, info := range list {
+		items = append(items, snapshotItem(info))
+	}
+	respondOK(c, http.StatusOK, "获取成功", gin.H{"snapshots": items})
+}
+
+// DownloadSnapshotV1
+// @Summary 下载项目快照
+// @Tags 项目模块 v1
+// @Security Bearer
+// @Param id path int true "项目ID"
+// @Param name path string true "快照名称"
+// @Success 200
+// @Router /api/v1/projects/{id}/snapshots/{name} [get]
+func DownloadSnapshotV1(c *gin.Context) {
+	proj, ok := loadProjectV1(c)
+	if !ok {
+		return
+	}
+	name := c.Param("name")
+	key, err := snapshotKey(proj, name)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	store, err := snapshotStore()
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	rc, info, err := store.Open(c.Request.Context(), key)
+	if err != nil {
+		respondError(c, snapshotError(err))
+		return
+	}
+	defer rc.Close()
+
+	contentType := archiveFormats["zip"]
+	if strings.HasSuffix(name, ".tar.gz") {
+		contentType = archiveFormats["tar.gz"]
+	}
+	c.DataFromReader(http.StatusOK, info.Size, contentType, rc, map[string]string{
+		"Content-Disposition":    mime.FormatMediaType("attachment", map[string]string{"filename": name}),
+		"X-Content-Type-Options": "nosniff",
+	})
+}
+
+// DeleteSnapshotV1
+// @Summary 删除项目快照
+// @Tags 项目模块 v1
+// @Security Bearer
+// @Param id path int true "项目ID"
+// @Param name path string true "快照名称"
+// @Success 200 {object} map[string]interface{}
+// @Router /api/v1/projects/{id}/snapshots/{name} [delete]
+func DeleteSnapshotV1(c *gin.Context) {
+	proj, ok := loadProjectV1(c)
+	if !ok {
+		return
+	}
+	userID, _ := c.Get("userID")
+	if !canManageProject(userID.(uint), proj) {
+		respondError(c, newAPIError(http.StatusForbidden, "无权限删除此项目的快照"))
+		return
+	}
+	key, err := snapshotKey(proj, c.Param("name"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	store, err := snapshotStore()
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	if err := store.Delete(c.Request.Context(), key); err != nil {
+		respondError(c, snapshotError(err))
+		return
+	}
+	respondOK(c, http.StatusOK, "快照已删除", nil)
+}
@@ -1,7 +1,6 @@
 package service
 
 import (
-	"CodeCampass/models"
 	"CodeCampass/utils"
 	"encoding/json"
 	"fmt"
@@ -99,6 +98,23 @@ func SubscribeProjectEvents(c *gin.Context) {
 	}
 
 	// 验证用户权限 - 支持从URL参数或Header获取token
+	userID, exists := resolveRequestUserID(c)
+	if !exists {
+		c.JSON(401, gin.H{"error": "用户未登录"})
+		return
+	}
+
+	// 验证项目访问权限
+	if _, err := findUserProjectByID(userID, projectID); err != nil {
+		c.JSON(404, gin.H{"error": "项目不存在或无权限"})
+		return
+	}
+
+	streamProjectEvents(c, projectID)
+}
+
+// resolveRequestUserID 获取当前请求的用户ID，EventSource 不支持自定义 Header，允许通过 token 参数传递
+func resolveRequestUserID(c *gin.Context) (uint, bool) {
 	var userID uint
 	var exists bool
 	
@@ -129,18 +145,11 @@ func SubscribeProjectEvents(c *gin.Context) {
 		}
 	}
 	
-	if !exists {
-		c.JSON(401, gin.H{"error": "用户未登录"})
-		return
-	}
-
-	// 验证项目所有权
-	var proj models.Project
-	if err := utils.DB.Where("id = ? and owner_id = ?", projectID, userID).First(&proj).Error; err != nil {
-		c.JSON(404, gin.H{"error": "项目不存在或无权限"})
-		return
-	}
+	return userID, exists
+}
 
+// streamProjectEvents 以 SSE 形式持续推送项目事件，直到客户端断开
+func streamProjectEvents(c *gin.Context, projectID uint) {
 	// 设置SSE响应头
 	c.Header("Content-Type", "text/event-stream")
 	c.Header("Cache-Control", "no-cache")
@@ -0,0 +1,243 @@
+package service
+
+import (
+	"CodeCampass/models"
+	"CodeCampass/utils"
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 子模块与 Git LFS：克隆时总是跳过 LFS 对象的下载（文件保留为指针），子模块也不随克隆检出，
+// 之后按项目设置单独检出子模块、拉取 LFS 对象，两步都由看门狗统计仓库大小
+
+// lfsPointerPrefix LFS 指针文件的开头
+const lfsPointerPrefix = "version https://git-lfs.github.com/spec/v1\n"
+
+// maxLFSPointerSize LFS 指针文件不会超过 1KB
+const maxLFSPointerSize = 1024
+
+// isLFSPointer 内容是否为 LFS 指针
+func isLFSPointer(content []byte) bool {
+	return len(content) < maxLFSPointerSize && bytes.HasPrefix(content, []byte(lfsPointerPrefix))
+}
+
+// fileIsLFSPointer 文件是否为 LFS 指针
+func fileIsLFSPointer(p string, size int64) bool {
+	if size >= maxLFSPointerSize {
+		return false
+	}
+	content, err := os.ReadFile(p)
+	return err == nil && isLFSPointer(content)
+}
+
+// gitEnv 执行 git 命令的环境变量，检出时不自动下载 LFS 对象
+func gitEnv() []string {
+	return append(os.Environ(), "GIT_LFS_SKIP_SMUDGE=1")
+}
+
+// applyCloneOptions 读取导入请求中的 submodules、submodule_depth、submodule_ignore 与 lfs 参数并保存到项目；
+// 未指定的参数沿用项目上次导入的设置
+func applyCloneOptions(c *gin.Context, proj *models.Project) error {
+	opts := *proj
+	if v := c.Query("submodules"); v != "" {
+		on, err := strconv.ParseBool(v)
+		if err != nil {
+			return newAPIError(http.StatusBadRequest, "submodules 只能是 true 或 false")
+		}
+		opts.Submodules = on
+	}
+	if v := c.Query("submodule_depth"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < -1 {
+			return newAPIError(http.StatusBadRequest, "无效的 submodule_depth")
+		}
+		if n > maxHistoryDepth {
+			n = maxHistoryDepth
+		}
+		opts.SubmoduleDepth = n
+	}
+	if v, ok := c.GetQuery("submodule_ignore"); ok {
+		patterns := make([]string, 0)
+		for _, p := range strings.Split(v, ",") {
+			if p = strings.Trim(strings.TrimSpace(p), "/"); p == "" {
+				continue
+			}
+			if _, err := path.Match(p, ""); err != nil {
+				return newAPIError(http.StatusBadRequest, "无效的 submodule_ignore: "+p)
+			}
+			patterns = append(patterns, p)
+		}
+		opts.SubmoduleIgnore = strings.Join(patterns, ",")
+	}
+	switch c.Query("lfs") {
+	case "":
+	case "fetch":
+		opts.FetchLFS = true
+	case "skip":
+		opts.FetchLFS = false
+	default:
+		return newAPIError(http.StatusBadRequest, "lfs 只能是 fetch 或 skip")
+	}
+	if opts.Submodules == proj.Submodules && opts.SubmoduleDepth == proj.SubmoduleDepth &&
+		opts.SubmoduleIgnore == proj.SubmoduleIgnore && opts.FetchLFS == proj.FetchLFS {
+		return nil
+	}
+	if err := utils.DB.Model(proj).Updates(map[string]interface{}{
+		"submodules":       opts.Submodules,
+		"submodule_depth":  opts.SubmoduleDepth,
+		"submodule_ignore": opts.SubmoduleIgnore,
+		"fetch_lfs":        opts.FetchLFS,
+	}).Error; err != nil {
+		return err
+	}
+	proj.Submodules, proj.SubmoduleDepth = opts.Submodules, opts.SubmoduleDepth
+	proj.SubmoduleIgnore, proj.FetchLFS = opts.SubmoduleIgnore, opts.FetchLFS
+	return nil
+}
+
+// maxSubmoduleNesting 逐层检出嵌套子模块的最大层数，避免子模块互相引用时无限递归
+const maxSubmoduleNesting = 5
+
+// gitlinkPaths dir 所在仓库当前提交中的子模块路径（gitlink），相对 dir；不依赖 .gitmodules 是否与提交一致
+func gitlinkPaths(dir string) []string {
+	out, err := runGit(dir, "ls-files", "--stage", "-z")
+	if err != nil {
+		return nil
+	}
+	roots := make([]string, 0)
+	for _, entry := range strings.Split(string(out), "\x00") {
+		// <mode> <object> <stage>\t<path>
+		if !strings.HasPrefix(entry, "160000 ") {
+			continue
+		}
+		if i := strings.IndexByte(entry, '\t'); i >= 0 {
+			roots = append(roots, entry[i+1:])
+		}
+	}
+	return roots
+}
+
+// submoduleCheckedOut 子模块是否已检出
+func submoduleCheckedOut(baseDir, root string) bool {
+	_, err := os.Stat(filepath.Join(baseDir, filepath.FromSlash(root), ".git"))
+	return err == nil
+}
+
+// gitSubmoduleRoots 仓库中的子模块路径，包括已检出的子模块中嵌套的子模块，路径相对仓库根目录
+func gitSubmoduleRoots(baseDir string) []string {
+	roots := make([]string, 0)
+	var walk func(prefix string, level int)
+	walk = func(prefix string, level int) {
+		for _, root := range gitlinkPaths(filepath.Join(baseDir, filepath.FromSlash(prefix))) {
+			full := path.Join(prefix, root)
+			roots = append(roots, full)
+			if level < maxSubmoduleNesting && submoduleCheckedOut(baseDir, full) {
+				walk(full, level+1)
+			}
+		}
+	}
+	walk("", 1)
+	return roots
+}
+
+// submoduleIgnored 子模块是否在不检出的列表中，root 为相对仓库根目录的完整路径
+func submoduleIgnored(proj models.Project, root string) bool {
+	for _, p := range strings.Split(proj.SubmoduleIgnore, ",") {
+		if p == "" {
+			continue
+		}
+		if ok, _ := path.Match(p, root); ok || p == root {
+			return true
+		}
+	}
+	return false
+}
+
+// submoduleUpdateArgs 按项目的子模块深度生成在 dir 中执行的 git submodule update 参数。
+// 不使用 --recursive，嵌套的子模块由 updateSubmodules 逐层检出，每层都按深度与忽略规则处理；
+// 禁止 file:// 协议，子模块地址不能指向服务器上的本地仓库
+func submoduleUpdateArgs(proj models.Project, dir string, roots []string) []string {
+	args := []string{"-c", "protocol.file.allow=never", "-C", dir, "submodule", "update", "--init"}
+	switch {
+	case proj.SubmoduleDepth == 0:
+		args = append(args, "--depth", "1")
+	case proj.SubmoduleDepth > 0:
+		args = append(args, "--depth", strconv.Itoa(proj.SubmoduleDepth))
+	}
+	return append(append(args, "--"), roots...)
+}
+
+// updateSubmodules 检出 prefix 所在仓库中未忽略的子模块，再逐层进入已检出的子模块；
+// 看门狗统计整个仓库的大小
+func updateSubmodules(proj models.Project, baseDir, prefix string, level int, sizeLimit int64) error {
+	dir := filepath.Join(baseDir, filepath.FromSlash(prefix))
+	roots := make([]string, 0)
+	for _, root := range gitlinkPaths(dir) {
+		if !submoduleIgnored(proj, path.Join(prefix, root)) {
+			roots = append(roots, root)
+		}
+	}
+	if len(roots) == 0 {
+		return nil
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", submoduleUpdateArgs(proj, dir, roots)...)
+	cmd.Env = gitEnv()
+	cmd.Stderr = &stderr
+	if err := runWithSizeWatchdog(cmd, baseDir, sizeLimit); err != nil {
+		if errorStatus(err) != http.StatusInternalServerError {
+			return err
+		}
+		// 部分子模块可能已经检出，继续处理其中嵌套的子模块
+		fmt.Printf("警告: 项目 %d 检出子模块失败: %v %s\n", proj.ID, err, strings.TrimSpace(stderr.String()))
+	}
+	if level >= maxSubmoduleNesting {
+		return nil
+	}
+	for _, root := range roots {
+		full := path.Join(prefix, root)
+		if !submoduleCheckedOut(baseDir, full) {
+			continue
+		}
+		if err := updateSubmodules(proj, baseDir, full, level+1, sizeLimit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prepareWorkTree 克隆或拉取之后检出子模块并拉取 LFS 对象。超出大小配额时返回错误，
+// 其他失败只记录警告，对应的子模块保持未检出、LFS 文件保持为指针
+func prepareWorkTree(proj models.Project, baseDir string, sizeLimit int64) error {
+	if proj.Submodules {
+		if err := updateSubmodules(proj, baseDir, "", 1, sizeLimit); err != nil {
+			return err
+		}
+	}
+
+	if proj.FetchLFS {
+		if err := exec.Command("git", "lfs", "version").Run(); err != nil {
+			fmt.Printf("警告: 未安装 git-lfs，项目 %d 的 LFS 文件保留为指针\n", proj.ID)
+			return nil
+		}
+		var stderr bytes.Buffer
+		cmd := exec.Command("git", "-C", baseDir, "lfs", "pull")
+		cmd.Stderr = &stderr
+		if err := runWithSizeWatchdog(cmd, baseDir, sizeLimit); err != nil {
+			if errorStatus(err) != http.StatusInternalServerError {
+				return err
+			}
+			fmt.Printf("警告: 项目 %d 拉取 LFS 对象失败: %v %s\n", proj.ID, err, strings.TrimSpace(stderr.String()))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,392 @@
+package service
+
+import (
+	"CodeCampass/models"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sashabaranov/go-openai"
+	"github.com/spf13/viper"
+)
+
+// 分层摘要：先为每个文件生成摘要，再由子项摘要汇总出目录摘要，最后生成仓库概览。
+// 每个节点记录哈希，重新导入时只为内容变化的文件及其上层目录重新生成
+
+const (
+	defaultSummaryMaxFiles = 300
+	maxSummaryFileBytes    = 6000 // 发送给 LLM 的单个文件内容上限
+	maxSummaryChildren     = 80   // 目录摘要中列出的子项上限
+	maxOverviewContext     = 6000 // 问答时附带的概览与目录摘要总长度上限
+)
+
+// summaryNode 摘要树中的文件或目录
+type summaryNode struct {
+	Path     string
+	Kind     string
+	Hash     string
+	Content  string // 文件内容，已截断
+	Summary  string
+	Children []*summaryNode
+}
+
+// summaryMaxFiles 每个项目最多为多少个文件生成摘要，配置为 0 时不生成
+func summaryMaxFiles() int {
+	if !viper.IsSet("summary.maxFiles") {
+		return defaultSummaryMaxFiles
+	}
+	return viper.GetInt("summary.maxFiles")
+}
+
+// collectSummaryTree 收集需要摘要的文件并组织成目录树。文件过多时优先代码文件，
+// 其次是层级较浅的文件
+func collectSummaryTree(baseDir string, maxFiles int) (*summaryNode, error) {
+	type candidate struct {
+		path    string
+		nonCode bool
+		depth   int
+	}
+	var files []candidate
+	err := filepath.Walk(baseDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() {
+			if p != baseDir && skipSourceDir(info.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		lang := detectLanguage(p)
+		if lang == "" || !info.Mode().IsRegular() || info.Size() > maxIndexedFileSize {
+			return nil
+		}
+		rel, _ := filepath.Rel(baseDir, p)
+		rel = filepath.ToSlash(rel)
+		files = append(files, candidate{path: rel, nonCode: nonCodeLanguages[lang], depth: strings.Count(rel, "/")})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(files, func(i, j int) bool {
+		a, b := files[i], files[j]
+		if a.nonCode != b.nonCode {
+			return !a.nonCode
+		}
+		if a.depth != b.depth {
+			return a.depth < b.depth
+		}
+		return a.path < b.path
+	})
+
+	root := &summaryNode{Kind: models.SummaryKindRepo}
+	dirs := map[string]*summaryNode{"": root}
+	var dirOf func(dir string) *summaryNode
+	dirOf = func(dir string) *summaryNode {
+		if n, ok := dirs[dir]; ok {
+			return n
+		}
+		parent := path.Dir(dir)
+		if parent == "." {
+			parent = ""
+		}
+		n := &summaryNode{Path: dir, Kind: models.SummaryKindDir}
+		dirs[dir] = n
+		p := dirOf(parent)
+		p.Children = append(p.Children, n)
+		return n
+	}
+
+	count := 0
+	for _, f := range files {
+		if count >= maxFiles {
+			break
+		}
+		data, err := os.ReadFile(filepath.Join(baseDir, f.path))
+		if err != nil || isBinaryContent(data) {
+			continue
+		}
+		sum := sha256.Sum256(data)
+		if len(data) > maxSummaryFileBytes {
+			data = data[:maxSummaryFileBytes]
+		}
+		dir := path.Dir(f.path)
+		if dir == "." {
+			dir = ""
+		}
+		parent := dirOf(dir)
+		parent.Children = append(parent.Children, &summaryNode{
+			Path:    f.path,
+			Kind:    models.SummaryKindFile,
+			Hash:    hex.EncodeToString(sum[:]),
+			Content: string(data),
+		})
+		count++
+	}
+
+	for _, n := range dirs {
+		sort.Slice(n.Children, func(i, j int) bool { return n.Children[i].Path < n.Children[j].Path })
+	}
+	return root, nil
+}
+
+// summarizer 自底向上生成摘要
+type summarizer struct {
+	proj      models.Project
+	cfg       llmConfig
+	client    *meteredClient
+	budget    budgetStatus
+	existing  map[string]models.ProjectSummary
+	generated int
+	reused    int
+}
+
+func (s *summarizer) summarize(ctx context.Context, n *summaryNode) error {
+	if n.Kind != models.SummaryKindFile {
+		h := sha256.New()
+		for _, ch := range n.Children {
+			if err := s.summarize(ctx, ch); err != nil {
+				return err
+			}
+			fmt.Fprintf(h, "%s\x00%s\n", ch.Path, ch.Hash)
+		}
+		n.Hash = hex.EncodeToString(h.Sum(nil))
+	}
+
+	if old, ok := s.existing[n.Path]; ok && old.Kind == n.Kind && old.Hash == n.Hash {
+		n.Summary = old.Summary
+		s.reused++
+		return nil
+	}
+
+	var prompt string
+	maxTokens := 200
+	switch {
+	case n.Kind == models.SummaryKindFile:
+		prompt = fmt.Sprintf("以下是仓库中的文件 %s，请用两三句话概括它的职责与主要内容（关键的类型、函数等），只输出摘要：\n\n%s", n.Path, n.Content)
+	case n.Kind == models.SummaryKindDir && len(n.Children) == 1:
+		// 只有一个子项的目录直接沿用子项的摘要
+		n.Summary = n.Children[0].Summary
+	case n.Kind == models.SummaryKindDir:
+		prompt = fmt.Sprintf("以下是仓库目录 %s 中各文件与子目录的摘要，请用三五句话概括这个目录的职责与组织方式，只输出摘要：\n\n%s", n.Path, childSummaries(n))
+		maxTokens = 300
+	default:
+		prompt = fmt.Sprintf(`以下是仓库 %s 顶层各文件与目录的摘要。请为刚加入项目的开发者写一份架构概览：项目是做什么的、代码如何组织、主要模块及其关系、建议的阅读顺序。使用 Markdown，不超过 600 字。
+
+%s`, s.proj.Name, childSummaries(n))
+		maxTokens = 1000
+	}
+
+	if prompt != "" {
+		if s.budget.Exceeded(s.client.Spent()) {
+			return s.budget.budgetError()
+		}
+		resp, err := s.client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+			Model:     s.cfg.ChatModel,
+			MaxTokens: maxTokens,
+			Messages: []openai.ChatCompletionMessage{
+				{Role: "system", Content: "你是代码与软件架构专家。"},
+				{Role: "user", Content: prompt},
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("LLM调用失败: %v", err)
+		}
+		if len(resp.Choices) == 0 {
+			return fmt.Errorf("LLM 未返回内容")
+		}
+		n.Summary = strings.TrimSpace(resp.Choices[0].Message.Content)
+		s.generated++
+	}
+
+	return models.SaveProjectSummary(&models.ProjectSummary{
+		ProjectId: s.proj.ID,
+		Path:      n.Path,
+		Kind:      n.Kind,
+		Hash:      n.Hash,
+		Summary:   n.Summary,
+		Model:     s.cfg.ChatModel,
+	})
+}
+
+// childSummaries 把子项摘要拼成列表
+func childSummaries(n *summaryNode) string {
+	var b strings.Builder
+	for i, ch := range n.Children {
+		if i == maxSummaryChildren {
+			fmt.Fprintf(&b, "- ……另有 %d 项\n", len(n.Children)-i)
+			break
+		}
+		name := ch.Path
+		if ch.Kind == models.SummaryKindDir {
+			name += "/"
+		}
+		fmt.Fprintf(&b, "- %s: %s\n", name, strings.ReplaceAll(ch.Summary, "\n", " "))
+	}
+	return b.String()
+}
+
+// buildProjectSummaries 生成或增量更新项目的分层摘要，返回新生成与沿用的数量
+func buildProjectSummaries(proj models.Project, baseDir string) (generated, reused int, err error) {
+	maxFiles := summaryMaxFiles()
+	if maxFiles <= 0 {
+		return 0, 0, nil
+	}
+	cfg, err := resolveLLMConfig(proj, proj.OwnerId)
+	if err != nil {
+		return 0, 0, err
+	}
+	budget := projectBudgetStatus(proj, proj.OwnerId)
+	if budget.Exceeded(0) {
+		return 0, 0, budget.budgetError()
+	}
+
+	root, err := collectSummaryTree(baseDir, maxFiles)
+	if err != nil || len(root.Children) == 0 {
+		return 0, 0, err
+	}
+	s := &summarizer{
+		proj:     proj,
+		cfg:      cfg,
+		client:   cfg.newMeteredClient(projectUsageScope(proj, proj.OwnerId)),
+		budget:   budget,
+		existing: models.FindProjectSummaries(proj.ID),
+	}
+	if err := s.summarize(context.Background(), root); err != nil {
+		return s.generated, s.reused, err
+	}
+
+	// 清理已删除的文件与目录的摘要
+	present := map[string]bool{}
+	var mark func(n *summaryNode)
+	mark = func(n *summaryNode) {
+		present[n.Path] = true
+		for _, ch := range n.Children {
+			mark(ch)
+		}
+	}
+	mark(root)
+	var stale []string
+	for p := range s.existing {
+		if !present[p] {
+			stale = append(stale, p)
+		}
+	}
+	return s.generated, s.reused, models.DeleteProjectSummaries(proj.ID, stale)
+}
+
+// summaryLockPrefix 生成概览时持有的锁，同一项目同一时间只生成一次
+const summaryLockPrefix = "summary_lock:"
+
+var errSummaryRunning = newAPIError(http.StatusConflict, "项目概览正在生成")
+
+// lockProjectSummaries 取得项目的概览生成锁，返回释放锁的函数；正在生成时返回 errSummaryRunning
+func lockProjectSummaries(projectID uint) (func(), error) {
+	ctx := context.Background()
+	token, err := acquireProjectLock(ctx, summaryLockPrefix, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("获取概览生成锁失败: %v", err)
+	}
+	if token == "" {
+		return nil, errSummaryRunning
+	}
+	stop := keepProjectLock(summaryLockPrefix, projectID, token)
+	return func() {
+		stop()
+		releaseProjectLock(ctx, summaryLockPrefix, projectID, token)
+	}, nil
+}
+
+// indexProjectSummaries 导入流程中的摘要阶段，在 embedding 完成后执行
+func indexProjectSummaries(proj models.Project, baseDir string) error {
+	unlock, err := lockProjectSummaries(proj.ID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return generateProjectSummaries(proj, baseDir)
+}
+
+// generateProjectSummaries 生成项目概览，调用方需持有概览生成锁
+func generateProjectSummaries(proj models.Project, baseDir string) error {
+	publishProjectEvent(proj.ID, SSEEvent{
+		Event: "summary_start",
+		Data:  gin.H{"message": "开始生成项目概览", "project_id": proj.ID},
+	})
+
+	generated, reused, err := buildProjectSummaries(proj, baseDir)
+	if err != nil {
+		publishProjectEvent(proj.ID, SSEEvent{
+			Event: "summary_error",
+			Data: gin.H{
+				"message":    fmt.Sprintf("生成项目概览失败: %v", err),
+				"project_id": proj.ID,
+				"error":      err.Error(),
+			},
+		})
+		return err
+	}
+
+	publishProjectEvent(proj.ID, SSEEvent{
+		Event: "summary_complete",
+		Data: gin.H{
+			"message":    "项目概览生成完成",
+			"project_id": proj.ID,
+			"generated":  generated,
+			"reused":     reused,
+		},
+	})
+	return nil
+}
+
+// broadQuestionKeywords 询问整体情况的问题中常见的词
+var broadQuestionKeywords = []string{
+	"架构", "结构", "整体", "概览", "总体", "组织", "模块", "目录", "介绍", "是做什么", "干什么", "入口", "流程",
+	"architecture", "overview", "structure", "organized", "organised", "layout", "what is this", "what does this",
+}
+
+// isBroadQuestion 是否为询问仓库整体情况的问题
+func isBroadQuestion(question string) bool {
+	q := strings.ToLower(question)
+	for _, kw := range broadQuestionKeywords {
+		if strings.Contains(q, kw) {
+			return true
+		}
+	}
+	return false
+}
+
+// projectOverviewContext 问答时附带的仓库概览与前两层目录的摘要，尚未生成时为空
+func projectOverviewContext(proj models.Project) string {
+	summaries := models.GetProjectSummaryList(proj.ID, models.SummaryKindRepo, models.SummaryKindDir)
+	if len(summaries) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	for _, s := range summaries {
+		if s.Kind == models.SummaryKindRepo {
+			fmt.Fprintf(&b, "\n[仓库概览]\n%s\n", s.Summary)
+		}
+	}
+	b.WriteString("\n[目录摘要]\n")
+	for _, s := range summaries {
+		if s.Kind != models.SummaryKindDir || strings.Count(s.Path, "/") > 1 {
+			continue
+		}
+		line := fmt.Sprintf("- %s/: %s\n", s.Path, strings.ReplaceAll(s.Summary, "\n", " "))
+		if b.Len()+len(line) > maxOverviewContext {
+			break
+		}
+		b.WriteString(line)
+	}
+	return b.String()
+}
@@ -0,0 +1,437 @@
+package service
+
+import (
+	"CodeCampass/models"
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"go/types"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 签名与包文档的最大长度
+const (
+	maxSignatureLen = 500
+	maxPackageDoc   = 500
+)
+
+// goPackageSource 一个目录下解析出的 Go 包
+type goPackageSource struct {
+	ImportPath string
+	Dir        string // 相对仓库根目录，根目录为 "."
+	Name       string
+	Files      []*ast.File
+	FileNames  []string // 与 Files 一一对应的相对路径
+	Doc        string
+}
+
+// gitHeadCommit 读取仓库当前提交，失败时返回空字符串
+func gitHeadCommit(baseDir string) string {
+	out, err := exec.Command("git", "-C", baseDir, "rev-parse", "HEAD").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// readModulePath 读取 go.mod 中的模块路径
+func readModulePath(baseDir string) string {
+	f, err := os.Open(filepath.Join(baseDir, "go.mod"))
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "module") {
+			return strings.Trim(strings.TrimSpace(strings.TrimPrefix(line, "module")), `"`)
+		}
+	}
+	return ""
+}
+
+// skipSourceDir 索引 Go 源码时跳过的目录
+func skipSourceDir(name string) bool {
+	return name == "vendor" || name == "testdata" || name == "node_modules" ||
+		strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")
+}
+
+// parseGoPackages 解析仓库中的所有 Go 包（不含测试文件），语法错误的文件会被跳过
+func parseGoPackages(baseDir string) (*token.FileSet, []*goPackageSource, error) {
+	fset := token.NewFileSet()
+	modulePath := readModulePath(baseDir)
+	var pkgs []*goPackageSource
+
+	err := filepath.Walk(baseDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return nil
+		}
+		if p != baseDir && skipSourceDir(info.Name()) {
+			return filepath.SkipDir
+		}
+
+		entries, err := os.ReadDir(p)
+		if err != nil {
+			return nil
+		}
+		relDir, _ := filepath.Rel(baseDir, p)
+		relDir = filepath.ToSlash(relDir)
+
+		// 同一目录可能混有 package main 的工具文件，按文件数取主要的包名
+		byName := map[string]*goPackageSource{}
+		for _, e := range entries {
+			name := e.Name()
+			if e.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+				continue
+			}
+			file, err := parser.ParseFile(fset, filepath.Join(p, name), nil, parser.ParseComments|parser.SkipObjectResolution)
+			if err != nil {
+				continue
+			}
+			src := byName[file.Name.Name]
+			if src == nil {
+				src = &goPackageSource{Dir: relDir, Name: file.Name.Name}
+				byName[file.Name.Name] = src
+			}
+			src.Files = append(src.Files, file)
+			src.FileNames = append(src.FileNames, path.Join(relDir, name))
+			if src.Doc == "" && file.Doc != nil {
+				src.Doc = file.Doc.Text()
+			}
+		}
+
+		var best *goPackageSource
+		for _, src := range byName {
+			if best == nil || len(src.Files) > len(best.Files) || (len(src.Files) == len(best.Files) && src.Name < best.Name) {
+				best = src
+			}
+		}
+		if best == nil {
+			return nil
+		}
+
+		switch {
+		case modulePath == "":
+			best.ImportPath = relDir
+		case relDir == ".":
+			best.ImportPath = modulePath
+		default:
+			best.ImportPath = modulePath + "/" + relDir
+		}
+		if len(best.Doc) > maxPackageDoc {
+			best.Doc = best.Doc[:maxPackageDoc]
+		}
+		pkgs = append(pkgs, best)
+		return nil
+	})
+	return fset, pkgs, err
+}
+
+// moduleImporter 仓库内的包按源码类型检查，外部依赖以空包代替，
+// 因此只能得到仓库内部的类型信息，但不需要下载依赖
+type moduleImporter struct {
+	fset     *token.FileSet
+	sources  map[string]*goPackageSource
+	packages map[string]*types.Package
+	infos    map[string]*types.Info
+	checking map[string]bool
+}
+
+func newModuleImporter(fset *token.FileSet, pkgs []*goPackageSource) *moduleImporter {
+	m := &moduleImporter{
+		fset:     fset,
+		sources:  map[string]*goPackageSource{},
+		packages: map[string]*types.Package{},
+		infos:    map[string]*types.Info{},
+		checking: map[string]bool{},
+	}
+	for _, src := range pkgs {
+		m.sources[src.ImportPath] = src
+	}
+	return m
+}
+
+func (m *moduleImporter) Import(importPath string) (*types.Package, error) {
+	if pkg, ok := m.packages[importPath]; ok {
+		return pkg, nil
+	}
+	src, ok := m.sources[importPath]
+	if !ok || m.checking[importPath] {
+		// 外部依赖或循环导入
+		pkg := types.NewPackage(importPath, path.Base(importPath))
+		pkg.MarkComplete()
+		if !ok {
+			m.packages[importPath] = pkg
+		}
+		return pkg, nil
+	}
+
+	m.checking[importPath] = true
+	defer delete(m.checking, importPath)
+
+	info := &types.Info{
+		Types:      map[ast.Expr]types.TypeAndValue{},
+		Defs:       map[*ast.Ident]types.Object{},
+		Uses:       map[*ast.Ident]types.Object{},
+		Selections: map[*ast.SelectorExpr]*types.Selection{},
+	}
+	conf := types.Config{
+		Importer:    m,
+		FakeImportC: true,
+		Error:       func(error) {}, // 容错：缺失依赖导致的错误不影响其余信息
+	}
+	pkg, _ := conf.Check(importPath, m.fset, src.Files, info)
+	m.packages[importPath] = pkg
+	m.infos[importPath] = info
+	return pkg, nil
+}
+
+// symbolCollector 从一个包的语法树中收集符号
+type symbolCollector struct {
+	fset   *token.FileSet
+	src    *goPackageSource
+	pkg    *types.Package
+	info   *types.Info
+	proj   models.Project
+	result []models.CodeSymbol
+}
+
+func (sc *symbolCollector) add(kind, name, parent, file string, pos, end token.Pos, signature string) {
+	if name == "" || name == "_" {
+		return
+	}
+	start := sc.fset.Position(pos)
+	if len(signature) > maxSignatureLen {
+		signature = signature[:maxSignatureLen] + "..."
+	}
+	sc.result = append(sc.result, models.CodeSymbol{
+		ProjectId: sc.proj.ID,
+		Commit:    sc.proj.HeadCommit,
+		Package:   sc.src.ImportPath,
+		Name:      name,
+		Kind:      kind,
+		Parent:    parent,
+		FilePath:  file,
+		Line:      start.Line,
+		Col:       start.Column,
+		EndLine:   sc.fset.Position(end).Line,
+		Signature: signature,
+		Exported:  ast.IsExported(name),
+	})
+}
+
+// nodeString 打印语法节点，多行结果压缩为一行
+func (sc *symbolCollector) nodeString(node interface{}) string {
+	var buf bytes.Buffer
+	if err := printer.Fprint(&buf, sc.fset, node); err != nil {
+		return ""
+	}
+	return strings.Join(strings.Fields(buf.String()), " ")
+}
+
+// objectType 用类型检查结果补全未显式声明类型的常量与变量，类型不完整时返回空字符串
+func (sc *symbolCollector) objectType(ident *ast.Ident) string {
+	if sc.info == nil {
+		return ""
+	}
+	obj := sc.info.Defs[ident]
+	if obj == nil || obj.Type() == nil {
+		return ""
+	}
+	s := types.TypeString(obj.Type(), types.RelativeTo(sc.pkg))
+	if strings.Contains(s, "invalid type") {
+		return ""
+	}
+	return s
+}
+
+// receiverName 方法接收者的类型名（去掉指针与类型参数）
+func receiverName(expr ast.Expr) string {
+	for {
+		switch t := expr.(type) {
+		case *ast.StarExpr:
+			expr = t.X
+		case *ast.IndexExpr:
+			expr = t.X
+		case *ast.IndexListExpr:
+			expr = t.X
+		case *ast.ParenExpr:
+			expr = t.X
+		case *ast.Ident:
+			return t.Name
+		default:
+			return ""
+		}
+	}
+}
+
+// embeddedName 嵌入字段的名字
+func embeddedName(expr ast.Expr) string {
+	if sel, ok := expr.(*ast.SelectorExpr); ok {
+		return sel.Sel.Name
+	}
+	return receiverName(expr)
+}
+
+func (sc *symbolCollector) collectFile(file *ast.File, name string) {
+	for _, decl := range file.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			header := *d
+			header.Body = nil
+			header.Doc = nil
+			kind, parent := models.SymbolKindFunc, ""
+			if d.Recv != nil && len(d.Recv.List) > 0 {
+				kind, parent = models.SymbolKindMethod, receiverName(d.Recv.List[0].Type)
+			}
+			sc.add(kind, d.Name.Name, parent, name, d.Name.Pos(), d.End(), sc.nodeString(&header))
+
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					sc.collectType(s, name)
+				case *ast.ValueSpec:
+					kind := models.SymbolKindVar
+					if d.Tok == token.CONST {
+						kind = models.SymbolKindConst
+					}
+					for _, ident := range s.Names {
+						typ := ""
+						if s.Type != nil {
+							typ = sc.nodeString(s.Type)
+						} else {
+							typ = sc.objectType(ident)
+						}
+						sig := strings.TrimSpace(fmt.Sprintf("%s %s %s", kind, ident.Name, typ))
+						sc.add(kind, ident.Name, "", name, ident.Pos(), s.End(), sig)
+					}
+				}
+			}
+		}
+	}
+}
+
+func (sc *symbolCollector) collectType(s *ast.TypeSpec, file string) {
+	head := "type " + s.Name.Name
+	if s.TypeParams != nil {
+		head += sc.nodeString(s.TypeParams)
+	}
+	if s.Assign.IsValid() {
+		head += " ="
+	}
+
+	switch t := s.Type.(type) {
+	case *ast.StructType:
+		sc.add(models.SymbolKindType, s.Name.Name, "", file, s.Name.Pos(), s.End(), head+" struct")
+		for _, field := range t.Fields.List {
+			typ := sc.nodeString(field.Type)
+			if len(field.Names) == 0 {
+				name := embeddedName(field.Type)
+				sc.add(models.SymbolKindField, name, s.Name.Name, file, field.Type.Pos(), field.End(), typ)
+				continue
+			}
+			for _, ident := range field.Names {
+				sc.add(models.SymbolKindField, ident.Name, s.Name.Name, file, ident.Pos(), field.End(), ident.Name+" "+typ)
+			}
+		}
+	case *ast.InterfaceType:
+		sc.add(models.SymbolKindType, s.Name.Name, "", file, s.Name.Pos(), s.End(), head+" interface")
+		for _, field := range t.Methods.List {
+			ft, ok := field.Type.(*ast.FuncType)
+			if !ok || len(field.Names) == 0 {
+				continue // 嵌入的接口或类型约束
+			}
+			sig := strings.TrimPrefix(sc.nodeString(ft), "func")
+			for _, ident := range field.Names {
+				sc.add(models.SymbolKindMethod, ident.Name, s.Name.Name, file, ident.Pos(), field.End(), ident.Name+sig)
+			}
+		}
+	default:
+		sc.add(models.SymbolKindType, s.Name.Name, "", file, s.Name.Pos(), s.End(), head+" "+sc.nodeString(s.Type))
+	}
+}
+
+// buildGoSymbolIndex 解析仓库中的 Go 代码并生成包与符号索引
+func buildGoSymbolIndex(proj models.Project, baseDir string) ([]models.GoPackage, []models.CodeSymbol, error) {
+	fset, srcs, err := parseGoPackages(baseDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Slice(srcs, func(i, j int) bool { return srcs[i].ImportPath < srcs[j].ImportPath })
+
+	imp := newModuleImporter(fset, srcs)
+	var pkgs []models.GoPackage
+	var syms []models.CodeSymbol
+	for _, src := range srcs {
+		pkg, _ := imp.Import(src.ImportPath)
+
+		pkgs = append(pkgs, models.GoPackage{
+			ProjectId:  proj.ID,
+			Commit:     proj.HeadCommit,
+			ImportPath: src.ImportPath,
+			Name:       src.Name,
+			Dir:        src.Dir,
+			FileCount:  len(src.Files),
+			Doc:        src.Doc,
+		})
+
+		sc := &symbolCollector{fset: fset, src: src, pkg: pkg, info: imp.infos[src.ImportPath], proj: proj}
+		first := src.Files[0]
+		sc.add(models.SymbolKindPackage, src.Name, "", src.FileNames[0], first.Name.Pos(), first.Name.End(),
+			fmt.Sprintf("package %s // %s", src.Name, src.ImportPath))
+		for i, file := range src.Files {
+			sc.collectFile(file, src.FileNames[i])
+		}
+		syms = append(syms, sc.result...)
+	}
+	return pkgs, syms, nil
+}
+
+// indexProjectSymbols 导入流程中的符号索引阶段，非 Go 项目不会产生任何符号
+func indexProjectSymbols(proj models.Project, baseDir string) error {
+	publishProjectEvent(proj.ID, SSEEvent{
+		Event: "symbols_start",
+		Data:  gin.H{"message": "开始构建符号索引", "project_id": proj.ID},
+	})
+
+	pkgs, syms, err := buildGoSymbolIndex(proj, baseDir)
+	if err == nil {
+		err = models.ReplaceProjectSymbols(proj.ID, pkgs, syms)
+	}
+	if err != nil {
+		publishProjectEvent(proj.ID, SSEEvent{
+			Event: "symbols_error",
+			Data: gin.H{
+				"message":    fmt.Sprintf("构建符号索引失败: %v", err),
+				"project_id": proj.ID,
+				"error":      err.Error(),
+			},
+		})
+		return err
+	}
+
+	publishProjectEvent(proj.ID, SSEEvent{
+		Event: "symbols_complete",
+		Data: gin.H{
+			"message":    "符号索引构建完成",
+			"project_id": proj.ID,
+			"packages":   len(pkgs),
+			"symbols":    len(syms),
+		},
+	})
+	return nil
+}
@@ -0,0 +1,152 @@
+package service
+
+import (
+	"CodeCampass/models"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// scoredSymbol 带匹配分数的符号
+type scoredSymbol struct {
+	models.CodeSymbol
+	Score int `json:"score"`
+}
+
+// fuzzyScore 模糊匹配得分：完全匹配 > 前缀 > 子串 > 子序列，不匹配返回 false。
+// 子序列匹配时连续命中和命中单词边界（驼峰、下划线、点号之后）加分，跳过的字符扣分
+func fuzzyScore(query, candidate string) (int, bool) {
+	q, c := strings.ToLower(query), strings.ToLower(candidate)
+	switch {
+	case q == c:
+		return 1000, true
+	case strings.HasPrefix(c, q):
+		return 800 - (len(c) - len(q)), true
+	}
+	if idx := strings.Index(c, q); idx >= 0 {
+		return 600 - idx - (len(c) - len(q)), true
+	}
+
+	runes := []rune(candidate)
+	lower := []rune(c)
+	qr := []rune(q)
+	score, qi, last := 0, 0, -1
+	for i := 0; i < len(lower) && qi < len(qr); i++ {
+		if lower[i] != qr[qi] {
+			continue
+		}
+		score += 10
+		if last == i-1 {
+			score += 15
+		}
+		if i == 0 || runes[i-1] == '_' || runes[i-1] == '.' ||
+			(unicode.IsUpper(runes[i]) && unicode.IsLower(runes[i-1])) {
+			score += 20
+		}
+		if last >= 0 {
+			score -= i - last - 1
+		}
+		last = i
+		qi++
+	}
+	if qi < len(qr) {
+		return 0, false
+	}
+	return score, true
+}
+
+// symbolScore 符号的匹配得分，同时尝试 名字、所属类型.名字、包名.名字
+func symbolScore(query string, sym models.CodeSymbol) (int, bool) {
+	best, ok := fuzzyScore(query, sym.Name)
+	qualified := []string{path.Base(sym.Package) + "." + sym.Name}
+	if sym.Parent != "" {
+		qualified = append(qualified, sym.Parent+"."+sym.Name)
+	}
+	if strings.Contains(query, ".") {
+		for _, name := range qualified {
+			if s, matched := fuzzyScore(query, name); matched && (!ok || s-5 > best) {
+				best, ok = s-5, true
+			}
+		}
+	}
+	if ok && sym.Exported {
+		best += 5
+	}
+	return best, ok
+}
+
+// SearchSymbolsV1
+// @Summary 搜索项目符号（Go 包、类型、函数、方法、字段、常量、变量）
+// @Tags 项目模块 v1
+// @Security Bearer
+// @Param id path int true "项目ID"
+// @Param q query string false "模糊匹配符号名，可写作 Type.Method 或 pkg.Name"
+// @Param kind query string false "符号类型，多个用逗号分隔：package/type/func/method/field/const/var"
+// @Param package query string false "限定包导入路径"
+// @Param limit query int false "返回数量，默认 50，最大 200"
+// @Success 200 {object} map[string]interface{}
+// @Router /api/v1/projects/{id}/symbols [get]
+func SearchSymbolsV1(c *gin.Context) {
+	proj, ok := loadProjectV1(c)
+	if !ok {
+		return
+	}
+
+	var kinds []string
+	for _, k := range strings.Split(c.Query("kind"), ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			kinds = append(kinds, k)
+		}
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 {
+		respondError(c, newAPIError(http.StatusBadRequest, "无效的 limit"))
+		return
+	}
+	if limit > 200 {
+		limit = 200
+	}
+
+	syms, err := models.FindProjectSymbols(proj.ID, kinds, c.Query("package"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	query := strings.TrimSpace(c.Query("q"))
+	items := make([]scoredSymbol, 0, len(syms))
+	for _, sym := range syms {
+		if query == "" {
+			items = append(items, scoredSymbol{CodeSymbol: sym})
+			continue
+		}
+		if score, matched := symbolScore(query, sym); matched {
+			items = append(items, scoredSymbol{CodeSymbol: sym, Score: score})
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		a, b := items[i], items[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if a.FilePath != b.FilePath {
+			return a.FilePath < b.FilePath
+		}
+		return a.Line < b.Line
+	})
+
+	total := len(items)
+	if len(items) > limit {
+		items = items[:limit]
+	}
+	respondOK(c, http.StatusOK, "查询成功", gin.H{
+		"commit": proj.HeadCommit,
+		"total":  total,
+		"items":  items,
+	})
+}
@@ -0,0 +1,463 @@
+package service
+
+import (
+	"CodeCampass/models"
+	"CodeCampass/utils"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/robfig/cron/v3"
+	"github.com/spf13/viper"
+)
+
+// 自动同步：按计划拉取远端的新提交并增量重建索引。每个实例都运行调度器，
+// 同一项目同一时间只允许一个实例同步，由 Redis 中的锁保证
+
+// 同步的触发方式
+const (
+	syncTriggerSchedule = "schedule" // 按计划
+	syncTriggerManual   = "manual"   // 手动触发
+	syncTriggerWebhook  = "webhook"  // 代码托管平台推送通知
+)
+
+const (
+	syncLockPrefix     = "sync_lock:"
+	syncPendingPrefix  = "sync_pending:" // 同步进行中又收到推送时做标记，当前同步结束后再同步一次
+	syncReloadInterval = time.Minute     // 调度器从数据库重新加载计划的间隔，其他实例修改的计划由此生效
+)
+
+var errSyncRunning = newAPIError(http.StatusConflict, "项目正在同步或导入")
+
+// syncInstanceID 当前实例的标识，写入锁中便于排查
+var syncInstanceID = func() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}()
+
+// syncMinInterval 同步计划允许的最小间隔，由 sync.minInterval 配置，默认 10 分钟
+func syncMinInterval() time.Duration {
+	if d := viper.GetDuration("sync.minInterval"); d > 0 {
+		return d
+	}
+	return 10 * time.Minute
+}
+
+// syncLockTTL 同步锁的过期时间，同步期间定期续期；实例崩溃时锁在到期后自动释放
+func syncLockTTL() time.Duration {
+	if d := viper.GetDuration("sync.lockTTL"); d > 0 {
+		return d
+	}
+	return 5 * time.Minute
+}
+
+// parseSyncSchedule 解析同步计划：cron 表达式（五段）、@daily 之类的写法或 6h 这样的间隔，
+// 返回规范化后的写法
+func parseSyncSchedule(spec string) (string, cron.Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return "", nil, newAPIError(http.StatusBadRequest, "同步计划不能为空")
+	}
+	if _, err := time.ParseDuration(spec); err == nil {
+		spec = "@every " + spec
+	}
+	sched, err := cron.ParseStandard(spec)
+	if err != nil {
+		return "", nil, newAPIError(http.StatusBadRequest, "无效的同步计划: "+err.Error())
+	}
+	// cron 表达式的间隔可能不均匀，检查接下来若干次执行的间隔
+	min := syncMinInterval()
+	t := sched.Next(time.Now())
+	for i := 0; i < 100; i++ {
+		next := sched.Next(t)
+		if next.IsZero() {
+			break
+		}
+		if next.Sub(t) < min {
+			return "", nil, newAPIError(http.StatusBadRequest, fmt.Sprintf("同步间隔不能小于 %s", min))
+		}
+		t = next
+	}
+	return spec, sched, nil
+}
+
+// 解锁与续期时确认锁仍由自己持有，避免误删其他实例在锁过期后取得的锁
+var (
+	releaseLockScript = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("del", KEYS[1]) end return 0`
+	refreshLockScript = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("pexpire", KEYS[1], ARGV[2]) end return 0`
+)
+
+// acquireProjectLock 取得项目的锁（同步、概览生成等），prefix 区分锁的用途，返回用于释放的令牌；
+// 锁已被占用时返回空字符串
+func acquireProjectLock(ctx context.Context, prefix string, projectID uint) (string, error) {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	token := syncInstanceID + ":" + hex.EncodeToString(buf)
+	ok, err := utils.Red.SetNX(ctx, fmt.Sprintf("%s%d", prefix, projectID), token, syncLockTTL()).Result()
+	if err != nil || !ok {
+		return "", err
+	}
+	return token, nil
+}
+
+// releaseProjectLock 释放项目的锁
+func releaseProjectLock(ctx context.Context, prefix string, projectID uint, token string) {
+	utils.Red.Eval(ctx, releaseLockScript, []string{fmt.Sprintf("%s%d", prefix, projectID)}, token)
+}
+
+// keepProjectLock 持有锁期间定期续期，返回停止续期的函数
+func keepProjectLock(prefix string, projectID uint, token string) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	ttl := syncLockTTL()
+	go func() {
+		ticker := time.NewTicker(ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				utils.Red.Eval(ctx, refreshLockScript, []string{fmt.Sprintf("%s%d", prefix, projectID)},
+					token, ttl.Milliseconds())
+			}
+		}
+	}()
+	return cancel
+}
+
+// syncResult 一次同步的结果
+type syncResult struct {
+	OldCommit string `json:"old_commit"`
+	NewCommit string `json:"new_commit"`
+	Changed   int    `json:"changed"` // 新增与修改的文件数
+	Deleted   int    `json:"deleted"`
+	UpToDate  bool   `json:"up_to_date"`
+}
+
+// syncRef 同步时拉取的远端引用：跟踪的分支，未设置时为远端的默认分支
+func syncRef(proj models.Project) string {
+	if proj.TrackedRef != "" {
+		return "refs/heads/" + proj.TrackedRef
+	}
+	return "HEAD"
+}
+
+// fetchArgs 按项目的历史深度生成 git fetch 参数
+func fetchArgs(proj models.Project) []string {
+	args := []string{"fetch", "--no-tags"}
+	switch {
+	case proj.HistoryDepth == 0:
+		args = append(args, "--depth", "1")
+	case proj.HistoryDepth > 0:
+		args = append(args, "--depth", fmt.Sprint(proj.HistoryDepth))
+	}
+	return append(args, "origin", syncRef(proj))
+}
+
+// diffChangedFiles 两个提交之间变更的文件：changed 包含新增、修改与删除的文件，deleted 为其中被删除的数量；
+// 子模块的提交有变化时无法得知其中哪些文件变了，返回 submodules 为 true
+func diffChangedFiles(baseDir, oldCommit, newCommit string) (changed map[string]bool, deleted int, submodules bool, err error) {
+	out, err := runGit(baseDir, "diff", "--raw", "-z", "--no-renames", oldCommit, newCommit)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	changed = make(map[string]bool)
+	// 每一项为 ":<旧模式> <新模式> <旧对象> <新对象> <状态>" 与路径两段
+	fields := strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00")
+	for i := 0; i+1 < len(fields); i += 2 {
+		meta := strings.Fields(strings.TrimPrefix(fields[i], ":"))
+		if len(meta) < 5 {
+			continue
+		}
+		changed[fields[i+1]] = true
+		if meta[0] == "160000" || meta[1] == "160000" {
+			submodules = true
+		}
+		if meta[4] == "D" {
+			deleted++
+		}
+	}
+	return changed, deleted, submodules, nil
+}
+
+// resetWorkTree 把工作区切换到指定提交，不自动下载 LFS 对象
+func resetWorkTree(baseDir, commit string) error {
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", "-C", baseDir, "reset", "--hard", "-q", commit)
+	cmd.Env = gitEnv()
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("git reset 失败: %s", strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// syncProject 拉取远端的新提交，有变化时增量重建索引
+func syncProject(proj models.Project) (syncResult, error) {
+	var res syncResult
+	baseDir, err := clonedRepoDir(proj)
+	if err != nil {
+		return res, err
+	}
+	res.OldCommit = gitHeadCommit(baseDir)
+
+	// 拉取过程中同样由看门狗检查仓库大小
+	quota := userQuota(proj.OwnerId)
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", append([]string{"-C", baseDir}, fetchArgs(proj)...)...)
+	cmd.Stderr = &stderr
+	if err := runWithSizeWatchdog(cmd, baseDir, quota.repoSizeLimit(proj)); err != nil {
+		if errorStatus(err) != http.StatusInternalServerError {
+			return res, err
+		}
+		return res, fmt.Errorf("git fetch 失败: %v %s", err, strings.TrimSpace(stderr.String()))
+	}
+	out, err := runGit(baseDir, "rev-parse", "FETCH_HEAD")
+	if err != nil {
+		return res, err
+	}
+	res.NewCommit = strings.TrimSpace(string(out))
+	if res.NewCommit == res.OldCommit && proj.HeadCommit == res.NewCommit {
+		res.UpToDate = true
+		return res, nil
+	}
+
+	changed, deleted, submodules, err := diffChangedFiles(baseDir, res.OldCommit, res.NewCommit)
+	res.Changed, res.Deleted = len(changed)-deleted, deleted
+	// 无法比较（如旧提交已不存在）或子模块有变化时重建全部 embedding
+	if err != nil || (submodules && proj.Submodules) {
+		changed = nil
+	}
+	if err := resetWorkTree(baseDir, res.NewCommit); err != nil {
+		return res, err
+	}
+	if err := prepareWorkTree(proj, baseDir, quota.repoSizeLimit(proj)); err != nil {
+		resetWorkTree(baseDir, res.OldCommit)
+		return res, err
+	}
+
+	langCounts, err := indexRepoFiles(proj, baseDir, quota.MaxFiles)
+	if err != nil {
+		// 新提交超出文件数配额时回到旧提交，已有的索引仍然对应工作区
+		resetWorkTree(baseDir, res.OldCommit)
+		return res, err
+	}
+	proj.HeadCommit = res.NewCommit
+	utils.DB.Model(&proj).Updates(map[string]interface{}{
+		"language":     dominantLanguage(langCounts),
+		"index_status": models.IndexStatusIndexing,
+		"head_commit":  proj.HeadCommit,
+	})
+	return res, buildProjectIndexes(proj, baseDir, changed)
+}
+
+// lockProjectSync 取得项目的同步锁，导入与同步共用，同一时间只有一个流程改动仓库目录；
+// 返回的函数释放锁，持有期间收到过推送时再同步一次
+func lockProjectSync(projectID uint) (func(), error) {
+	ctx := context.Background()
+	token, err := acquireProjectLock(ctx, syncLockPrefix, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("获取同步锁失败: %v", err)
+	}
+	if token == "" {
+		return nil, errSyncRunning
+	}
+	stop := keepProjectLock(syncLockPrefix, projectID, token)
+	return func() {
+		stop()
+		releaseProjectLock(ctx, syncLockPrefix, projectID, token)
+		// 释放锁之后再检查标记，标记方在锁被占用时写入标记后会再尝试一次加锁，推送不会丢失
+		if utils.Red.Del(ctx, fmt.Sprintf("%s%d", syncPendingPrefix, projectID)).Val() > 0 {
+			enqueueProjectSync(projectID, syncTriggerWebhook)
+		}
+	}, nil
+}
+
+// enqueueProjectSync 在后台同步项目；项目正在同步或导入时返回 409
+func enqueueProjectSync(projectID uint, trigger string) error {
+	unlock, err := lockProjectSync(projectID)
+	if err != nil {
+		return err
+	}
+	// 取得锁后再读取项目，避免使用其他实例同步前的旧状态
+	var proj models.Project
+	if err := utils.DB.Where("id = ?", projectID).First(&proj).Error; err != nil {
+		unlock()
+		return newAPIError(http.StatusNotFound, "项目不存在")
+	}
+	go func() {
+		defer unlock()
+		runProjectSync(proj, trigger)
+	}()
+	return nil
+}
+
+// RecoverInterruptedImports 启动时处理上次退出时中断的导入与同步：状态仍为克隆中或建立索引中、
+// 但没有实例持有同步锁的项目标记为失败，下次同步或重新导入时重建
+func RecoverInterruptedImports() {
+	ctx := context.Background()
+	for _, id := range models.GetProjectIdsByIndexStatus(models.IndexStatusCloning, models.IndexStatusIndexing) {
+		token, err := acquireProjectLock(ctx, syncLockPrefix, id)
+		if err != nil || token == "" {
+			continue // 其他实例仍在处理
+		}
+		models.UpdateProjectIndexStatus(id, models.IndexStatusFailed)
+		releaseProjectLock(ctx, syncLockPrefix, id, token)
+	}
+}
+
+// requestProjectSync 收到推送时同步项目；正在同步时标记为待同步，当前同步结束后再执行一次，
+// 返回是否推迟到当前同步结束后执行
+func requestProjectSync(projectID uint) (bool, error) {
+	err := enqueueProjectSync(projectID, syncTriggerWebhook)
+	if !errors.Is(err, errSyncRunning) {
+		return false, err
+	}
+	ctx := context.Background()
+	if err := utils.Red.Set(ctx, fmt.Sprintf("%s%d", syncPendingPrefix, projectID), 1, syncLockTTL()*12).Err(); err != nil {
+		return false, err
+	}
+	// 写入标记前同步可能刚好结束，再尝试一次
+	if err := enqueueProjectSync(projectID, syncTriggerWebhook); err != nil && !errors.Is(err, errSyncRunning) {
+		return false, err
+	}
+	return true, nil
+}
+
+// runProjectSync 执行同步，记录结果并通过项目的 SSE 推送
+func runProjectSync(proj models.Project, trigger string) {
+	start := time.Now()
+	publishProjectEvent(proj.ID, SSEEvent{
+		Event: "sync_start",
+		Data: gin.H{
+			"message":    "开始同步仓库",
+			"project_id": proj.ID,
+			"trigger":    trigger,
+		},
+	})
+
+	res, err := syncProject(proj)
+	if err != nil {
+		fmt.Printf("警告: 同步项目 %d 失败: %v\n", proj.ID, err)
+		models.UpdateProjectSyncResult(proj.ID, models.SyncStatusFailed, err.Error(), "", start)
+		publishProjectEvent(proj.ID, SSEEvent{
+			Event: "sync_error",
+			Data: gin.H{
+				"message":    fmt.Sprintf("同步仓库失败: %v", err),
+				"project_id": proj.ID,
+				"trigger":    trigger,
+				"error":      err.Error(),
+			},
+		})
+		return
+	}
+
+	// 没有新提交时发布单独的事件，订阅 sync_complete 的通知只在仓库有变化时发送
+	status, event, message := models.SyncStatusSuccess, "sync_complete", "同步完成"
+	if res.UpToDate {
+		status, event, message = models.SyncStatusUpToDate, "sync_up_to_date", "仓库已是最新"
+	}
+	models.UpdateProjectSyncResult(proj.ID, status, "", res.NewCommit, start)
+	publishProjectEvent(proj.ID, SSEEvent{
+		Event: event,
+		Data: gin.H{
+			"message":    message,
+			"project_id": proj.ID,
+			"trigger":    trigger,
+			"result":     res,
+		},
+	})
+}
+
+// syncScheduler 按计划触发同步
+type syncScheduler struct {
+	cron    *cron.Cron
+	mu      sync.Mutex
+	entries map[uint]syncEntry
+}
+
+type syncEntry struct {
+	id       cron.EntryID
+	schedule string
+}
+
+var scheduler *syncScheduler
+
+// StartSyncScheduler 启动自动同步调度器，sync.scheduler 为 false 时本实例不执行计划
+func StartSyncScheduler() {
+	if viper.IsSet("sync.scheduler") && !viper.GetBool("sync.scheduler") {
+		return
+	}
+	scheduler = &syncScheduler{cron: cron.New(), entries: map[uint]syncEntry{}}
+	scheduler.cron.Start()
+	scheduler.reload()
+	go func() {
+		for range time.Tick(syncReloadInterval) {
+			scheduler.reload()
+		}
+	}()
+}
+
+// reloadSyncSchedules 计划修改后立即生效
+func reloadSyncSchedules() {
+	if scheduler != nil {
+		scheduler.reload()
+	}
+}
+
+// reload 按数据库中启用的计划增删调度任务
+func (s *syncScheduler) reload() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	active := map[uint]bool{}
+	for _, ps := range models.GetEnabledProjectSyncs() {
+		active[ps.ProjectId] = true
+		if e, ok := s.entries[ps.ProjectId]; ok {
+			if e.schedule == ps.Schedule {
+				continue
+			}
+			s.cron.Remove(e.id)
+			delete(s.entries, ps.ProjectId)
+		}
+		_, sched, err := parseSyncSchedule(ps.Schedule)
+		if err != nil {
+			fmt.Printf("警告: 项目 %d 的同步计划无效: %v\n", ps.ProjectId, err)
+			continue
+		}
+		projectID := ps.ProjectId
+		id := s.cron.Schedule(sched, cron.FuncJob(func() { scheduledSync(projectID) }))
+		s.entries[projectID] = syncEntry{id: id, schedule: ps.Schedule}
+	}
+	for projectID, e := range s.entries {
+		if !active[projectID] {
+			s.cron.Remove(e.id)
+			delete(s.entries, projectID)
+		}
+	}
+}
+
+// scheduledSync 计划触发的同步。各实例的调度器都会触发，取得锁的实例执行；
+// 锁释放后其他实例可能才触发，最近刚同步过时跳过
+func scheduledSync(projectID uint) {
+	ps, err := models.FindProjectSync(projectID)
+	if err != nil || !ps.Enabled {
+		return
+	}
+	if ps.LastRunAt != nil && time.Since(*ps.LastRunAt) < syncMinInterval()/2 {
+		return
+	}
+	if err := enqueueProjectSync(projectID, syncTriggerSchedule); err != nil && !errors.Is(err, errSyncRunning) {
+		fmt.Printf("项目 %d 跳过本次同步: %v\n", projectID, err)
+	}
+}
@@ -0,0 +1,127 @@
+package service
+
+import (
+	"CodeCampass/models"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// syncRequest 修改同步计划的请求，schedule 与 interval 二选一
+type syncRequest struct {
+	Schedule string `json:"schedule"` // cron 表达式，如 0 3 * * *
+	Interval string `json:"interval"` // 间隔，如 6h
+	Enabled  *bool  `json:"enabled"`
+}
+
+// syncData 同步计划与下次执行时间
+func syncData(ps models.ProjectSync) gin.H {
+	data := gin.H{"sync": ps, "next_run_at": nil}
+	if ps.Enabled {
+		if _, sched, err := parseSyncSchedule(ps.Schedule); err == nil {
+			data["next_run_at"] = sched.Next(time.Now())
+		}
+	}
+	return data
+}
+
+// GetProjectSyncV1
+// @Summary 查看项目的自动同步计划
+// @Tags 项目模块 v1
+// @Security Bearer
+// @Param id path int true "项目ID"
+// @Success 200 {object} map[string]interface{}
+// @Router /api/v1/projects/{id}/sync [get]
+func GetProjectSyncV1(c *gin.Context) {
+	proj, ok := loadProjectV1(c)
+	if !ok {
+		return
+	}
+	ps, err := models.FindProjectSync(proj.ID)
+	if err != nil {
+		ps = models.ProjectSync{ProjectId: proj.ID}
+	}
+	respondOK(c, http.StatusOK, "获取成功", syncData(ps))
+}
+
+// UpdateProjectSyncV1
+// @Summary 设置项目的自动同步计划
+// @Description 按 cron 表达式或间隔定期拉取远端的新提交并增量重建索引，结果通过项目的 SSE 推送
+// @Tags 项目模块 v1
+// @Security Bearer
+// @Param id path int true "项目ID"
+// @Param body body syncRequest true "schedule 为 cron 表达式，interval 为间隔（如 6h），enabled 为是否启用"
+// @Success 200 {object} map[string]interface{}
+// @Router /api/v1/projects/{id}/sync [put]
+func UpdateProjectSyncV1(c *gin.Context) {
+	proj, ok := loadProjectV1(c)
+	if !ok {
+		return
+	}
+	userID, _ := c.Get("userID")
+	if !canManageProject(userID.(uint), proj) {
+		respondError(c, newAPIError(http.StatusForbidden, "无权限修改此项目的同步计划"))
+		return
+	}
+	var req syncRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, newAPIError(http.StatusBadRequest, "参数错误"))
+		return
+	}
+	if req.Schedule != "" && req.Interval != "" {
+		respondError(c, newAPIError(http.StatusBadRequest, "schedule 与 interval 只能指定一个"))
+		return
+	}
+
+	ps, err := models.FindProjectSync(proj.ID)
+	if err != nil {
+		ps = models.ProjectSync{ProjectId: proj.ID, Enabled: true}
+	}
+	if spec := req.Schedule + req.Interval; spec != "" {
+		normalized, _, err := parseSyncSchedule(spec)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		ps.Schedule = normalized
+	}
+	if req.Enabled != nil {
+		ps.Enabled = *req.Enabled
+	}
+	if ps.Enabled && ps.Schedule == "" {
+		respondError(c, newAPIError(http.StatusBadRequest, "同步计划不能为空"))
+		return
+	}
+	if err := models.SaveProjectSync(&ps); err != nil {
+		respondError(c, err)
+		return
+	}
+	reloadSyncSchedules()
+	respondOK(c, http.StatusOK, "同步计划已保存", syncData(ps))
+}
+
+// RunProjectSyncV1
+// @Summary 立即同步项目
+// @Description 在后台拉取远端的新提交并增量重建索引，进度与结果通过项目的 SSE 推送（sync_start、sync_complete、sync_up_to_date、sync_error）
+// @Tags 项目模块 v1
+// @Security Bearer
+// @Param id path int true "项目ID"
+// @Success 202 {object} map[string]interface{}
+// @Router /api/v1/projects/{id}/sync/run [post]
+func RunProjectSyncV1(c *gin.Context) {
+	proj, ok := loadProjectV1(c)
+	if !ok {
+		return
+	}
+	userID, _ := c.Get("userID")
+	if !canManageProject(userID.(uint), proj) {
+		respondError(c, newAPIError(http.StatusForbidden, "无权限同步此项目"))
+		return
+	}
+	if err := enqueueProjectSync(proj.ID, syncTriggerManual); err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusAccepted, "同步已开始", nil)
+}
@@ -0,0 +1,309 @@
+package service
+
+import (
+	"CodeCampass/models"
+	"CodeCampass/storage"
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// 代码搜索使用 codesearch 风格的三元组索引：导入时为每个文本文件提取所有（小写化的）
+// 三字节组合，查询时先用查询中必然出现的字面量求候选文件，再逐个文件用正则校验
+
+const (
+	trigramIndexVersion = 1
+	maxIndexedFileSize  = 1 << 20
+)
+
+// indexedFile 索引中的一个文件
+type indexedFile struct {
+	Path     string
+	Language string
+}
+
+// trigramIndex 序列化到磁盘的索引
+type trigramIndex struct {
+	Version  int
+	Commit   string
+	Files    []indexedFile
+	Postings map[uint32][]uint32 // 三元组 -> 升序的文件序号
+}
+
+// searchIndexExt 搜索索引文件的扩展名
+const searchIndexExt = "idx"
+
+// searchIndexPath 项目搜索索引的存放位置，放在仓库目录旁边以免被当作仓库文件
+func searchIndexPath(proj models.Project) string {
+	return storage.SidecarPath(proj.OwnerId, proj.ID, searchIndexExt)
+}
+
+func trigramOf(b []byte, i int) uint32 {
+	return uint32(b[i])<<16 | uint32(b[i+1])<<8 | uint32(b[i+2])
+}
+
+// isBinaryContent 前 8KB 中出现 NUL 字节的内容视为二进制
+func isBinaryContent(data []byte) bool {
+	head := data
+	if len(head) > 8192 {
+		head = head[:8192]
+	}
+	return bytes.IndexByte(head, 0) >= 0
+}
+
+// buildTrigramIndex 为仓库中的文本文件建立三元组索引
+func buildTrigramIndex(baseDir, commit string) (*trigramIndex, error) {
+	idx := &trigramIndex{Version: trigramIndexVersion, Commit: commit, Postings: map[uint32][]uint32{}}
+	err := filepath.Walk(baseDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() {
+			if p != baseDir && skipSourceDir(info.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() || info.Size() > maxIndexedFileSize {
+			return nil
+		}
+		data, err := os.ReadFile(p)
+		if err != nil || isBinaryContent(data) {
+			return nil
+		}
+
+		rel, _ := filepath.Rel(baseDir, p)
+		id := uint32(len(idx.Files))
+		idx.Files = append(idx.Files, indexedFile{Path: filepath.ToSlash(rel), Language: detectLanguage(p)})
+
+		lower := bytes.ToLower(data)
+		seen := map[uint32]bool{}
+		for i := 0; i+3 <= len(lower); i++ {
+			t := trigramOf(lower, i)
+			if !seen[t] {
+				seen[t] = true
+				idx.Postings[t] = append(idx.Postings[t], id)
+			}
+		}
+		return nil
+	})
+	return idx, err
+}
+
+// writeTrigramIndex 先写临时文件再改名，避免查询读到写了一半的索引
+func writeTrigramIndex(idx *trigramIndex, dest string) error {
+	tmp := dest + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(f).Encode(idx); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dest)
+}
+
+func readTrigramIndex(src string) (*trigramIndex, error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var idx trigramIndex
+	if err := gob.NewDecoder(f).Decode(&idx); err != nil {
+		return nil, err
+	}
+	if idx.Version != trigramIndexVersion {
+		return nil, fmt.Errorf("索引版本 %d 已过期", idx.Version)
+	}
+	return &idx, nil
+}
+
+// 已加载的索引按文件路径与修改时间缓存
+var trigramCache = struct {
+	sync.Mutex
+	entries map[string]*trigramCacheEntry
+	order   []string
+}{entries: map[string]*trigramCacheEntry{}}
+
+type trigramCacheEntry struct {
+	modTime int64
+	index   *trigramIndex
+}
+
+const maxTrigramCacheEntries = 8
+
+// loadTrigramIndex 读取索引，文件未变化时使用缓存
+func loadTrigramIndex(src string) (*trigramIndex, error) {
+	info, err := os.Stat(src)
+	if err != nil {
+		return nil, err
+	}
+
+	trigramCache.Lock()
+	entry, ok := trigramCache.entries[src]
+	trigramCache.Unlock()
+	if ok && entry.modTime == info.ModTime().UnixNano() {
+		return entry.index, nil
+	}
+
+	idx, err := readTrigramIndex(src)
+	if err != nil {
+		return nil, err
+	}
+
+	trigramCache.Lock()
+	defer trigramCache.Unlock()
+	if _, exists := trigramCache.entries[src]; !exists {
+		trigramCache.order = append(trigramCache.order, src)
+	}
+	trigramCache.entries[src] = &trigramCacheEntry{modTime: info.ModTime().UnixNano(), index: idx}
+	if len(trigramCache.order) > maxTrigramCacheEntries {
+		delete(trigramCache.entries, trigramCache.order[0])
+		trigramCache.order = trigramCache.order[1:]
+	}
+	return idx, nil
+}
+
+// requiredLiterals 从正则中提取匹配结果必然包含的字面量（只分析顶层的连接，
+// 分支、重复等结构视为没有约束），用于缩小候选文件范围
+func requiredLiterals(expr string) []string {
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return nil
+	}
+	re = re.Simplify()
+
+	var lits []string
+	var cur []rune
+	flush := func() {
+		if len(cur) >= 3 {
+			lits = append(lits, string(cur))
+		}
+		cur = nil
+	}
+	var walk func(r *syntax.Regexp)
+	walk = func(r *syntax.Regexp) {
+		switch r.Op {
+		case syntax.OpLiteral:
+			cur = append(cur, r.Rune...)
+		case syntax.OpCapture:
+			walk(r.Sub[0])
+		case syntax.OpConcat:
+			for _, sub := range r.Sub {
+				walk(sub)
+			}
+		case syntax.OpPlus:
+			// x+ 至少出现一次 x，但之后的内容不一定紧邻
+			walk(r.Sub[0])
+			flush()
+		case syntax.OpBeginLine, syntax.OpEndLine, syntax.OpBeginText, syntax.OpEndText,
+			syntax.OpWordBoundary, syntax.OpNoWordBoundary, syntax.OpEmptyMatch:
+			// 零宽断言不打断字面量
+		default:
+			flush()
+		}
+	}
+	walk(re)
+	flush()
+	return lits
+}
+
+// candidates 返回包含所有字面量的文件序号，没有可用字面量时返回全部文件
+func (idx *trigramIndex) candidates(literals []string) []uint32 {
+	var result []uint32
+	first := true
+	for _, lit := range literals {
+		lower := []byte(strings.ToLower(lit))
+		for i := 0; i+3 <= len(lower); i++ {
+			list := idx.Postings[trigramOf(lower, i)]
+			if first {
+				result = append([]uint32(nil), list...)
+				first = false
+			} else {
+				result = intersectPostings(result, list)
+			}
+			if len(result) == 0 {
+				return result
+			}
+		}
+	}
+	if first {
+		result = make([]uint32, len(idx.Files))
+		for i := range result {
+			result[i] = uint32(i)
+		}
+	}
+	return result
+}
+
+func intersectPostings(a, b []uint32) []uint32 {
+	out := a[:0]
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			out = append(out, a[i])
+			i++
+			j++
+		case a[i] < b[j]:
+			i++
+		default:
+			j++
+		}
+	}
+	return out
+}
+
+// globRegexp 把路径 glob 转为正则：** 匹配任意层目录，* 与 ? 不跨越 /；
+// 不含 / 的 glob 只匹配文件名
+func globRegexp(glob string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	if !strings.Contains(glob, "/") {
+		b.WriteString(`(^|/)`)
+	} else {
+		b.WriteString(`^`)
+	}
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				i++
+				if i+1 < len(glob) && glob[i+1] == '/' {
+					i++
+					b.WriteString(`(.*/)?`)
+				} else {
+					b.WriteString(`.*`)
+				}
+			} else {
+				b.WriteString(`[^/]*`)
+			}
+		case '?':
+			b.WriteString(`[^/]`)
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString(`$`)
+	return regexp.Compile(b.String())
+}
+
+// sortedFileIDs 按路径排序候选文件，保证分页结果稳定
+func (idx *trigramIndex) sortedFileIDs(ids []uint32) []uint32 {
+	sort.Slice(ids, func(i, j int) bool { return idx.Files[ids[i]].Path < idx.Files[ids[j]].Path })
+	return ids
+}
@@ -0,0 +1,278 @@
+package service
+
+import (
+	"CodeCampass/models"
+	"CodeCampass/utils"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/spf13/viper"
+)
+
+// modelPrice 模型单价（美元 / 百万 token），embedding 模型只需 prompt
+type modelPrice struct {
+	Model      string  `mapstructure:"model"`
+	Prompt     float64 `mapstructure:"prompt"`
+	Completion float64 `mapstructure:"completion"`
+}
+
+// defaultPrices 配置 pricing 未覆盖时使用的内置单价
+var defaultPrices = []modelPrice{
+	{Model: "gpt-4o-mini", Prompt: 0.15, Completion: 0.6},
+	{Model: "gpt-4o", Prompt: 2.5, Completion: 10},
+	{Model: "gpt-4.1-mini", Prompt: 0.4, Completion: 1.6},
+	{Model: "gpt-4.1", Prompt: 2, Completion: 8},
+	{Model: "gpt-3.5-turbo", Prompt: 0.5, Completion: 1.5},
+	{Model: "text-embedding-3-small", Prompt: 0.02},
+	{Model: "text-embedding-3-large", Prompt: 0.13},
+	{Model: "text-embedding-ada-002", Prompt: 0.1},
+}
+
+// lookupPrice 查找模型单价，配置优先；按前缀匹配以兼容带日期后缀的模型名，取最长匹配
+func lookupPrice(model string) modelPrice {
+	var configured []modelPrice
+	_ = viper.UnmarshalKey("pricing", &configured)
+
+	model = strings.ToLower(model)
+	for _, prices := range [][]modelPrice{configured, defaultPrices} {
+		best := modelPrice{}
+		for _, p := range prices {
+			name := strings.ToLower(p.Model)
+			if strings.HasPrefix(model, name) && len(name) > len(best.Model) {
+				best = p
+				best.Model = name
+			}
+		}
+		if best.Model != "" {
+			return best
+		}
+	}
+	return modelPrice{Model: model}
+}
+
+// estimateCost 按单价估算一次调用的花费
+func estimateCost(model string, promptTokens, completionTokens int) float64 {
+	p := lookupPrice(model)
+	return (float64(promptTokens)*p.Prompt + float64(completionTokens)*p.Completion) / 1e6
+}
+
+// usageScope 用量记录归属
+type usageScope struct {
+	UserID    uint
+	OrgID     uint
+	ProjectID uint
+}
+
+// projectUsageScope 项目调用的用量归属，组织项目同时计入组织
+func projectUsageScope(proj models.Project, userID uint) usageScope {
+	return usageScope{UserID: userID, OrgID: proj.OrgId, ProjectID: proj.ID}
+}
+
+// meteredClient 包装 OpenAI 客户端，每次成功调用后写入用量记录
+type meteredClient struct {
+	client       *openai.Client
+	scope        usageScope
+	credentialID uint
+
+	mu   sync.Mutex
+	cost float64 // 本客户端累计花费，用于长任务中途检查预算
+}
+
+// newMeteredClient 创建带用量记录的客户端
+func (cfg llmConfig) newMeteredClient(scope usageScope) *meteredClient {
+	return &meteredClient{client: cfg.newClient(), scope: scope, credentialID: cfg.CredentialID}
+}
+
+func (m *meteredClient) CreateChatCompletion(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	resp, err := m.client.CreateChatCompletion(ctx, req)
+	if err != nil {
+		return resp, err
+	}
+	model := resp.Model
+	if model == "" {
+		model = req.Model
+	}
+	m.record(models.UsageKindChat, model, resp.Usage.PromptTokens, resp.Usage.CompletionTokens, 0)
+	return resp, nil
+}
+
+func (m *meteredClient) CreateEmbeddings(ctx context.Context, req openai.EmbeddingRequest) (openai.EmbeddingResponse, error) {
+	resp, err := m.client.CreateEmbeddings(ctx, req)
+	if err != nil {
+		return resp, err
+	}
+	m.record(models.UsageKindEmbedding, string(req.Model), 0, 0, resp.Usage.PromptTokens)
+	return resp, nil
+}
+
+// CreateChatCompletionStream 流式调用，每段增量交给 onDelta，结束后返回完整回答。
+// 请求流末尾附带用量；服务端不支持或流中途出错、客户端断开时，按提示与已生成内容的字符数粗略估算，
+// 中断的回答同样计入用量
+func (m *meteredClient) CreateChatCompletionStream(ctx context.Context, req openai.ChatCompletionRequest, onDelta func(string) error) (string, error) {
+	req.StreamOptions = &openai.StreamOptions{IncludeUsage: true}
+	stream, err := m.client.CreateChatCompletionStream(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	defer stream.Close()
+
+	var answer strings.Builder
+	var usage *openai.Usage
+	defer func() {
+		if usage == nil {
+			promptChars := 0
+			for _, msg := range req.Messages {
+				promptChars += len([]rune(msg.Content))
+			}
+			usage = &openai.Usage{PromptTokens: promptChars / 2, CompletionTokens: len([]rune(answer.String())) / 2}
+		}
+		m.record(models.UsageKindChat, req.Model, usage.PromptTokens, usage.CompletionTokens, 0)
+	}()
+
+	for {
+		resp, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return answer.String(), err
+		}
+		if resp.Usage != nil {
+			usage = resp.Usage
+		}
+		if len(resp.Choices) == 0 || resp.Choices[0].Delta.Content == "" {
+			continue
+		}
+		delta := resp.Choices[0].Delta.Content
+		answer.WriteString(delta)
+		if err := onDelta(delta); err != nil {
+			return answer.String(), err
+		}
+	}
+	return answer.String(), nil
+}
+
+// Spent 本客户端累计花费
+func (m *meteredClient) Spent() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.cost
+}
+
+func (m *meteredClient) record(kind, model string, promptTokens, completionTokens, embeddingTokens int) {
+	cost := estimateCost(model, promptTokens+embeddingTokens, completionTokens)
+	m.mu.Lock()
+	m.cost += cost
+	m.mu.Unlock()
+
+	rec := models.UsageRecord{
+		UserId:           m.scope.UserID,
+		OrgId:            m.scope.OrgID,
+		ProjectId:        m.scope.ProjectID,
+		CredentialId:     m.credentialID,
+		Kind:             kind,
+		Model:            model,
+		PromptTokens:     promptTokens,
+		CompletionTokens: completionTokens,
+		EmbeddingTokens:  embeddingTokens,
+		Cost:             cost,
+	}
+	if err := models.CreateUsageRecord(&rec); err != nil {
+		fmt.Println("记录用量失败:", err)
+	}
+}
+
+// budgetStatus 当月预算状态
+type budgetStatus struct {
+	Scope  string  `json:"scope"` // user / org
+	Limit  float64 `json:"limit"` // 0 表示不限制
+	Spent  float64 `json:"spent"`
+	Action string  `json:"action"`
+}
+
+// Exceeded 是否已超出预算，extra 为尚未入账的花费
+func (b budgetStatus) Exceeded(extra float64) bool {
+	return b.Limit > 0 && b.Spent+extra >= b.Limit
+}
+
+// Degraded 超出预算且处理方式为降级
+func (b budgetStatus) Degraded() bool {
+	return b.Exceeded(0) && b.Action == models.BudgetActionDegrade
+}
+
+// budgetError 超出预算时拒绝调用的错误
+func (b budgetStatus) budgetError() error {
+	who := "本月"
+	if b.Scope == "org" {
+		who = "组织本月"
+	}
+	return newAPIError(http.StatusPaymentRequired,
+		fmt.Sprintf("%s LLM 花费 $%.4f 已达上限 $%.2f", who, b.Spent, b.Limit))
+}
+
+// monthStart 当月第一天零点
+func monthStart(now time.Time) time.Time {
+	return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.Local)
+}
+
+// normalizeBudgetAction 校验处理方式，为空时使用配置 budget.defaultAction（默认 refuse）
+func normalizeBudgetAction(action string) string {
+	if action == "" {
+		action = viper.GetString("budget.defaultAction")
+	}
+	if action == models.BudgetActionDegrade {
+		return action
+	}
+	return models.BudgetActionRefuse
+}
+
+// userBudgetStatus 用户个人项目的当月预算，未单独设置时使用配置 budget.userMonthlyLimit
+func userBudgetStatus(userID uint) budgetStatus {
+	status := budgetStatus{
+		Scope:  "user",
+		Limit:  viper.GetFloat64("budget.userMonthlyLimit"),
+		Action: normalizeBudgetAction(""),
+	}
+	if budget, err := models.FindUserBudget(userID); err == nil {
+		status.Limit = budget.MonthlyLimit
+		status.Action = normalizeBudgetAction(budget.Action)
+	}
+	status.Spent = models.UserSpendSince(userID, monthStart(time.Now()))
+	return status
+}
+
+// orgBudgetStatus 组织的当月预算
+func orgBudgetStatus(org models.Organization) budgetStatus {
+	return budgetStatus{
+		Scope:  "org",
+		Limit:  org.SpendingLimit,
+		Spent:  models.OrgSpendSince(org.ID, monthStart(time.Now())),
+		Action: normalizeBudgetAction(org.BudgetAction),
+	}
+}
+
+// projectBudgetStatus 项目调用所适用的预算：组织项目按组织上限，个人项目按用户预算
+func projectBudgetStatus(proj models.Project, userID uint) budgetStatus {
+	if proj.OrgId != 0 {
+		var org models.Organization
+		if err := utils.DB.Where("id = ?", proj.OrgId).First(&org).Error; err == nil {
+			return orgBudgetStatus(org)
+		}
+	}
+	return userBudgetStatus(userID)
+}
+
+// degradeConfig 降级后的调用配置，模型取自配置 budget.degradeChatModel
+func degradeConfig(cfg llmConfig) llmConfig {
+	if model := viper.GetString("budget.degradeChatModel"); model != "" {
+		cfg.ChatModel = model
+	}
+	return cfg
+}
@@ -0,0 +1,154 @@
+package service
+
+import (
+	"CodeCampass/models"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// budgetRequest 设置个人预算的请求体
+type budgetRequest struct {
+	MonthlyLimit float64 `json:"monthly_limit"`
+	Action       string  `json:"action"`
+}
+
+// parseUsageDate 解析 YYYY-MM-DD 格式的日期
+func parseUsageDate(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", v, time.Local)
+	if err != nil {
+		return t, newAPIError(http.StatusBadRequest, "日期格式应为 YYYY-MM-DD")
+	}
+	return t, nil
+}
+
+// GetUsageV1
+// @Summary 查询 LLM 用量统计
+// @Tags 用量模块 v1
+// @Security Bearer
+// @Param group_by query string false "聚合维度 day/project/model，默认 day"
+// @Param from query string false "开始日期 YYYY-MM-DD，默认当月第一天"
+// @Param to query string false "结束日期 YYYY-MM-DD（包含）"
+// @Param project_id query int false "只统计某个项目"
+// @Param org_id query int false "统计组织用量（需要组织管理员）"
+// @Success 200 {object} map[string]interface{}
+// @Router /api/v1/usage [get]
+func GetUsageV1(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	uid := userID.(uint)
+
+	query := models.UsageQuery{UserId: uid, GroupBy: c.DefaultQuery("group_by", models.UsageGroupDay)}
+	switch query.GroupBy {
+	case models.UsageGroupDay, models.UsageGroupProject, models.UsageGroupModel:
+	default:
+		respondError(c, newAPIError(http.StatusBadRequest, "group_by 只能是 day、project 或 model"))
+		return
+	}
+
+	from, err := parseUsageDate(c.Query("from"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	if from.IsZero() {
+		from = monthStart(time.Now())
+	}
+	to, err := parseUsageDate(c.Query("to"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	if !to.IsZero() {
+		to = to.AddDate(0, 0, 1)
+	}
+	query.From, query.To = from, to
+
+	if v := c.Query("project_id"); v != "" {
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			respondError(c, newAPIError(http.StatusBadRequest, "无效的项目ID"))
+			return
+		}
+		query.ProjectId = uint(id)
+	}
+	if v := c.Query("org_id"); v != "" {
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			respondError(c, newAPIError(http.StatusBadRequest, "无效的组织ID"))
+			return
+		}
+		member, err := models.FindOrgMember(uint(id), uid)
+		if err != nil || !member.IsManager() {
+			respondError(c, newAPIError(http.StatusForbidden, "只有组织管理员可以查看组织用量"))
+			return
+		}
+		query.OrgId = uint(id)
+	}
+
+	items, err := models.AggregateUsage(query)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	total := models.UsageSummary{Key: "total"}
+	for _, item := range items {
+		total.Calls += item.Calls
+		total.PromptTokens += item.PromptTokens
+		total.CompletionTokens += item.CompletionTokens
+		total.EmbeddingTokens += item.EmbeddingTokens
+		total.Cost += item.Cost
+	}
+	respondOK(c, http.StatusOK, "查询成功", gin.H{
+		"group_by": query.GroupBy,
+		"items":    items,
+		"total":    total,
+	})
+}
+
+// GetBudgetV1
+// @Summary 查看个人当月预算与花费
+// @Tags 用量模块 v1
+// @Security Bearer
+// @Success 200 {object} map[string]interface{}
+// @Router /api/v1/usage/budget [get]
+func GetBudgetV1(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	respondOK(c, http.StatusOK, "查询成功", userBudgetStatus(userID.(uint)))
+}
+
+// UpdateBudgetV1
+// @Summary 设置个人每月预算
+// @Tags 用量模块 v1
+// @Security Bearer
+// @Param body body budgetRequest true "monthly_limit 为每月上限（美元，0 表示不限制），action 为 refuse/degrade"
+// @Success 200 {object} map[string]interface{}
+// @Router /api/v1/usage/budget [put]
+func UpdateBudgetV1(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	var req budgetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, newAPIError(http.StatusBadRequest, "请求体格式错误"))
+		return
+	}
+	if req.MonthlyLimit < 0 {
+		respondError(c, newAPIError(http.StatusBadRequest, "无效的预算上限"))
+		return
+	}
+	if req.Action != "" && req.Action != models.BudgetActionRefuse && req.Action != models.BudgetActionDegrade {
+		respondError(c, newAPIError(http.StatusBadRequest, "action 只能是 refuse 或 degrade"))
+		return
+	}
+
+	if _, err := models.SaveUserBudget(userID.(uint), req.MonthlyLimit, req.Action); err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, "预算已更新", userBudgetStatus(userID.(uint)))
+}
@@ -0,0 +1,344 @@
+package service
+
+import (
+	"CodeCampass/models"
+	"CodeCampass/utils"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 接收 GitHub、GitLab 与 Gitea 的推送通知，推送到跟踪的分支时触发增量同步。
+// 每个项目一个密钥：GitHub 与 Gitea 用它计算请求体的 HMAC-SHA256 签名，GitLab 原样放在请求头中
+
+const (
+	providerGitHub = "github"
+	providerGitLab = "gitlab"
+	providerGitea  = "gitea"
+)
+
+// maxWebhookBody 推送请求体的上限，GitHub 的推送不超过 25MB
+const maxWebhookBody = 25 << 20
+
+// zeroCommit 删除分支时 after 为全 0
+const zeroCommit = "0000000000000000000000000000000000000000"
+
+// webhookSecretKey 项目的 webhook 密钥加密存放在 Redis 中的键
+func webhookSecretKey(projectID uint) string {
+	return fmt.Sprintf("webhook_secret:%d", projectID)
+}
+
+// webhookURL 项目的 webhook 地址
+func webhookURL(c *gin.Context, projectID uint) string {
+	scheme := "http"
+	if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s/api/v1/hooks/projects/%d", scheme, c.Request.Host, projectID)
+}
+
+// webhookRequest 从请求头中识别出的平台、事件与签名
+type webhookRequest struct {
+	Provider   string
+	Event      string
+	DeliveryID string
+	Signature  string // GitHub、Gitea 的签名，GitLab 的令牌
+}
+
+// parseWebhookHeaders 按请求头识别平台。Gitea 为兼容也会发送 GitHub 的请求头，需先判断
+func parseWebhookHeaders(h http.Header) (webhookRequest, bool) {
+	switch {
+	case h.Get("X-Gitea-Event") != "":
+		return webhookRequest{
+			Provider:   providerGitea,
+			Event:      h.Get("X-Gitea-Event"),
+			DeliveryID: h.Get("X-Gitea-Delivery"),
+			Signature:  h.Get("X-Gitea-Signature"),
+		}, true
+	case h.Get("X-Gitlab-Event") != "":
+		return webhookRequest{
+			Provider:   providerGitLab,
+			Event:      h.Get("X-Gitlab-Event"),
+			DeliveryID: h.Get("X-Gitlab-Event-UUID"),
+			Signature:  h.Get("X-Gitlab-Token"),
+		}, true
+	case h.Get("X-GitHub-Event") != "":
+		return webhookRequest{
+			Provider:   providerGitHub,
+			Event:      h.Get("X-GitHub-Event"),
+			DeliveryID: h.Get("X-GitHub-Delivery"),
+			Signature:  strings.TrimPrefix(h.Get("X-Hub-Signature-256"), "sha256="),
+		}, true
+	}
+	return webhookRequest{}, false
+}
+
+// verify 校验签名或令牌
+func (r webhookRequest) verify(secret string, body []byte) bool {
+	if r.Signature == "" {
+		return false
+	}
+	if r.Provider == providerGitLab {
+		return subtle.ConstantTimeCompare([]byte(r.Signature), []byte(secret)) == 1
+	}
+	sig, err := hex.DecodeString(r.Signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(sig, mac.Sum(nil))
+}
+
+// isPush 是否为分支推送事件
+func (r webhookRequest) isPush() bool {
+	switch r.Provider {
+	case providerGitLab:
+		return r.Event == "Push Hook"
+	default:
+		return r.Event == "push"
+	}
+}
+
+// pushPayload 三个平台推送事件中共有的字段
+type pushPayload struct {
+	Ref        string `json:"ref"`
+	After      string `json:"after"`
+	Repository struct {
+		DefaultBranch string `json:"default_branch"`
+	} `json:"repository"`
+	// GitLab 的仓库信息在 project 中
+	Project struct {
+		DefaultBranch string `json:"default_branch"`
+	} `json:"project"`
+}
+
+// parsePushPayload 解析推送内容，GitHub 可配置为表单格式，JSON 放在 payload 字段中
+func parsePushPayload(contentType string, body []byte) (pushPayload, error) {
+	var p pushPayload
+	if strings.HasPrefix(contentType, "application/x-www-form-urlencoded") {
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			return p, err
+		}
+		body = []byte(form.Get("payload"))
+	}
+	err := json.Unmarshal(body, &p)
+	return p, err
+}
+
+// trackedBranch 推送需要匹配的分支：项目跟踪的分支，未设置时为仓库的默认分支
+func (p pushPayload) trackedBranch(proj models.Project) string {
+	switch {
+	case proj.TrackedRef != "":
+		return proj.TrackedRef
+	case p.Repository.DefaultBranch != "":
+		return p.Repository.DefaultBranch
+	}
+	return p.Project.DefaultBranch
+}
+
+// ReceiveWebhookV1
+// @Summary 接收代码托管平台的推送通知
+// @Description 支持 GitHub、GitLab 与 Gitea 的 push 事件，校验签名（GitLab 为令牌）后，推送到跟踪的分支时触发增量同步。无需登录
+// @Tags 项目模块 v1
+// @Param id path int true "项目ID"
+// @Success 202 {object} map[string]interface{}
+// @Router /api/v1/hooks/projects/{id} [post]
+func ReceiveWebhookV1(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		respondError(c, newAPIError(http.StatusBadRequest, "无效的项目ID"))
+		return
+	}
+	var proj models.Project
+	if err := utils.DB.Where("id = ?", id).First(&proj).Error; err != nil {
+		respondError(c, newAPIError(http.StatusNotFound, "项目不存在"))
+		return
+	}
+
+	req, ok := parseWebhookHeaders(c.Request.Header)
+	delivery := models.WebhookDelivery{
+		ProjectId:  proj.ID,
+		Provider:   req.Provider,
+		Event:      req.Event,
+		DeliveryId: req.DeliveryID,
+		RemoteAddr: c.ClientIP(),
+	}
+	// finish 记录推送并响应平台
+	finish := func(status string, code int, message string) {
+		delivery.Status, delivery.StatusCode, delivery.Message = status, code, message
+		if err := models.CreateWebhookDelivery(&delivery); err != nil {
+			fmt.Printf("警告: 记录项目 %d 的 webhook 推送失败: %v\n", proj.ID, err)
+		}
+		if code >= http.StatusBadRequest {
+			respondError(c, newAPIError(code, message))
+			return
+		}
+		respondOK(c, code, message, gin.H{"status": status})
+	}
+	if !ok {
+		finish(models.WebhookRejected, http.StatusBadRequest, "无法识别的推送来源")
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxWebhookBody+1))
+	if err != nil {
+		finish(models.WebhookRejected, http.StatusBadRequest, "读取请求体失败")
+		return
+	}
+	if len(body) > maxWebhookBody {
+		finish(models.WebhookRejected, http.StatusRequestEntityTooLarge, "请求体过大")
+		return
+	}
+
+	secret, err := utils.GetSecret(c.Request.Context(), webhookSecretKey(proj.ID))
+	if err != nil {
+		finish(models.WebhookFailed, http.StatusInternalServerError, "读取 webhook 密钥失败")
+		return
+	}
+	if secret == "" {
+		finish(models.WebhookRejected, http.StatusForbidden, "项目未设置 webhook 密钥")
+		return
+	}
+	if !req.verify(secret, body) {
+		finish(models.WebhookRejected, http.StatusUnauthorized, "签名校验失败")
+		return
+	}
+
+	if req.Provider == providerGitHub && req.Event == "ping" {
+		finish(models.WebhookIgnored, http.StatusOK, "pong")
+		return
+	}
+	if !req.isPush() {
+		finish(models.WebhookIgnored, http.StatusOK, "不处理的事件: "+req.Event)
+		return
+	}
+	payload, err := parsePushPayload(c.ContentType(), body)
+	if err != nil {
+		finish(models.WebhookRejected, http.StatusBadRequest, "无法解析推送内容")
+		return
+	}
+	delivery.Ref, delivery.Commit = payload.Ref, payload.After
+
+	branch := payload.trackedBranch(proj)
+	if branch == "" || payload.Ref != "refs/heads/"+branch {
+		finish(models.WebhookIgnored, http.StatusOK, fmt.Sprintf("推送的 %s 不是跟踪的分支 %s", payload.Ref, branch))
+		return
+	}
+	if payload.After == zeroCommit {
+		finish(models.WebhookIgnored, http.StatusOK, "跟踪的分支已被删除")
+		return
+	}
+
+	// 项目正在同步或导入时标记为待同步，结束后再同步一次
+	deferred, err := requestProjectSync(proj.ID)
+	if err != nil {
+		finish(models.WebhookFailed, http.StatusInternalServerError, "触发同步失败: "+err.Error())
+		return
+	}
+	if deferred {
+		finish(models.WebhookQueued, http.StatusAccepted, "项目正在同步或导入，完成后将再同步一次")
+		return
+	}
+	finish(models.WebhookQueued, http.StatusAccepted, "已开始同步")
+}
+
+// GetWebhookV1
+// @Summary 查看项目的 webhook 设置
+// @Description 返回在代码托管平台中填写的地址、是否已设置密钥与跟踪的分支
+// @Tags 项目模块 v1
+// @Security Bearer
+// @Param id path int true "项目ID"
+// @Success 200 {object} map[string]interface{}
+// @Router /api/v1/projects/{id}/webhook [get]
+func GetWebhookV1(c *gin.Context) {
+	proj, ok := loadProjectV1(c)
+	if !ok {
+		return
+	}
+	secret, err := utils.GetSecret(c.Request.Context(), webhookSecretKey(proj.ID))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, "获取成功", gin.H{
+		"url":         webhookURL(c, proj.ID),
+		"secret_set":  secret != "",
+		"tracked_ref": proj.TrackedRef,
+	})
+}
+
+// ResetWebhookSecretV1
+// @Summary 生成项目的 webhook 密钥
+// @Description 生成新的密钥并替换旧密钥，密钥只在本次响应中返回。GitHub、Gitea 填入 Secret，GitLab 填入 Secret token
+// @Tags 项目模块 v1
+// @Security Bearer
+// @Param id path int true "项目ID"
+// @Success 200 {object} map[string]interface{}
+// @Router /api/v1/projects/{id}/webhook/secret [post]
+func ResetWebhookSecretV1(c *gin.Context) {
+	proj, ok := loadProjectV1(c)
+	if !ok {
+		return
+	}
+	userID, _ := c.Get("userID")
+	if !canManageProject(userID.(uint), proj) {
+		respondError(c, newAPIError(http.StatusForbidden, "无权限修改此项目的 webhook"))
+		return
+	}
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		respondError(c, err)
+		return
+	}
+	secret := hex.EncodeToString(buf)
+	if err := utils.SetSecret(c.Request.Context(), webhookSecretKey(proj.ID), secret); err != nil {
+		if errors.Is(err, utils.ErrNoMasterKey) {
+			respondError(c, newAPIError(http.StatusServiceUnavailable, err.Error()))
+			return
+		}
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, "密钥已生成", gin.H{
+		"url":    webhookURL(c, proj.ID),
+		"secret": secret,
+	})
+}
+
+// ListWebhookDeliveriesV1
+// @Summary 查看项目最近的 webhook 推送记录
+// @Tags 项目模块 v1
+// @Security Bearer
+// @Param id path int true "项目ID"
+// @Param limit query int false "返回条数，默认 30，最多 100"
+// @Success 200 {object} map[string]interface{}
+// @Router /api/v1/projects/{id}/webhook/deliveries [get]
+func ListWebhookDeliveriesV1(c *gin.Context) {
+	proj, ok := loadProjectV1(c)
+	if !ok {
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "30"))
+	if err != nil || limit <= 0 {
+		respondError(c, newAPIError(http.StatusBadRequest, "无效的 limit"))
+		return
+	}
+	if limit > 100 {
+		limit = 100
+	}
+	respondOK(c, http.StatusOK, "获取成功", gin.H{"deliveries": models.GetWebhookDeliveries(proj.ID, limit)})
+}
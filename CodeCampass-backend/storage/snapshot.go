@@ -0,0 +1,290 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/spf13/viper"
+)
+
+var (
+	ErrSnapshotNotFound = errors.New("快照不存在")
+	ErrInvalidKey       = errors.New("无效的快照名称")
+)
+
+// SnapshotInfo 已归档的快照
+type SnapshotInfo struct {
+	Key     string    `json:"key"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// SnapshotStore 归档快照的存储后端，key 为 / 分隔的相对路径
+type SnapshotStore interface {
+	// Put 写入快照，r 的长度事先未知，读到 EOF 为止
+	Put(ctx context.Context, key string, r io.Reader) error
+	Open(ctx context.Context, key string) (io.ReadCloser, SnapshotInfo, error)
+	// List 列出 prefix 下的快照，按 key 排序
+	List(ctx context.Context, prefix string) ([]SnapshotInfo, error)
+	Delete(ctx context.Context, key string) error
+}
+
+var (
+	snapshotOnce  sync.Once
+	snapshotStore SnapshotStore
+	snapshotErr   error
+)
+
+// Snapshots 按配置 storage.snapshots.backend 创建的快照存储，local（默认）或 s3
+func Snapshots() (SnapshotStore, error) {
+	snapshotOnce.Do(func() {
+		switch backend := viper.GetString("storage.snapshots.backend"); backend {
+		case "", "local":
+			dir := viper.GetString("storage.snapshots.dir")
+			if dir == "" {
+				dir = "data/snapshots"
+			}
+			snapshotStore, snapshotErr = NewLocalStore(dir)
+		case "s3":
+			snapshotStore, snapshotErr = NewS3Store(S3Config{
+				Endpoint:  viper.GetString("storage.snapshots.s3.endpoint"),
+				Bucket:    viper.GetString("storage.snapshots.s3.bucket"),
+				AccessKey: viper.GetString("storage.snapshots.s3.accessKey"),
+				SecretKey: viper.GetString("storage.snapshots.s3.secretKey"),
+				Region:    viper.GetString("storage.snapshots.s3.region"),
+				UseSSL:    viper.GetBool("storage.snapshots.s3.useSSL"),
+			})
+		default:
+			snapshotErr = fmt.Errorf("不支持的快照存储: %s", backend)
+		}
+	})
+	return snapshotStore, snapshotErr
+}
+
+// checkKey key 不能越出存储根目录
+func checkKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") || path.Clean(key) != key ||
+		key == ".." || strings.HasPrefix(key, "../") {
+		return ErrInvalidKey
+	}
+	return nil
+}
+
+// LocalStore 存放在本地目录中的快照
+type LocalStore struct {
+	dir string
+}
+
+func NewLocalStore(dir string) (*LocalStore, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(abs, 0755); err != nil {
+		return nil, fmt.Errorf("创建快照目录失败: %v", err)
+	}
+	return &LocalStore{dir: abs}, nil
+}
+
+func (s *LocalStore) Put(ctx context.Context, key string, r io.Reader) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	dest := filepath.Join(s.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	// 先写临时文件再改名，写到一半失败时不留下残缺的快照
+	tmp, err := os.CreateTemp(filepath.Dir(dest), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dest)
+}
+
+func (s *LocalStore) Open(ctx context.Context, key string) (io.ReadCloser, SnapshotInfo, error) {
+	if err := checkKey(key); err != nil {
+		return nil, SnapshotInfo{}, err
+	}
+	f, err := os.Open(filepath.Join(s.dir, filepath.FromSlash(key)))
+	if os.IsNotExist(err) {
+		return nil, SnapshotInfo{}, ErrSnapshotNotFound
+	}
+	if err != nil {
+		return nil, SnapshotInfo{}, err
+	}
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		f.Close()
+		return nil, SnapshotInfo{}, ErrSnapshotNotFound
+	}
+	return f, SnapshotInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (s *LocalStore) List(ctx context.Context, prefix string) ([]SnapshotInfo, error) {
+	list := make([]SnapshotInfo, 0)
+	err := filepath.WalkDir(s.dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || strings.HasPrefix(d.Name(), ".upload-") {
+			return nil
+		}
+		rel, _ := filepath.Rel(s.dir, p)
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		list = append(list, SnapshotInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	sort.Slice(list, func(i, j int) bool { return list[i].Key < list[j].Key })
+	return list, err
+}
+
+func (s *LocalStore) Delete(ctx context.Context, key string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	err := os.Remove(filepath.Join(s.dir, filepath.FromSlash(key)))
+	if os.IsNotExist(err) {
+		return ErrSnapshotNotFound
+	}
+	return err
+}
+
+// S3Config S3 兼容存储（AWS S3、MinIO 等）的连接参数
+type S3Config struct {
+	Endpoint  string // 如 s3.amazonaws.com、localhost:9000
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	Region    string
+	UseSSL    bool
+}
+
+// S3Store 存放在 S3 兼容对象存储中的快照
+type S3Store struct {
+	client *minio.Client
+	bucket string
+
+	bucketMu    sync.Mutex
+	bucketReady bool // 确认 bucket 存在后不再检查；检查失败时下次写入重试
+}
+
+func NewS3Store(cfg S3Config) (*S3Store, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, errors.New("未配置 S3 的 endpoint 或 bucket")
+	}
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure: cfg.UseSSL,
+		Region: cfg.Region,
+		// 路径风格的地址在 MinIO 等自建服务上无需配置泛域名
+		BucketLookup: minio.BucketLookupPath,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("连接 S3 失败: %v", err)
+	}
+	return &S3Store{client: client, bucket: cfg.Bucket}, nil
+}
+
+// ensureBucket 首次写入时创建 bucket，只记住成功的结果，网络或服务的临时错误不会让之后的写入一直失败
+func (s *S3Store) ensureBucket(ctx context.Context) error {
+	s.bucketMu.Lock()
+	defer s.bucketMu.Unlock()
+	if s.bucketReady {
+		return nil
+	}
+	exists, err := s.client.BucketExists(ctx, s.bucket)
+	if err == nil && !exists {
+		err = s.client.MakeBucket(ctx, s.bucket, minio.MakeBucketOptions{})
+		if minio.ToErrorResponse(err).Code == "BucketAlreadyOwnedByYou" {
+			err = nil // 其他实例同时创建
+		}
+	}
+	s.bucketReady = err == nil
+	return err
+}
+
+func (s *S3Store) Put(ctx context.Context, key string, r io.Reader) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	if err := s.ensureBucket(ctx); err != nil {
+		return fmt.Errorf("创建 bucket 失败: %v", err)
+	}
+	// 长度为 -1 时按分片流式上传
+	_, err := s.client.PutObject(ctx, s.bucket, key, r, -1, minio.PutObjectOptions{ContentType: "application/octet-stream"})
+	return err
+}
+
+func (s *S3Store) Open(ctx context.Context, key string) (io.ReadCloser, SnapshotInfo, error) {
+	if err := checkKey(key); err != nil {
+		return nil, SnapshotInfo{}, err
+	}
+	stat, err := s.client.StatObject(ctx, s.bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return nil, SnapshotInfo{}, s3Error(err)
+	}
+	obj, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, SnapshotInfo{}, s3Error(err)
+	}
+	return obj, SnapshotInfo{Key: key, Size: stat.Size, ModTime: stat.LastModified}, nil
+}
+
+func (s *S3Store) List(ctx context.Context, prefix string) ([]SnapshotInfo, error) {
+	list := make([]SnapshotInfo, 0)
+	for obj := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if obj.Err != nil {
+			if minio.ToErrorResponse(obj.Err).Code == minio.NoSuchBucket {
+				return list, nil
+			}
+			return nil, obj.Err
+		}
+		list = append(list, SnapshotInfo{Key: obj.Key, Size: obj.Size, ModTime: obj.LastModified})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Key < list[j].Key })
+	return list, nil
+}
+
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	// S3 删除不存在的对象不会报错，先确认存在
+	if _, err := s.client.StatObject(ctx, s.bucket, key, minio.StatObjectOptions{}); err != nil {
+		return s3Error(err)
+	}
+	return s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{})
+}
+
+func s3Error(err error) error {
+	switch minio.ToErrorResponse(err).Code {
+	case minio.NoSuchKey, minio.NoSuchBucket:
+		return ErrSnapshotNotFound
+	}
+	return err
+}
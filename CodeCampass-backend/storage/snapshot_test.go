@@ -0,0 +1,316 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testSnapshotStore 两种存储后端共用的行为检查
+func testSnapshotStore(t *testing.T, s SnapshotStore) {
+	ctx := context.Background()
+
+	if _, _, err := s.Open(ctx, "1/missing.tar.gz"); !errors.Is(err, ErrSnapshotNotFound) {
+		t.Fatalf("Open(missing) err = %v", err)
+	}
+	if list, err := s.List(ctx, "1/"); err != nil || len(list) != 0 {
+		t.Fatalf("List(empty) = %v, %v", list, err)
+	}
+
+	files := map[string]string{
+		"1/b.zip":    "second",
+		"1/a.tar.gz": "first snapshot",
+		"2/c.tar.gz": "other project",
+	}
+	for key, content := range files {
+		if err := s.Put(ctx, key, strings.NewReader(content)); err != nil {
+			t.Fatalf("Put(%s): %v", key, err)
+		}
+	}
+
+	rc, info, err := s.Open(ctx, "1/a.tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(data) != "first snapshot" || info.Size != int64(len(data)) || info.Key != "1/a.tar.gz" {
+		t.Fatalf("Open = %q %+v", data, info)
+	}
+
+	list, err := s.List(ctx, "1/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].Key != "1/a.tar.gz" || list[1].Key != "1/b.zip" || list[1].Size != 6 {
+		t.Fatalf("List = %+v", list)
+	}
+
+	if err := s.Delete(ctx, "1/a.tar.gz"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(ctx, "1/a.tar.gz"); !errors.Is(err, ErrSnapshotNotFound) {
+		t.Fatalf("Delete twice err = %v", err)
+	}
+	if list, _ := s.List(ctx, "1/"); len(list) != 1 {
+		t.Fatalf("List after delete = %+v", list)
+	}
+
+	for _, key := range []string{"", "/abs", "../x", "1/../../x", "a\\b"} {
+		if err := s.Put(ctx, key, strings.NewReader("x")); !errors.Is(err, ErrInvalidKey) {
+			t.Fatalf("Put(%q) err = %v", key, err)
+		}
+	}
+}
+
+func TestLocalStore(t *testing.T) {
+	s, err := NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testSnapshotStore(t, s)
+}
+
+func TestS3Store(t *testing.T) {
+	srv := httptest.NewServer(newFakeS3())
+	defer srv.Close()
+
+	s, err := NewS3Store(S3Config{
+		Endpoint:  strings.TrimPrefix(srv.URL, "http://"),
+		Bucket:    "snapshots",
+		AccessKey: "minioadmin",
+		SecretKey: "minioadmin",
+		Region:    "us-east-1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testSnapshotStore(t, s)
+}
+
+func TestS3StoreBucketRetry(t *testing.T) {
+	fake := newFakeS3()
+	fake.bucketFailures = 1
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	s, err := NewS3Store(S3Config{
+		Endpoint: strings.TrimPrefix(srv.URL, "http://"),
+		Bucket:   "snapshots",
+		Region:   "us-east-1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := s.Put(ctx, "1/a.zip", strings.NewReader("x")); err == nil {
+		t.Fatal("bucket 检查失败时 Put 应返回错误")
+	}
+	if err := s.Put(ctx, "1/a.zip", strings.NewReader("x")); err != nil {
+		t.Fatalf("故障恢复后 Put 仍失败: %v", err)
+	}
+}
+
+// fakeS3 内存中的 S3 服务，只实现快照存储用到的接口（路径风格地址，不校验签名），
+// 代替测试环境中不可用的 MinIO
+type fakeS3 struct {
+	mu      sync.Mutex
+	buckets map[string]map[string]fakeObject
+	uploads map[string]map[int][]byte
+	nextID  int
+
+	bucketFailures int // 接下来若干次对 bucket 的请求返回错误，模拟临时故障
+}
+
+type fakeObject struct {
+	data    []byte
+	modTime time.Time
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{buckets: map[string]map[string]fakeObject{}, uploads: map[string]map[int][]byte{}}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	bucket, key := parts[0], ""
+	if len(parts) == 2 {
+		key = parts[1]
+	}
+	q := r.URL.Query()
+	objects, exists := f.buckets[bucket]
+
+	if key == "" {
+		if f.bucketFailures > 0 {
+			f.bucketFailures--
+			s3ErrorResponse(w, r, http.StatusForbidden, "AccessDenied")
+			return
+		}
+		switch {
+		case r.Method == http.MethodPut:
+			if !exists {
+				f.buckets[bucket] = map[string]fakeObject{}
+			}
+		case !exists:
+			s3ErrorResponse(w, r, http.StatusNotFound, "NoSuchBucket")
+		case r.Method == http.MethodHead:
+		case r.Method == http.MethodGet && q.Get("list-type") == "2":
+			f.list(w, bucket, objects, q.Get("prefix"))
+		default:
+			s3ErrorResponse(w, r, http.StatusNotImplemented, "NotImplemented")
+		}
+		return
+	}
+	if !exists {
+		s3ErrorResponse(w, r, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPost && q.Has("uploads"):
+		f.nextID++
+		id := fmt.Sprint(f.nextID)
+		f.uploads[id] = map[int][]byte{}
+		writeXML(w, struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			Bucket   string
+			Key      string
+			UploadId string
+		}{Bucket: bucket, Key: key, UploadId: id})
+	case r.Method == http.MethodPut && q.Get("uploadId") != "":
+		var n int
+		fmt.Sscan(q.Get("partNumber"), &n)
+		f.uploads[q.Get("uploadId")][n] = readS3Body(r)
+		w.Header().Set("ETag", fmt.Sprintf(`"part-%d"`, n))
+	case r.Method == http.MethodPost && q.Get("uploadId") != "":
+		parts := f.uploads[q.Get("uploadId")]
+		nums := make([]int, 0, len(parts))
+		for n := range parts {
+			nums = append(nums, n)
+		}
+		sort.Ints(nums)
+		var buf bytes.Buffer
+		for _, n := range nums {
+			buf.Write(parts[n])
+		}
+		delete(f.uploads, q.Get("uploadId"))
+		objects[key] = fakeObject{data: buf.Bytes(), modTime: time.Now()}
+		writeXML(w, struct {
+			XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+			Bucket  string
+			Key     string
+			ETag    string
+		}{Bucket: bucket, Key: key, ETag: `"object"`})
+	case r.Method == http.MethodDelete && q.Get("uploadId") != "":
+		delete(f.uploads, q.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut:
+		objects[key] = fakeObject{data: readS3Body(r), modTime: time.Now()}
+		w.Header().Set("ETag", `"object"`)
+	case r.Method == http.MethodHead || r.Method == http.MethodGet:
+		obj, ok := objects[key]
+		if !ok {
+			s3ErrorResponse(w, r, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("ETag", `"object"`)
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Last-Modified", obj.modTime.UTC().Format(http.TimeFormat))
+		http.ServeContent(w, r, key, obj.modTime, bytes.NewReader(obj.data))
+	case r.Method == http.MethodDelete:
+		delete(objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s3ErrorResponse(w, r, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+type fakeS3Content struct {
+	Key          string
+	LastModified string
+	Size         int64
+	ETag         string
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, bucket string, objects map[string]fakeObject, prefix string) {
+	contents := make([]fakeS3Content, 0)
+	for key, obj := range objects {
+		if strings.HasPrefix(key, prefix) {
+			contents = append(contents, fakeS3Content{
+				Key:          key,
+				LastModified: obj.modTime.UTC().Format(time.RFC3339),
+				Size:         int64(len(obj.data)),
+				ETag:         `"object"`,
+			})
+		}
+	}
+	sort.Slice(contents, func(i, j int) bool { return contents[i].Key < contents[j].Key })
+	writeXML(w, struct {
+		XMLName     xml.Name `xml:"ListBucketResult"`
+		Name        string
+		Prefix      string
+		KeyCount    int
+		MaxKeys     int
+		IsTruncated bool
+		Contents    []fakeS3Content
+	}{Name: bucket, Prefix: prefix, KeyCount: len(contents), MaxKeys: 1000, Contents: contents})
+}
+
+// readS3Body 读取请求体，流式签名（aws-chunked）时去掉分块头
+func readS3Body(r *http.Request) []byte {
+	data, _ := io.ReadAll(r.Body)
+	if !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return data
+	}
+	var out []byte
+	for len(data) > 0 {
+		i := bytes.Index(data, []byte("\r\n"))
+		if i < 0 {
+			break
+		}
+		var size int
+		fmt.Sscanf(string(data[:i]), "%x", &size)
+		data = data[i+2:]
+		if size == 0 || size > len(data) {
+			break
+		}
+		out = append(out, data[:size]...)
+		data = bytes.TrimPrefix(data[size:], []byte("\r\n"))
+	}
+	return out
+}
+
+func writeXML(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(v)
+}
+
+func s3ErrorResponse(w http.ResponseWriter, r *http.Request, status int, code string) {
+	if r.Method == http.MethodHead {
+		w.WriteHeader(status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(struct {
+		XMLName  xml.Name `xml:"Error"`
+		Code     string
+		Message  string
+		Resource string
+	}{Code: code, Message: code, Resource: r.URL.Path})
+}
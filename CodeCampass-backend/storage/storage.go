@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/viper"
+)
+
+// defaultRoot 未配置 storage.root 时的工作区根目录，相对于启动目录
+const defaultRoot = "data/repos"
+
+// Root 克隆仓库与索引的工作区根目录
+func Root() string {
+	root := viper.GetString("storage.root")
+	if root == "" {
+		root = defaultRoot
+	}
+	if abs, err := filepath.Abs(root); err == nil {
+		return abs
+	}
+	return root
+}
+
+// legacyRoot 旧版本固定使用的工作区根目录
+const legacyRoot = "/home/ubuntu/Repos"
+
+// MigrateLegacyRoot 启动时把旧版本 /home/ubuntu/Repos 下的仓库移到当前的根目录，
+// 当前根目录不存在或为空时才移动；无法移动（如跨文件系统）时改为继续使用旧目录，已导入的仓库不会丢失
+func MigrateLegacyRoot() error {
+	return migrateRoot(legacyRoot)
+}
+
+func migrateRoot(legacy string) error {
+	root := Root()
+	if root == legacy {
+		return nil
+	}
+	if info, err := os.Stat(legacy); err != nil || !info.IsDir() {
+		return nil
+	}
+	if entries, err := os.ReadDir(root); err == nil && len(entries) > 0 {
+		return fmt.Errorf("旧工作区 %s 与 %s 都有数据，未自动迁移，请手动合并后删除旧目录", legacy, root)
+	}
+	os.Remove(root) // 空目录，改名前删除
+	if err := os.MkdirAll(filepath.Dir(root), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(legacy, root); err != nil {
+		viper.Set("storage.root", legacy)
+		return fmt.Errorf("无法把旧工作区 %s 移动到 %s（%v），继续使用旧目录", legacy, root, err)
+	}
+	return nil
+}
+
+// ProjectDir 项目仓库的工作区目录：<根目录>/<所有者ID>/<项目ID>
+func ProjectDir(ownerID, projectID uint) string {
+	return filepath.Join(Root(), fmt.Sprint(ownerID), fmt.Sprint(projectID))
+}
+
+// SidecarPath 与项目目录并列存放的附属文件（如搜索索引），不会被当作仓库文件
+func SidecarPath(ownerID, projectID uint, ext string) string {
+	return ProjectDir(ownerID, projectID) + "." + ext
+}
+
+// DirUsage 统计目录占用的字节数，不跟随符号链接；目录不存在时返回 0
+func DirUsage(dir string) (int64, error) {
+	var total int64
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil // 遍历过程中被删除的文件
+		}
+		total += info.Size()
+		return nil
+	})
+	return total, err
+}
+
+// ProjectUsage 项目占用的磁盘空间：仓库目录与附属文件
+func ProjectUsage(ownerID, projectID uint, sidecars ...string) (int64, error) {
+	total, err := DirUsage(ProjectDir(ownerID, projectID))
+	if err != nil {
+		return 0, err
+	}
+	for _, ext := range sidecars {
+		if info, err := os.Stat(SidecarPath(ownerID, projectID, ext)); err == nil {
+			total += info.Size()
+		}
+	}
+	return total, nil
+}
@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestProjectDirAndUsage(t *testing.T) {
+	root := t.TempDir()
+	viper.Set("storage.root", root)
+	defer viper.Set("storage.root", "")
+
+	dir := ProjectDir(3, 42)
+	if want := filepath.Join(root, "3", "42"); dir != want {
+		t.Fatalf("ProjectDir = %s, want %s", dir, want)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(dir, "a.txt"), make([]byte, 10), 0644)
+	os.WriteFile(filepath.Join(dir, "sub", "b.txt"), make([]byte, 5), 0644)
+	os.WriteFile(SidecarPath(3, 42, "idx"), make([]byte, 7), 0644)
+
+	usage, err := ProjectUsage(3, 42, "idx", "nav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage != 22 {
+		t.Fatalf("ProjectUsage = %d, want 22", usage)
+	}
+	if n, err := DirUsage(filepath.Join(root, "missing")); err != nil || n != 0 {
+		t.Fatalf("DirUsage(missing) = %d, %v", n, err)
+	}
+}
+
+func TestMigrateRoot(t *testing.T) {
+	tmp := t.TempDir()
+	legacy := filepath.Join(tmp, "legacy")
+	root := filepath.Join(tmp, "data", "repos")
+	viper.Set("storage.root", root)
+	defer viper.Set("storage.root", "")
+
+	// 旧目录不存在时什么也不做
+	if err := migrateRoot(legacy); err != nil {
+		t.Fatal(err)
+	}
+
+	os.MkdirAll(filepath.Join(legacy, "1", "2"), 0755)
+	os.WriteFile(filepath.Join(legacy, "1", "2", "main.go"), []byte("package main"), 0644)
+	os.MkdirAll(root, 0755) // 空的新目录
+	if err := migrateRoot(legacy); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(ProjectDir(1, 2), "main.go")); err != nil {
+		t.Fatalf("仓库未迁移: %v", err)
+	}
+	if _, err := os.Stat(legacy); !os.IsNotExist(err) {
+		t.Fatalf("旧目录仍然存在: %v", err)
+	}
+
+	// 两边都有数据时不迁移，继续使用配置的目录
+	os.MkdirAll(filepath.Join(legacy, "5"), 0755)
+	if err := migrateRoot(legacy); err == nil {
+		t.Fatal("两边都有数据时应返回错误")
+	}
+	if Root() != root {
+		t.Fatalf("Root = %s, want %s", Root(), root)
+	}
+}
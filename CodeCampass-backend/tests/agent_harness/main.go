@@ -0,0 +1,137 @@
+package main
+
+import (
+	"CodeCampass/service"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// agent 模式的离线演练：用 ScriptedLLM 编排工具调用，在本地仓库目录上运行 agent 循环，
+// 检查工具结果、调用记录与步数上限。在后端目录下执行 go run ./tests/agent_harness
+
+type scenario struct {
+	name     string
+	replies  []openai.ChatCompletionMessage
+	maxSteps int
+	check    func(res service.AgentResult, llm *service.ScriptedLLM) error
+}
+
+func main() {
+	dir := flag.String("dir", ".", "用于演练的仓库目录")
+	flag.Parse()
+
+	ws, err := service.NewLocalWorkspace(*dir)
+	if err != nil {
+		fmt.Println("构建工作区失败:", err)
+		os.Exit(1)
+	}
+
+	scenarios := []scenario{
+		{
+			name: "依次调用各个工具后回答",
+			replies: []openai.ChatCompletionMessage{
+				service.ScriptToolCall("call_1", "list_dir", `{"path": "."}`),
+				service.ScriptToolCall("call_2", "grep", `{"pattern": "func main\\(", "path_glob": "*.go"}`),
+				service.ScriptToolCall("call_3", "read_file", `{"path": "main.go", "start_line": 1, "end_line": 15}`),
+				service.ScriptToolCall("call_4", "search_symbols", `{"query": "Router", "kind": "func"}`),
+				service.ScriptAnswer("入口在 main.go，路由在 router.Router 中注册。"),
+			},
+			maxSteps: 8,
+			check: func(res service.AgentResult, llm *service.ScriptedLLM) error {
+				if res.StopReason != "answered" || len(res.Steps) != 4 {
+					return fmt.Errorf("期望 4 步后回答，实际 %d 步，结束原因 %s", len(res.Steps), res.StopReason)
+				}
+				for _, step := range res.Steps {
+					if step.Error != "" {
+						return fmt.Errorf("工具 %s 调用失败: %s", step.Tool, step.Error)
+					}
+				}
+				if !strings.Contains(res.Steps[0].Result, "main.go") {
+					return fmt.Errorf("list_dir 结果中没有 main.go")
+				}
+				if !strings.Contains(res.Steps[1].Result, "main.go:") {
+					return fmt.Errorf("grep 没有找到 main 函数")
+				}
+				if !strings.Contains(res.Steps[2].Result, "1\tpackage main") {
+					return fmt.Errorf("read_file 结果缺少行号")
+				}
+				if !strings.Contains(res.Steps[3].Result, "router/website.go") {
+					return fmt.Errorf("search_symbols 没有找到 Router")
+				}
+				// 最后一次请求应当带上全部 4 个工具结果，且 tool_call_id 对应
+				last := llm.Requests[len(llm.Requests)-1]
+				ids := map[string]bool{}
+				for _, m := range last.Messages {
+					if m.Role == openai.ChatMessageRoleTool {
+						ids[m.ToolCallID] = true
+					}
+				}
+				for _, id := range []string{"call_1", "call_2", "call_3", "call_4"} {
+					if !ids[id] {
+						return fmt.Errorf("工具结果 %s 没有回传给模型", id)
+					}
+				}
+				return nil
+			},
+		},
+		{
+			name: "越出仓库的路径被拒绝，循环继续",
+			replies: []openai.ChatCompletionMessage{
+				service.ScriptToolCall("call_1", "read_file", `{"path": "../../etc/passwd"}`),
+				service.ScriptToolCall("call_2", "semantic_search", `{"query": "embedding"}`),
+				service.ScriptAnswer("无法读取该文件。"),
+			},
+			maxSteps: 8,
+			check: func(res service.AgentResult, llm *service.ScriptedLLM) error {
+				if len(res.Steps) != 2 || res.Steps[0].Error == "" || res.Steps[1].Error == "" {
+					return fmt.Errorf("期望两次工具调用都失败，实际 %+v", res.Steps)
+				}
+				if res.Answer != "无法读取该文件。" {
+					return fmt.Errorf("回答不符: %s", res.Answer)
+				}
+				return nil
+			},
+		},
+		{
+			name: "达到步数上限后要求直接回答",
+			replies: []openai.ChatCompletionMessage{
+				service.ScriptToolCall("call_1", "list_dir", `{"path": "service"}`),
+				service.ScriptToolCall("call_2", "list_dir", `{"path": "models"}`),
+				service.ScriptAnswer("根据目录结构作答。"),
+			},
+			maxSteps: 2,
+			check: func(res service.AgentResult, llm *service.ScriptedLLM) error {
+				if res.StopReason != "max_steps" || len(res.Steps) != 2 {
+					return fmt.Errorf("期望 2 步后因步数上限结束，实际 %d 步，结束原因 %s", len(res.Steps), res.StopReason)
+				}
+				if last := llm.Requests[len(llm.Requests)-1]; len(last.Tools) != 0 {
+					return fmt.Errorf("最后一次请求不应再提供工具")
+				}
+				return nil
+			},
+		},
+	}
+
+	failed := 0
+	for _, sc := range scenarios {
+		llm := &service.ScriptedLLM{Replies: sc.replies}
+		res, err := service.RunAgent(context.Background(), llm, ws, "scripted", "这个项目的入口在哪里？", sc.maxSteps)
+		if err == nil {
+			err = sc.check(res, llm)
+		}
+		if err != nil {
+			failed++
+			fmt.Printf("FAIL %s: %v\n", sc.name, err)
+			continue
+		}
+		fmt.Printf("ok   %s（%d 次工具调用）\n", sc.name, len(res.Steps))
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,266 @@
+package utils
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/spf13/viper"
+)
+
+// 密钥存储采用信封加密：每条记录随机生成数据密钥（DEK）加密明文，
+// 再用主密钥（KEK）加密数据密钥，两层均为 AES-256-GCM。
+// 主密钥可配置多个，新写入的记录使用 activeKey，旧密钥只用于解密，便于轮换。
+
+const (
+	secretPrefix = "enc:v1:"
+	// 环境变量中的主密钥优先于配置文件
+	envMasterKey   = "CODECAMPASS_MASTER_KEY"
+	envMasterKeyID = "CODECAMPASS_MASTER_KEY_ID"
+)
+
+// SecretKeyPatterns 需要加密存储的 Redis 键，轮换命令会扫描这些键
+var SecretKeyPatterns = []string{"openai_key:*", "credential_key:*", "webhook_secret:*", "notify_secret:*"}
+
+var ErrNoMasterKey = errors.New("未配置主密钥，无法加密存储密钥")
+
+var (
+	masterKeys  = map[string][]byte{}
+	activeKeyID string
+)
+
+// secretRecord 加密后的记录
+type secretRecord struct {
+	KeyID        string `json:"kid"` // 加密数据密钥所用的主密钥ID
+	EncryptedKey string `json:"edk"` // 被主密钥加密的数据密钥
+	KeyNonce     string `json:"kn"`
+	Nonce        string `json:"n"`
+	CipherText   string `json:"ct"`
+}
+
+// InitSecretStore 加载主密钥：配置 secret.masterKeys（ID -> base64 编码的 32 字节密钥）
+// 与 secret.activeKey，或通过环境变量 CODECAMPASS_MASTER_KEY 提供
+func InitSecretStore() error {
+	masterKeys = map[string][]byte{}
+	// viper 的键不区分大小写，主密钥ID统一转为小写
+	activeKeyID = strings.ToLower(viper.GetString("secret.activeKey"))
+
+	for id, v := range viper.GetStringMapString("secret.masterKeys") {
+		key, err := decodeMasterKey(v)
+		if err != nil {
+			return fmt.Errorf("主密钥 %s 无效: %v", id, err)
+		}
+		masterKeys[id] = key
+	}
+
+	if v := os.Getenv(envMasterKey); v != "" {
+		key, err := decodeMasterKey(v)
+		if err != nil {
+			return fmt.Errorf("环境变量 %s 无效: %v", envMasterKey, err)
+		}
+		id := strings.ToLower(os.Getenv(envMasterKeyID))
+		if id == "" {
+			id = "env"
+		}
+		masterKeys[id] = key
+		activeKeyID = id
+	}
+
+	if len(masterKeys) == 0 {
+		fmt.Println("警告: 未配置主密钥，API Key 将无法保存")
+		return nil
+	}
+	if _, ok := masterKeys[activeKeyID]; !ok {
+		return fmt.Errorf("当前主密钥 %q 不存在", activeKeyID)
+	}
+	fmt.Println("secret store inited, active key:", activeKeyID)
+	return nil
+}
+
+func decodeMasterKey(v string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(v))
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != 32 {
+		return nil, errors.New("主密钥长度必须为 32 字节")
+	}
+	return key, nil
+}
+
+// EncryptSecret 使用当前主密钥加密明文，aad 绑定记录所在的键，防止密文被挪用到其他键
+func EncryptSecret(plaintext string, aad string) (string, error) {
+	kek, ok := masterKeys[activeKeyID]
+	if !ok {
+		return "", ErrNoMasterKey
+	}
+
+	dek := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dek); err != nil {
+		return "", err
+	}
+	nonce, ct, err := gcmSeal(dek, []byte(plaintext), []byte(aad))
+	if err != nil {
+		return "", err
+	}
+	keyNonce, edk, err := gcmSeal(kek, dek, []byte(activeKeyID))
+	if err != nil {
+		return "", err
+	}
+
+	data, _ := json.Marshal(secretRecord{
+		KeyID:        activeKeyID,
+		EncryptedKey: base64.StdEncoding.EncodeToString(edk),
+		KeyNonce:     base64.StdEncoding.EncodeToString(keyNonce),
+		Nonce:        base64.StdEncoding.EncodeToString(nonce),
+		CipherText:   base64.StdEncoding.EncodeToString(ct),
+	})
+	return secretPrefix + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// DecryptSecret 解密 EncryptSecret 的结果；没有加密前缀的旧数据原样返回
+func DecryptSecret(stored string, aad string) (string, error) {
+	if !IsEncryptedSecret(stored) {
+		return stored, nil
+	}
+	rec, err := parseSecretRecord(stored)
+	if err != nil {
+		return "", err
+	}
+	kek, ok := masterKeys[rec.KeyID]
+	if !ok {
+		return "", fmt.Errorf("缺少主密钥 %q，无法解密", rec.KeyID)
+	}
+
+	dek, err := gcmOpen(kek, rec.KeyNonce, rec.EncryptedKey, []byte(rec.KeyID))
+	if err != nil {
+		return "", fmt.Errorf("数据密钥解密失败: %v", err)
+	}
+	plaintext, err := gcmOpen(dek, rec.Nonce, rec.CipherText, []byte(aad))
+	if err != nil {
+		return "", fmt.Errorf("密钥解密失败: %v", err)
+	}
+	return string(plaintext), nil
+}
+
+// IsEncryptedSecret 判断存储的值是否已经加密
+func IsEncryptedSecret(stored string) bool {
+	return strings.HasPrefix(stored, secretPrefix)
+}
+
+func parseSecretRecord(stored string) (secretRecord, error) {
+	var rec secretRecord
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(stored, secretPrefix))
+	if err != nil {
+		return rec, fmt.Errorf("密文格式错误: %v", err)
+	}
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return rec, fmt.Errorf("密文格式错误: %v", err)
+	}
+	return rec, nil
+}
+
+func gcmSeal(key, plaintext, aad []byte) (nonce, ciphertext []byte, err error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce = make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, nil, err
+	}
+	return nonce, gcm.Seal(nil, nonce, plaintext, aad), nil
+}
+
+func gcmOpen(key []byte, nonceB64, ciphertextB64 string, aad []byte) ([]byte, error) {
+	nonce, err := base64.StdEncoding.DecodeString(nonceB64)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(ciphertextB64)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return nil, errors.New("nonce 长度错误")
+	}
+	return gcm.Open(nil, nonce, ciphertext, aad)
+}
+
+// SetSecret 加密后保存到 Redis
+func SetSecret(ctx context.Context, key string, plaintext string) error {
+	stored, err := EncryptSecret(plaintext, key)
+	if err != nil {
+		return err
+	}
+	return Red.Set(ctx, key, stored, 0).Err()
+}
+
+// GetSecret 从 Redis 读取并解密，键不存在时返回空字符串
+func GetSecret(ctx context.Context, key string) (string, error) {
+	stored, err := Red.Get(ctx, key).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return DecryptSecret(stored, key)
+}
+
+// DelSecret 删除密钥
+func DelSecret(ctx context.Context, key string) error {
+	return Red.Del(ctx, key).Err()
+}
+
+// RotateSecrets 使用当前主密钥重新加密 SecretKeyPatterns 下的所有记录（包括尚未加密的旧数据），
+// 返回处理的记录数
+func RotateSecrets(ctx context.Context) (int, error) {
+	if _, ok := masterKeys[activeKeyID]; !ok {
+		return 0, ErrNoMasterKey
+	}
+
+	count := 0
+	for _, pattern := range SecretKeyPatterns {
+		iter := Red.Scan(ctx, 0, pattern, 100).Iterator()
+		for iter.Next(ctx) {
+			key := iter.Val()
+			plaintext, err := GetSecret(ctx, key)
+			if err != nil {
+				return count, fmt.Errorf("%s: %v", key, err)
+			}
+			if plaintext == "" {
+				continue
+			}
+			if err := SetSecret(ctx, key, plaintext); err != nil {
+				return count, fmt.Errorf("%s: %v", key, err)
+			}
+			count++
+		}
+		if err := iter.Err(); err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}